@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"strings"
+)
+
+/*
+	Bulk import and export of the product catalog.
+
+	A file holds one record per product, described like the body of the create product endpoint
+	(dto.ProductModel) and matched to the stored products by SKU: a known SKU updates the product,
+	an unknown one creates it. Records are full descriptions, a field left out takes its zero value,
+	except for isActive, stock, images, variants and attributes: left out (or an empty CSV cell)
+	they keep what is stored, given they replace it. A new product left without them is active and
+	has no stock. Variants are matched by their SKU, or by name and value when they have none, and
+	stored variants missing from a record are deactivated.
+
+	Stock is what is on hand, the stock held by unpaid orders included. The import subtracts those
+	holds before storing it, since the orders already took their stock and an expired hold gives
+	it back, and export adds them again.
+
+	Formats:
+		ndjson  one JSON object per line, the create product body
+		csv     a header line, then one line per product and one more per further variant
+
+	CSV columns, every one optional but sku:
+		sku, name, description, price, stock, categoryId, isActive, minStockLevel, maxStockLevel,
+		reorderPoint, barcode, weight, dimensions, images (URLs separated by |), attr.<key>,
+		variant.sku, variant.name, variant.value, variant.priceAdjustment, variant.stock,
+		variant.isActive, variant.attr.<key>
+	Lines following a product with the same sku add variants to it, their product columns are
+	not read. categoryId takes a category id or slug in both formats.
+
+	Export writes the same formats, so an exported file can be edited and imported again.
+*/
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ErrUnknownFormat is returned for a format other than FormatCSV and FormatNDJSON.
+var ErrUnknownFormat = errors.New("format must be csv or ndjson")
+
+// Record is one product of an import file.
+type Record struct {
+	// Line is where the product starts in the file, counting from 1
+	Line    int
+	Product dto.ProductModel
+	// Problems are the values that could not be read, e.g. a price that is not a number
+	Problems []cjson.FieldError
+	// Omitted are the fields among isActive, stock and variants[i].stock the file has no value for
+	Omitted map[string]bool
+}
+
+func (r *Record) omit(field string) {
+	if r.Omitted == nil {
+		r.Omitted = map[string]bool{}
+	}
+	r.Omitted[field] = true
+}
+
+// FormatFromName guesses the format from a file name or media type, "" when it can not tell.
+func FormatFromName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".csv"), strings.HasPrefix(name, "text/csv"):
+		return FormatCSV
+	case strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"),
+		strings.HasPrefix(name, "application/x-ndjson"), strings.HasPrefix(name, "application/jsonl"):
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// fileError is a problem with the file as a whole, nothing of it is imported.
+func fileError(line int, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
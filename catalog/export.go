@@ -0,0 +1,191 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportBatch is how many products are loaded at a time while exporting.
+const exportBatch = 200
+
+// Export writes the whole catalog to w in format, a batch of products at a time. afterBatch runs
+// once a batch is written, the handler flushes and moves its write deadline there.
+func Export(ctx context.Context, w io.Writer, format string, afterBatch func() error) error {
+	switch format {
+	case FormatNDJSON:
+		encoder := json.NewEncoder(w)
+		return models.ExportProducts(ctx, exportBatch, func(products []models.Product) error {
+			for _, product := range products {
+				if err := encoder.Encode(exportRecord(product)); err != nil {
+					return err
+				}
+			}
+			return afterBatch()
+		})
+
+	case FormatCSV:
+		productKeys, err := models.AttributeKeys(ctx, models.AttributeLevelProduct)
+		if err != nil {
+			return err
+		}
+		variantKeys, err := models.AttributeKeys(ctx, models.AttributeLevelVariant)
+		if err != nil {
+			return err
+		}
+		out := csvWriter{w: csv.NewWriter(w), productKeys: productKeys, variantKeys: variantKeys}
+		if err := out.header(); err != nil {
+			return err
+		}
+		return models.ExportProducts(ctx, exportBatch, func(products []models.Product) error {
+			for _, product := range products {
+				if err := out.product(exportRecord(product)); err != nil {
+					return err
+				}
+			}
+			out.w.Flush()
+			if err := out.w.Error(); err != nil {
+				return err
+			}
+			return afterBatch()
+		})
+	}
+	return ErrUnknownFormat
+}
+
+// exportRecord describes the product the way an import reads it back. Images, variants and
+// attributes are always set, importing the record again leaves the product as it is.
+func exportRecord(p models.Product) dto.ProductModel {
+	record := dto.ProductModel{
+		Name:          p.Name,
+		Description:   p.Description,
+		Price:         p.Price,
+		Stock:         p.Stock,
+		CategoryId:    p.CategoryId,
+		Images:        make([]dto.ImageMode, 0, len(p.Images)),
+		IsActive:      p.IsActive,
+		MinStockLevel: p.MinStockLevel,
+		MaxStockLevel: p.MaxStockLevel,
+		ReorderPoint:  p.ReorderPoint,
+		SKU:           p.SKU,
+		Barcode:       p.Barcode,
+		Weight:        p.Weight,
+		Dimensions:    p.Dimensions,
+		Variants:      make([]dto.ProductVariantDTO, 0, len(p.Variants)),
+		Attributes:    exportAttributes(p.Attributes),
+	}
+	for _, image := range p.Images {
+		record.Images = append(record.Images, dto.ImageMode{FieldId: image.FieldId, URL: image.URL, Name: image.FileName})
+	}
+	for _, variant := range p.Variants {
+		isActive := variant.IsActive
+		record.Variants = append(record.Variants, dto.ProductVariantDTO{
+			Name:            variant.VariantName,
+			Value:           variant.VariantValue,
+			PriceAdjustment: variant.PriceAdjustment,
+			Stock:           variant.Stock,
+			SKU:             variant.SKU,
+			IsActive:        &isActive,
+			Attributes:      exportAttributes(variant.Attributes),
+		})
+	}
+	return record
+}
+
+// exportAttributes keys the values by attribute key, numbers and booleans in their own JSON type.
+func exportAttributes(values []models.ProductAttributeValue) map[string]any {
+	out := make(map[string]any, len(values))
+	for _, value := range values {
+		if value.Attribute == nil {
+			continue
+		}
+		switch {
+		case value.Attribute.Type == models.AttributeNumber && value.Number != nil:
+			out[value.Attribute.Key] = *value.Number
+		case value.Attribute.Type == models.AttributeBoolean:
+			out[value.Attribute.Key], _ = strconv.ParseBool(value.Value)
+		default:
+			out[value.Attribute.Key] = value.Value
+		}
+	}
+	return out
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	productKeys []string
+	variantKeys []string
+}
+
+func (c csvWriter) header() error {
+	header := append([]string{}, productColumns...)
+	for _, key := range c.productKeys {
+		header = append(header, attributePrefix+key)
+	}
+	header = append(header, variantColumns...)
+	for _, key := range c.variantKeys {
+		header = append(header, variantAttributePrefix+key)
+	}
+	return c.w.Write(header)
+}
+
+// product writes the product with its first variant on one line and every further variant on a
+// line of its own that only repeats the sku.
+func (c csvWriter) product(p dto.ProductModel) error {
+	urls := make([]string, 0, len(p.Images))
+	for _, image := range p.Images {
+		urls = append(urls, image.URL)
+	}
+	row := []string{p.SKU, p.Name, p.Description, strconv.Itoa(p.Price), strconv.Itoa(p.Stock), p.CategoryId,
+		strconv.FormatBool(p.IsActive), strconv.Itoa(p.MinStockLevel), strconv.Itoa(p.MaxStockLevel),
+		strconv.Itoa(p.ReorderPoint), p.Barcode, strconv.FormatFloat(p.Weight, 'f', -1, 64), p.Dimensions,
+		strings.Join(urls, imageSeparator)}
+	row = append(row, attributeCells(p.Attributes, c.productKeys)...)
+
+	if len(p.Variants) == 0 {
+		return c.w.Write(append(row, make([]string, len(variantColumns)+len(c.variantKeys))...))
+	}
+	for i, variant := range p.Variants {
+		if i > 0 {
+			row = make([]string, len(productColumns)+len(c.productKeys))
+			row[0] = p.SKU
+		}
+		row = append(row, variant.SKU, variant.Name, variant.Value, strconv.Itoa(variant.PriceAdjustment),
+			strconv.Itoa(variant.Stock), strconv.FormatBool(variant.IsActive == nil || *variant.IsActive))
+		row = append(row, attributeCells(variant.Attributes, c.variantKeys)...)
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func attributeCells(values map[string]any, keys []string) []string {
+	cells := make([]string, len(keys))
+	for i, key := range keys {
+		switch value := values[key].(type) {
+		case float64:
+			cells[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			cells[i] = strconv.FormatBool(value)
+		case string:
+			cells[i] = value
+		}
+	}
+	return cells
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
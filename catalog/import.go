@@ -0,0 +1,435 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/validation"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// maxReportedErrors caps the errors stored with a job, Failed still counts every product
+	maxReportedErrors = 1000
+	// progressEvery is how often a running job saves its counts and renews its lease
+	progressEvery = 2 * time.Second
+)
+
+var wake = make(chan struct{}, 1)
+
+// Wake tells the import worker a job is waiting, so it does not wait for its next tick.
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartImportWorker runs the waiting imports one after another, checking every interval and
+// whenever Wake is called, until ctx is done.
+func StartImportWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+		for ctx.Err() == nil {
+			job, err := models.ClaimProductImport(ctx)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					log.Err(err).Msg("Issue while claiming a product import")
+				}
+				break
+			}
+			RunImport(ctx, job)
+		}
+	}
+}
+
+// RunImport imports every product of the job's file and records the outcome on the job. A
+// product that fails is reported and skipped, the others are still imported.
+func RunImport(ctx context.Context, job *models.ProductImportJob) {
+	logger := log.With().Str("importId", job.Id).Bool("dryRun", job.DryRun).Logger()
+	// the models log through the context, their lines carry the import id
+	ctx = logger.WithContext(ctx)
+	started := time.Now()
+
+	err := runImport(ctx, job)
+	if errors.Is(err, context.Canceled) {
+		// shutting down, the lease runs out and the job starts over on the next claim
+		logger.Warn().Int("processed", job.Processed).Msg("Product import interrupted")
+		return
+	}
+	if err := models.FinishProductImport(context.WithoutCancel(ctx), job, err); err != nil {
+		return
+	}
+	logger.Info().Str("status", job.Status).Int("created", job.Created).Int("updated", job.Updated).
+		Int("failed", job.Failed).Dur("took", time.Since(started)).Msg("Product import finished")
+}
+
+func runImport(ctx context.Context, job *models.ProductImportJob) error {
+	rd, err := newReader(job.Format, bytes.NewReader(job.Payload))
+	if err != nil {
+		return err
+	}
+	imp := &importer{
+		ctx:         ctx,
+		dryRun:      job.DryRun,
+		categories:  map[string]string{},
+		schemas:     map[string][]models.CategoryAttribute{},
+		skus:        map[string]int{},
+		variantSKUs: map[string]int{},
+	}
+
+	saved := time.Now()
+	for {
+		record, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		created, problems, err := imp.importRecord(record)
+		if err != nil {
+			return err
+		}
+		job.Processed++
+		switch {
+		case len(problems) > 0:
+			job.Failed++
+			for _, problem := range problems {
+				if len(job.Errors) < maxReportedErrors {
+					job.Errors = append(job.Errors, models.ImportRowError{Line: record.Line, SKU: record.Product.SKU, Field: problem.Field, Message: problem.Message})
+				}
+			}
+		case created:
+			job.Created++
+		default:
+			job.Updated++
+		}
+
+		if time.Since(saved) >= progressEvery {
+			if err := models.SaveProductImportProgress(ctx, job); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+}
+
+// importer keeps what the products of one file share: the categories and schemas looked up so
+// far and the SKUs already seen.
+type importer struct {
+	ctx         context.Context
+	dryRun      bool
+	categories  map[string]string
+	schemas     map[string][]models.CategoryAttribute
+	skus        map[string]int
+	variantSKUs map[string]int
+}
+
+// importRecord checks one product and saves it. Problems with the product come back as field
+// errors, an error means the import can not go on.
+func (imp *importer) importRecord(record *Record) (bool, []cjson.FieldError, error) {
+	in := &record.Product
+	problems := append([]cjson.FieldError{}, record.Problems...)
+	// a cell that did not parse is left at zero, its field is reported once
+	for _, problem := range validation.Struct(in) {
+		if !hasField(record.Problems, problem.Field) {
+			problems = append(problems, problem)
+		}
+	}
+	invalid := func(field, code, message string) {
+		problems = append(problems, cjson.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	if in.SKU == "" {
+		invalid("sku", cjson.FieldRequired, "sku is required, products are matched by it")
+	} else if line, ok := imp.skus[in.SKU]; ok {
+		invalid("sku", cjson.FieldInvalid, fmt.Sprintf("sku %s is also on line %d", in.SKU, line))
+	} else {
+		imp.skus[in.SKU] = record.Line
+	}
+	for i, variant := range in.Variants {
+		field := fmt.Sprintf("variants[%d]", i)
+		if variant.SKU != "" {
+			if line, ok := imp.variantSKUs[variant.SKU]; ok {
+				invalid(field+".sku", cjson.FieldInvalid, fmt.Sprintf("%s.sku %s is also on line %d", field, variant.SKU, line))
+			}
+			imp.variantSKUs[variant.SKU] = record.Line
+		}
+		if in.Price > 0 && in.Price+variant.PriceAdjustment <= 0 {
+			invalid(field+".priceAdjustment", cjson.FieldInvalid, field+".priceAdjustment makes the variant price zero or negative")
+		}
+	}
+	if len(problems) > 0 {
+		return false, problems, nil
+	}
+
+	categoryId, err := imp.category(in.CategoryId)
+	if err != nil {
+		return false, nil, err
+	}
+	if categoryId == "" {
+		invalid("categoryId", cjson.FieldInvalid, "categoryId is not an existing category id or slug")
+		return false, problems, nil
+	}
+	schema, err := imp.schema(categoryId)
+	if err != nil {
+		return false, nil, err
+	}
+
+	existing, err := models.GetProductBySKU(imp.ctx, in.SKU)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil, err
+	}
+	product := newProduct(in, categoryId, existing)
+	if record.Omitted["isActive"] {
+		product.IsActive = existing == nil || existing.IsActive
+	}
+	var stock models.ImportStock
+	if !record.Omitted["stock"] {
+		stock.Product = &in.Stock
+	}
+	for i := range in.Variants {
+		var count *int
+		if !record.Omitted[fmt.Sprintf("variants[%d].stock", i)] {
+			count = &in.Variants[i].Stock
+		}
+		stock.Variants = append(stock.Variants, count)
+	}
+
+	input := in.Attributes
+	if input == nil && existing != nil {
+		input = models.AttributeInput(existing.Attributes)
+	}
+	product.Attributes = attributeValues(schema, models.AttributeLevelProduct, input, "attributes", &problems)
+	product.Variants = variants(in, existing, schema, &problems)
+	if len(problems) > 0 {
+		return false, problems, nil
+	}
+
+	if err := models.ImportProduct(imp.ctx, product, stock, imp.dryRun); err != nil {
+		if errors.Is(err, models.ErrImportSKUTaken) {
+			invalid("variants", cjson.FieldInvalid, "a variant sku is already used by another product")
+			return false, problems, nil
+		}
+		if imp.ctx.Err() != nil {
+			return false, nil, imp.ctx.Err()
+		}
+		invalid("", cjson.FieldInvalid, "the product could not be saved")
+		return false, problems, nil
+	}
+	return existing == nil, nil, nil
+}
+
+// category resolves a category id or slug, "" when there is no such category.
+func (imp *importer) category(idOrSlug string) (string, error) {
+	if id, ok := imp.categories[idOrSlug]; ok {
+		return id, nil
+	}
+	category, err := models.GetCategoryById(imp.ctx, idOrSlug)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		category, err = models.GetCategoryBySlug(imp.ctx, idOrSlug)
+	}
+	id := ""
+	switch {
+	case err == nil:
+		id = category.Id
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return "", err
+	}
+	imp.categories[idOrSlug] = id
+	return id, nil
+}
+
+func (imp *importer) schema(categoryId string) ([]models.CategoryAttribute, error) {
+	if schema, ok := imp.schemas[categoryId]; ok {
+		return schema, nil
+	}
+	schema, err := models.GetCategorySchema(imp.ctx, categoryId)
+	if err != nil {
+		return nil, err
+	}
+	imp.schemas[categoryId] = schema
+	return schema, nil
+}
+
+// newProduct is the product to save for the record, existing is the stored product with its SKU.
+// Its stock is left out, ImportProduct takes it separately.
+func newProduct(in *dto.ProductModel, categoryId string, existing *models.Product) *models.Product {
+	product := &models.Product{
+		Name:          in.Name,
+		Description:   in.Description,
+		Price:         in.Price,
+		CategoryId:    categoryId,
+		IsActive:      in.IsActive,
+		MinStockLevel: in.MinStockLevel,
+		MaxStockLevel: in.MaxStockLevel,
+		ReorderPoint:  in.ReorderPoint,
+		SKU:           in.SKU,
+		Barcode:       in.Barcode,
+		Weight:        in.Weight,
+		Dimensions:    in.Dimensions,
+	}
+	if existing != nil {
+		product.Id = existing.Id
+		// the stock levels get defaults on create, an empty one keeps the stored level
+		if product.MinStockLevel == 0 {
+			product.MinStockLevel = existing.MinStockLevel
+		}
+		if product.MaxStockLevel == 0 {
+			product.MaxStockLevel = existing.MaxStockLevel
+		}
+		if product.ReorderPoint == 0 {
+			product.ReorderPoint = existing.ReorderPoint
+		}
+	}
+
+	if in.Images != nil && (existing == nil || !sameImages(in.Images, existing.Images)) {
+		product.Images = make([]models.Image, 0, len(in.Images))
+		for i, image := range in.Images {
+			name := image.Name
+			if name == "" {
+				name = path.Base(strings.SplitN(image.URL, "?", 2)[0])
+			}
+			product.Images = append(product.Images, models.Image{
+				URL:       image.URL,
+				FileName:  name,
+				FieldId:   image.FieldId,
+				SortOrder: i,
+				IsPrimary: i == 0,
+			})
+		}
+	}
+	return product
+}
+
+// sameImages tells whether the record lists the stored images, which are then left alone.
+func sameImages(images []dto.ImageMode, stored []models.Image) bool {
+	if len(images) != len(stored) {
+		return false
+	}
+	for i := range images {
+		if images[i].URL != stored[i].URL {
+			return false
+		}
+	}
+	return true
+}
+
+// variants matches the record's variants to the stored ones, by SKU or else by name and value,
+// a matched variant without isActive keeps the stored flag.
+// A record without variants keeps the stored ones, their attribute values are checked again since
+// the product may have moved to another category.
+func variants(in *dto.ProductModel, existing *models.Product, schema []models.CategoryAttribute, problems *[]cjson.FieldError) []models.ProductVariant {
+	var stored []models.ProductVariant
+	if existing != nil {
+		stored = existing.Variants
+	}
+
+	if in.Variants == nil {
+		if existing == nil {
+			return nil
+		}
+		kept := make([]models.ProductVariant, 0, len(stored))
+		for i, variant := range stored {
+			variant.Product = models.Product{}
+			variant.Attributes = attributeValues(schema, models.AttributeLevelVariant, models.AttributeInput(variant.Attributes), fmt.Sprintf("variants[%d].attributes", i), problems)
+			kept = append(kept, variant)
+		}
+		return kept
+	}
+
+	out := make([]models.ProductVariant, 0, len(in.Variants))
+	matched := map[string]bool{}
+	for i, v := range in.Variants {
+		variant := models.ProductVariant{
+			VariantName:     v.Name,
+			VariantValue:    v.Value,
+			PriceAdjustment: v.PriceAdjustment,
+			SKU:             v.SKU,
+			IsActive:        v.IsActive == nil || *v.IsActive,
+		}
+		input := v.Attributes
+		if match := matchVariant(v, stored); match != nil && !matched[match.Id] {
+			matched[match.Id] = true
+			variant.Id, variant.SKU = match.Id, match.SKU
+			if v.IsActive == nil {
+				variant.IsActive = match.IsActive
+			}
+			if v.SKU != "" {
+				variant.SKU = v.SKU
+			}
+			if input == nil {
+				input = models.AttributeInput(match.Attributes)
+			}
+		}
+		variant.Attributes = attributeValues(schema, models.AttributeLevelVariant, input, fmt.Sprintf("variants[%d].attributes", i), problems)
+		out = append(out, variant)
+	}
+	return out
+}
+
+func matchVariant(v dto.ProductVariantDTO, stored []models.ProductVariant) *models.ProductVariant {
+	for i := range stored {
+		if v.SKU != "" && stored[i].SKU == v.SKU {
+			return &stored[i]
+		}
+	}
+	if v.SKU != "" {
+		return nil
+	}
+	for i := range stored {
+		if strings.EqualFold(stored[i].VariantName, v.Name) && strings.EqualFold(stored[i].VariantValue, v.Value) {
+			return &stored[i]
+		}
+	}
+	return nil
+}
+
+// attributeValues checks the values against the schema attributes of level and adds a field error
+// per bad value, named after where the value sits in the record.
+func attributeValues(schema []models.CategoryAttribute, level string, input map[string]any, prefix string, problems *[]cjson.FieldError) []models.ProductAttributeValue {
+	values, bad := models.AttributeValues(schema, level, input)
+	for _, key := range sortedKeys(bad) {
+		field := prefix + "." + key
+		code := cjson.FieldInvalid
+		if bad[key] == "is required" {
+			code = cjson.FieldRequired
+		}
+		*problems = append(*problems, cjson.FieldError{Field: field, Code: code, Message: field + " " + bad[key]})
+	}
+	for i := range values {
+		values[i].Attribute = nil
+	}
+	return values
+}
+
+func hasField(problems []cjson.FieldError, field string) bool {
+	for _, problem := range problems {
+		if problem.Field == field {
+			return true
+		}
+	}
+	return false
+}
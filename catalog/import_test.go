@@ -0,0 +1,256 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/migrations"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/rs/zerolog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The import tests run against an in-memory SQLite database migrated like a real one. Every test
+// uses its own category and SKUs.
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	cfg := config.DatabaseConfig{Driver: database.DriverSQLite, DSN: database.SQLiteMemoryDSN}
+	if err := database.InitDB(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "open test database:", err)
+		os.Exit(1)
+	}
+	if _, err := migrations.NewRunner(database.DB).Up(0); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate test database:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// newCategory creates a category and returns its slug, which the files below refer to it by.
+func newCategory(t *testing.T) string {
+	t.Helper()
+	suffix := uuid.New().String()[:8]
+	category := models.Category{Name: "Lighting " + suffix, Slug: "lighting-" + suffix}
+	if err := database.DB.Create(&category).Error; err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	return category.Slug
+}
+
+// runJob stores the file as an import job, runs it and returns the finished job.
+func runJob(t *testing.T, format, payload string, dryRun bool) *models.ProductImportJob {
+	t.Helper()
+	ctx := context.Background()
+	job := &models.ProductImportJob{Format: format, Payload: []byte(payload), DryRun: dryRun}
+	if _, err := job.CreateProductImport(ctx); err != nil {
+		t.Fatalf("create import: %v", err)
+	}
+	RunImport(ctx, job)
+
+	stored, err := models.GetProductImportById(ctx, job.Id)
+	if err != nil {
+		t.Fatalf("load import: %v", err)
+	}
+	return stored
+}
+
+func expectCounts(t *testing.T, job *models.ProductImportJob, status string, created, updated, failed int) {
+	t.Helper()
+	if job.Status != status || job.Created != created || job.Updated != updated || job.Failed != failed {
+		t.Fatalf("job %s created %d updated %d failed %d (errors %+v), want %s %d %d %d",
+			job.Status, job.Created, job.Updated, job.Failed, job.Errors, status, created, updated, failed)
+	}
+}
+
+func productBySKU(t *testing.T, sku string) *models.Product {
+	t.Helper()
+	product, err := models.GetProductBySKU(context.Background(), sku)
+	if err != nil {
+		t.Fatalf("load %s: %v", sku, err)
+	}
+	return product
+}
+
+func TestImportCreatesAndUpdatesBySKU(t *testing.T) {
+	slug := newCategory(t)
+	sku := "LAMP-" + uuid.New().String()[:8]
+	header := "sku,name,price,stock,categoryId,isActive,images,variant.sku,variant.name,variant.value,variant.stock\n"
+
+	job := runJob(t, FormatCSV, header+
+		fmt.Sprintf("%s,Desk lamp,1500,4,%s,true,https://cdn.example.com/a.jpg,%s-S,Size,Small,2\n", sku, slug, sku)+
+		fmt.Sprintf("%s,,,,,,,%s-L,Size,Large,3\n", sku, sku), false)
+	expectCounts(t, job, models.ImportStatusCompleted, 1, 0, 0)
+
+	product := productBySKU(t, sku)
+	if product.Name != "Desk lamp" || product.Price != 1500 || product.Stock != 4 || len(product.Images) != 1 || len(product.Variants) != 2 {
+		t.Fatalf("created product = %+v", product)
+	}
+	firstId := product.Variants[0].Id
+
+	// the same SKU updates, the small variant is matched by its SKU and the large one left out
+	job = runJob(t, FormatCSV, header+
+		fmt.Sprintf("%s,Brass desk lamp,1700,6,%s,true,https://cdn.example.com/a.jpg,%s-S,Size,Small,5\n", sku, slug, sku), false)
+	expectCounts(t, job, models.ImportStatusCompleted, 0, 1, 0)
+
+	updated := productBySKU(t, sku)
+	if updated.Id != product.Id || updated.Name != "Brass desk lamp" || updated.Price != 1700 {
+		t.Fatalf("updated product = %+v", updated)
+	}
+	if len(updated.Variants) != 2 {
+		t.Fatalf("got %d variants, the one left out should be kept inactive", len(updated.Variants))
+	}
+	for _, variant := range updated.Variants {
+		active := variant.Id == firstId
+		if variant.IsActive != active {
+			t.Fatalf("variant %s active = %v, want %v", variant.SKU, variant.IsActive, active)
+		}
+	}
+}
+
+func TestImportDryRunSavesNothing(t *testing.T) {
+	slug := newCategory(t)
+	sku := "RUG-" + uuid.New().String()[:8]
+
+	job := runJob(t, FormatNDJSON, fmt.Sprintf(`{"sku":%q,"name":"Rug","price":900,"stock":3,"categoryId":%q}`, sku, slug), true)
+	expectCounts(t, job, models.ImportStatusCompleted, 1, 0, 0)
+
+	if _, err := models.GetProductBySKU(context.Background(), sku); err == nil {
+		t.Fatal("a dry run saved the product")
+	}
+}
+
+func TestImportReportsBadRows(t *testing.T) {
+	slug := newCategory(t)
+	suffix := uuid.New().String()[:8]
+	payload := "sku,name,price,categoryId\n" +
+		fmt.Sprintf("OK-%s,Vase,700,%s\n", suffix, slug) +
+		fmt.Sprintf("PRICE-%s,Bowl,cheap,%s\n", suffix, slug) +
+		fmt.Sprintf("CAT-%s,Jug,300,no-such-category\n", suffix) +
+		fmt.Sprintf("OK-%s,Vase again,800,%s\n", suffix, slug) +
+		fmt.Sprintf(",Cup,200,%s\n", slug)
+
+	job := runJob(t, FormatCSV, payload, false)
+	expectCounts(t, job, models.ImportStatusCompleted, 1, 0, 4)
+
+	var got []string
+	for _, rowErr := range job.Errors {
+		got = append(got, fmt.Sprintf("%d:%s", rowErr.Line, rowErr.Field))
+	}
+	// the price that did not parse is reported once, not also as missing
+	if want := "3:price 4:categoryId 5:sku 6:sku"; strings.Join(got, " ") != want {
+		t.Fatalf("errors on %q, want %q", strings.Join(got, " "), want)
+	}
+	if product := productBySKU(t, "OK-"+suffix); product.Name != "Vase" {
+		t.Fatalf("the repeated SKU changed the product to %q", product.Name)
+	}
+}
+
+func TestImportFailsOnUnreadableFile(t *testing.T) {
+	job := runJob(t, FormatCSV, "sku,colour\nA,red\n", false)
+	if job.Status != models.ImportStatusFailed || !strings.Contains(job.Error, `unknown column "colour"`) || job.Processed != 0 {
+		t.Fatalf("job %s, error %q, processed %d", job.Status, job.Error, job.Processed)
+	}
+}
+
+func TestImportKeepsOmittedActiveAndStock(t *testing.T) {
+	slug := newCategory(t)
+	sku := "JUG-" + uuid.New().String()[:8]
+	header := "sku,name,price,stock,categoryId,isActive,variant.sku,variant.name,variant.value,variant.stock,variant.isActive\n"
+
+	// isActive false sticks on create even though the column defaults to true
+	job := runJob(t, FormatCSV, header+
+		fmt.Sprintf("%s,Jug,300,7,%s,false,%s-S,Size,Small,2,false\n", sku, slug, sku), false)
+	expectCounts(t, job, models.ImportStatusCompleted, 1, 0, 0)
+	product := productBySKU(t, sku)
+	if product.IsActive || product.Stock != 7 || product.Variants[0].IsActive || product.Variants[0].Stock != 2 {
+		t.Fatalf("created product active %v stock %d, variant %+v", product.IsActive, product.Stock, product.Variants[0])
+	}
+
+	// empty cells keep the stored flags and stock
+	job = runJob(t, FormatCSV, header+
+		fmt.Sprintf("%s,Water jug,350,,%s,,%s-S,Size,Small,,\n", sku, slug, sku), false)
+	expectCounts(t, job, models.ImportStatusCompleted, 0, 1, 0)
+	product = productBySKU(t, sku)
+	if product.Name != "Water jug" || product.IsActive || product.Stock != 7 || product.Variants[0].IsActive || product.Variants[0].Stock != 2 {
+		t.Fatalf("updated product active %v stock %d, variant %+v", product.IsActive, product.Stock, product.Variants[0])
+	}
+
+	// and so do keys left out of an NDJSON record
+	job = runJob(t, FormatNDJSON, fmt.Sprintf(`{"sku":%q,"name":"Jug","price":300,"categoryId":%q,"variants":[{"sku":"%s-S","name":"Size","value":"Small"}]}`, sku, slug, sku), false)
+	expectCounts(t, job, models.ImportStatusCompleted, 0, 1, 0)
+	product = productBySKU(t, sku)
+	if product.IsActive || product.Stock != 7 || product.Variants[0].IsActive || product.Variants[0].Stock != 2 {
+		t.Fatalf("product after NDJSON active %v stock %d, variant %+v", product.IsActive, product.Stock, product.Variants[0])
+	}
+
+	// a new product left without them is active with no stock
+	other := "CUP-" + uuid.New().String()[:8]
+	runJob(t, FormatNDJSON, fmt.Sprintf(`{"sku":%q,"name":"Cup","price":200,"categoryId":%q}`, other, slug), false)
+	if product := productBySKU(t, other); !product.IsActive || product.Stock != 0 {
+		t.Fatalf("new product active %v stock %d", product.IsActive, product.Stock)
+	}
+}
+
+func TestImportStockIsOnHand(t *testing.T) {
+	slug := newCategory(t)
+	sku := "BOWL-" + uuid.New().String()[:8]
+	header := "sku,name,price,stock,categoryId,variant.sku,variant.name,variant.value,variant.stock\n"
+	runJob(t, FormatCSV, header+fmt.Sprintf("%s,Bowl,400,10,%s,%s-S,Size,Small,5\n", sku, slug, sku), false)
+	product := productBySKU(t, sku)
+	variantId := product.Variants[0].Id
+
+	// unpaid orders took 3 of the product and 2 of the variant, a paid one 1 more
+	expires := time.Now().Add(time.Hour)
+	for _, reservation := range []models.StockReservation{
+		{ProductId: product.Id, Quantity: 3, Status: models.ReservationActive, ExpiresAt: &expires},
+		{ProductId: product.Id, VariantId: &variantId, Quantity: 2, Status: models.ReservationActive, ExpiresAt: &expires},
+		{ProductId: product.Id, Quantity: 1, Status: models.ReservationCommitted},
+	} {
+		reservation.OrderId, reservation.OrderItemId = uuid.New().String(), uuid.New().String()
+		if err := database.DB.Create(&reservation).Error; err != nil {
+			t.Fatalf("create reservation: %v", err)
+		}
+	}
+
+	runJob(t, FormatCSV, header+fmt.Sprintf("%s,Bowl,400,12,%s,%s-S,Size,Small,6\n", sku, slug, sku), false)
+	product = productBySKU(t, sku)
+	if product.Stock != 9 || product.Variants[0].Stock != 4 {
+		t.Fatalf("stock %d, variant stock %d, want 9 and 4", product.Stock, product.Variants[0].Stock)
+	}
+
+	// export counts the holds again, so the file imports back to the same stock
+	var out bytes.Buffer
+	if err := Export(context.Background(), &out, FormatNDJSON, func() error { return nil }); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	var exported string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, `"sku":"`+sku+`"`) {
+			exported = line
+		}
+	}
+	var record struct {
+		Stock    int `json:"stock"`
+		Variants []struct {
+			Stock int `json:"stock"`
+		} `json:"variants"`
+	}
+	if err := json.Unmarshal([]byte(exported), &record); err != nil {
+		t.Fatalf("exported line %q: %v", exported, err)
+	}
+	if record.Stock != 12 || record.Variants[0].Stock != 6 {
+		t.Fatalf("exported stock %d, variant stock %d, want 12 and 6", record.Stock, record.Variants[0].Stock)
+	}
+
+	expectCounts(t, runJob(t, FormatNDJSON, exported, false), models.ImportStatusCompleted, 0, 1, 0)
+	if product := productBySKU(t, sku); product.Stock != 9 || product.Variants[0].Stock != 4 {
+		t.Fatalf("stock after importing the export %d and %d, want 9 and 4", product.Stock, product.Variants[0].Stock)
+	}
+}
@@ -0,0 +1,394 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxLineSize is the longest NDJSON line read, a product with many variants fits easily.
+const maxLineSize = 1 << 20
+
+// productColumns and variantColumns are the fixed CSV columns in the order export writes them.
+var (
+	productColumns = []string{"sku", "name", "description", "price", "stock", "categoryId", "isActive",
+		"minStockLevel", "maxStockLevel", "reorderPoint", "barcode", "weight", "dimensions", "images"}
+	variantColumns = []string{"variant.sku", "variant.name", "variant.value", "variant.priceAdjustment",
+		"variant.stock", "variant.isActive"}
+)
+
+const (
+	attributePrefix        = "attr."
+	variantAttributePrefix = "variant.attr."
+	imageSeparator         = "|"
+)
+
+type recordReader interface {
+	// Next returns the next record, io.EOF after the last one, or a fileError.
+	Next() (*Record, error)
+}
+
+func newReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &ndjsonReader{scanner: scanner}, nil
+	case FormatCSV:
+		return newCSVReader(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// CountRecords reads the whole file and returns how many products it has. It fails on what stops
+// the file from being read at all, problems with single products are for the import to report.
+func CountRecords(format string, payload []byte) (int, error) {
+	rd, err := newReader(format, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		_, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonReader) Next() (*Record, error) {
+	for n.scanner.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		record := &Record{Line: n.line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record.Product); err != nil {
+			record.Problems = append(record.Problems, jsonProblem(err))
+			return record, nil
+		}
+
+		// a left out isActive or stock keeps the stored one, the product body can not tell
+		var given struct {
+			IsActive *bool `json:"isActive"`
+			Stock    *int  `json:"stock"`
+			Variants []struct {
+				Stock *int `json:"stock"`
+			} `json:"variants"`
+		}
+		// the line decoded into the stricter product already
+		_ = json.NewDecoder(bytes.NewReader(text)).Decode(&given)
+		if given.IsActive == nil {
+			record.omit("isActive")
+		}
+		if given.Stock == nil {
+			record.omit("stock")
+		}
+		for i, variant := range given.Variants {
+			if variant.Stock == nil {
+				record.omit(fmt.Sprintf("variants[%d].stock", i))
+			}
+		}
+		return record, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fileError(n.line+1, "is longer than %d bytes", maxLineSize)
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func jsonProblem(err error) cjson.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		expected := "a " + typeErr.Type.String()
+		switch typeErr.Type.Kind() {
+		case reflect.Int, reflect.Int64:
+			expected = "a whole number"
+		case reflect.Float64:
+			expected = "a number"
+		case reflect.Bool:
+			expected = "true or false"
+		case reflect.String:
+			expected = "text"
+		}
+		return cjson.FieldError{Field: typeErr.Field, Code: cjson.FieldInvalid, Message: fmt.Sprintf("%s must be %s", typeErr.Field, expected)}
+	}
+	return cjson.FieldError{Code: cjson.FieldInvalid, Message: "the line is not a valid product: " + err.Error()}
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+	index   map[string]int
+	// the line after the current product, read to see whether it was one of its variants
+	pending     []string
+	pendingLine int
+	pendingErr  error
+	hasVariants bool
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	c := &csvReader{r: csv.NewReader(r), index: map[string]int{}}
+	c.r.TrimLeadingSpace = true
+
+	header, err := c.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fileError(1, "the file is empty, a header line is required")
+	}
+	if err != nil {
+		return nil, fileError(1, "%v", err)
+	}
+
+	known := map[string]bool{}
+	for _, column := range append(append([]string{}, productColumns...), variantColumns...) {
+		known[column] = true
+	}
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if i == 0 {
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		isAttribute := strings.HasPrefix(column, attributePrefix) || strings.HasPrefix(column, variantAttributePrefix)
+		switch {
+		case !known[column] && !isAttribute:
+			return nil, fileError(1, "unknown column %q", column)
+		case column == attributePrefix || column == variantAttributePrefix:
+			return nil, fileError(1, "column %q needs an attribute key", column)
+		}
+		if _, ok := c.index[column]; ok {
+			return nil, fileError(1, "column %q appears twice", column)
+		}
+		c.index[column] = i
+		c.columns = append(c.columns, column)
+		if strings.HasPrefix(column, "variant.") {
+			c.hasVariants = true
+		}
+	}
+	if _, ok := c.index["sku"]; !ok {
+		return nil, fileError(1, "the sku column is required, products are matched by it")
+	}
+	c.r.FieldsPerRecord = len(header)
+	c.pending, c.pendingLine, c.pendingErr = c.read()
+	return c, nil
+}
+
+// read returns the next line, a line with the wrong number of cells comes back with an error
+// that only concerns it.
+func (c *csvReader) read() ([]string, int, error) {
+	row, err := c.r.Read()
+	if err == nil {
+		line, _ := c.r.FieldPos(0)
+		return row, line, nil
+	}
+	var parseErr *csv.ParseError
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil, 0, err
+	case errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount):
+		return row, parseErr.StartLine, err
+	case errors.As(err, &parseErr):
+		return nil, 0, fileError(parseErr.StartLine, "%v", parseErr.Err)
+	}
+	return nil, 0, err
+}
+
+func (c *csvReader) Next() (*Record, error) {
+	row, line, err := c.pending, c.pendingLine, c.pendingErr
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+		return nil, err
+	}
+
+	record := &Record{Line: line}
+	sku := c.cell(row, "sku")
+	c.readProduct(record, row, err)
+	c.readVariant(record, row, line, err)
+
+	for {
+		c.pending, c.pendingLine, c.pendingErr = c.read()
+		if c.pendingErr != nil && !errors.Is(c.pendingErr, csv.ErrFieldCount) {
+			break
+		}
+		if sku == "" || c.cell(c.pending, "sku") != sku {
+			break
+		}
+		c.readVariant(record, c.pending, c.pendingLine, c.pendingErr)
+	}
+	return record, nil
+}
+
+func (c *csvReader) cell(row []string, column string) string {
+	i, ok := c.index[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (c *csvReader) readProduct(record *Record, row []string, rowErr error) {
+	if rowErr != nil {
+		record.Problems = append(record.Problems, cjson.FieldError{Code: cjson.FieldInvalid, Message: fmt.Sprintf("line %d has %d columns, the header has %d", record.Line, len(row), len(c.columns))})
+	}
+	cells := cellReader{c: c, row: row, record: record}
+	p := &record.Product
+	p.SKU = c.cell(row, "sku")
+	p.Name = c.cell(row, "name")
+	p.Description = c.cell(row, "description")
+	p.Price = cells.int("price", "price")
+	p.Stock = cells.int("stock", "stock")
+	if c.cell(row, "stock") == "" {
+		record.omit("stock")
+	}
+	p.CategoryId = c.cell(row, "categoryId")
+	if isActive := cells.bool("isActive", "isActive"); isActive != nil {
+		p.IsActive = *isActive
+	} else {
+		record.omit("isActive")
+	}
+	p.MinStockLevel = cells.int("minStockLevel", "minStockLevel")
+	p.MaxStockLevel = cells.int("maxStockLevel", "maxStockLevel")
+	p.ReorderPoint = cells.int("reorderPoint", "reorderPoint")
+	p.Barcode = c.cell(row, "barcode")
+	p.Weight = cells.float("weight", "weight")
+	p.Dimensions = c.cell(row, "dimensions")
+
+	if _, ok := c.index["images"]; ok {
+		p.Images = []dto.ImageMode{}
+		for _, url := range strings.Split(c.cell(row, "images"), imageSeparator) {
+			if url = strings.TrimSpace(url); url != "" {
+				p.Images = append(p.Images, dto.ImageMode{URL: url})
+			}
+		}
+	}
+	p.Attributes = c.attributes(row, attributePrefix)
+	if c.hasVariants {
+		p.Variants = []dto.ProductVariantDTO{}
+	}
+}
+
+// readVariant adds the variant on the line to the record, if the line has one.
+func (c *csvReader) readVariant(record *Record, row []string, line int, rowErr error) {
+	if line != record.Line && rowErr != nil {
+		record.Problems = append(record.Problems, cjson.FieldError{Code: cjson.FieldInvalid, Message: fmt.Sprintf("line %d has %d columns, the header has %d", line, len(row), len(c.columns))})
+	}
+	empty := true
+	for _, column := range c.columns {
+		if strings.HasPrefix(column, "variant.") && c.cell(row, column) != "" {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return
+	}
+
+	prefix := fmt.Sprintf("variants[%d].", len(record.Product.Variants))
+	cells := cellReader{c: c, row: row, record: record}
+	record.Product.Variants = append(record.Product.Variants, dto.ProductVariantDTO{
+		SKU:             c.cell(row, "variant.sku"),
+		Name:            c.cell(row, "variant.name"),
+		Value:           c.cell(row, "variant.value"),
+		PriceAdjustment: cells.int("variant.priceAdjustment", prefix+"priceAdjustment"),
+		Stock:           cells.int("variant.stock", prefix+"stock"),
+		IsActive:        cells.bool("variant.isActive", prefix+"isActive"),
+		Attributes:      c.attributes(row, variantAttributePrefix),
+	})
+	if c.cell(row, "variant.stock") == "" {
+		record.omit(prefix + "stock")
+	}
+}
+
+// attributes collects the attr.<key> (or variant.attr.<key>) cells of the line, nil when the file
+// has no such column. An empty cell is no value.
+func (c *csvReader) attributes(row []string, prefix string) map[string]any {
+	var values map[string]any
+	for _, column := range c.columns {
+		key, ok := strings.CutPrefix(column, prefix)
+		// attr. is also the end of variant.attr.
+		if !ok || (prefix == attributePrefix && strings.HasPrefix(column, variantAttributePrefix)) {
+			continue
+		}
+		if values == nil {
+			values = map[string]any{}
+		}
+		if value := c.cell(row, column); value != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// cellReader reads typed cells, a cell that does not parse becomes a problem of the record.
+type cellReader struct {
+	c      *csvReader
+	row    []string
+	record *Record
+}
+
+func (r cellReader) problem(field, message string) {
+	r.record.Problems = append(r.record.Problems, cjson.FieldError{Field: field, Code: cjson.FieldInvalid, Message: field + " " + message})
+}
+
+func (r cellReader) int(column, field string) int {
+	value := r.c.cell(r.row, column)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		r.problem(field, "must be a whole number")
+	}
+	return n
+}
+
+func (r cellReader) float(column, field string) float64 {
+	value := r.c.cell(r.row, column)
+	if value == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.problem(field, "must be a number")
+	}
+	return f
+}
+
+func (r cellReader) bool(column, field string) *bool {
+	value := r.c.cell(r.row, column)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.problem(field, "must be true or false")
+		return nil
+	}
+	return &b
+}
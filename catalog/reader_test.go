@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, format, payload string) []*Record {
+	t.Helper()
+	rd, err := newReader(format, strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var records []*Record
+	for {
+		record, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func problemFields(record *Record) []string {
+	fields := make([]string, len(record.Problems))
+	for i, problem := range record.Problems {
+		fields[i] = problem.Field
+	}
+	return fields
+}
+
+func TestCSVGroupsVariantsBySKU(t *testing.T) {
+	payload := "\ufeffsku,name,price,stock,categoryId,isActive,images,attr.material,variant.sku,variant.name,variant.value,variant.stock,variant.attr.size\n" +
+		"LAMP-1,Desk lamp,1500,4,lighting,true,https://cdn.example.com/a.jpg|https://cdn.example.com/b.jpg,brass,LAMP-1-S,Size,Small,2,S\n" +
+		"LAMP-1,ignored,,,,,,,LAMP-1-L,Size,Large,3,L\n" +
+		"RUG-1,Rug,900,,decor,,,,,,,,\n"
+
+	records := readAll(t, FormatCSV, payload)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	lamp := records[0]
+	if lamp.Line != 2 || len(lamp.Problems) != 0 {
+		t.Fatalf("lamp line %d, problems %v", lamp.Line, lamp.Problems)
+	}
+	p := lamp.Product
+	if p.SKU != "LAMP-1" || p.Name != "Desk lamp" || p.Price != 1500 || p.Stock != 4 || p.CategoryId != "lighting" || !p.IsActive {
+		t.Fatalf("lamp product = %+v", p)
+	}
+	if len(p.Images) != 2 || p.Images[1].URL != "https://cdn.example.com/b.jpg" {
+		t.Fatalf("lamp images = %+v", p.Images)
+	}
+	if p.Attributes["material"] != "brass" {
+		t.Fatalf("lamp attributes = %v", p.Attributes)
+	}
+	if len(p.Variants) != 2 || p.Variants[1].SKU != "LAMP-1-L" || p.Variants[1].Stock != 3 || p.Variants[1].Attributes["size"] != "L" {
+		t.Fatalf("lamp variants = %+v", p.Variants)
+	}
+
+	rug := records[1]
+	if rug.Line != 4 || rug.Product.Stock != 0 || len(rug.Product.Variants) != 0 || rug.Product.Variants == nil {
+		t.Fatalf("rug = %+v", rug)
+	}
+	if _, ok := rug.Product.Attributes["material"]; ok {
+		t.Fatalf("an empty cell became the value %v", rug.Product.Attributes["material"])
+	}
+}
+
+func TestCSVCellProblems(t *testing.T) {
+	payload := "sku,name,price,isActive,variant.name,variant.value,variant.stock\n" +
+		"A-1,Lamp,cheap,maybe,Size,Small,2\n" +
+		"A-1,,,,Size,Large,many\n" +
+		"B-1,Rug,900\n" +
+		"C-1,Vase,700,true,,,\n"
+
+	records := readAll(t, FormatCSV, payload)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	if got := strings.Join(problemFields(records[0]), ","); got != "price,isActive,variants[1].stock" {
+		t.Fatalf("problems of A-1 are on %q", got)
+	}
+	if records[0].Problems[0].Message != "price must be a whole number" {
+		t.Fatalf("message = %q", records[0].Problems[0].Message)
+	}
+	// a line with a missing cell is reported, the next product is still read
+	if len(records[1].Problems) != 1 || !strings.Contains(records[1].Problems[0].Message, "line 4 has 3 columns") {
+		t.Fatalf("problems of B-1 = %v", records[1].Problems)
+	}
+	if records[2].Product.SKU != "C-1" || len(records[2].Problems) != 0 {
+		t.Fatalf("C-1 = %+v", records[2])
+	}
+}
+
+func TestCSVHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name, payload, want string
+	}{
+		{"empty file", "", "line 1: the file is empty"},
+		{"no sku", "name,price\nLamp,100\n", "the sku column is required"},
+		{"unknown column", "sku,colour\nA,red\n", `unknown column "colour"`},
+		{"twice", "sku,name,name\nA,B,C\n", `column "name" appears twice`},
+		{"attribute without key", "sku,attr.\nA,B\n", `column "attr." needs an attribute key`},
+		{"bad quote", "sku,name\nA,\"Lamp\n", "line 2:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CountRecords(FormatCSV, []byte(tt.payload))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	count, err := CountRecords(FormatCSV, []byte("sku,variant.name,variant.value\nA,Size,S\nA,Size,M\nB,,\n"))
+	if err != nil || count != 2 {
+		t.Fatalf("csv: count %d, err %v", count, err)
+	}
+	count, err = CountRecords(FormatNDJSON, []byte("{\"sku\":\"A\"}\n\n{\"sku\":\"B\"}\nnot json\n"))
+	if err != nil || count != 3 {
+		t.Fatalf("ndjson: count %d, err %v", count, err)
+	}
+	if _, err := CountRecords("xml", nil); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("xml: err %v", err)
+	}
+}
+
+func TestNDJSONProblems(t *testing.T) {
+	payload := `{"sku":"A","name":"Lamp","price":1500,"variants":[{"name":"Size","value":"S","isActive":false}]}` + "\n" +
+		`{"sku":"B","price":"cheap"}` + "\n" +
+		`{"sku":"C","isActive":"yes"}` + "\n" +
+		`{"sku":"D","colour":"red"}` + "\n" +
+		`{"sku":"E",` + "\n"
+
+	records := readAll(t, FormatNDJSON, payload)
+	if len(records) != 5 {
+		t.Fatalf("got %d records, want 5", len(records))
+	}
+	lamp := records[0]
+	if len(lamp.Problems) != 0 || lamp.Product.Price != 1500 || len(lamp.Product.Variants) != 1 || *lamp.Product.Variants[0].IsActive {
+		t.Fatalf("lamp = %+v", lamp)
+	}
+
+	want := []struct {
+		line          int
+		field, prefix string
+	}{
+		{2, "price", "price must be a whole number"},
+		{3, "isActive", "isActive must be true or false"},
+		{4, "", "the line is not a valid product"},
+		{5, "", "the line is not a valid product"},
+	}
+	for i, w := range want {
+		record := records[i+1]
+		if record.Line != w.line || len(record.Problems) != 1 {
+			t.Fatalf("record %d: line %d, problems %v", i+1, record.Line, record.Problems)
+		}
+		if problem := record.Problems[0]; problem.Field != w.field || !strings.HasPrefix(problem.Message, w.prefix) {
+			t.Fatalf("line %d: problem %+v, want %q on %q", w.line, problem, w.prefix, w.field)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cjson
+
+import "net/http"
+
+// ProblemTypeBase prefixes the type URI of problems that carry a specific code.
+const ProblemTypeBase = "urn:sibling-bond:problem:"
+
+// Generic codes, used when a handler does not set a more specific one.
+const (
+	CodeBadRequest         = "BAD_REQUEST"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeNotFound           = "NOT_FOUND"
+	CodeConflict           = "CONFLICT"
+	CodeTooManyRequests    = "TOO_MANY_REQUESTS"
+	CodeInternal           = "INTERNAL_ERROR"
+	CodeUpstream           = "UPSTREAM_ERROR"
+	CodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+)
+
+// Request problems.
+const (
+	CodeInvalidBody      = "INVALID_REQUEST_BODY"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeMissingParameter = "MISSING_PARAMETER"
+	CodeBodyTooLarge     = "REQUEST_BODY_TOO_LARGE"
+)
+
+// Field level codes used in FieldError.Code.
+const (
+	FieldRequired = "REQUIRED"
+	FieldInvalid  = "INVALID"
+	FieldTooSmall = "TOO_SMALL"
+	FieldTooLarge = "TOO_LARGE"
+)
+
+// Auth.
+const (
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeTokenInvalid       = "TOKEN_INVALID"
+	CodeTokenRevoked       = "TOKEN_REVOKED"
+	CodeRefreshTokenReused = "REFRESH_TOKEN_REUSED"
+	CodeAdminRequired      = "ADMIN_REQUIRED"
+	CodeResetTokenMissing  = "RESET_TOKEN_MISSING"
+	CodeResetTokenInvalid  = "RESET_TOKEN_INVALID"
+	CodeEmailTaken         = "EMAIL_TAKEN"
+	CodeRateLimited        = "RATE_LIMITED"
+)
+
+// Shop.
+const (
+	CodeProductNotFound         = "PRODUCT_NOT_FOUND"
+	CodeCartEmpty               = "CART_EMPTY"
+	CodeCartItemOutOfStock      = "CART_ITEM_OUT_OF_STOCK"
+	CodeCouponInvalid           = "COUPON_INVALID"
+	CodeOrderNotFound           = "ORDER_NOT_FOUND"
+	CodeIllegalStatusTransition = "ORDER_STATUS_TRANSITION_NOT_ALLOWED"
+	CodeRefundExceedsPaid       = "REFUND_EXCEEDS_PAID"
+	CodeRefundInProgress        = "REFUND_IN_PROGRESS"
+	CodeReturnStatusChanged     = "RETURN_STATUS_CHANGED"
+	CodePaymentProviderError    = "PAYMENT_PROVIDER_ERROR"
+	CodePaymentProviderUnknown  = "PAYMENT_PROVIDER_UNAVAILABLE"
+	CodeCategoryHasProducts     = "CATEGORY_HAS_PRODUCTS"
+	CodeCategorySlugTaken       = "CATEGORY_SLUG_TAKEN"
+	CodeAttributeKeyTaken       = "ATTRIBUTE_KEY_TAKEN"
+	CodeAttributeOptionInUse    = "ATTRIBUTE_OPTION_IN_USE"
+	CodeImportFileInvalid       = "IMPORT_FILE_INVALID"
+)
+
+// CodeForStatus is the generic code for an HTTP status.
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return CodeUpstream
+	case http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
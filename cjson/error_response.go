@@ -1,17 +1,43 @@
 package cjson
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
 
-type ErrorResponse struct {
-	Status        int    `json:"status"`
-	Message       string `json:"message"`
-	InternalError error  `json:"internalError"`
+/*
+	Errors leave the API as RFC 7807 problem details (application/problem+json). Code is the stable,
+	machine-readable part clients should branch on; Detail is for humans and may change. The
+	internal error is only ever logged, never sent.
+*/
+
+// Problem is the RFC 7807 body. Message repeats Detail for clients written against the old envelope.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestId string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Message   string       `json:"message,omitempty"`
+}
+
+// FieldError points at one invalid field of the request, Field uses the JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 type HTTPError struct {
-	Status        int    `json:"status"`
-	Message       string `json:"message"`
-	InternalError error  `json:"-"`
+	Status        int          `json:"status"`
+	Code          string       `json:"code,omitempty"`
+	Message       string       `json:"message"`
+	Fields        []FieldError `json:"errors,omitempty"`
+	InternalError error        `json:"-"`
 }
 
 func (h *HTTPError) Error() string {
@@ -21,6 +47,38 @@ func (h *HTTPError) Error() string {
 	return h.Message
 }
 
+func (h *HTTPError) Unwrap() error {
+	return h.InternalError
+}
+
+// ErrorCode is the explicit Code or, when none was set, the generic code for the status.
+func (h *HTTPError) ErrorCode() string {
+	if h.Code != "" {
+		return h.Code
+	}
+	return CodeForStatus(h.Status)
+}
+
+// Problem renders the error for the client. instance is the request path.
+func (h *HTTPError) Problem(instance, requestId string) Problem {
+	code := h.ErrorCode()
+	problemType := "about:blank"
+	if code != CodeForStatus(h.Status) {
+		problemType = ProblemTypeBase + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+	}
+	return Problem{
+		Type:      problemType,
+		Title:     http.StatusText(h.Status),
+		Status:    h.Status,
+		Detail:    h.Message,
+		Instance:  instance,
+		Code:      code,
+		RequestId: requestId,
+		Errors:    h.Fields,
+		Message:   h.Message,
+	}
+}
+
 func NewError(status int, message string, internalError error) *HTTPError {
 	return &HTTPError{
 		Status:        status,
@@ -28,3 +86,13 @@ func NewError(status int, message string, internalError error) *HTTPError {
 		InternalError: internalError,
 	}
 }
+
+// NewValidationError is a 400 listing every invalid field at once.
+func NewValidationError(fields []FieldError) *HTTPError {
+	return &HTTPError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeValidationFailed,
+		Message: "Some fields are not valid",
+		Fields:  fields,
+	}
+}
@@ -10,3 +10,10 @@ func WriteJSON(w http.ResponseWriter, status int, data any) error {
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(data)
 }
+
+// WriteProblem answers with an RFC 7807 problem document.
+func WriteProblem(w http.ResponseWriter, problem Problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	return json.NewEncoder(w).Encode(problem)
+}
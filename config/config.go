@@ -0,0 +1,379 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"net/netip"
+	"os"
+	"time"
+)
+
+/*
+	Configuration is layered, later sources win:
+		1. defaults below
+		2. YAML file (--config flag, CONFIG_FILE, or ./config.yaml when present)
+		3. .env file
+		4. environment variables named in the `env` tags
+
+	Fields tagged `secret:"true"` are redacted by Redacted.
+*/
+
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Payment   PaymentConfig   `yaml:"payment"`
+	ImageKit  ImageKitConfig  `yaml:"imagekit"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Mail      MailConfig      `yaml:"mail"`
+	Search    SearchConfig    `yaml:"search"`
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"SERVER_ADDR"`
+	TLS               TLSConfig     `yaml:"tls"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies in front of the server. Only
+	// requests from them have their client address read from X-Forwarded-For.
+	TrustedProxies []string `yaml:"trustedProxies" env:"SERVER_TRUSTED_PROXIES"`
+	// ShutdownDrainDelay is how long the server keeps serving after readiness turns to 503, so load
+	// balancers stop sending new requests before the listener closes.
+	ShutdownDrainDelay time.Duration `yaml:"shutdownDrainDelay" env:"SERVER_SHUTDOWN_DRAIN_DELAY"`
+	// ShutdownTimeout is how long in-flight requests get to finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, a plain address becomes a single address prefix.
+func (c ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, entry := range c.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("server.trustedProxies: %q is not an address or CIDR", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled" env:"TLS_ENABLED"`
+	CertFile string `yaml:"certFile" env:"TLS_CERT_FILE"`
+	KeyFile  string `yaml:"keyFile" env:"TLS_KEY_FILE"`
+}
+
+type DatabaseConfig struct {
+	Driver          string        `yaml:"driver" env:"DB_DRIVER"`
+	DSN             string        `yaml:"dsn" env:"DB_DSN" secret:"true"`
+	MaxOpenConns    int           `yaml:"maxOpenConns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"maxIdleConns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" env:"DB_CONN_MAX_LIFETIME"`
+	AutoMigrate     bool          `yaml:"autoMigrate" env:"DB_AUTO_MIGRATE"`
+}
+
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL" env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL" env:"REFRESH_TOKEN_TTL"`
+	JWTSecret       string        `yaml:"jwtSecret" env:"JWT_SECRET" secret:"true"`
+	JWTKeysDir      string        `yaml:"jwtKeysDir" env:"JWT_KEYS_DIR"`
+	JWTActiveKid    string        `yaml:"jwtActiveKid" env:"JWT_ACTIVE_KID"`
+	CartTokenSecret string        `yaml:"cartTokenSecret" env:"CART_TOKEN_SECRET" secret:"true"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins" env:"CORS_ALLOWED_ORIGINS"`
+}
+
+type PaymentConfig struct {
+	Provider      string `yaml:"provider" env:"PAYMENT_PROVIDER"`
+	WebhookSecret string `yaml:"webhookSecret" env:"PAYMENT_WEBHOOK_SECRET" secret:"true"`
+}
+
+type ImageKitConfig struct {
+	PublicKey   string `yaml:"publicKey" env:"IMAGEKIT_PUBLIC_KEY"`
+	PrivateKey  string `yaml:"privateKey" env:"IMAGEKIT_PRIVATE_KEY" secret:"true"`
+	URLEndpoint string `yaml:"urlEndpoint" env:"IMAGEKIT_URL_ENDPOINT"`
+}
+
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" env:"METRICS_ENABLED"`
+	// Addr is the internal listener /metrics is served on. Left empty, /metrics moves to the
+	// public listener and Token is required.
+	Addr string `yaml:"addr" env:"METRICS_ADDR"`
+	// Token, when set, has to be sent as a Bearer token to read /metrics.
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// RateLimitConfig throttles login, register and forgot-password. Each limit allows Requests per
+// Period and refills evenly, zero requests turns that limit off.
+type RateLimitConfig struct {
+	Enabled         bool          `yaml:"enabled" env:"RATE_LIMIT_ENABLED"`
+	IPRequests      int           `yaml:"ipRequests" env:"RATE_LIMIT_IP_REQUESTS"`
+	IPPeriod        time.Duration `yaml:"ipPeriod" env:"RATE_LIMIT_IP_PERIOD"`
+	AccountRequests int           `yaml:"accountRequests" env:"RATE_LIMIT_ACCOUNT_REQUESTS"`
+	AccountPeriod   time.Duration `yaml:"accountPeriod" env:"RATE_LIMIT_ACCOUNT_PERIOD"`
+	// LockoutThreshold failed passwords in a row lock the account for LockoutDuration, doubling
+	// with every further failure up to LockoutMaxDuration.
+	LockoutThreshold   int           `yaml:"lockoutThreshold" env:"LOCKOUT_THRESHOLD"`
+	LockoutDuration    time.Duration `yaml:"lockoutDuration" env:"LOCKOUT_DURATION"`
+	LockoutMaxDuration time.Duration `yaml:"lockoutMaxDuration" env:"LOCKOUT_MAX_DURATION"`
+}
+
+// MailConfig picks how queued emails leave the server: smtp, file (.eml files in FileDir) or log.
+type MailConfig struct {
+	Driver       string `yaml:"driver" env:"MAIL_DRIVER"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	SMTPHost     string `yaml:"smtpHost" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtpPort" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtpUsername" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtpPassword" env:"SMTP_PASSWORD" secret:"true"`
+	FileDir      string `yaml:"fileDir" env:"MAIL_FILE_DIR"`
+	// BaseURL is the storefront address links in emails point to, e.g. the password reset page.
+	BaseURL string `yaml:"baseURL" env:"APP_BASE_URL"`
+}
+
+// SearchConfig picks the product search index. memory keeps an inverted index in process and
+// rebuilds it every RefreshInterval, mysql uses the FULLTEXT indexes and needs the mysql driver.
+type SearchConfig struct {
+	Backend         string        `yaml:"backend" env:"SEARCH_BACKEND"`
+	RefreshInterval time.Duration `yaml:"refreshInterval" env:"SEARCH_REFRESH_INTERVAL"`
+}
+
+func (c ImageKitConfig) Enabled() bool {
+	return c.PublicKey != "" && c.PrivateKey != "" && c.URLEndpoint != ""
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:               ":5000",
+			ReadHeaderTimeout:  5 * time.Second,
+			ReadTimeout:        15 * time.Second,
+			WriteTimeout:       30 * time.Second,
+			IdleTimeout:        60 * time.Second,
+			ShutdownDrainDelay: 5 * time.Second,
+			ShutdownTimeout:    20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver:          "mysql",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			AutoMigrate:     true,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+		},
+		Payment: PaymentConfig{
+			Provider: "fake",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Addr:    "127.0.0.1:9100",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:            true,
+			IPRequests:         20,
+			IPPeriod:           time.Minute,
+			AccountRequests:    10,
+			AccountPeriod:      15 * time.Minute,
+			LockoutThreshold:   5,
+			LockoutDuration:    time.Minute,
+			LockoutMaxDuration: time.Hour,
+		},
+		Mail: MailConfig{
+			Driver:   "log",
+			From:     "Sibling Bond <no-reply@siblingbond.local>",
+			SMTPPort: 587,
+			FileDir:  "tmp/mail",
+			BaseURL:  "http://localhost:3000",
+		},
+		Search: SearchConfig{
+			Backend:         "memory",
+			RefreshInterval: 5 * time.Minute,
+		},
+	}
+}
+
+var current = Default()
+
+// Get returns the configuration loaded at startup.
+func Get() *Config {
+	return current
+}
+
+// Load builds the configuration from every source and validates it. path may be empty.
+// Only a valid configuration becomes the one returned by Get.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		if _, err := os.Stat("config.yaml"); err == nil {
+			path = "config.yaml"
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	// a missing .env is normal outside development
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	// the config is still returned so --print-config can show what was wrong
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	current = cfg
+	return cfg, nil
+}
+
+// Validate reports every problem at once so a bad deployment can be fixed in one go.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr (SERVER_ADDR) is required"))
+	}
+	if c.Server.TLS.Enabled && (c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("server.tls.certFile and server.tls.keyFile are required when TLS is enabled"))
+	}
+	srv := c.Server
+	if srv.ReadHeaderTimeout < 0 || srv.ReadTimeout < 0 || srv.WriteTimeout < 0 || srv.IdleTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts can not be negative"))
+	}
+	if _, err := srv.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, err)
+	}
+	if srv.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("server.shutdownDrainDelay can not be negative"))
+	}
+	if srv.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdownTimeout must be positive"))
+	}
+
+	switch c.Database.Driver {
+	case "mysql", "postgres":
+		if c.Database.DSN == "" {
+			errs = append(errs, errors.New("database.dsn (DB_DSN) is required"))
+		}
+	case "sqlite":
+		// an empty DSN runs on an in-memory database
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q must be mysql, postgres or sqlite", c.Database.Driver))
+	}
+	if c.Metrics.Enabled && c.Metrics.Addr == "" && c.Metrics.Token == "" {
+		errs = append(errs, errors.New("metrics.token (METRICS_TOKEN) is required when metrics.addr is empty and /metrics is served on the public listener"))
+	}
+	if c.Metrics.Enabled && c.Metrics.Addr != "" && c.Metrics.Addr == c.Server.Addr {
+		errs = append(errs, errors.New("metrics.addr must differ from server.addr"))
+	}
+
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database pool sizes can not be negative"))
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database.maxIdleConns can not exceed database.maxOpenConns"))
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database.connMaxLifetime can not be negative"))
+	}
+
+	if c.Auth.AccessTokenTTL <= 0 {
+		errs = append(errs, errors.New("auth.accessTokenTTL must be positive"))
+	}
+	if c.Auth.RefreshTokenTTL <= c.Auth.AccessTokenTTL {
+		errs = append(errs, errors.New("auth.refreshTokenTTL must be longer than auth.accessTokenTTL"))
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		errs = append(errs, errors.New("auth.jwtSecret must be at least 32 characters"))
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowedOrigins needs at least one origin"))
+	}
+
+	if c.Payment.Provider == "" {
+		errs = append(errs, errors.New("payment.provider (PAYMENT_PROVIDER) is required"))
+	}
+
+	ik := c.ImageKit
+	if (ik.PublicKey != "" || ik.PrivateKey != "" || ik.URLEndpoint != "") && !ik.Enabled() {
+		errs = append(errs, errors.New("imagekit needs publicKey, privateKey and urlEndpoint together"))
+	}
+
+	rl := c.RateLimit
+	if rl.IPRequests < 0 || rl.AccountRequests < 0 || rl.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("rateLimit requests and lockoutThreshold can not be negative"))
+	}
+	if (rl.IPRequests > 0 && rl.IPPeriod <= 0) || (rl.AccountRequests > 0 && rl.AccountPeriod <= 0) {
+		errs = append(errs, errors.New("rateLimit periods must be positive"))
+	}
+	if rl.LockoutThreshold > 0 && (rl.LockoutDuration <= 0 || rl.LockoutMaxDuration < rl.LockoutDuration) {
+		errs = append(errs, errors.New("rateLimit.lockoutDuration must be positive and not above lockoutMaxDuration"))
+	}
+
+	mail := c.Mail
+	switch mail.Driver {
+	case "smtp":
+		if mail.SMTPHost == "" || mail.SMTPPort <= 0 {
+			errs = append(errs, errors.New("mail.smtpHost (SMTP_HOST) and a positive mail.smtpPort are required for the smtp driver"))
+		}
+	case "file", "log":
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver %q must be smtp, file or log", mail.Driver))
+	}
+	if mail.From == "" {
+		errs = append(errs, errors.New("mail.from (MAIL_FROM) is required"))
+	}
+	if mail.BaseURL == "" {
+		errs = append(errs, errors.New("mail.baseURL (APP_BASE_URL) is required"))
+	}
+
+	switch c.Search.Backend {
+	case "memory":
+		if c.Search.RefreshInterval <= 0 {
+			errs = append(errs, errors.New("search.refreshInterval must be positive"))
+		}
+	case "mysql":
+		if c.Database.Driver != "mysql" {
+			errs = append(errs, errors.New("search.backend mysql needs database.driver mysql"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("search.backend %q must be memory or mysql", c.Search.Backend))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
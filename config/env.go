@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redactedValue = "******"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides every field that has an `env` tag and a non-empty variable.
+func applyEnv(cfg *Config) error {
+	return walk(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) error {
+		name := tag.Get("env")
+		if name == "" {
+			return nil
+		}
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			return nil
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func walk(v reflect.Value, fn func(field reflect.Value, tag reflect.StructTag) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		tag := v.Type().Field(i).Tag
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			if err := walk(field, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config field type %s", field.Type())
+	}
+	return nil
+}
+
+// Redacted returns a copy with every secret replaced, safe to log or print.
+func (c *Config) Redacted() *Config {
+	clone := *c
+	clone.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+	_ = walk(reflect.ValueOf(&clone).Elem(), func(field reflect.Value, tag reflect.StructTag) error {
+		if tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redactedValue)
+		}
+		return nil
+	})
+	return &clone
+}
+
+// Dump renders the redacted configuration as YAML, the format of the config file.
+func (c *Config) Dump() (string, error) {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
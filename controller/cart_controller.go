@@ -1,11 +1,15 @@
 package controller
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/pratyush934/sibling-bond-server/cjson"
 	"github.com/pratyush934/sibling-bond-server/database"
 	"github.com/pratyush934/sibling-bond-server/dto"
 	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/utils"
+	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 )
@@ -19,70 +23,159 @@ RemoveFromCart - Remove specific item from cart
 ClearCart - Remove all items from user's cart
 GetCartTotal - Calculate total price of items in cart
 ValidateCartItems - Check if cart items are still available in inventory
+MergeCart - Move a guest cart into the logged-in user's cart
+
+Carts work for guests too: without a Bearer token the cart is found through the signed cart token.
 */
 
-func GetCart(w http.ResponseWriter, r *http.Request) {
+// requestCart resolves the cart for the request: the user's cart when logged in, otherwise the
+// guest cart from the cart token. With create set, a missing cart is created and guests get a new token.
+func requestCart(w http.ResponseWriter, r *http.Request, create bool) *models.Cart {
+	if userId, ok := r.Context().Value("userId").(string); ok && userId != "" {
+		cart, err := models.GetCartByUserId(r.Context(), userId)
+		if err == nil {
+			return cart
+		}
+		if !create {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusNotFound,
+				Message:       "Not able to get the CartById",
+				InternalError: err,
+			})
+		}
+		cart, err = models.Create(r.Context(), &models.Cart{UserId: &userId})
+		if err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Not able to create Cart",
+				InternalError: err,
+			})
+		}
+		return cart
+	}
 
-	userId, ok := r.Context().Value("userId").(string)
+	if token := utils.GetCartTokenFromRequest(r); token != "" {
+		cartId, err := utils.ParseCartToken(token)
+		if err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusUnauthorized,
+				Message:       "Invalid cart token",
+				InternalError: err,
+			})
+		}
+		// a token for a cart that was merged or deleted falls through to a fresh guest cart
+		if cart, err := models.GetCartById(r.Context(), cartId); err == nil && cart.IsGuest() {
+			return cart
+		}
+	}
 
-	if userId == "" || !ok {
+	if !create {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
-			Message:       "Not able to get the UserId",
+			Message:       "Cart not found",
 			InternalError: nil,
 		})
 	}
 
-	cartById, err := models.GetCartByUserId(userId)
+	cart, err := models.Create(r.Context(), &models.Cart{})
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the CartById",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to create guest Cart",
 			InternalError: err,
 		})
 	}
-	_ = cjson.WriteJSON(w, http.StatusOK, cartById)
+	utils.SetCartTokenCookie(w, cart.Id)
+	return cart
 }
 
-func CreateCart(w http.ResponseWriter, r *http.Request) {
-	userId, ok := r.Context().Value("userId").(string)
+// canAccessCart reports whether the request owns the cart, either as its user or through the guest cart token.
+func canAccessCart(r *http.Request, cart *models.Cart) bool {
+	if !cart.IsGuest() {
+		userId, ok := r.Context().Value("userId").(string)
+		return ok && userId != "" && cart.OwnerId() == userId
+	}
+	cartId, err := utils.ParseCartToken(utils.GetCartTokenFromRequest(r))
+	return err == nil && cartId == cart.Id
+}
 
-	if !ok {
+// priceCartLine works out the unit price of a cart line on the server: the product's price plus the
+// selected variant's adjustment. It returns the variant id to store on the line, nil when none was chosen,
+// and the stock available for it.
+func priceCartLine(ctx context.Context, productId, variantId string) (int, *string, int) {
+	product, err := models.GetProductById(ctx, productId)
+	if err != nil || !product.IsActive {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "Not able to get the Id from the User",
-			InternalError: nil,
+			Status:        http.StatusNotFound,
+			Code:          cjson.CodeProductNotFound,
+			Message:       "Product not found",
+			InternalError: err,
 		})
 	}
 
-	var cartModel dto.CartDataModel
+	if variantId == "" {
+		return product.Price, nil, product.Stock
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&cartModel); err != nil {
+	variant, err := models.GetVariantById(ctx, productId, variantId)
+	if err != nil || !variant.IsActive {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
-			Message:       "Not able to decode the cartModel",
+			Message:       "Variant not found for this product",
 			InternalError: err,
 		})
 	}
+	return variant.UnitPrice(product.Price), &variant.Id, variant.Stock
+}
 
-	newCart := models.Cart{
-		UserId: userId,
+// checkCartStock refuses a cart line whose quantity the stock can not cover.
+func checkCartStock(quantity, stock int) {
+	if quantity > stock {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusConflict,
+			Code:          cjson.CodeCartItemOutOfStock,
+			Message:       fmt.Sprintf("Only %d left in stock", stock),
+			InternalError: nil,
+		})
 	}
+}
+
+func GetCart(w http.ResponseWriter, r *http.Request) {
+
+	cart := requestCart(w, r, false)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, cart)
+}
+
+func CreateCart(w http.ResponseWriter, r *http.Request) {
+
+	var cartModel dto.CartDataModel
+
+	decodeBody(r, &cartModel, "Not able to decode the cartModel")
+	validateBody(&cartModel)
 
-	if len(cartModel.CartItems) > 0 {
-		cartitems := make([]models.CartItem, 0, len(cartModel.CartItems))
+	newCart := requestCart(w, r, true)
 
-		for _, v := range cartModel.CartItems {
-			cartitems = append(cartitems, models.CartItem{
-				ProductId:     v.ProductId,
-				Quantity:      v.Quantity,
-				PriceAtAdding: v.PriceAtAdding,
+	for _, v := range cartModel.CartItems {
+		price, variantId, stock := priceCartLine(r.Context(), v.ProductId, v.VariantId)
+		checkCartStock(v.Quantity, stock)
+		cartItem := models.CartItem{
+			CartId:        newCart.Id,
+			ProductId:     v.ProductId,
+			VariantId:     variantId,
+			Quantity:      v.Quantity,
+			PriceAtAdding: price,
+		}
+		if _, err := models.AddItem(r.Context(), &cartItem); err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Not able to get it",
+				InternalError: err,
 			})
 		}
-		newCart.CartItems = cartitems
 	}
 
-	cartCreated, err := models.Create(&newCart)
+	cartCreated, err := models.GetCartById(r.Context(), newCart.Id)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
@@ -97,47 +190,21 @@ func CreateCart(w http.ResponseWriter, r *http.Request) {
 
 func AddToCart(w http.ResponseWriter, r *http.Request) {
 
-	userId, ok := r.Context().Value("userId").(string)
-
-	if !ok {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "Not able to get the UserId",
-			InternalError: nil,
-		})
-	}
 	var cartItem dto.CartItemModel
 
-	if err := json.NewDecoder(r.Body).Decode(&cartItem); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the CartItem",
-			InternalError: err,
-		})
-	}
+	decodeBody(r, &cartItem, "Not able to get the CartItem")
+	validateBody(&cartItem)
 
-	cartByUserId, err := models.GetCartByUserId(userId)
-	if err != nil {
-		//cart is not there so we need to create one
-		newCart := models.Cart{
-			UserId: userId,
-		}
+	price, variantId, stock := priceCartLine(r.Context(), cartItem.ProductId, cartItem.VariantId)
 
-		cartByUserId, err = models.Create(&newCart)
-		if err != nil {
-			panic(&cjson.HTTPError{
-				Status:        http.StatusInternalServerError,
-				Message:       "Not able to create Cart",
-				InternalError: err,
-			})
-		}
-	}
+	cartByUserId := requestCart(w, r, true)
 
-	existingItem, err := models.GetItemByCartAndProduct(cartByUserId.Id, cartItem.ProductId)
+	existingItem, err := models.GetItemByCartAndProduct(r.Context(), cartByUserId.Id, cartItem.ProductId, variantId)
 
 	if err == nil {
 		/* product exist and then update the quantity */
-		updateQuantityStuff, err := models.IncrementItemQuantity(existingItem.Id, cartItem.Quantity)
+		checkCartStock(existingItem.Quantity+cartItem.Quantity, stock)
+		updateQuantityStuff, err := models.IncrementItemQuantity(r.Context(), existingItem.Id, cartItem.Quantity)
 		if err != nil {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusBadRequest,
@@ -149,14 +216,17 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	checkCartStock(cartItem.Quantity, stock)
+
 	newProduct := models.CartItem{
 		ProductId:     cartItem.ProductId,
+		VariantId:     variantId,
 		Quantity:      cartItem.Quantity,
-		PriceAtAdding: cartItem.PriceAtAdding,
+		PriceAtAdding: price,
 		CartId:        cartByUserId.Id,
 	}
 
-	addedItem, err := models.AddItem(&newProduct)
+	addedItem, err := models.AddItem(r.Context(), &newProduct)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -170,15 +240,6 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 
 func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 
-	userId, ok := r.Context().Value("userId").(string)
-	if !ok {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "User authentication required",
-			InternalError: nil,
-		})
-	}
-
 	/* cartId and ProductId */
 
 	cartItemId := r.URL.Query().Get("cartItem")
@@ -186,7 +247,8 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 
 	if cartItemId == "" {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Please provide the cartItemId",
 			InternalError: nil,
 		})
@@ -206,7 +268,7 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 
 	// Verify cart ownership - get the cart item first
 	var cartItem models.CartItem
-	if err := database.DB.Where("id = ?", cartItemId).First(&cartItem).Error; err != nil {
+	if err := database.DB.WithContext(r.Context()).Where("id = ?", cartItemId).First(&cartItem).Error; err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
 			Message:       "Cart item not found",
@@ -215,7 +277,7 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the cart to verify ownership
-	cart, err := models.GetCartById(cartItem.CartId)
+	cart, err := models.GetCartById(r.Context(), cartItem.CartId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -224,8 +286,8 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Verify cart belongs to the authenticated user
-	if cart.UserId != userId {
+	// Verify cart belongs to the authenticated user or the guest token holder
+	if !canAccessCart(r, cart) {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusForbidden,
 			Message:       "You are not authorized to modify this cart",
@@ -233,7 +295,14 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	itemQuantity, err := models.UpdateItemQuantity(cartItemId, amount)
+	variantId := ""
+	if cartItem.VariantId != nil {
+		variantId = *cartItem.VariantId
+	}
+	_, _, stock := priceCartLine(r.Context(), cartItem.ProductId, variantId)
+	checkCartStock(amount, stock)
+
+	itemQuantity, err := models.UpdateItemQuantity(r.Context(), cartItemId, amount)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -247,19 +316,10 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
 
 func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 
-	userId, ok := r.Context().Value("userId").(string)
-	if !ok {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "User authentication required",
-			InternalError: nil,
-		})
-	}
-
 	cartItemId := r.URL.Query().Get("cartItem")
 
 	var cartItem models.CartItem
-	if err := database.DB.Where("id = ?", cartItemId).First(&cartItem).Error; err != nil {
+	if err := database.DB.WithContext(r.Context()).Where("id = ?", cartItemId).First(&cartItem).Error; err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
 			Message:       "Cart item not found",
@@ -268,7 +328,7 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the cart to verify ownership
-	cart, err := models.GetCartById(cartItem.CartId)
+	cart, err := models.GetCartById(r.Context(), cartItem.CartId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -277,8 +337,8 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Verify cart belongs to the authenticated user
-	if cart.UserId != userId {
+	// Verify cart belongs to the authenticated user or the guest token holder
+	if !canAccessCart(r, cart) {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusForbidden,
 			Message:       "You are not authorized to modify this cart",
@@ -286,7 +346,7 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	err = models.RemoveItem(cartItemId)
+	err = models.RemoveItem(r.Context(), cartItemId)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -301,18 +361,9 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 
 func ClearCart(w http.ResponseWriter, r *http.Request) {
 
-	userId, ok := r.Context().Value("userId").(string)
-	if !ok {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "User authentication required",
-			InternalError: nil,
-		})
-	}
-
 	cartId := r.URL.Query().Get("cart")
 
-	cart, err := models.GetCartById(cartId)
+	cart, err := models.GetCartById(r.Context(), cartId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -321,8 +372,8 @@ func ClearCart(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Verify cart belongs to the authenticated user
-	if cart.UserId != userId {
+	// Verify cart belongs to the authenticated user or the guest token holder
+	if !canAccessCart(r, cart) {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusForbidden,
 			Message:       "You are not authorized to modify this cart",
@@ -330,7 +381,7 @@ func ClearCart(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	err = models.DeleteAllItemsByCartId(cartId)
+	err = models.DeleteAllItemsByCartId(r.Context(), cartId)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -345,43 +396,41 @@ func ClearCart(w http.ResponseWriter, r *http.Request) {
 
 func GetCartItemTotal(w http.ResponseWriter, r *http.Request) {
 
-	userId, ok := r.Context().Value("userId").(string)
-
-	if userId == "" || !ok {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the UserId",
-			InternalError: nil,
-		})
-	}
-
-	cartByUserId, err := models.GetCartByUserId(userId)
-
-	if err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusInternalServerError,
-			Message:       "Not able to getCart",
-			InternalError: err,
-		})
-	}
+	cartByUserId := requestCart(w, r, false)
 
-	total := 0
 	totalQuantity := 0
 	for _, v := range cartByUserId.CartItems {
-		total += v.PriceAtAdding * v.Quantity
 		totalQuantity += v.Quantity
 	}
+	subTotal := cartByUserId.CalculateSubTotal()
 
 	type CartTotalResponse struct {
-		TotalMoney int `json:"totalMoney"`
-		Quantity   int `json:"quantity"`
+		SubTotal    int    `json:"subTotal"`
+		Discount    int    `json:"discount"`
+		CouponCode  string `json:"couponCode,omitempty"`
+		CouponError string `json:"couponError,omitempty"`
+		TotalMoney  int    `json:"totalMoney"`
+		Quantity    int    `json:"quantity"`
 	}
 
 	totalResponse := CartTotalResponse{
-		TotalMoney: total,
+		SubTotal:   subTotal,
+		TotalMoney: subTotal,
 		Quantity:   totalQuantity,
 	}
 
+	if cartByUserId.Coupon != nil {
+		totalResponse.CouponCode = cartByUserId.Coupon.Code
+		// the coupon may have expired or stopped matching the cart since it was applied
+		discount, err := cartByUserId.CalculateDiscount(r.Context())
+		if err != nil {
+			totalResponse.CouponError = err.Error()
+		} else {
+			totalResponse.Discount = discount
+			totalResponse.TotalMoney = subTotal - discount
+		}
+	}
+
 	_ = cjson.WriteJSON(w, http.StatusOK, totalResponse)
 
 }
@@ -396,48 +445,77 @@ func ApplyCoupon(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Parse coupon from request body
-	type CouponRequest struct {
-		CouponCode string `json:"couponCode"`
-	}
-	var couponReq CouponRequest
-	if err := json.NewDecoder(r.Body).Decode(&couponReq); err != nil {
+	var couponReq dto.ApplyCouponModel
+	decodeBody(r, &couponReq, "Invalid coupon data")
+	validateBody(&couponReq)
+
+	// Get user's cart
+	cart, err := models.GetCartByUserId(r.Context(), userId)
+	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid coupon data",
+			Status:        http.StatusNotFound,
+			Message:       "Cart not found",
 			InternalError: err,
 		})
 	}
 
-	// Get user's cart
-	cart, err := models.GetCartByUserId(userId)
+	coupon, err := models.GetCouponByCode(r.Context(), couponReq.CouponCode)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
-			Message:       "Cart not found",
+			Message:       "Coupon not found",
+			InternalError: err,
+		})
+	}
+
+	subTotal := cart.CalculateSubTotal()
+	if err := coupon.Validate(r.Context(), userId, subTotal); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       err.Error(),
 			InternalError: err,
 		})
 	}
 
-	// In a real implementation, verify coupon validity from database
-	// For now, we'll just respond with success
+	discount := coupon.CalculateDiscount(cart.CartItems)
+	if discount == 0 {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Coupon does not apply to any item in the cart",
+			InternalError: nil,
+		})
+	}
+
+	if err := models.SetCartCoupon(r.Context(), cart.Id, &coupon.Id); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to apply the coupon",
+			InternalError: err,
+		})
+	}
 
 	type ApplyCouponResponse struct {
 		Message    string `json:"message"`
 		CouponCode string `json:"couponCode"`
 		CartId     string `json:"cartId"`
+		SubTotal   int    `json:"subTotal"`
+		Discount   int    `json:"discount"`
+		TotalMoney int    `json:"totalMoney"`
 	}
 
 	response := ApplyCouponResponse{
 		Message:    "Coupon applied successfully",
-		CouponCode: couponReq.CouponCode,
+		CouponCode: coupon.Code,
 		CartId:     cart.Id,
+		SubTotal:   subTotal,
+		Discount:   discount,
+		TotalMoney: subTotal - discount,
 	}
 
 	_ = cjson.WriteJSON(w, http.StatusOK, response)
 }
 
-func MergeCart(w http.ResponseWriter, r *http.Request) {
+func RemoveCoupon(w http.ResponseWriter, r *http.Request) {
 	userId, ok := r.Context().Value("userId").(string)
 	if !ok {
 		panic(&cjson.HTTPError{
@@ -447,96 +525,73 @@ func MergeCart(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Get guest cart ID from request
-	type MergeRequest struct {
-		GuestCartId string `json:"guestCartId"`
+	cart, err := models.GetCartByUserId(r.Context(), userId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Cart not found",
+			InternalError: err,
+		})
 	}
-	var mergeReq MergeRequest
-	if err := json.NewDecoder(r.Body).Decode(&mergeReq); err != nil {
+
+	if err := models.SetCartCoupon(r.Context(), cart.Id, nil); err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid request data",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to remove the coupon",
 			InternalError: err,
 		})
 	}
 
-	if mergeReq.GuestCartId == "" {
+	_ = cjson.WriteJSON(w, http.StatusOK, "Coupon removed successfully")
+}
+
+// MergeCart folds the guest cart identified by the cart token into the logged-in user's cart.
+// Login does this automatically; this endpoint covers clients that authenticate another way.
+func MergeCart(w http.ResponseWriter, r *http.Request) {
+	userId, ok := r.Context().Value("userId").(string)
+	if userId == "" || !ok {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Guest cart ID is required",
+			Status:        http.StatusUnauthorized,
+			Message:       "User ID not found in context",
 			InternalError: nil,
 		})
 	}
 
-	// Get user's cart or create if it doesn't exist
-	userCart, err := models.GetCartByUserId(userId)
-	if err != nil {
-		newCart := models.Cart{
-			UserId: userId,
-		}
-		userCart, err = models.Create(&newCart)
-		if err != nil {
-			panic(&cjson.HTTPError{
-				Status:        http.StatusInternalServerError,
-				Message:       "Failed to create user cart",
-				InternalError: err,
-			})
-		}
+	token := utils.GetCartTokenFromRequest(r)
+	if token == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Guest cart token is required",
+			InternalError: nil,
+		})
 	}
 
-	// Get guest cart
-	guestCart, err := models.GetCartById(mergeReq.GuestCartId)
+	guestCartId, err := utils.ParseCartToken(token)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Guest cart not found",
+			Status:        http.StatusUnauthorized,
+			Message:       "Invalid cart token",
 			InternalError: err,
 		})
 	}
 
-	// Merge items from guest cart to user cart
-	for _, item := range guestCart.CartItems {
-		existingItem, err := models.GetItemByCartAndProduct(userCart.Id, item.ProductId)
-		if err == nil {
-			// Product exists in user cart, update quantity
-			_, err = models.IncrementItemQuantity(existingItem.Id, item.Quantity)
-			if err != nil {
-				panic(&cjson.HTTPError{
-					Status:        http.StatusInternalServerError,
-					Message:       "Failed to update item quantity",
-					InternalError: err,
-				})
-			}
-		} else {
-			// Product doesn't exist in user cart, add it
-			newItem := models.CartItem{
-				CartId:        userCart.Id,
-				ProductId:     item.ProductId,
-				Quantity:      item.Quantity,
-				PriceAtAdding: item.PriceAtAdding,
-			}
-			_, err = models.AddItem(&newItem)
-			if err != nil {
-				panic(&cjson.HTTPError{
-					Status:        http.StatusInternalServerError,
-					Message:       "Failed to add item to cart",
-					InternalError: err,
-				})
-			}
-		}
+	updatedCart, err := models.MergeGuestCart(r.Context(), guestCartId, userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Guest cart not found, it may have been merged already",
+			InternalError: err,
+		})
 	}
-
-	// Delete guest cart
-	if err := models.DeleteCart(guestCart.Id); err != nil {
+	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
-			Message:       "Failed to delete guest cart",
+			Message:       "Not able to merge the guest cart",
 			InternalError: err,
 		})
 	}
 
-	// Get updated user cart to return
-	updatedCart, _ := models.GetCartByUserId(userId)
+	utils.ClearCartTokenCookie(w)
 	_ = cjson.WriteJSON(w, http.StatusOK, updatedCart)
 }
 
@@ -551,7 +606,7 @@ func ValidateCartItems(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user's cart
-	cart, err := models.GetCartByUserId(userId)
+	cart, err := models.GetCartByUserId(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -583,10 +638,7 @@ func ValidateCartItems(w http.ResponseWriter, r *http.Request) {
 
 	// Check each item in cart against inventory
 	for _, item := range cart.CartItems {
-		// In a real implementation, check against actual inventory
-		// For now, assume we're checking product.Stock
-
-		available := item.Product.Stock // Assuming Product has Stock field
+		available := item.AvailableStock()
 		isValid := item.Quantity <= available
 
 		validationItem := ValidationItem{
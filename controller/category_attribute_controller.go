@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"errors"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"net/http"
+	"strings"
+)
+
+/*
+GetCategoryAttributes - The attribute schema of a category, inherited attributes included
+CreateCategoryAttribute - Add an attribute to a category (admin only)
+UpdateCategoryAttribute - Update the name, unit, options, bounds or order of an attribute (admin only)
+DeleteCategoryAttribute - Remove an attribute and its values (admin only)
+*/
+
+func GetCategoryAttributes(w http.ResponseWriter, r *http.Request) {
+	category := categoryFromQuery(r)
+
+	schema, err := models.GetCategorySchema(r.Context(), category.Id)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to fetch the category attributes",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, schema)
+}
+
+func CreateCategoryAttribute(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Admin privileges required",
+			InternalError: err,
+		})
+	}
+
+	categoryId := r.URL.Query().Get("categoryId")
+	if categoryId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Category ID is required",
+			InternalError: nil,
+		})
+	}
+	if _, err := models.GetCategoryById(r.Context(), categoryId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Category not found",
+			InternalError: err,
+		})
+	}
+
+	var attributeModel dto.CategoryAttributeModel
+	decodeBody(r, &attributeModel, "Invalid attribute data")
+	validateBody(&attributeModel)
+
+	attribute := models.CategoryAttribute{
+		CategoryId: categoryId,
+		Key:        attributeModel.Key,
+		Name:       attributeModel.Name,
+		Type:       attributeModel.Type,
+		Unit:       attributeModel.Unit,
+		Options:    attributeModel.Options,
+		Min:        attributeModel.Min,
+		Max:        attributeModel.Max,
+		Level:      attributeModel.Level,
+	}
+	if attribute.Key == "" {
+		attribute.Key = models.Slugify(attribute.Name)
+	}
+	if attribute.Level == "" {
+		attribute.Level = models.AttributeLevelProduct
+	}
+	if attributeModel.Required != nil {
+		attribute.Required = *attributeModel.Required
+	}
+	if attributeModel.SortOrder != nil {
+		attribute.SortOrder = *attributeModel.SortOrder
+	}
+	checkAttributeDefinition(&attribute)
+
+	createdAttribute, err := attribute.CreateCategoryAttribute(r.Context())
+	if err != nil {
+		if errors.Is(err, models.ErrAttributeKeyTaken) {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Code:          cjson.CodeAttributeKeyTaken,
+				Message:       "The category or one above it already has an attribute with this key",
+				InternalError: err,
+			})
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to create attribute",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusCreated, createdAttribute)
+}
+
+// UpdateCategoryAttribute - Key, type and level can not change, the stored values depend on them.
+// Delete the attribute and create it again instead.
+func UpdateCategoryAttribute(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Admin privileges required",
+			InternalError: err,
+		})
+	}
+
+	attribute := attributeFromQuery(r)
+
+	var attributeModel dto.CategoryAttributeModel
+	decodeBody(r, &attributeModel, "Invalid attribute data")
+	validatePartial(&attributeModel)
+
+	var fields []cjson.FieldError
+	for _, fixed := range []struct{ field, given, stored string }{
+		{"key", attributeModel.Key, attribute.Key},
+		{"type", attributeModel.Type, attribute.Type},
+		{"level", attributeModel.Level, attribute.Level},
+	} {
+		if fixed.given != "" && fixed.given != fixed.stored {
+			fields = append(fields, cjson.FieldError{Field: fixed.field, Code: cjson.FieldInvalid, Message: fixed.field + " can not be changed"})
+		}
+	}
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+
+	if attributeModel.Name != "" {
+		attribute.Name = attributeModel.Name
+	}
+	if attributeModel.Unit != "" {
+		attribute.Unit = attributeModel.Unit
+	}
+	if attributeModel.Options != nil {
+		attribute.Options = attributeModel.Options
+	}
+	if attributeModel.Min != nil {
+		attribute.Min = attributeModel.Min
+	}
+	if attributeModel.Max != nil {
+		attribute.Max = attributeModel.Max
+	}
+	if attributeModel.Required != nil {
+		attribute.Required = *attributeModel.Required
+	}
+	if attributeModel.SortOrder != nil {
+		attribute.SortOrder = *attributeModel.SortOrder
+	}
+	checkAttributeDefinition(attribute)
+
+	updatedAttribute, err := models.UpdateCategoryAttribute(r.Context(), attribute)
+	if err != nil {
+		if errors.Is(err, models.ErrAttributeNoOptions) {
+			panic(cjson.NewValidationError([]cjson.FieldError{{Field: "options", Code: cjson.FieldRequired, Message: "options is required"}}))
+		}
+		if errors.Is(err, models.ErrAttributeOptionInUse) {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Code:          cjson.CodeAttributeOptionInUse,
+				Message:       "Products still use an option that was removed, change them first",
+				InternalError: err,
+			})
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to update attribute",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, updatedAttribute)
+}
+
+func DeleteCategoryAttribute(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Admin privileges required",
+			InternalError: err,
+		})
+	}
+
+	attribute := attributeFromQuery(r)
+
+	if err := models.DeleteCategoryAttribute(r.Context(), attribute.Id); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to delete attribute",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, "Attribute deleted successfully")
+}
+
+func attributeFromQuery(r *http.Request) *models.CategoryAttribute {
+	attributeId := r.URL.Query().Get("attributeId")
+	if attributeId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Attribute ID is required",
+			InternalError: nil,
+		})
+	}
+
+	attribute, err := models.GetCategoryAttributeById(r.Context(), attributeId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Attribute not found",
+			InternalError: err,
+		})
+	}
+	return attribute
+}
+
+// checkAttributeDefinition checks what the tags can not: the fields that only fit some types and
+// the enum options.
+func checkAttributeDefinition(a *models.CategoryAttribute) {
+	var fields []cjson.FieldError
+	invalid := func(field, message string) {
+		fields = append(fields, cjson.FieldError{Field: field, Code: cjson.FieldInvalid, Message: field + " " + message})
+	}
+
+	if a.Key == "" {
+		invalid("key", "can not be made from the name, give one")
+	}
+	if a.Type == models.AttributeEnum {
+		if len(a.Options) == 0 {
+			fields = append(fields, cjson.FieldError{Field: "options", Code: cjson.FieldRequired, Message: "options is required"})
+		}
+		seen := map[string]bool{}
+		for i, option := range a.Options {
+			option = strings.TrimSpace(option)
+			a.Options[i] = option
+			switch {
+			case option == "" || len([]rune(option)) > 255:
+				invalid("options", "must have between 1 and 255 characters each")
+			case seen[strings.ToLower(option)]:
+				invalid("options", "must not repeat "+option)
+			}
+			seen[strings.ToLower(option)] = true
+		}
+	} else if len(a.Options) > 0 {
+		invalid("options", "are only for enum attributes")
+	}
+	if a.Type != models.AttributeNumber {
+		if a.Unit != "" {
+			invalid("unit", "is only for number attributes")
+		}
+		if a.Min != nil || a.Max != nil {
+			invalid("min", "and max are only for number attributes")
+		}
+	} else if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		invalid("max", "can not be below min")
+	}
+
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+}
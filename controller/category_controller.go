@@ -1,55 +1,81 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
 	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
 	"github.com/pratyush934/sibling-bond-server/models"
 	"net/http"
-	"strconv"
 )
 
 // GetAllCategories - List all product categories
 func GetAllCategories(w http.ResponseWriter, r *http.Request) {
-	// Handle pagination parameters
-	limit := 10
-	offset := 0
+	q := listQuery(r, models.CategoryListSpec)
 
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
+	page, err := models.ListCategories(r.Context(), q)
+	if err != nil {
+		listFailed(err, "Failed to fetch categories")
 	}
 
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
+}
 
-	categories, err := models.GetAll(limit, offset)
+// GetCategoryTree - Every category nested under its parent
+func GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := models.GetCategoryTree(r.Context())
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
-			Message:       "Failed to fetch categories",
+			Message:       "Failed to fetch the category tree",
 			InternalError: err,
 		})
 	}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, categories)
+	_ = cjson.WriteJSON(w, http.StatusOK, tree)
 }
 
-// GetCategoryById - Get specific category details
+// GetCategoryById - Get specific category details, by categoryId or slug
 func GetCategoryById(w http.ResponseWriter, r *http.Request) {
-	categoryId := r.URL.Query().Get("categoryId")
-	if categoryId == "" {
+	category := categoryFromQuery(r)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, category)
+}
+
+// GetCategoryBreadcrumbs - The path from the root to a category, by categoryId or slug
+func GetCategoryBreadcrumbs(w http.ResponseWriter, r *http.Request) {
+	category := categoryFromQuery(r)
+
+	breadcrumbs, err := models.GetCategoryBreadcrumbs(r.Context(), category.Id)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to fetch the breadcrumbs",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, breadcrumbs)
+}
+
+// categoryFromQuery loads the category named by the categoryId or slug query parameter.
+func categoryFromQuery(r *http.Request) *models.Category {
+	categoryId, slug := r.URL.Query().Get("categoryId"), r.URL.Query().Get("slug")
+	if categoryId == "" && slug == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
-			Message:       "Category ID is required",
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Category ID or slug is required",
 			InternalError: nil,
 		})
 	}
 
-	category, err := models.GetCategoryById(categoryId)
+	var category *models.Category
+	var err error
+	if categoryId != "" {
+		category, err = models.GetCategoryById(r.Context(), categoryId)
+	} else {
+		category, err = models.GetCategoryBySlug(r.Context(), slug)
+	}
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -57,8 +83,7 @@ func GetCategoryById(w http.ResponseWriter, r *http.Request) {
 			InternalError: err,
 		})
 	}
-
-	_ = cjson.WriteJSON(w, http.StatusOK, category)
+	return category
 }
 
 // CreateCategory - Add new category (admin only)
@@ -73,26 +98,25 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	var category models.Category
-	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid category data",
-			InternalError: err,
-		})
-	}
+	var categoryModel dto.CategoryModel
+	decodeBody(r, &categoryModel, "Invalid category data")
+	validateBody(&categoryModel)
 
-	// Validate category name
-	if category.Name == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Category name is required",
-			InternalError: nil,
-		})
+	category := models.Category{
+		Name:        categoryModel.Name,
+		Slug:        categoryModel.Slug,
+		Description: categoryModel.Description,
+	}
+	if categoryModel.ParentId != nil && *categoryModel.ParentId != "" {
+		category.ParentId = categoryModel.ParentId
+		checkCategoryParent(r, *category.ParentId)
+	}
+	if categoryModel.SortOrder != nil {
+		category.SortOrder = *categoryModel.SortOrder
 	}
 
-	// Check if category with same name already exists
-	existing, err := models.GetCategoryByName(category.Name)
+	// Check if a sibling with the same name already exists
+	existing, err := models.GetSiblingCategoryByName(r.Context(), category.ParentId, category.Name)
 	if err == nil && existing != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusConflict,
@@ -101,19 +125,15 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	createdCategory, err := category.CreateCategory()
+	createdCategory, err := category.CreateCategory(r.Context())
 	if err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusInternalServerError,
-			Message:       "Failed to create category",
-			InternalError: err,
-		})
+		categorySaveFailed(err, "Failed to create category")
 	}
 
 	_ = cjson.WriteJSON(w, http.StatusCreated, createdCategory)
 }
 
-// UpdateCategory - Update category details (admin only)
+// UpdateCategory - Update category details or move it in the tree (admin only)
 func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	// Verify admin role
 	role, ok := r.Context().Value("role").(float64)
@@ -129,13 +149,14 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	if categoryId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Category ID is required",
 			InternalError: nil,
 		})
 	}
 
 	// Get existing category
-	existingCategory, err := models.GetCategoryById(categoryId)
+	existingCategory, err := models.GetCategoryById(r.Context(), categoryId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -145,49 +166,89 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse update data
-	var updatedData models.Category
-	if err := json.NewDecoder(r.Body).Decode(&updatedData); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid update data",
-			InternalError: err,
-		})
-	}
+	var updatedData dto.CategoryModel
+	decodeBody(r, &updatedData, "Invalid update data")
+	validatePartial(&updatedData)
 
 	// Update fields if provided
-	if updatedData.Name != "" {
-		// Check if the new name would conflict with an existing category
-		if existingCategory.Name != updatedData.Name {
-			existing, err := models.GetCategoryByName(updatedData.Name)
-			if err == nil && existing != nil && existing.Id != categoryId {
-				panic(&cjson.HTTPError{
-					Status:        http.StatusConflict,
-					Message:       "Another category with this name already exists",
-					InternalError: nil,
-				})
-			}
+	if updatedData.ParentId != nil {
+		if *updatedData.ParentId == "" {
+			existingCategory.ParentId = nil
+		} else {
+			checkCategoryParent(r, *updatedData.ParentId)
+			existingCategory.ParentId = updatedData.ParentId
 		}
+	}
+	if updatedData.Name != "" {
 		existingCategory.Name = updatedData.Name
 	}
+	if updatedData.Name != "" || updatedData.ParentId != nil {
+		// Check if the name would conflict with another category under the same parent
+		existing, err := models.GetSiblingCategoryByName(r.Context(), existingCategory.ParentId, existingCategory.Name)
+		if err == nil && existing != nil && existing.Id != categoryId {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Message:       "Another category with this name already exists",
+				InternalError: nil,
+			})
+		}
+	}
 
+	if updatedData.Slug != "" {
+		existingCategory.Slug = updatedData.Slug
+	}
 	if updatedData.Description != "" {
 		existingCategory.Description = updatedData.Description
 	}
+	if updatedData.SortOrder != nil {
+		existingCategory.SortOrder = *updatedData.SortOrder
+	}
 
 	// Save updates
-	updatedCategory, err := models.UpdateCategory(existingCategory)
+	updatedCategory, err := models.UpdateCategory(r.Context(), existingCategory)
 	if err != nil {
+		categorySaveFailed(err, "Failed to update category")
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, updatedCategory)
+}
+
+// checkCategoryParent makes sure the parent a category is put under exists.
+func checkCategoryParent(r *http.Request, parentId string) {
+	if _, err := models.GetCategoryById(r.Context(), parentId); err != nil {
+		panic(cjson.NewValidationError([]cjson.FieldError{{
+			Field:   "parentId",
+			Code:    cjson.FieldInvalid,
+			Message: "parentId is not an existing category",
+		}}))
+	}
+}
+
+func categorySaveFailed(err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrCategoryCycle):
+		panic(cjson.NewValidationError([]cjson.FieldError{{
+			Field:   "parentId",
+			Code:    cjson.FieldInvalid,
+			Message: "a category can not be moved below itself or one of its subcategories",
+		}}))
+	case errors.Is(err, models.ErrCategorySlugTaken):
 		panic(&cjson.HTTPError{
-			Status:        http.StatusInternalServerError,
-			Message:       "Failed to update category",
+			Status:        http.StatusConflict,
+			Code:          cjson.CodeCategorySlugTaken,
+			Message:       "Another category already uses this slug",
 			InternalError: err,
 		})
 	}
-
-	_ = cjson.WriteJSON(w, http.StatusOK, updatedCategory)
+	panic(&cjson.HTTPError{
+		Status:        http.StatusInternalServerError,
+		Message:       message,
+		InternalError: err,
+	})
 }
 
-// DeleteCategory - Remove a category (admin only)
+// DeleteCategory - Remove a category (admin only). Its subcategories move up to its parent, its
+// products move to the category given as reassignTo; without one a category with products stays.
 func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	// Verify admin role
 	role, ok := r.Context().Value("role").(float64)
@@ -203,13 +264,14 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	if categoryId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Category ID is required",
 			InternalError: nil,
 		})
 	}
 
 	// Check if category exists
-	_, err := models.GetCategoryById(categoryId)
+	_, err := models.GetCategoryById(r.Context(), categoryId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -218,8 +280,26 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	reassignTo := r.URL.Query().Get("reassignTo")
+	if reassignTo != "" {
+		if reassignTo == categoryId {
+			panic(cjson.NewValidationError([]cjson.FieldError{{
+				Field:   "reassignTo",
+				Code:    cjson.FieldInvalid,
+				Message: "reassignTo must be another category",
+			}}))
+		}
+		if _, err := models.GetCategoryById(r.Context(), reassignTo); err != nil {
+			panic(cjson.NewValidationError([]cjson.FieldError{{
+				Field:   "reassignTo",
+				Code:    cjson.FieldInvalid,
+				Message: "reassignTo is not an existing category",
+			}}))
+		}
+	}
+
 	// Check if category has associated products
-	hasProducts, err := models.CategoryHasProducts(categoryId)
+	hasProducts, err := models.CategoryHasProducts(r.Context(), categoryId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
@@ -228,16 +308,16 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	if hasProducts {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusConflict,
-			Message:       "Cannot delete category with associated products",
-			InternalError: nil,
-		})
+	if hasProducts && reassignTo == "" {
+		categoryHasProducts(nil)
 	}
 
 	// Delete the category
-	if err := models.DeleteCategory(categoryId); err != nil {
+	if err := models.DeleteCategory(r.Context(), categoryId, reassignTo); err != nil {
+		if errors.Is(err, models.ErrCategoryHasProducts) {
+			// products were added since the check above
+			categoryHasProducts(err)
+		}
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
 			Message:       "Failed to delete category",
@@ -247,3 +327,12 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 
 	_ = cjson.WriteJSON(w, http.StatusOK, "Category deleted successfully")
 }
+
+func categoryHasProducts(err error) {
+	panic(&cjson.HTTPError{
+		Status:        http.StatusConflict,
+		Code:          cjson.CodeCategoryHasProducts,
+		Message:       "Cannot delete category with associated products, pass reassignTo to move them to another category",
+		InternalError: err,
+	})
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"net/http"
+	"strconv"
+)
+
+/*
+CreateCoupon - Add new coupon (admin only)
+GetAllCoupons - List coupons (admin only)
+GetCouponById - Get specific coupon (admin only)
+UpdateCoupon - Update coupon details and scope (admin only)
+DeleteCoupon - Remove a coupon (admin only)
+*/
+
+func couponScope(ctx context.Context, couponModel dto.CouponModel) ([]models.Category, []models.Product) {
+	categories := make([]models.Category, 0, len(couponModel.CategoryIds))
+	for _, id := range couponModel.CategoryIds {
+		category, err := models.GetCategoryById(ctx, id)
+		if err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusBadRequest,
+				Message:       "Coupon category not found : " + id,
+				InternalError: err,
+			})
+		}
+		categories = append(categories, *category)
+	}
+
+	products := make([]models.Product, 0, len(couponModel.ProductIds))
+	for _, id := range couponModel.ProductIds {
+		product, err := models.GetProductById(ctx, id)
+		if err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusBadRequest,
+				Message:       "Coupon product not found : " + id,
+				InternalError: err,
+			})
+		}
+		products = append(products, *product)
+	}
+	return categories, products
+}
+
+func CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not able to verify the user as admin",
+			InternalError: err,
+		})
+	}
+
+	var couponModel dto.CouponModel
+	decodeBody(r, &couponModel, "Invalid coupon data")
+	validateBody(&couponModel)
+
+	if _, err := models.GetCouponByCode(r.Context(), couponModel.Code); err == nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusConflict,
+			Message:       "Coupon with this code already exists",
+			InternalError: nil,
+		})
+	}
+
+	categories, products := couponScope(r.Context(), couponModel)
+
+	isActive := true
+	if couponModel.IsActive != nil {
+		isActive = *couponModel.IsActive
+	}
+
+	coupon := models.Coupon{
+		Code:          couponModel.Code,
+		Description:   couponModel.Description,
+		DiscountType:  couponModel.DiscountType,
+		DiscountValue: couponModel.DiscountValue,
+		MaxDiscount:   couponModel.MaxDiscount,
+		MinCartValue:  couponModel.MinCartValue,
+		UsageLimit:    couponModel.UsageLimit,
+		PerUserLimit:  couponModel.PerUserLimit,
+		ValidFrom:     couponModel.ValidFrom,
+		ValidUntil:    couponModel.ValidUntil,
+		IsActive:      isActive,
+		Categories:    categories,
+		Products:      products,
+	}
+
+	createdCoupon, err := coupon.CreateCoupon(r.Context())
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Not able to create the coupon",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusCreated, createdCoupon)
+}
+
+func GetAllCoupons(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	offset := 0
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	coupons, err := models.GetAllCoupons(r.Context(), limit, offset)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to fetch coupons",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, coupons)
+}
+
+func GetCouponById(w http.ResponseWriter, r *http.Request) {
+	couponId := mux.Vars(r)["id"]
+
+	coupon, err := models.GetCouponById(r.Context(), couponId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Coupon not found",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, coupon)
+}
+
+func UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	couponId := mux.Vars(r)["id"]
+
+	existingCoupon, err := models.GetCouponById(r.Context(), couponId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Coupon not found",
+			InternalError: err,
+		})
+	}
+
+	var couponModel dto.CouponModel
+	decodeBody(r, &couponModel, "Invalid coupon data")
+	validatePartial(&couponModel)
+
+	if couponModel.Code != "" && couponModel.Code != existingCoupon.Code {
+		if other, err := models.GetCouponByCode(r.Context(), couponModel.Code); err == nil && other.Id != couponId {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Message:       "Another coupon with this code already exists",
+				InternalError: nil,
+			})
+		}
+		existingCoupon.Code = couponModel.Code
+	}
+
+	categories, products := couponScope(r.Context(), couponModel)
+
+	existingCoupon.Description = couponModel.Description
+	existingCoupon.DiscountType = couponModel.DiscountType
+	existingCoupon.DiscountValue = couponModel.DiscountValue
+	existingCoupon.MaxDiscount = couponModel.MaxDiscount
+	existingCoupon.MinCartValue = couponModel.MinCartValue
+	existingCoupon.UsageLimit = couponModel.UsageLimit
+	existingCoupon.PerUserLimit = couponModel.PerUserLimit
+	existingCoupon.ValidFrom = couponModel.ValidFrom
+	existingCoupon.ValidUntil = couponModel.ValidUntil
+	existingCoupon.Categories = categories
+	existingCoupon.Products = products
+	if couponModel.IsActive != nil {
+		existingCoupon.IsActive = *couponModel.IsActive
+	}
+
+	updatedCoupon, err := models.UpdateCoupon(r.Context(), existingCoupon)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Not able to update the coupon",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, updatedCoupon)
+}
+
+func DeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	couponId := mux.Vars(r)["id"]
+
+	if _, err := models.GetCouponById(r.Context(), couponId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Coupon not found",
+			InternalError: err,
+		})
+	}
+
+	if err := models.DeleteCoupon(r.Context(), couponId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Failed to delete coupon",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, "Coupon deleted successfully")
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/ikprovider"
+	"github.com/pratyush934/sibling-bond-server/migrations"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	1. Healthz
+	2. Readyz
+*/
+
+const (
+	CheckOK          = "ok"
+	CheckFailing     = "failing"
+	CheckUnavailable = "unavailable"
+	CheckDisabled    = "disabled"
+)
+
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Version *int   `json:"version,omitempty"`
+	Latest  *int   `json:"latest,omitempty"`
+}
+
+type ReadinessReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+var shuttingDown atomic.Bool
+
+// MarkShuttingDown makes Readyz fail so load balancers stop routing here while requests drain.
+func MarkShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Healthz only says the process is up and serving, it never touches dependencies.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	_ = cjson.WriteJSON(w, http.StatusOK, map[string]string{"status": CheckOK})
+}
+
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	report := ReadinessReport{Status: CheckOK, Checks: map[string]HealthCheck{}}
+	fail := func(name string, check HealthCheck) {
+		check.Status = CheckFailing
+		report.Checks[name] = check
+		report.Status = CheckUnavailable
+	}
+
+	if shuttingDown.Load() {
+		report.Status = CheckUnavailable
+		report.Checks["server"] = HealthCheck{Status: "shutting down"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := database.Ping(ctx); err != nil {
+		fail("database", HealthCheck{Error: err.Error()})
+	} else {
+		report.Checks["database"] = HealthCheck{Status: CheckOK}
+	}
+
+	latest := migrations.Latest()
+	version, err := migrations.NewRunner(database.DB.WithContext(ctx)).Version()
+	switch {
+	case err != nil:
+		fail("migrations", HealthCheck{Error: err.Error(), Latest: &latest})
+	case version < latest:
+		fail("migrations", HealthCheck{Error: "pending migrations", Version: &version, Latest: &latest})
+	default:
+		report.Checks["migrations"] = HealthCheck{Status: CheckOK, Version: &version, Latest: &latest}
+	}
+
+	// image uploads are optional, only a configured ImageKit that can not start counts against readiness
+	if !config.Get().ImageKit.Enabled() {
+		report.Checks["imagekit"] = HealthCheck{Status: CheckDisabled}
+	} else if _, err := ikprovider.GetImageKitService(); err != nil {
+		fail("imagekit", HealthCheck{Error: err.Error()})
+	} else {
+		report.Checks["imagekit"] = HealthCheck{Status: CheckOK}
+	}
+
+	status := http.StatusOK
+	if report.Status != CheckOK {
+		status = http.StatusServiceUnavailable
+	}
+	_ = cjson.WriteJSON(w, status, report)
+}
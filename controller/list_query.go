@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"errors"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"net/http"
+)
+
+// listQuery parses the filters, sort and page of a list request, reporting every bad parameter at once.
+func listQuery(r *http.Request, spec listquery.Spec) *listquery.Query {
+	q, fields := listquery.Parse(r.URL.Query(), spec)
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+	return q
+}
+
+// listFailed answers a failed listing. A cursor that does not fit the stored rows is the client's
+// to fix, anything else is ours.
+func listFailed(err error, message string) {
+	if errors.Is(err, listquery.ErrInvalidCursor) {
+		panic(cjson.NewValidationError([]cjson.FieldError{{
+			Field:   "cursor",
+			Code:    cjson.FieldInvalid,
+			Message: "is not a cursor of this list and sort, start again from the first page",
+		}}))
+	}
+	panic(&cjson.HTTPError{
+		Status:        http.StatusInternalServerError,
+		Message:       message,
+		InternalError: err,
+	})
+}
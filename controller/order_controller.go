@@ -2,22 +2,26 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/pratyush934/sibling-bond-server/cjson"
-	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"github.com/pratyush934/sibling-bond-server/models"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/payment"
+	"io"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
 
 /*
 CreateOrder - Convert cart to order
-CancelOrder - Cancel existing order
+CancelOrder - Cancel existing order, it stays in the history with its reason
 UpdateOrderStatus - Change order status (admin only)
-ProcessPayment - Handle payment for order
+GetOrderTimeline - Status history of an order
+ProcessPayment - Start a payment attempt for the order
 GetAllOrders - List all orders (admin only)
 */
 
@@ -33,20 +37,16 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	shippingAddressId := r.URL.Query().Get("shippingAddressId")
-	if shippingAddressId == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "There is not shipping Id found",
-			InternalError: nil,
-		})
+	orderRequest := dto.CreateOrderModel{
+		ShippingAddressId: r.URL.Query().Get("shippingAddressId"),
+		PaymentMethod:     r.URL.Query().Get("paymentMethod"),
 	}
-	paymentMethod := r.URL.Query().Get("paymentMethod")
-	if paymentMethod == "" {
-		paymentMethod = "cod"
+	if orderRequest.PaymentMethod == "" {
+		orderRequest.PaymentMethod = "cod"
 	}
+	validateBody(&orderRequest)
 
-	cartByUserId, err := models.GetCartByUserId(userId)
+	cartByUserId, err := models.GetCartByUserId(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -57,7 +57,8 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	if len(cartByUserId.CartItems) == 0 {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusExpectationFailed,
+			Status:        http.StatusConflict,
+			Code:          cjson.CodeCartEmpty,
 			Message:       "There are not Items in the cart",
 			InternalError: nil,
 		})
@@ -72,6 +73,7 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 		orderItem := models.OrderItem{
 			ProductId:       v.ProductId,
+			VariantId:       v.VariantId,
 			Quantity:        v.Quantity,
 			PriceAtPurchase: v.PriceAtAdding,
 		}
@@ -82,30 +84,55 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		orderItemsSlice = append(orderItemsSlice, orderItem)
 	}
 
+	discount, err := cartByUserId.CalculateDiscount(r.Context())
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeCouponInvalid,
+			Message:       "Applied coupon is no longer valid, please remove it",
+			InternalError: err,
+		})
+	}
+
 	newOrderModel := models.Order{
 		UserId:            userId,
 		OrderItems:        orderItemsSlice,
-		TotalAmount:       totalAmount,
-		ShippingAddressId: shippingAddressId,
-		PaymentMode:       paymentMethod,
-		PaymentStatus:     "pending",
-		Status:            "pending",
+		SubTotal:          totalAmount,
+		DiscountAmount:    discount,
+		TotalAmount:       totalAmount - discount,
+		ShippingAddressId: orderRequest.ShippingAddressId,
+		PaymentMode:       orderRequest.PaymentMethod,
+		PaymentStatus:     models.PaymentStatusPending,
+		Status:            models.OrderStatusPending,
 		TrackingNumber:    generateTrackingNumber(),
 	}
 
-	createdOrder, err := newOrderModel.Create()
+	if cartByUserId.Coupon != nil {
+		newOrderModel.CouponId = &cartByUserId.Coupon.Id
+		newOrderModel.CouponCode = cartByUserId.Coupon.Code
+	}
+
+	createdOrder, err := newOrderModel.Create(r.Context())
 
 	if err != nil {
+		if errors.Is(err, models.ErrInsufficientStock) {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Code:          cjson.CodeCartItemOutOfStock,
+				Message:       err.Error(),
+				InternalError: err,
+			})
+		}
 		panic(&cjson.HTTPError{
-			Status:        http.StatusExpectationFailed,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to create Order",
 			InternalError: err,
 		})
 	}
 
-	err = models.DeleteCart(cartByUserId.Id)
+	err = models.DeleteCart(r.Context(), cartByUserId.Id)
 	if err != nil {
-		log.Err(err).Msg("not able to delete the cart but order is now created")
+		logging.Ctx(r.Context()).Err(err).Msg("not able to delete the cart but order is now created")
 	}
 	_ = cjson.WriteJSON(w, http.StatusCreated, createdOrder)
 
@@ -127,33 +154,49 @@ func CancelOrder(w http.ResponseWriter, r *http.Request) {
 	if orderId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Order ID is required",
 			InternalError: nil,
 		})
 	}
 
 	// Get the order and verify ownership
-	order, err := models.GetOrderByUserIdAndOrderId(userId, orderId)
+	order, err := models.GetOrderByUserIdAndOrderId(r.Context(), userId, orderId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
+			Code:          cjson.CodeOrderNotFound,
 			Message:       "Order not found or doesn't belong to you",
 			InternalError: err,
 		})
 	}
 
-	// Check if order is in a cancellable state
-	// Only allow cancellation for pending or confirmed orders
-	if order.Status != "pending" && order.Status != "confirmed" {
+	// Reason is optional, an empty body is fine
+	var cancelModel dto.CancelOrderModel
+	if err := json.NewDecoder(r.Body).Decode(&cancelModel); err != nil && !errors.Is(err, io.EOF) {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeInvalidBody,
+			Message:       "Invalid cancellation data",
+			InternalError: err,
+		})
+	}
+	validateBody(&cancelModel)
+	if cancelModel.Reason == "" {
+		cancelModel.Reason = "cancelled by customer"
+	}
+
+	// Only pending, confirmed or processing orders can move to cancelled
+	if !models.CanTransition(order.Status, models.OrderStatusCancelled) {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusConflict,
+			Code:          cjson.CodeIllegalStatusTransition,
 			Message:       fmt.Sprintf("Order in '%s' state cannot be cancelled", order.Status),
 			InternalError: nil,
 		})
 	}
 
-	// Delete the order
-	err = models.DeleteOrderById(orderId)
+	cancelledOrder, err := models.CancelOrder(r.Context(), order.Id, userId, cancelModel.Reason)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
@@ -162,36 +205,40 @@ func CancelOrder(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, "Order cancelled successfully")
+	_ = cjson.WriteJSON(w, http.StatusOK, cancelledOrder)
 }
 
 func UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 
-	role, ok := r.Context().Value("role").(int)
-
-	if role != 2 || !ok {
+	if err := CheckAdmin(w, r); err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
 			Message:       "Not able to get the role or if got the person is not admin",
-			InternalError: nil,
+			InternalError: err,
 		})
 	}
+	adminId, _ := r.Context().Value("userId").(string)
 
-	orderStatus := r.URL.Query().Get("orderStatus")
-	if orderStatus == "" {
-		orderStatus = "delivered"
+	statusRequest := dto.UpdateOrderStatusModel{
+		OrderId: r.URL.Query().Get("orderId"),
+		Status:  strings.ToLower(r.URL.Query().Get("orderStatus")),
+		Note:    r.URL.Query().Get("note"),
 	}
-	orderId := r.URL.Query().Get("orderId")
-	if orderId == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "please provide orderId",
-			InternalError: nil,
-		})
+	validateBody(&statusRequest)
+	if statusRequest.Status == models.OrderStatusCancelled && statusRequest.Note == "" {
+		statusRequest.Note = "cancelled by admin"
 	}
 
-	status, err := models.UpdateStatus(orderId, strings.ToLower(orderStatus))
+	status, err := models.UpdateStatus(r.Context(), statusRequest.OrderId, statusRequest.Status, adminId, statusRequest.Note)
 	if err != nil {
+		if errors.Is(err, models.ErrIllegalStatusTransition) {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Code:          cjson.CodeIllegalStatusTransition,
+				Message:       err.Error(),
+				InternalError: err,
+			})
+		}
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
 			Message:       "Not able to update the status",
@@ -202,48 +249,61 @@ func UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	_ = cjson.WriteJSON(w, http.StatusOK, status)
 }
 
-func GetAllOrders(w http.ResponseWriter, r *http.Request) {
-
-	role, ok := r.Context().Value("role").(int)
-
-	if role != 2 || !ok {
+// GetOrderTimeline - List every status change of an order, oldest first
+func GetOrderTimeline(w http.ResponseWriter, r *http.Request) {
+	userId, ok := r.Context().Value("userId").(string)
+	if userId == "" || !ok {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
-			Message:       "You are not authorized to get the orders",
+			Message:       "Authentication required",
 			InternalError: nil,
 		})
 	}
-	limitN := 0
-	offSetN := 0
 
-	limit := r.URL.Query().Get("limit")
-	if limit == "" {
-		limitN = 10
+	orderId := mux.Vars(r)["id"]
+
+	// admins can look at any order, everybody else only at their own
+	var err error
+	if CheckAdmin(w, r) == nil {
+		_, err = models.GetOrderById(r.Context(), orderId)
 	} else {
-		limitN, _ = strconv.Atoi(limit)
+		_, err = models.GetOrderByUserIdAndOrderId(r.Context(), userId, orderId)
 	}
-	offSet := r.URL.Query().Get("offSet")
-	if offSet == "" {
-		offSetN = 5
-	} else {
-		offSetN, _ = strconv.Atoi(offSet)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Code:          cjson.CodeOrderNotFound,
+			Message:       "Order not found or doesn't belong to you",
+			InternalError: err,
+		})
 	}
 
-	//fmt.Println(limitN, offSetN)
-
-	orderAll, err := models.GetAllOrder(limitN, offSetN, "")
+	timeline, err := models.GetOrderStatusHistory(r.Context(), orderId)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get all ordes",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the order timeline",
 			InternalError: err,
 		})
 	}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, orderAll)
+	_ = cjson.WriteJSON(w, http.StatusOK, timeline)
+}
+
+// GetAllOrders lists every order for the admins, see models.OrderListSpec for the filters and sorts.
+func GetAllOrders(w http.ResponseWriter, r *http.Request) {
+	q := listQuery(r, models.OrderListSpec)
 
+	page, err := models.ListOrders(r.Context(), q)
+	if err != nil {
+		listFailed(err, "Not able to get all orders")
+	}
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
 }
 
+// ProcessPayment starts a payment attempt for the order. For online methods it creates an intent with
+// the payment provider and returns what the client needs to pay; the order is only marked paid when
+// the provider's signed webhook arrives. Cash on delivery confirms the order straight away.
 func ProcessPayment(w http.ResponseWriter, r *http.Request) {
 	// Get authenticated user ID
 	userId, ok := r.Context().Value("userId").(string)
@@ -260,82 +320,91 @@ func ProcessPayment(w http.ResponseWriter, r *http.Request) {
 	if orderId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Order ID is required",
 			InternalError: nil,
 		})
 	}
 
 	// Get payment details from request
-	var paymentDetails struct {
-		PaymentMethod string `json:"paymentMethod"`
-		TransactionID string `json:"transactionId,omitempty"`
-		PaymentAmount int    `json:"paymentAmount"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&paymentDetails); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid payment details",
-			InternalError: err,
-		})
-	}
+	var paymentDetails dto.PaymentModel
+	decodeBody(r, &paymentDetails, "Invalid payment details")
+	validateBody(&paymentDetails)
 
 	// Get the order and verify ownership
-	order, err := models.GetOrderByUserIdAndOrderId(userId, orderId)
+	order, err := models.GetOrderByUserIdAndOrderId(r.Context(), userId, orderId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
+			Code:          cjson.CodeOrderNotFound,
 			Message:       "Order not found or doesn't belong to you",
 			InternalError: err,
 		})
 	}
 
-	// Verify payment amount matches order total
-	if paymentDetails.PaymentAmount != order.TotalAmount {
+	// An unpaid order whose stock hold expired has been cancelled by the sweeper
+	if order.Status == models.OrderStatusCancelled {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Payment amount doesn't match order total",
+			Status:        http.StatusConflict,
+			Message:       "Order has been cancelled and can not be paid",
 			InternalError: nil,
 		})
 	}
 
-	// Validate payment method
-	validPaymentMethods := []string{"credit_card", "debit_card", "upi", "netbanking", "cod", "wallet"}
-	isValidMethod := false
-	for _, method := range validPaymentMethods {
-		if method == paymentDetails.PaymentMethod {
-			isValidMethod = true
-			break
-		}
-	}
-
-	if !isValidMethod {
+	if order.PaymentStatus == models.PaymentStatusCompleted {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Invalid payment method",
+			Status:        http.StatusConflict,
+			Message:       "Order is already paid",
 			InternalError: nil,
 		})
 	}
 
-	// Update order with payment information
-	tx := database.DB.Begin()
+	if paymentDetails.PaymentMethod == "cod" {
+		confirmedOrder, err := models.ConfirmCashOnDelivery(r.Context(), order.Id, userId)
+		if err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Failed to process payment",
+				InternalError: err,
+			})
+		}
+		_ = cjson.WriteJSON(w, http.StatusOK, confirmedOrder)
+		return
+	}
 
-	// Update payment method if different from existing
-	if order.PaymentMode != paymentDetails.PaymentMethod {
-		order.PaymentMode = paymentDetails.PaymentMethod
+	provider, err := payment.Default()
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusServiceUnavailable,
+			Code:          cjson.CodePaymentProviderUnknown,
+			Message:       "Payment provider not available",
+			InternalError: err,
+		})
 	}
 
-	// Update payment status to completed
-	order.PaymentStatus = "completed"
+	// The amount always comes from the order, never from the client
+	intent, err := provider.CreateIntent(order.Id, order.TotalAmount, payment.DefaultCurrency)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadGateway,
+			Code:          cjson.CodePaymentProviderError,
+			Message:       "Not able to start the payment with the provider",
+			InternalError: err,
+		})
+	}
 
-	// If payment is completed, update order status to confirmed
-	if order.Status == "pending" {
-		order.Status = "confirmed"
+	newPayment := models.Payment{
+		OrderId:     order.Id,
+		Provider:    provider.Name(),
+		ProviderRef: intent.ProviderRef,
+		Method:      paymentDetails.PaymentMethod,
+		Amount:      intent.Amount,
+		Currency:    intent.Currency,
 	}
 
-	if err := tx.Save(order).Error; err != nil {
-		tx.Rollback()
-		log.Err(err).Msg("Failed to update payment status")
+	createdPayment, err := newPayment.CreatePayment(r.Context())
+	if err != nil {
+		logging.Ctx(r.Context()).Err(err).Msg("Failed to store the payment attempt")
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
 			Message:       "Failed to process payment",
@@ -343,10 +412,15 @@ func ProcessPayment(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	tx.Commit()
+	type PaymentIntentResponse struct {
+		Payment      *models.Payment `json:"payment"`
+		ClientSecret string          `json:"clientSecret"`
+	}
 
-	// Return the updated order
-	_ = cjson.WriteJSON(w, http.StatusOK, order)
+	_ = cjson.WriteJSON(w, http.StatusCreated, PaymentIntentResponse{
+		Payment:      createdPayment,
+		ClientSecret: intent.ClientSecret,
+	})
 }
 
 func generateTrackingNumber() int {
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/payment"
+	"io"
+	"net/http"
+)
+
+/*
+PaymentWebhook - Receive signed payment notifications from a provider
+SimulateFakePayment - Send a signed event through the fake provider (admin only)
+RefundPayment - Give back a payment that is waiting for a refund, e.g. a second payment of an order (admin only)
+*/
+
+const maxWebhookBodySize = 64 << 10
+
+// handlePaymentEvent applies a verified provider event. Every branch is safe to repeat because
+// providers retry deliveries until they get a 2xx.
+func handlePaymentEvent(ctx context.Context, provider payment.Provider, event *payment.Event) error {
+	switch event.Type {
+	case payment.EventPaymentAuthorized:
+		existing, err := models.GetPaymentByProviderRef(ctx, provider.Name(), event.ProviderRef)
+		if err != nil {
+			return err
+		}
+		return provider.Capture(event.ProviderRef, existing.Amount)
+	case payment.EventPaymentSucceeded:
+		_, err := models.CompletePayment(ctx, provider.Name(), event.ProviderRef, event.Amount)
+		return err
+	case payment.EventPaymentFailed:
+		_, err := models.FailPayment(ctx, provider.Name(), event.ProviderRef, event.Reason)
+		return err
+	default:
+		logging.Ctx(ctx).Info().Str("provider", provider.Name()).Str("type", event.Type).Msg("Ignoring payment event")
+		return nil
+	}
+}
+
+func PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	provider, err := payment.Get(mux.Vars(r)["provider"])
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Unknown payment provider",
+			InternalError: err,
+		})
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Not able to read the webhook body",
+			InternalError: err,
+		})
+	}
+
+	event, err := provider.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Webhook signature verification failed",
+			InternalError: err,
+		})
+	}
+
+	if err := handlePaymentEvent(r.Context(), provider, event); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to process the payment event",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, "Event processed")
+}
+
+// SimulateFakePayment lets an admin play the part of the gateway in development. The event is
+// signed by the fake provider and goes through the same verification as a real delivery.
+func SimulateFakePayment(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	provider, err := payment.Get(payment.FakeProviderName)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Fake payment provider is not enabled",
+			InternalError: err,
+		})
+	}
+	fake, ok := provider.(*payment.FakeProvider)
+	if !ok {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Fake payment provider is misconfigured",
+			InternalError: errors.New("registered fake provider has unexpected type"),
+		})
+	}
+
+	var simulateModel dto.SimulatePaymentModel
+	decodeBody(r, &simulateModel, "Not able to decode the event")
+	validateBody(&simulateModel)
+
+	existing, err := models.GetPaymentByProviderRef(r.Context(), payment.FakeProviderName, simulateModel.ProviderRef)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Payment not found",
+			InternalError: err,
+		})
+	}
+
+	amount := simulateModel.Amount
+	if amount == 0 {
+		amount = existing.Amount
+	}
+
+	payload, header, err := fake.BuildWebhook(payment.Event{
+		Type:        simulateModel.Type,
+		ProviderRef: simulateModel.ProviderRef,
+		Amount:      amount,
+		Reason:      simulateModel.Reason,
+	})
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to build the event",
+			InternalError: err,
+		})
+	}
+
+	event, err := fake.VerifyWebhook(payload, header)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to verify the event",
+			InternalError: err,
+		})
+	}
+
+	if err := handlePaymentEvent(r.Context(), fake, event); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Not able to process the payment event",
+			InternalError: err,
+		})
+	}
+
+	updatedPayment, err := models.GetPaymentByProviderRef(r.Context(), payment.FakeProviderName, simulateModel.ProviderRef)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the payment",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, updatedPayment)
+}
+
+// RefundPayment sends back the whole of a payment the order did not need. The payment is claimed
+// before the provider is called, so a retried or concurrent call can not refund it twice.
+func RefundPayment(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	paymentId := r.URL.Query().Get("paymentId")
+	if paymentId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Payment ID is required",
+			InternalError: nil,
+		})
+	}
+
+	claimed, err := models.ClaimPaymentRefund(r.Context(), paymentId)
+	if err != nil {
+		if errors.Is(err, models.ErrPaymentNotRefundable) {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Code:          cjson.CodeRefundInProgress,
+				Message:       "The payment is not waiting for a refund, or its refund is already under way",
+				InternalError: err,
+			})
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to refund the payment",
+			InternalError: err,
+		})
+	}
+
+	provider, err := payment.Get(claimed.Provider)
+	var refundRef string
+	if err == nil {
+		refundRef, err = provider.Refund(claimed.ProviderRef, claimed.Amount)
+	}
+	if err != nil {
+		if releaseErr := models.ReleasePaymentRefund(r.Context(), claimed.Id); releaseErr != nil {
+			logging.Ctx(r.Context()).Err(releaseErr).Str("paymentId", claimed.Id).Msg("Payment left in refunding after a failed refund")
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadGateway,
+			Code:          cjson.CodePaymentProviderError,
+			Message:       "Refund failed at the payment provider",
+			InternalError: err,
+		})
+	}
+
+	refunded, err := models.CompletePaymentRefund(r.Context(), claimed.Id)
+	if err != nil {
+		logging.Ctx(r.Context()).Err(err).Str("paymentId", claimed.Id).Str("refundRef", refundRef).Msg("Refund issued but not recorded")
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to record the refund",
+			InternalError: err,
+		})
+	}
+
+	logging.Ctx(r.Context()).Info().Str("paymentId", refunded.Id).Str("refundRef", refundRef).Msg("Payment refunded")
+	_ = cjson.WriteJSON(w, http.StatusOK, refunded)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"gorm.io/gorm"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// categorySchema loads the attribute schema products of the category have to follow.
+func categorySchema(r *http.Request, categoryId string) []models.CategoryAttribute {
+	schema, err := models.GetCategorySchema(r.Context(), categoryId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			panic(cjson.NewValidationError([]cjson.FieldError{{
+				Field:   "categoryId",
+				Code:    cjson.FieldInvalid,
+				Message: "categoryId is not an existing category",
+			}}))
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the category attributes",
+			InternalError: err,
+		})
+	}
+	return schema
+}
+
+// attributeValues checks input against the schema attributes of level and adds a field error per
+// bad value, named after where the value sits in the body (prefix is "attributes" or
+// "variants[1].attributes").
+func attributeValues(schema []models.CategoryAttribute, level string, input map[string]any, prefix string, fields *[]cjson.FieldError) []models.ProductAttributeValue {
+	values, problems := models.AttributeValues(schema, level, input)
+
+	keys := make([]string, 0, len(problems))
+	for key := range problems {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := prefix + "." + key
+		code := cjson.FieldInvalid
+		if problems[key] == "is required" {
+			code = cjson.FieldRequired
+		}
+		*fields = append(*fields, cjson.FieldError{Field: field, Code: code, Message: field + " " + problems[key]})
+	}
+
+	// the attributes are stored already, only their ids go with the values
+	for i := range values {
+		values[i].Attribute = nil
+	}
+	return values
+}
+
+// attributeFilters turns the attr.<key>, attr.<key>.min and attr.<key>.max parameters of a product
+// listing into conditions on the attribute values of the products and their variants.
+func attributeFilters(r *http.Request, q *listquery.Query, fields *[]cjson.FieldError) {
+	params := r.URL.Query()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if strings.HasPrefix(name, "attr.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		raw := strings.TrimSpace(params.Get(name))
+		key := strings.TrimPrefix(name, "attr.")
+		bound := ""
+		if k, ok := strings.CutSuffix(key, ".min"); ok {
+			key, bound = k, "min"
+		} else if k, ok := strings.CutSuffix(key, ".max"); ok {
+			key, bound = k, "max"
+		}
+		if key == "" || raw == "" {
+			*fields = append(*fields, cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " needs an attribute key and a value"})
+			continue
+		}
+
+		if bound == "" {
+			condition, args := models.AttributeEquals(key, raw)
+			q.Where(condition, args...)
+			continue
+		}
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			*fields = append(*fields, cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " must be a number"})
+			continue
+		}
+		condition, args := models.AttributeAtMost(key, number)
+		if bound == "min" {
+			condition, args = models.AttributeAtLeast(key, number)
+		}
+		q.Where(condition, args...)
+	}
+}
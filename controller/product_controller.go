@@ -1,15 +1,17 @@
 package controller
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pratyush934/sibling-bond-server/cjson"
 	"github.com/pratyush934/sibling-bond-server/database"
 	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/listquery"
 	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/search"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 /*
@@ -37,35 +39,27 @@ func CheckAdmin(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// GetAllProducts lists the active products, see models.ProductListSpec for the filters and sorts it accepts.
 func GetAllProducts(w http.ResponseWriter, r *http.Request) {
+	q := productListQuery(r, models.ProductListSpec)
+	q.Where("is_active = ?", true)
 
-	limit := 10
-	offSet := 5
-
-	limitStr := r.URL.Query().Get("limit")
-	offSetStr := r.URL.Query().Get("offset")
-
-	if limitStr == "" || offSetStr == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Please provide limitStr or OffSetStr",
-			InternalError: nil,
-		})
+	page, err := models.ListProducts(r.Context(), q)
+	if err != nil {
+		listFailed(err, "Not able to get the Products")
 	}
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
+}
 
-	limit, _ = strconv.Atoi(limitStr)
-	offSet, _ = strconv.Atoi(offSetStr)
+// GetAllProductsByAdmin lists every product, inactive ones included, see models.AdminProductListSpec.
+func GetAllProductsByAdmin(w http.ResponseWriter, r *http.Request) {
+	q := productListQuery(r, models.AdminProductListSpec)
 
-	products, err := models.GetAllProducts(limit, offSet)
+	page, err := models.ListProducts(r.Context(), q)
 	if err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the Products",
-			InternalError: err,
-		})
+		listFailed(err, "Not able to get the Products")
 	}
-	_ = cjson.WriteJSON(w, http.StatusOK, products)
-
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
 }
 
 func GetProductById(w http.ResponseWriter, r *http.Request) {
@@ -74,14 +68,16 @@ func GetProductById(w http.ResponseWriter, r *http.Request) {
 	if productId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Please provide productId",
 			InternalError: nil,
 		})
 	}
-	productById, err := models.GetProductById(productId)
+	productById, err := models.GetProductById(r.Context(), productId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
+			Code:          cjson.CodeProductNotFound,
 			Message:       "Not found the product, the Id may be wrong",
 			InternalError: err,
 		})
@@ -89,74 +85,168 @@ func GetProductById(w http.ResponseWriter, r *http.Request) {
 	_ = cjson.WriteJSON(w, http.StatusOK, productById)
 }
 
+// SearchProduct ranks products by relevance to q and returns facet counts for narrowing down.
+// Filters: categoryId, minPrice and maxPrice in paise, inStock, and attr.<name>=<value> per variant
+// attribute, e.g. attr.size=m.
 func SearchProduct(w http.ResponseWriter, r *http.Request) {
-	limit, offSet := 10, 5
-
-	limitStr := r.URL.Query().Get("limit")
-	offSetStr := r.URL.Query().Get("offSet")
-	categoryId := r.URL.Query().Get("categoryId")
-	search := r.URL.Query().Get("search")
-
-	if limitStr == "" || offSetStr == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Please provide limitStr or OffSetStr",
-			InternalError: nil,
-		})
+	query := searchQuery(r)
+	if categoryId := r.URL.Query().Get("categoryId"); categoryId != "" {
+		query.CategoryIds = categoryIds(r, categoryId)
 	}
 
-	limit, _ = strconv.Atoi(limitStr)
-	offSet, _ = strconv.Atoi(offSetStr)
-
-	allProducts, err := models.GetAllProductsWithQueries(limit, offSet, categoryId, search)
+	products, result, err := models.SearchProducts(r.Context(), query)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the products",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to search the products",
 			InternalError: err,
 		})
 	}
-	_ = cjson.WriteJSON(w, http.StatusOK, allProducts)
+
+	type SearchResponse struct {
+		Products []models.Product `json:"products"`
+		Total    int              `json:"total"`
+		Limit    int              `json:"limit"`
+		Offset   int              `json:"offset"`
+		Facets   search.Facets    `json:"facets"`
+	}
+	_ = cjson.WriteJSON(w, http.StatusOK, SearchResponse{
+		Products: products,
+		Total:    result.Total,
+		Limit:    query.Limit,
+		Offset:   query.Offset,
+		Facets:   result.Facets,
+	})
 }
 
-func GetProductsByCategory(w http.ResponseWriter, r *http.Request) {
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchQuery reads the search parameters, every malformed one is reported in a single 400.
+func searchQuery(r *http.Request) search.Query {
+	params := r.URL.Query()
+	var fields []cjson.FieldError
+
+	intParam := func(name string, min int) *int {
+		raw := params.Get(name)
+		if raw == "" {
+			return nil
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			fields = append(fields, cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: "must be a whole number"})
+			return nil
+		}
+		if value < min {
+			fields = append(fields, cjson.FieldError{Field: name, Code: cjson.FieldTooSmall, Message: fmt.Sprintf("must be at least %d", min)})
+			return nil
+		}
+		return &value
+	}
 
-	limit := 10
-	offSet := 5
+	query := search.Query{
+		Text:       params.Get("q"),
+		MinPrice:   intParam("minPrice", 0),
+		MaxPrice:   intParam("maxPrice", 0),
+		Attributes: map[string]string{},
+		Limit:      defaultSearchLimit,
+	}
+	// search and offSet are the names the endpoint used to take
+	if query.Text == "" {
+		query.Text = params.Get("search")
+	}
+	if limit := intParam("limit", 1); limit != nil {
+		query.Limit = *limit
+	}
+	if query.Limit > maxSearchLimit {
+		fields = append(fields, cjson.FieldError{Field: "limit", Code: cjson.FieldTooLarge, Message: fmt.Sprintf("must be at most %d", maxSearchLimit)})
+	}
+	offsetName := "offset"
+	if params.Get(offsetName) == "" && params.Get("offSet") != "" {
+		offsetName = "offSet"
+	}
+	if offset := intParam(offsetName, 0); offset != nil {
+		query.Offset = *offset
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		fields = append(fields, cjson.FieldError{Field: "maxPrice", Code: cjson.FieldInvalid, Message: "can not be below minPrice"})
+	}
 
-	limitStr := r.URL.Query().Get("limit")
-	offSetStr := r.URL.Query().Get("offset")
+	if raw := params.Get("inStock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, cjson.FieldError{Field: "inStock", Code: cjson.FieldInvalid, Message: "must be true or false"})
+		} else {
+			query.InStock = &inStock
+		}
+	}
 
-	if limitStr == "" || offSetStr == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Please provide limitStr or OffSetStr",
-			InternalError: nil,
-		})
+	for key, values := range params {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value := strings.ToLower(strings.TrimSpace(values[0]))
+		if name == "" || value == "" {
+			fields = append(fields, cjson.FieldError{Field: key, Code: cjson.FieldInvalid, Message: "needs an attribute name and a value"})
+			continue
+		}
+		query.Attributes[name] = value
 	}
 
-	limit, _ = strconv.Atoi(limitStr)
-	offSet, _ = strconv.Atoi(offSetStr)
-	categoryId := r.URL.Query().Get("categoryId")
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+	return query
+}
 
-	if categoryId == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Please provide limitStr or OffSetStr",
-			InternalError: nil,
-		})
+// productListQuery is the list query of a product listing, a categoryId also takes in the products
+// of its subcategories and attr.<key> parameters filter by attribute values.
+func productListQuery(r *http.Request, spec listquery.Spec) *listquery.Query {
+	q, fields := listquery.Parse(r.URL.Query(), spec)
+	attributeFilters(r, q, &fields)
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+	if categoryId := r.URL.Query().Get("categoryId"); categoryId != "" {
+		q.Where("category_id IN ?", categoryIds(r, categoryId))
 	}
+	return q
+}
 
-	productById, err := models.GetProductsByCategoryId(categoryId, limit, offSet)
+func categoryIds(r *http.Request, categoryId string) []string {
+	ids, err := models.CategoryWithDescendants(r.Context(), categoryId)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the products",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the subcategories",
 			InternalError: err,
 		})
 	}
+	return ids
+}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, productById)
+// GetProductsByCategory is GetAllProducts with the categoryId filter required.
+func GetProductsByCategory(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("categoryId") == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Please provide the categoryId",
+			InternalError: nil,
+		})
+	}
+	q := productListQuery(r, models.ProductListSpec)
+	q.Where("is_active = ?", true)
+
+	page, err := models.ListProducts(r.Context(), q)
+	if err != nil {
+		listFailed(err, "Not able to get the products")
+	}
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
 }
 
 func CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -171,22 +261,8 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var productModel dto.ProductModel
-
-	if err := json.NewDecoder(r.Body).Decode(&productModel); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to decode the Product",
-			InternalError: err,
-		})
-	}
-
-	if productModel.Name == "" || productModel.CategoryId == "" || productModel.Price <= 0 {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Need to feed Name, CategoryId, Price",
-			InternalError: nil,
-		})
-	}
+	decodeBody(r, &productModel, "Not able to decode the Product")
+	validateBody(&productModel)
 
 	newProduct := models.Product{
 		Name:          productModel.Name,
@@ -204,19 +280,25 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 		Dimensions:    productModel.Dimensions,
 	}
 
+	// attribute values are checked against the category's schema, all problems in one response
+	schema := categorySchema(r, productModel.CategoryId)
+	var fields []cjson.FieldError
+	newProduct.Attributes = attributeValues(schema, models.AttributeLevelProduct, productModel.Attributes, "attributes", &fields)
+
 	if len(productModel.Variants) > 0 {
 		variants := make([]models.ProductVariant, 0, len(productModel.Variants))
-		for _, v := range productModel.Variants {
-			variants = append(variants, models.ProductVariant{
-				VariantName:  v.Name,
-				VariantValue: v.Value,
-				Price:        v.PriceAdjustment,
-			})
+		for i, v := range productModel.Variants {
+			variant := newVariantFromDTO(v)
+			variant.Attributes = attributeValues(schema, models.AttributeLevelVariant, v.Attributes, fmt.Sprintf("variants[%d].attributes", i), &fields)
+			variants = append(variants, variant)
 		}
 		newProduct.Variants = variants
 	}
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
 
-	product, err := newProduct.CreateProduct()
+	product, err := newProduct.CreateProduct(r.Context())
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
@@ -236,7 +318,7 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 				IsPrimary: i == 0,
 			}
 
-			_, err := image.CreateImage()
+			_, err := image.CreateImage(r.Context())
 			if err != nil {
 				panic(&cjson.HTTPError{
 					Status:        http.StatusBadRequest,
@@ -247,7 +329,7 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 		}
 
 	}
-	productById, err := models.GetProductById(product.Id)
+	productById, err := models.GetProductById(r.Context(), product.Id)
 
 	if err != nil {
 		_ = cjson.WriteJSON(w, http.StatusCreated, product)
@@ -273,27 +355,81 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 	if productId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Product ID is required",
 			InternalError: nil,
 		})
 	}
 
-	_, err = models.GetProductById(productId)
+	existing, err := models.GetProductById(r.Context(), productId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
+			Code:          cjson.CodeProductNotFound,
 			Message:       "Product not found",
 			InternalError: err,
 		})
 	}
 
 	var productModel dto.ProductModel
-	if err := json.NewDecoder(r.Body).Decode(&productModel); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Not able to Decode the Product",
-			InternalError: err,
-		})
+	decodeBody(r, &productModel, "Not able to Decode the Product")
+	validatePartial(&productModel)
+
+	// Check the attribute values against the schema of the category the product ends up in. Moving
+	// to another category checks the stored values again, they have to fit the new schema too.
+	categoryChanged := productModel.CategoryId != "" && productModel.CategoryId != existing.CategoryId
+	var schema []models.CategoryAttribute
+	var fields []cjson.FieldError
+	var productValues []models.ProductAttributeValue
+	var newVariantValues [][]models.ProductAttributeValue
+	variantValues := map[string][]models.ProductAttributeValue{}
+	if productModel.Attributes != nil || categoryChanged || len(productModel.Variants) > 0 {
+		categoryId := existing.CategoryId
+		if categoryChanged {
+			categoryId = productModel.CategoryId
+		}
+		schema = categorySchema(r, categoryId)
+
+		// the given values go over the stored ones, null drops one
+		input := models.AttributeInput(existing.Attributes)
+		for key, value := range productModel.Attributes {
+			if value == nil {
+				delete(input, key)
+				continue
+			}
+			input[key] = value
+		}
+		productValues = attributeValues(schema, models.AttributeLevelProduct, input, "attributes", &fields)
+
+		for i, v := range productModel.Variants {
+			newVariantValues = append(newVariantValues, attributeValues(schema, models.AttributeLevelVariant, v.Attributes, fmt.Sprintf("variants[%d].attributes", i), &fields))
+		}
+		if categoryChanged && len(productModel.Variants) == 0 {
+			for i, variant := range existing.Variants {
+				variantValues[variant.Id] = attributeValues(schema, models.AttributeLevelVariant, models.AttributeInput(variant.Attributes), fmt.Sprintf("variants[%d].attributes", i), &fields)
+			}
+		}
+	}
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+	if productModel.Attributes != nil || categoryChanged {
+		if err := models.ReplaceAttributeValues(r.Context(), &productId, nil, productValues); err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Failed to save the product attributes",
+				InternalError: err,
+			})
+		}
+	}
+	for variantId, values := range variantValues {
+		if err := models.ReplaceAttributeValues(r.Context(), nil, &variantId, values); err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Failed to save the variant attributes",
+				InternalError: err,
+			})
+		}
 	}
 
 	// Update product WITHOUT images field
@@ -316,7 +452,7 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 
 	// Handle variants
 	if len(productModel.Variants) > 0 {
-		if err := database.DB.Where("product_id = ?", productId).Delete(&models.ProductVariant{}).Error; err != nil {
+		if err := database.DB.WithContext(r.Context()).Where("product_id = ?", productId).Delete(&models.ProductVariant{}).Error; err != nil {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusInternalServerError,
 				Message:       "Failed to delete existing product variants",
@@ -325,13 +461,11 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 		}
 
 		variants := make([]models.ProductVariant, 0, len(productModel.Variants))
-		for _, v := range productModel.Variants {
-			variants = append(variants, models.ProductVariant{
-				ProductId:    productId,
-				VariantName:  v.Name,
-				VariantValue: v.Value,
-				Price:        v.PriceAdjustment,
-			})
+		for i, v := range productModel.Variants {
+			variant := newVariantFromDTO(v)
+			variant.ProductId = productId
+			variant.Attributes = newVariantValues[i]
+			variants = append(variants, variant)
 		}
 		updateProduct.Variants = variants
 	}
@@ -339,7 +473,7 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 	// Handle images separately if provided
 	if len(productModel.Images) > 0 {
 		// Delete existing images
-		if err := database.DB.Where("product_id = ?", productId).Delete(&models.Image{}).Error; err != nil {
+		if err := database.DB.WithContext(r.Context()).Where("product_id = ?", productId).Delete(&models.Image{}).Error; err != nil {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusInternalServerError,
 				Message:       "Failed to delete existing images",
@@ -358,7 +492,7 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 				IsPrimary: i == 0,
 			}
 
-			_, err := image.CreateImage()
+			_, err := image.CreateImage(r.Context())
 			if err != nil {
 				panic(&cjson.HTTPError{
 					Status:        http.StatusInternalServerError,
@@ -369,7 +503,7 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	product, err := models.UpdateProduct(&updateProduct)
+	product, err := models.UpdateProduct(r.Context(), &updateProduct)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
@@ -379,7 +513,7 @@ func UpdateProductDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch complete product with images
-	completeProduct, err := models.GetProductById(productId)
+	completeProduct, err := models.GetProductById(r.Context(), productId)
 	if err != nil {
 		_ = cjson.WriteJSON(w, http.StatusOK, product)
 		return
@@ -411,7 +545,7 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	err := models.DeleteProduct(productId)
+	err := models.DeleteProduct(r.Context(), productId)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -457,7 +591,7 @@ func UpdateProductQuantity(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	productById, err := models.GetProductById(productId)
+	productById, err := models.GetProductById(r.Context(), productId)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -466,7 +600,7 @@ func UpdateProductQuantity(w http.ResponseWriter, r *http.Request) {
 			InternalError: err,
 		})
 	}
-	_, err = productById.UpdateStock(quantity, operationStr)
+	_, err = productById.UpdateStock(r.Context(), quantity, operationStr)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -476,7 +610,7 @@ func UpdateProductQuantity(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	newProductById, err := models.GetProductById(productId)
+	newProductById, err := models.GetProductById(r.Context(), productId)
 
 	_ = cjson.WriteJSON(w, http.StatusOK, newProductById)
 
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/catalog"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+ImportProducts - Upload a CSV or NDJSON catalog file, imported by a background job (admin only)
+GetProductImport - Progress and report of an import job (admin only)
+ExportProducts - Download the whole catalog as CSV or NDJSON (admin only)
+*/
+
+const (
+	maxImportSize = 20 << 20
+	// importUploadTimeout replaces the server's read timeout while a catalog file is uploaded
+	importUploadTimeout = 5 * time.Minute
+	// exportBatchTimeout is how long writing one batch of the export may take
+	exportBatchTimeout = time.Minute
+)
+
+// ImportProducts - The file is the request body or the "file" field of a multipart form. format
+// is csv or ndjson, guessed from the file name or content type when left out. With dryRun=true
+// every product is checked and saved inside a transaction that is rolled back, the job's report
+// then says what an import would do.
+func ImportProducts(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	params := r.URL.Query()
+	var fields []cjson.FieldError
+	dryRun := false
+	if raw := params.Get("dryRun"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, cjson.FieldError{Field: "dryRun", Code: cjson.FieldInvalid, Message: "dryRun must be true or false"})
+		}
+		dryRun = parsed
+	}
+
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(importUploadTimeout))
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	payload, fileName := importPayload(r)
+
+	format := params.Get("format")
+	if format == "" {
+		format = catalog.FormatFromName(fileName)
+	}
+	if format == "" {
+		format = catalog.FormatFromName(r.Header.Get("Content-Type"))
+	}
+	switch {
+	case format == "":
+		fields = append(fields, cjson.FieldError{Field: "format", Code: cjson.FieldRequired, Message: "format is required when the file name does not tell, use csv or ndjson"})
+	case format != catalog.FormatCSV && format != catalog.FormatNDJSON:
+		fields = append(fields, cjson.FieldError{Field: "format", Code: cjson.FieldInvalid, Message: "format must be one of: csv, ndjson"})
+	}
+	if len(payload) == 0 {
+		fields = append(fields, cjson.FieldError{Field: "file", Code: cjson.FieldRequired, Message: "file is required"})
+	}
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+
+	// a file that can not be read at all is refused now, problems with single products go in the report
+	total, err := catalog.CountRecords(format, payload)
+	if err == nil && total == 0 {
+		err = errors.New("the file has no products")
+	}
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeImportFileInvalid,
+			Message:       "The file can not be imported: " + err.Error(),
+			InternalError: err,
+		})
+	}
+
+	userId, _ := r.Context().Value("userId").(string)
+	job := models.ProductImportJob{
+		Format:    format,
+		FileName:  fileName,
+		DryRun:    dryRun,
+		Payload:   payload,
+		CreatedBy: userId,
+		TotalRows: total,
+	}
+	createdJob, err := job.CreateProductImport(r.Context())
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to queue the import",
+			InternalError: err,
+		})
+	}
+	catalog.Wake()
+
+	_ = cjson.WriteJSON(w, http.StatusAccepted, createdJob)
+}
+
+// importPayload reads the uploaded file and its name, empty for a plain body.
+func importPayload(r *http.Request) ([]byte, string) {
+	var body io.Reader = r.Body
+	fileName := ""
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			importReadFailed(err)
+		}
+		defer file.Close()
+		body, fileName = file, header.Filename
+	}
+
+	payload, err := io.ReadAll(body)
+	if err != nil {
+		importReadFailed(err)
+	}
+	return payload, fileName
+}
+
+func importReadFailed(err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusRequestEntityTooLarge,
+			Code:          cjson.CodeBodyTooLarge,
+			Message:       fmt.Sprintf("The file must be at most %d MB", maxImportSize>>20),
+			InternalError: err,
+		})
+	}
+	if errors.Is(err, http.ErrMissingFile) {
+		panic(cjson.NewValidationError([]cjson.FieldError{{Field: "file", Code: cjson.FieldRequired, Message: "file is required"}}))
+	}
+	panic(&cjson.HTTPError{
+		Status:        http.StatusBadRequest,
+		Code:          cjson.CodeInvalidBody,
+		Message:       "Not able to read the uploaded file",
+		InternalError: err,
+	})
+}
+
+func GetProductImport(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	jobId := r.URL.Query().Get("jobId")
+	if jobId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Job ID is required",
+			InternalError: nil,
+		})
+	}
+
+	job, err := models.GetProductImportById(r.Context(), jobId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Import not found",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, job)
+}
+
+// ExportProducts - Streams the catalog in the format ImportProducts takes, csv unless format=ndjson.
+func ExportProducts(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	format := r.URL.Query().Get("format")
+	contentType := ""
+	switch format {
+	case "", catalog.FormatCSV:
+		format, contentType = catalog.FormatCSV, "text/csv; charset=utf-8"
+	case catalog.FormatNDJSON:
+		contentType = "application/x-ndjson"
+	default:
+		panic(cjson.NewValidationError([]cjson.FieldError{{Field: "format", Code: cjson.FieldInvalid, Message: "format must be one of: csv, ndjson"}}))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"products-%s.%s\"", time.Now().Format("20060102"), format))
+
+	// the whole catalog takes longer than the server's write timeout, every batch gets its own
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(exportBatchTimeout))
+	out := &countingWriter{w: w}
+	err := catalog.Export(r.Context(), out, format, func() error {
+		_ = rc.Flush()
+		_ = rc.SetWriteDeadline(time.Now().Add(exportBatchTimeout))
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	if out.n == 0 {
+		w.Header().Del("Content-Disposition")
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to export the products",
+			InternalError: err,
+		})
+	}
+	// the status is sent already, cutting the connection tells the client the file is incomplete
+	logging.Ctx(r.Context()).Err(err).Int64("bytes", out.n).Msg("Product export failed part way")
+	panic(http.ErrAbortHandler)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
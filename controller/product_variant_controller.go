@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"net/http"
+)
+
+/*
+GetProductVariants - List variants of a product (admin only)
+CreateProductVariant - Add a variant to a product (admin only)
+UpdateProductVariant - Update variant details, price adjustment and stock (admin only)
+DeleteProductVariant - Remove a variant (admin only)
+*/
+
+func newVariantFromDTO(v dto.ProductVariantDTO) models.ProductVariant {
+	isActive := true
+	if v.IsActive != nil {
+		isActive = *v.IsActive
+	}
+	return models.ProductVariant{
+		VariantName:     v.Name,
+		VariantValue:    v.Value,
+		PriceAdjustment: v.PriceAdjustment,
+		Stock:           v.Stock,
+		SKU:             v.SKU,
+		IsActive:        isActive,
+	}
+}
+
+func validateVariantDTO(v dto.ProductVariantDTO, basePrice int) {
+	validateBody(&v)
+	// the tags can not see the product, the final price is checked here
+	if basePrice+v.PriceAdjustment <= 0 {
+		panic(cjson.NewValidationError([]cjson.FieldError{{
+			Field:   "priceAdjustment",
+			Code:    cjson.FieldInvalid,
+			Message: "Price adjustment makes the variant price zero or negative",
+		}}))
+	}
+}
+
+// variantAttributes checks the attribute values of a variant against its product's category.
+func variantAttributes(r *http.Request, categoryId string, input map[string]any) []models.ProductAttributeValue {
+	var fields []cjson.FieldError
+	values := attributeValues(categorySchema(r, categoryId), models.AttributeLevelVariant, input, "attributes", &fields)
+	if len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+	return values
+}
+
+func GetProductVariants(w http.ResponseWriter, r *http.Request) {
+	productId := mux.Vars(r)["id"]
+
+	if _, err := models.GetProductById(r.Context(), productId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Product not found",
+			InternalError: err,
+		})
+	}
+
+	variants, err := models.GetAllProductVariants(r.Context(), productId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the variants",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, variants)
+}
+
+func CreateProductVariant(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	productId := mux.Vars(r)["id"]
+
+	product, err := models.GetProductById(r.Context(), productId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Product not found",
+			InternalError: err,
+		})
+	}
+
+	var variantModel dto.ProductVariantDTO
+	decodeBody(r, &variantModel, "Not able to decode the variant")
+
+	validateVariantDTO(variantModel, product.Price)
+
+	variant := newVariantFromDTO(variantModel)
+	variant.ProductId = productId
+	variant.Attributes = variantAttributes(r, product.CategoryId, variantModel.Attributes)
+
+	createdVariant, err := variant.CreateVariant(r.Context())
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Not able to create the variant",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusCreated, createdVariant)
+}
+
+func UpdateProductVariant(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	vars := mux.Vars(r)
+	productId := vars["id"]
+	variantId := vars["variantId"]
+
+	product, err := models.GetProductById(r.Context(), productId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Product not found",
+			InternalError: err,
+		})
+	}
+
+	existingVariant, err := models.GetVariantById(r.Context(), productId, variantId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Variant not found",
+			InternalError: err,
+		})
+	}
+
+	var variantModel dto.ProductVariantDTO
+	decodeBody(r, &variantModel, "Not able to decode the variant")
+
+	validateVariantDTO(variantModel, product.Price)
+
+	existingVariant.VariantName = variantModel.Name
+	existingVariant.VariantValue = variantModel.Value
+	existingVariant.PriceAdjustment = variantModel.PriceAdjustment
+	existingVariant.Stock = variantModel.Stock
+	if variantModel.SKU != "" {
+		existingVariant.SKU = variantModel.SKU
+	}
+	if variantModel.IsActive != nil {
+		existingVariant.IsActive = *variantModel.IsActive
+	}
+	if variantModel.Attributes != nil {
+		values := variantAttributes(r, product.CategoryId, variantModel.Attributes)
+		if err := models.ReplaceAttributeValues(r.Context(), nil, &variantId, values); err != nil {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusInternalServerError,
+				Message:       "Not able to save the variant attributes",
+				InternalError: err,
+			})
+		}
+	}
+
+	updatedVariant, err := models.UpdateVariant(r.Context(), existingVariant)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to update the variant",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, updatedVariant)
+}
+
+func DeleteProductVariant(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	vars := mux.Vars(r)
+	productId := vars["id"]
+	variantId := vars["variantId"]
+
+	if _, err := models.GetVariantById(r.Context(), productId, variantId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Variant not found",
+			InternalError: err,
+		})
+	}
+
+	if err := models.DeleteVariant(r.Context(), productId, variantId); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to delete the variant",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, "Variant deleted successfully")
+}
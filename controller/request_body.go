@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/validation"
+	"net/http"
+)
+
+// decodeBody reads the JSON body into v, a body that does not parse is a 400 with the given message.
+func decodeBody(r *http.Request, v any, message string) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeInvalidBody,
+			Message:       message,
+			InternalError: err,
+		})
+	}
+}
+
+// validateBody checks the validate tags of v and answers with every failing field in one response.
+func validateBody(v any) {
+	if fields := validation.Struct(v); len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+}
+
+// validatePartial is validateBody for updates, where an empty field keeps the stored value.
+func validatePartial(v any) {
+	if fields := validation.Partial(v); len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
+	}
+}
@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/payment"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+/*
+CreateReturnRequest - Ask to return items of a delivered order
+GetMyReturnRequests - List the user's return requests
+GetAllReturnRequests - List return requests, optionally by status (admin only)
+GetReturnRequestById - Get one return request (admin only)
+ApproveReturn - Approve a requested return (admin only)
+RejectReturn - Reject a requested return (admin only)
+ReceiveReturn - Mark the goods as received, restock them and refund the line (admin only)
+RefundReturn - Retry the refund of a received return (admin only)
+RefundOrder - Refund whatever is left to refund on an order (admin only)
+*/
+
+// issueRefund sends the refund to the provider that took the payment. Orders without a provider
+// payment (cash on delivery) are refunded by hand and get a nil payment id.
+func issueRefund(ctx context.Context, orderId string, amount int) (*string, string, error) {
+	if amount <= 0 {
+		return nil, "", nil
+	}
+
+	paid, err := models.GetRefundablePayment(ctx, orderId, amount)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "manual", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	provider, err := payment.Get(paid.Provider)
+	if err != nil {
+		return nil, "", err
+	}
+	refundRef, err := provider.Refund(paid.ProviderRef, amount)
+	if err != nil {
+		return nil, "", err
+	}
+	return &paid.Id, refundRef, nil
+}
+
+func returnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrReturnStatusChanged), errors.Is(err, models.ErrRefundExceedsPaid),
+		errors.Is(err, models.ErrRefundInProgress), errors.Is(err, models.ErrNothingToRefund):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// returnErrorCode pairs returnErrorStatus with the code for the conflicts a client can act on.
+func returnErrorCode(err error) string {
+	switch {
+	case errors.Is(err, models.ErrReturnStatusChanged):
+		return cjson.CodeReturnStatusChanged
+	case errors.Is(err, models.ErrRefundExceedsPaid):
+		return cjson.CodeRefundExceedsPaid
+	case errors.Is(err, models.ErrRefundInProgress):
+		return cjson.CodeRefundInProgress
+	}
+	return ""
+}
+
+// refundReceivedReturn claims the received return before the provider is called, so two calls for
+// the same return can not both send money.
+func refundReceivedReturn(ctx context.Context, returnId, adminId string) *models.ReturnRequest {
+	rr, err := models.ClaimReturnRefund(ctx, returnId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Only received returns that are not being refunded already can be refunded",
+			InternalError: err,
+		})
+	}
+
+	paymentId, refundRef, err := issueRefund(ctx, rr.OrderId, rr.RefundAmount)
+	if err != nil {
+		if releaseErr := models.ReleaseReturnRefund(ctx, rr.Id); releaseErr != nil {
+			logging.Ctx(ctx).Err(releaseErr).Str("returnId", rr.Id).Msg("Return left in refunding after a failed refund")
+		}
+		// the goods are back in stock already, the refund can be retried through RefundReturn
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadGateway,
+			Code:          cjson.CodePaymentProviderError,
+			Message:       "Return received but the refund failed, please retry the refund",
+			InternalError: err,
+		})
+	}
+
+	refunded, err := models.CompleteReturnRefund(ctx, rr.Id, paymentId, refundRef, adminId)
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Str("returnId", rr.Id).Str("refundRef", refundRef).Msg("Refund issued but not recorded")
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to record the refund",
+			InternalError: err,
+		})
+	}
+	return refunded
+}
+
+func CreateReturnRequest(w http.ResponseWriter, r *http.Request) {
+	userId, ok := r.Context().Value("userId").(string)
+	if userId == "" || !ok {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Authentication required",
+			InternalError: nil,
+		})
+	}
+
+	var returnModel dto.ReturnRequestModel
+	decodeBody(r, &returnModel, "Not able to decode the return request")
+	validateBody(&returnModel)
+
+	returnRequest := models.ReturnRequest{
+		OrderId:     returnModel.OrderId,
+		OrderItemId: returnModel.OrderItemId,
+		UserId:      userId,
+		Quantity:    returnModel.Quantity,
+		Reason:      returnModel.Reason,
+		Comment:     returnModel.Comment,
+	}
+
+	createdReturn, err := returnRequest.CreateReturnRequest(r.Context())
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to create the return request: " + err.Error(),
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusCreated, createdReturn)
+}
+
+func GetMyReturnRequests(w http.ResponseWriter, r *http.Request) {
+	userId, ok := r.Context().Value("userId").(string)
+	if userId == "" || !ok {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Authentication required",
+			InternalError: nil,
+		})
+	}
+
+	requests, err := models.GetReturnRequestsByUserId(r.Context(), userId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the return requests",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, requests)
+}
+
+func GetAllReturnRequests(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	limit := 10
+	offset := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			limit = v
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if v, err := strconv.Atoi(o); err == nil && v >= 0 {
+			offset = v
+		}
+	}
+
+	requests, err := models.GetAllReturnRequests(r.Context(), limit, offset, r.URL.Query().Get("status"))
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the return requests",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, requests)
+}
+
+func GetReturnRequestById(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+
+	returnRequest, err := models.GetReturnRequestById(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Return request not found",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, returnRequest)
+}
+
+// decodeReviewNote reads the optional admin note, an empty body is fine.
+func decodeReviewNote(r *http.Request) string {
+	var reviewModel dto.ReviewReturnModel
+	if r.ContentLength != 0 {
+		decodeBody(r, &reviewModel, "Not able to decode the review")
+		validateBody(&reviewModel)
+	}
+	return reviewModel.Note
+}
+
+func ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+	adminId, _ := r.Context().Value("userId").(string)
+
+	approved, err := models.ApproveReturn(r.Context(), mux.Vars(r)["id"], adminId, decodeReviewNote(r))
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to approve the return",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, approved)
+}
+
+func RejectReturn(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+	adminId, _ := r.Context().Value("userId").(string)
+
+	note := decodeReviewNote(r)
+	if note == "" {
+		panic(cjson.NewValidationError([]cjson.FieldError{{
+			Field:   "note",
+			Code:    cjson.FieldRequired,
+			Message: "A note explaining the rejection is required",
+		}}))
+	}
+
+	rejected, err := models.RejectReturn(r.Context(), mux.Vars(r)["id"], adminId, note)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to reject the return",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, rejected)
+}
+
+func ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+	adminId, _ := r.Context().Value("userId").(string)
+
+	received, err := models.ReceiveReturn(r.Context(), mux.Vars(r)["id"], adminId, decodeReviewNote(r))
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to receive the return",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, refundReceivedReturn(r.Context(), received.Id, adminId))
+}
+
+func RefundReturn(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+	adminId, _ := r.Context().Value("userId").(string)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, refundReceivedReturn(r.Context(), mux.Vars(r)["id"], adminId))
+}
+
+func RefundOrder(w http.ResponseWriter, r *http.Request) {
+	if err := CheckAdmin(w, r); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "Not an admin",
+			InternalError: err,
+		})
+	}
+	adminId, _ := r.Context().Value("userId").(string)
+
+	orderId := r.URL.Query().Get("orderId")
+	if orderId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
+			Message:       "Order ID is required",
+			InternalError: nil,
+		})
+	}
+
+	// the order is claimed before the provider is called, a second call gets a conflict instead of a second refund
+	order, amount, err := models.ClaimOrderRefund(r.Context(), orderId)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			panic(&cjson.HTTPError{
+				Status:        http.StatusNotFound,
+				Code:          cjson.CodeOrderNotFound,
+				Message:       "Order not found",
+				InternalError: err,
+			})
+		case errors.Is(err, models.ErrNothingToRefund):
+			panic(&cjson.HTTPError{
+				Status:        http.StatusConflict,
+				Message:       "Nothing left to refund on this order",
+				InternalError: err,
+			})
+		}
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to refund the order",
+			InternalError: err,
+		})
+	}
+
+	paymentId, refundRef, err := issueRefund(r.Context(), order.Id, amount)
+	if err != nil {
+		if releaseErr := models.ReleaseOrderRefund(r.Context(), order.Id, order.PaymentStatus); releaseErr != nil {
+			logging.Ctx(r.Context()).Err(releaseErr).Str("orderId", order.Id).Msg("Order left in refunding after a failed refund")
+		}
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadGateway,
+			Code:          cjson.CodePaymentProviderError,
+			Message:       "Refund failed at the payment provider",
+			InternalError: err,
+		})
+	}
+
+	refundedOrder, err := models.RefundOrder(r.Context(), order.Id, paymentId, amount, adminId)
+	if err != nil {
+		logging.Ctx(r.Context()).Err(err).Str("orderId", order.Id).Str("refundRef", refundRef).Msg("Refund issued but not recorded")
+		panic(&cjson.HTTPError{
+			Status:        returnErrorStatus(err),
+			Code:          returnErrorCode(err),
+			Message:       "Not able to record the refund",
+			InternalError: err,
+		})
+	}
+
+	_ = cjson.WriteJSON(w, http.StatusOK, refundedOrder)
+}
@@ -1,7 +1,8 @@
 package controller
 
 import (
-	"encoding/json"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/dto"
 	"github.com/pratyush934/sibling-bond-server/models"
 	"net/http"
 	"strconv"
@@ -19,73 +20,93 @@ func NewRoleController(db *gorm.DB) *RoleController {
 }
 
 func (rc *RoleController) CreateRole(w http.ResponseWriter, r *http.Request) {
-	var role models.Role
-	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	var roleModel dto.RoleModel
+	decodeBody(r, &roleModel, "Not able to decode the role")
+	validateBody(&roleModel)
+	role := models.Role{RoleName: roleModel.RoleName, Description: roleModel.Description}
 	if err := models.CreateRole(rc.DB, &role); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to save the role",
+			InternalError: err,
+		})
 	}
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(role)
+	_ = cjson.WriteJSON(w, http.StatusCreated, role)
 }
 
 func (rc *RoleController) GetRoleByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Invalid ID",
+			InternalError: err,
+		})
 	}
 	role, err := models.GetRoleByID(rc.DB, id)
 	if err != nil {
-		http.Error(w, "Role not found", http.StatusNotFound)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusNotFound,
+			Message:       "Role not found",
+			InternalError: err,
+		})
 	}
-	_ = json.NewEncoder(w).Encode(role)
+	_ = cjson.WriteJSON(w, http.StatusOK, role)
 }
 
 func (rc *RoleController) GetAllRoles(w http.ResponseWriter, r *http.Request) {
 	roles, err := models.GetAllRoles(rc.DB)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to get the roles",
+			InternalError: err,
+		})
 	}
-	_ = json.NewEncoder(w).Encode(roles)
+	_ = cjson.WriteJSON(w, http.StatusOK, roles)
 }
 
 func (rc *RoleController) UpdateRole(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
-	}
-	var role models.Role
-	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Invalid ID",
+			InternalError: err,
+		})
 	}
-	role.Id = id
+	var roleModel dto.RoleModel
+	decodeBody(r, &roleModel, "Not able to decode the role")
+	validateBody(&roleModel)
+	role := models.Role{Id: id, RoleName: roleModel.RoleName, Description: roleModel.Description}
 	if err := models.UpdateRole(rc.DB, &role); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to save the role",
+			InternalError: err,
+		})
 	}
-	_ = json.NewEncoder(w).Encode(role)
+	_ = cjson.WriteJSON(w, http.StatusOK, role)
 }
 
 func (rc *RoleController) DeleteRole(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusBadRequest,
+			Message:       "Invalid ID",
+			InternalError: err,
+		})
 	}
 	if err := models.DeleteRole(rc.DB, id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to delete the role",
+			InternalError: err,
+		})
 	}
-	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted"})
+	_ = cjson.WriteJSON(w, http.StatusOK, map[string]string{"message": "Role deleted"})
 }
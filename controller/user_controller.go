@@ -1,16 +1,20 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/config"
 	"github.com/pratyush934/sibling-bond-server/dto"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/mailer"
 	"github.com/pratyush934/sibling-bond-server/models"
 	"github.com/pratyush934/sibling-bond-server/utils"
-	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 	"io"
 	"net/http"
-	"strconv"
+	"net/url"
 	"time"
 )
 
@@ -20,18 +24,14 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			log.Err(err).Msg("Issue in Closing r.Body in Register")
+			logging.Ctx(r.Context()).Err(err).Msg("Issue in Closing r.Body in Register")
 			return
 		}
 	}(r.Body)
 
-	if err := json.NewDecoder(r.Body).Decode(&register); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusForbidden,
-			Message:       "Not able to read the register",
-			InternalError: err,
-		})
-	}
+	decodeBody(r, &register, "Not able to read the register")
+	validateBody(&register)
+	utils.ThrottleAccount(w, r, "register", register.Email)
 	user := models.User{
 		FirstName: register.FirstName,
 		LastName:  register.LastName,
@@ -39,14 +39,26 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		PassWord:  register.Password,
 	}
 
-	createUser, err := user.CreateUser()
+	createUser, err := user.CreateUser(r.Context())
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusConflict,
+			Code:          cjson.CodeEmailTaken,
+			Message:       "An account with this email already exists",
+			InternalError: err,
+		})
+	}
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusForbidden,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to CreateUser in Register",
 			InternalError: err,
 		})
 	}
+	welcome := mailer.WelcomeData{Name: createUser.FirstName, Email: createUser.Email}
+	if err := models.QueueEmail(r.Context(), createUser.Email, mailer.TemplateWelcome, welcome); err != nil {
+		logging.Ctx(r.Context()).Err(err).Msg("Issue while queueing the welcome email in Register")
+	}
 	//createUser.PassWord = "" just ignore this for now
 	_ = cjson.WriteJSON(w, http.StatusCreated, createUser)
 }
@@ -57,78 +69,260 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			log.Err(err).Msg("Issue while closing the r.Body in Login")
+			logging.Ctx(r.Context()).Err(err).Msg("Issue while closing the r.Body in Login")
 			return
 		}
 	}(r.Body)
 
-	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+	decodeBody(r, &login, "Not able to read the Login")
+	validateBody(&login)
+	utils.ThrottleAccount(w, r, "login", login.Email)
+
+	userByEmail, err := models.GetUserByEmail(r.Context(), login.Email)
+	if err == nil {
+		// a locked account is refused before the password is even looked at, with the same answer
+		// as an unknown email so the response does not tell which emails have an account
+		if lock := userByEmail.LockedFor(time.Now()); lock > 0 {
+			panic(&cjson.HTTPError{
+				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeInvalidCredentials,
+				Message:       "Invalid Email or Password",
+				InternalError: fmt.Errorf("account %s is locked for %s", userByEmail.Id, lock),
+			})
+		}
+	}
+	if err != nil || !userByEmail.ValidatePassWord(login.PassWord) {
+		if err == nil {
+			if lock, lockErr := models.RecordFailedLogin(r.Context(), userByEmail.Id); lockErr == nil && lock > 0 {
+				logging.Ctx(r.Context()).Warn().Str("userId", userByEmail.Id).Dur("lock", lock).Msg("Account locked after failed logins")
+			}
+		}
 		panic(&cjson.HTTPError{
-			Status:        http.StatusForbidden,
-			Message:       "Not able to read the Login",
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeInvalidCredentials,
+			Message:       "Invalid Email or Password",
 			InternalError: err,
 		})
 	}
-
-	if login.Email == "" || login.PassWord == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Email and password are required",
-			InternalError: nil,
-		})
+	if userByEmail.FailedLoginAttempts > 0 {
+		_ = models.ClearFailedLogins(r.Context(), userByEmail.Id)
 	}
 
-	userByEmail, err := models.GetUserByEmail(login.Email)
-	if err != nil || !userByEmail.ValidatePassWord(login.PassWord) {
+	session, refreshToken, err := models.CreateSession(r.Context(), userByEmail.Id, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
-			Message:       "Invalid Email or Password",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to start the session",
 			InternalError: err,
 		})
 	}
 
-	token, err := utils.CreateToken(userByEmail)
+	token, err := utils.CreateToken(userByEmail, session.Id)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to Generate Token",
 			InternalError: err,
 		})
 	}
 	//randomValue := cjson.CreateRandomToken()
 
+	// fold the guest cart built before login into the user's cart. The cookie stays when the merge
+	// fails, so the cart is merged on the next login or through POST /api/cart/merge; only a token
+	// that is invalid or whose cart is gone is dropped along with a merged one.
+	if cartToken := utils.GetCartTokenFromRequest(r); cartToken != "" {
+		clearToken := true
+		if guestCartId, err := utils.ParseCartToken(cartToken); err == nil {
+			if _, err := models.MergeGuestCart(r.Context(), guestCartId, userByEmail.Id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				logging.Ctx(r.Context()).Err(err).Msg("Issue while merging the guest cart in Login")
+				clearToken = false
+			}
+		}
+		if clearToken {
+			utils.ClearCartTokenCookie(w)
+		}
+	}
+
+	setAuthCookies(w, token, refreshToken, session.ExpiresAt)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, dto.LoginResponse{
+		User:         *userByEmail,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+	})
+}
+
+const refreshTokenCookie = "refresh_token"
+
+func setAuthCookies(w http.ResponseWriter, token, refreshToken string, sessionExpiresAt time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_token",
 		Value:    token,
-		Expires:  time.Now().Add(time.Hour * 1),
+		Expires:  time.Now().Add(utils.AccessTokenTTL),
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 	})
+	// the refresh token is only ever sent to the refresh endpoint
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Expires:  sessionExpiresAt,
+		Path:     "/api/users/token",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, dto.LoginResponse{User: *userByEmail, Token: token})
+func clearAuthCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{"auth_token": "/", refreshTokenCookie: "/api/users/token"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Now().Add(-time.Hour),
+			Path:     path,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
 }
 
-func LogOut(w http.ResponseWriter, r *http.Request) {
+// RefreshToken trades a refresh token for a new access token and a new refresh token. The old
+// refresh token stops working; sending it again ends the session.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refreshModel dto.RefreshTokenModel
+	if r.ContentLength != 0 {
+		decodeBody(r, &refreshModel, "Not able to read the refresh token")
+	}
+	if refreshModel.RefreshToken == "" {
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+			refreshModel.RefreshToken = cookie.Value
+		}
+	}
+	if refreshModel.RefreshToken == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
+			Message:       "Refresh token is required",
+			InternalError: nil,
+		})
+	}
 
-	/* setting expired cookie to logout */
+	session, refreshToken, err := models.RotateRefreshToken(r.Context(), refreshModel.RefreshToken)
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		clearAuthCookies(w)
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeRefreshTokenReused,
+			Message:       "Refresh token was already used, please login again",
+			InternalError: err,
+		})
+	}
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
+			Message:       "Invalid or expired refresh token",
+			InternalError: err,
+		})
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
+	user, err := models.GetUserById(r.Context(), session.UserId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "user not found",
+			InternalError: err,
+		})
+	}
+
+	token, err := utils.CreateToken(user, session.Id)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to Generate Token",
+			InternalError: err,
+		})
+	}
+
+	setAuthCookies(w, token, refreshToken, session.ExpiresAt)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, dto.LoginResponse{
+		User:         *user,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
 	})
+}
+
+// GetJWKS publishes the public keys our tokens can be verified with.
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = cjson.WriteJSON(w, http.StatusOK, utils.JWKS())
+}
+
+// LogOut revokes the access token that made the request and ends its session, so neither the
+// access token nor the refresh token work afterwards.
+func LogOut(w http.ResponseWriter, r *http.Request) {
+	jti, _ := r.Context().Value("jti").(string)
+	sessionId, _ := r.Context().Value("sessionId").(string)
+	expiresAt, ok := r.Context().Value("tokenExpiresAt").(time.Time)
+	if !ok {
+		expiresAt = time.Now().Add(utils.AccessTokenTTL)
+	}
+
+	if err := models.RevokeAccessToken(r.Context(), jti, expiresAt); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to logout",
+			InternalError: err,
+		})
+	}
+	if err := models.RevokeSession(r.Context(), sessionId, "logout"); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to logout",
+			InternalError: err,
+		})
+	}
+
+	clearAuthCookies(w)
 
 	_ = cjson.WriteJSON(w, http.StatusOK, map[string]string{
 		"message": "Successfully logged out",
 	})
 }
 
+// LogOutAll ends every session of the user, on all devices.
+func LogOutAll(w http.ResponseWriter, r *http.Request) {
+	userId, ok := r.Context().Value("userId").(string)
+	if !ok {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Message:       "User Id not found in context",
+			InternalError: nil,
+		})
+	}
+
+	if err := models.RevokeUserSessions(r.Context(), userId, "logout everywhere"); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to logout",
+			InternalError: err,
+		})
+	}
+
+	clearAuthCookies(w)
+
+	_ = cjson.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": "Logged out of all sessions",
+	})
+}
+
 /*
 GetProfile - Get user details
 UpdateProfile - Update user details
@@ -149,7 +343,7 @@ func GetProfile(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	userById, err := models.GetUserById(userId)
+	userById, err := models.GetUserById(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
@@ -176,7 +370,7 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	userById, err := models.GetUserById(userId)
+	userById, err := models.GetUserById(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
@@ -185,19 +379,9 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	type newPassWord struct {
-		Pass string `json:"pass"`
-	}
-
-	var newPass newPassWord
-
-	if err := json.NewDecoder(r.Body).Decode(&newPass); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Not able to read the newPassword",
-			InternalError: err,
-		})
-	}
+	var newPass dto.ChangePasswordModel
+	decodeBody(r, &newPass, "Not able to read the newPassword")
+	validateBody(&newPass)
 
 	if err := userById.ResetPassword(newPass.Pass); err != nil {
 		panic(&cjson.HTTPError{
@@ -206,133 +390,106 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 			InternalError: nil,
 		})
 	}
+	// a changed password has to lock out whoever else might be logged in
+	if err := models.RevokeUserSessions(r.Context(), userById.Id, "password changed"); err != nil {
+		logging.Ctx(r.Context()).Err(err).Msg("Issue while revoking sessions in ChangePassword")
+	}
 	_ = cjson.WriteJSON(w, http.StatusOK, "Reset the PassWord!!")
 }
 
+// ForgotPassWord emails a reset link. The answer is the same whether or not the account exists,
+// so the endpoint can not be used to find out who is registered.
 func ForgotPassWord(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		Email string `json:"email"`
-	}
+	var request dto.ForgotPasswordModel
+	decodeBody(r, &request, "Unable to parse request")
+	validateBody(&request)
+	utils.ThrottleAccount(w, r, "forgot-password", request.Email)
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Unable to parse request",
-			InternalError: err,
-		})
-	}
+	accepted := map[string]string{"message": "If an account exists for this email, a reset link is on its way"}
 
-	// Validate input
-	if request.Email == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Email is required",
-			InternalError: nil,
-		})
+	byEmail, err := models.GetUserByEmail(r.Context(), request.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_ = cjson.WriteJSON(w, http.StatusAccepted, accepted)
+		return
 	}
-
-	byEmail, err := models.GetUserByEmail(request.Email)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "user is not there via email",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to look up the user",
 			InternalError: err,
 		})
 	}
 	token := byEmail.GeneratePasswordResetToken()
 
-	user, err := models.UpdateUser(byEmail)
-	if err != nil {
+	if _, err := models.UpdateUser(r.Context(), byEmail); err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to save the user",
 			InternalError: err,
 		})
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset-token",
-		Value:    token,
-		Expires:  time.Now().Add(1 * time.Hour),
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Secure:   true,
-	})
-	user.PassWord = "kya-karoge-jaan-kar"
-
-	_ = cjson.WriteJSON(w, http.StatusCreated, user)
-}
-
-func ResetPasswordFromToken(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("reset-token")
-
-	if err != nil {
+	reset := mailer.PasswordResetData{
+		Name:      byEmail.FirstName,
+		ResetURL:  config.Get().Mail.BaseURL + "/reset-password?token=" + url.QueryEscape(token),
+		ExpiresIn: "1 hour",
+	}
+	if err := models.QueueEmail(r.Context(), byEmail.Email, mailer.TemplatePasswordReset, reset); err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadGateway,
-			Message:       "Not able to get the cookie",
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to send the reset email",
 			InternalError: err,
 		})
 	}
 
-	token := cookie.Value
-
-	var EmailStruct struct {
-		Password string `json:"password"`
-	}
+	_ = cjson.WriteJSON(w, http.StatusAccepted, accepted)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&EmailStruct); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadGateway,
-			Message:       "Not able to get the EmailStruct",
-			InternalError: err,
-		})
-	}
+func ResetPasswordFromToken(w http.ResponseWriter, r *http.Request) {
+	var resetModel dto.ResetPasswordModel
+	decodeBody(r, &resetModel, "Not able to read the new password")
+	validateBody(&resetModel)
 
-	if EmailStruct.Password == "" {
+	if resetModel.Token == "" {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadGateway,
-			Message:       "Password is empty",
-			InternalError: nil,
+			Status:  http.StatusBadRequest,
+			Code:    cjson.CodeResetTokenMissing,
+			Message: "Reset token is missing, please request a new password reset",
 		})
 	}
 
-	userByToken, err := models.GetUserByResetToken(token)
+	userByToken, err := models.GetUserByResetToken(r.Context(), resetModel.Token)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusBadGateway,
-			Message:       "Not able to get the EmailStruct",
+			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeResetTokenInvalid,
+			Message:       "Reset token is invalid or has expired",
 			InternalError: err,
 		})
 	}
 
-	if err := userByToken.ResetPassword(EmailStruct.Password); err != nil {
+	if err := userByToken.ResetPassword(resetModel.Password); err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to reset the Password",
 			InternalError: err,
 		})
 	}
 
-	user, err := models.UpdateUser(userByToken)
+	user, err := models.UpdateUser(r.Context(), userByToken)
 	if err != nil {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
+			Status:        http.StatusInternalServerError,
 			Message:       "Not able to update the user",
 			InternalError: err,
 		})
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset-token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Secure:   true,
-	})
+	if err := models.RevokeUserSessions(r.Context(), user.Id, "password reset"); err != nil {
+		logging.Ctx(r.Context()).Err(err).Msg("Issue while revoking sessions in ResetPasswordFromToken")
+	}
+
 	user.PassWord = ""
 	_ = cjson.WriteJSON(w, http.StatusOK, user)
 }
@@ -355,7 +512,7 @@ func GetAddresses(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	addressById, err := models.GetAddressByUserId(userId)
+	addressById, err := models.GetAddressByUserId(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
@@ -378,13 +535,8 @@ func AddAddress(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var addressModel dto.AddressModel
-	if err := json.NewDecoder(r.Body).Decode(&addressModel); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusForbidden,
-			Message:       "Not able to get the AddressModel",
-			InternalError: err,
-		})
-	}
+	decodeBody(r, &addressModel, "Not able to get the AddressModel")
+	validateBody(&addressModel)
 	realAddress := models.Address{
 		UserId:     userId,
 		StreetName: addressModel.StreetName,
@@ -394,7 +546,7 @@ func AddAddress(w http.ResponseWriter, r *http.Request) {
 		State:      addressModel.State,
 	}
 
-	create, err := realAddress.Create()
+	create, err := realAddress.Create(r.Context())
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
@@ -419,34 +571,12 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the request body
-	var updateRequest struct {
-		AddressID  string `json:"addressId"`
-		StreetName string `json:"streetName"`
-		LandMark   string `json:"landMark"`
-		ZipCode    string `json:"zipCode"`
-		City       string `json:"city"`
-		State      string `json:"state"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Not able to parse address update request",
-			InternalError: err,
-		})
-	}
-
-	// Ensure address ID is provided
-	if updateRequest.AddressID == "" {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "Address ID is required",
-			InternalError: nil,
-		})
-	}
+	var updateRequest dto.UpdateAddressModel
+	decodeBody(r, &updateRequest, "Not able to parse address update request")
+	validateBody(&updateRequest)
 
 	// Retrieve the existing address
-	existingAddress, err := models.GetAddressById(updateRequest.AddressID)
+	existingAddress, err := models.GetAddressById(r.Context(), updateRequest.AddressId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -472,7 +602,7 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request) {
 	existingAddress.State = updateRequest.State
 
 	// Save the updated address
-	updatedAddress, err := models.UpdateAddress(existingAddress)
+	updatedAddress, err := models.UpdateAddress(r.Context(), existingAddress)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
@@ -488,7 +618,7 @@ func DeleteAddress(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	addressId := vars["id"]
 
-	err := models.DeleteAddress(addressId)
+	err := models.DeleteAddress(r.Context(), addressId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
@@ -505,6 +635,7 @@ GetOrderHistory - List user's past orders
 GetOrderDetails - Get specific order details
 */
 
+// GetOrderHistory lists the user's own orders, see models.CustomerOrderListSpec for the filters and sorts.
 func GetOrderHistory(w http.ResponseWriter, r *http.Request) {
 	userId, ok := r.Context().Value("userId").(string)
 	if !ok {
@@ -514,31 +645,14 @@ func GetOrderHistory(w http.ResponseWriter, r *http.Request) {
 			InternalError: nil,
 		})
 	}
-	page := 1
-	pageSize := 10
-
-	// Parse pagination parameters if provided
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
-			page = parsedPage
-		}
-	}
-
-	if sizeStr := r.URL.Query().Get("pageSize"); sizeStr != "" {
-		if parsedSize, err := strconv.Atoi(sizeStr); err == nil && parsedSize > 0 {
-			pageSize = parsedSize
-		}
-	}
+	q := listQuery(r, models.CustomerOrderListSpec)
+	q.Where("user_id = ?", userId)
 
-	ordersById, err := models.GetOrdersByUserId(userId, page, pageSize)
+	page, err := models.ListOrders(r.Context(), q)
 	if err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to get the Orders",
-			InternalError: err,
-		})
+		listFailed(err, "Not able to get the Orders")
 	}
-	_ = cjson.WriteJSON(w, http.StatusOK, ordersById)
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
 }
 
 func GetOrderDetails(w http.ResponseWriter, r *http.Request) {
@@ -556,12 +670,13 @@ func GetOrderDetails(w http.ResponseWriter, r *http.Request) {
 	if orderId == "" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeMissingParameter,
 			Message:       "Order Id is required",
 			InternalError: nil,
 		})
 	}
 
-	orderById, err := models.GetOrderByUserIdAndOrderId(userId, orderId)
+	orderById, err := models.GetOrderByUserIdAndOrderId(r.Context(), userId, orderId)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
@@ -600,16 +715,14 @@ func GetAllUsersByAdmin(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	users, err := models.GetAllUsers(5, 10)
+	q := listQuery(r, models.UserListSpec)
+
+	page, err := models.ListUsers(r.Context(), q)
 	if err != nil {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusNotFound,
-			Message:       "Not able to getAll the Users",
-			InternalError: err,
-		})
+		listFailed(err, "Not able to getAll the Users")
 	}
 
-	_ = cjson.WriteJSON(w, http.StatusOK, users)
+	_ = cjson.WriteJSON(w, http.StatusOK, page)
 }
 
 func GetUserById(w http.ResponseWriter, r *http.Request) {
@@ -633,7 +746,7 @@ func GetUserById(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	userById, err := models.GetUserById(userId)
+	userById, err := models.GetUserById(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusNotFound,
@@ -667,7 +780,7 @@ func DeleteUserById(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	err := models.DeleteUser(userId)
+	err := models.DeleteUser(r.Context(), userId)
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
@@ -1,15 +1,24 @@
 package database
 
 import (
+	"context"
+	"github.com/pratyush934/sibling-bond-server/config"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"strings"
 )
 
 var DB *gorm.DB
 
-func InitDB() error {
-	db, err := connectToDB()
+// SQLiteMemoryDSN keeps the whole database inside the process, nothing survives a restart.
+const SQLiteMemoryDSN = "file::memory:?cache=shared"
+
+func InitDB(cfg config.DatabaseConfig) error {
+	db, err := connectToDB(cfg)
 	if err != nil {
 		log.Err(err).Msg("Issue while InitDB")
 		return err
@@ -18,13 +27,83 @@ func InitDB() error {
 	return nil
 }
 
-func connectToDB() (*gorm.DB, error) {
-	dsn := "root:Pratyush@123@tcp(127.0.0.1:3306)/siblingbond?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Ping checks that the database still answers, used by the readiness probe.
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close releases the connection pool, called once the server has drained.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func openDialector(cfg config.DatabaseConfig) gorm.Dialector {
+	switch cfg.Driver {
+	case DriverPostgres:
+		return postgres.Open(cfg.DSN)
+	case DriverSQLite:
+		dsn := cfg.DSN
+		if dsn == "" {
+			dsn = SQLiteMemoryDSN
+		}
+		// foreign keys are off by default in SQLite; taking the write lock when a transaction
+		// begins, with a busy timeout, lets concurrent writers queue instead of failing
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return sqlite.Open(dsn + separator + "_foreign_keys=1&_busy_timeout=5000&_txlock=immediate")
+	}
+	return mysql.Open(cfg.DSN)
+}
+
+func connectToDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	dialect, err := dialectFor(cfg.Driver)
+	if err != nil {
+		log.Err(err).Msg("Issue while picking the driver in connectToDB")
+		return nil, err
+	}
+
+	db, err := gorm.Open(openDialector(cfg), &gorm.Config{
+		Logger: queryLogger{level: gormlogger.Warn},
+		// unique violations come back as gorm.ErrDuplicatedKey whatever the driver
+		TranslateError: true,
+	})
 
 	if err != nil {
 		log.Err(err).Msg("Issue while connecting the DB connectToDB")
 		return nil, err
 	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Err(err).Msg("Issue while getting the pool in connectToDB")
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	if cfg.Driver == DriverSQLite && (cfg.DSN == "" || strings.Contains(cfg.DSN, ":memory:")) {
+		// the in-memory database disappears with its last connection, so one connection stays
+		// open for good; transactions only ever use their tx, so a single connection is enough
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+	}
+
+	current = dialect
+	log.Info().Str("driver", dialect.Name()).Msg("Connected to the DB")
 	return db, nil
 }
@@ -0,0 +1,49 @@
+package database
+
+import "fmt"
+
+/*
+	The Dialect of the open connection names the database it runs on, for the few places that
+	have to tell them apart, such as the driver label on the DB metrics.
+*/
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+type Dialect interface {
+	Name() string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return DriverMySQL }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return DriverPostgres }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return DriverSQLite }
+
+var current Dialect = mysqlDialect{}
+
+// CurrentDialect is the dialect of the connection opened by InitDB.
+func CurrentDialect() Dialect {
+	return current
+}
+
+func dialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "", DriverMySQL:
+		return mysqlDialect{}, nil
+	case DriverPostgres:
+		return postgresDialect{}, nil
+	case DriverSQLite:
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", driver)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"time"
+)
+
+// SlowQueryThreshold is the duration above which a query is logged as a warning.
+var SlowQueryThreshold = 200 * time.Millisecond
+
+// queryLogger sends gorm's output through zerolog. The statement context carries the request
+// logger when the query was built with WithContext, which ties DB errors to the request.
+type queryLogger struct {
+	level gormlogger.LogLevel
+}
+
+func (l queryLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	return queryLogger{level: level}
+}
+
+func (l queryLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Info {
+		logging.Ctx(ctx).Info().Msg(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Warn {
+		logging.Ctx(ctx).Warn().Msg(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= gormlogger.Error {
+		logging.Ctx(ctx).Error().Msg(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+
+	switch {
+	// a missing row is an answer, not a failure, callers decide what it means
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		sql, rows := fc()
+		logging.Ctx(ctx).Error().Err(err).Str("sql", sql).Int64("rows", rows).Dur("took", elapsed).Msg("Query failed")
+	case elapsed > SlowQueryThreshold && l.level >= gormlogger.Warn:
+		sql, rows := fc()
+		logging.Ctx(ctx).Warn().Str("sql", sql).Int64("rows", rows).Dur("took", elapsed).Msg("Slow query")
+	case l.level >= gormlogger.Info:
+		sql, rows := fc()
+		logging.Ctx(ctx).Debug().Str("sql", sql).Int64("rows", rows).Dur("took", elapsed).Msg("Query")
+	}
+}
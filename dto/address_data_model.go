@@ -1,9 +1,14 @@
 package dto
 
 type AddressModel struct {
-	StreetName string ` json:"streetName"`
-	LandMark   string ` json:"landMark"`
-	ZipCode    string ` json:"zipCode"`
-	City       string ` json:"city"`
-	State      string ` json:"state"`
+	StreetName string `json:"streetName" validate:"required,max=255"`
+	LandMark   string `json:"landMark" validate:"max=255"`
+	ZipCode    string `json:"zipCode" validate:"required,zipcode"`
+	City       string `json:"city" validate:"required,max=100"`
+	State      string `json:"state" validate:"required,max=100"`
+}
+
+type UpdateAddressModel struct {
+	AddressId string `json:"addressId" validate:"required"`
+	AddressModel
 }
@@ -2,13 +2,14 @@ package dto
 
 type CartDataModel struct {
 	UserId    string          `json:"userId"`
-	CartItems []CartItemModel `json:"cartItems"`
+	CartItems []CartItemModel `json:"cartItems" validate:"dive"`
 }
 
 type CartItemModel struct {
 	Id            string       `json:"id,omitempty"`
-	ProductId     string       `json:"productId"`
-	Quantity      int          `json:"quantity"`
+	ProductId     string       `json:"productId" validate:"required"`
+	VariantId     string       `json:"variantId,omitempty"`
+	Quantity      int          `json:"quantity" validate:"required,min=1,max=100"`
 	PriceAtAdding int          `json:"priceAtAdding,omitempty"`
 	Product       ProductModel `json:"product,omitempty"`
 }
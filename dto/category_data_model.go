@@ -0,0 +1,26 @@
+package dto
+
+// CategoryModel creates and updates categories. A missing slug is made from the name, on update a
+// nil ParentId keeps the parent and an empty one makes the category a root.
+type CategoryModel struct {
+	Name        string  `json:"name" validate:"required,max=100"`
+	Slug        string  `json:"slug" validate:"omitempty,slug,max=191"`
+	Description string  `json:"description" validate:"max=1000"`
+	ParentId    *string `json:"parentId"`
+	SortOrder   *int    `json:"sortOrder" validate:"omitempty,min=0"`
+}
+
+// CategoryAttributeModel defines one attribute of a category's schema. Key, type and level can
+// not change once products carry values for the attribute.
+type CategoryAttributeModel struct {
+	Key       string   `json:"key" validate:"omitempty,slug,max=64"`
+	Name      string   `json:"name" validate:"required,max=100"`
+	Type      string   `json:"type" validate:"required,oneof=enum number boolean text"`
+	Unit      string   `json:"unit" validate:"max=20"`
+	Options   []string `json:"options" validate:"max=100"`
+	Min       *float64 `json:"min"`
+	Max       *float64 `json:"max"`
+	Level     string   `json:"level" validate:"omitempty,oneof=product variant"`
+	Required  *bool    `json:"required"`
+	SortOrder *int     `json:"sortOrder" validate:"omitempty,min=0"`
+}
@@ -0,0 +1,23 @@
+package dto
+
+import "time"
+
+type CouponModel struct {
+	Code          string     `json:"code" validate:"required,max=64"`
+	Description   string     `json:"description" validate:"max=500"`
+	DiscountType  string     `json:"discountType" validate:"required,oneof=percentage flat"` // percentage or flat
+	DiscountValue int        `json:"discountValue" validate:"required,min=1"`
+	MaxDiscount   int        `json:"maxDiscount" validate:"min=0"`
+	MinCartValue  int        `json:"minCartValue" validate:"min=0"`
+	UsageLimit    int        `json:"usageLimit" validate:"min=0"`   // 0 means unlimited
+	PerUserLimit  int        `json:"perUserLimit" validate:"min=0"` // 0 means unlimited
+	ValidFrom     *time.Time `json:"validFrom"`
+	ValidUntil    *time.Time `json:"validUntil"`
+	IsActive      *bool      `json:"isActive"`
+	CategoryIds   []string   `json:"categoryIds"`
+	ProductIds    []string   `json:"productIds"`
+}
+
+type ApplyCouponModel struct {
+	CouponCode string `json:"couponCode" validate:"required,max=64"`
+}
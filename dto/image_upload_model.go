@@ -2,6 +2,6 @@ package dto
 
 type ImageMode struct {
 	FieldId string `json:"fieldId"`
-	URL     string `json:"URL"`
-	Name    string `json:"name"`
+	URL     string `json:"URL" validate:"required,max=2048"`
+	Name    string `json:"name" validate:"max=255"`
 }
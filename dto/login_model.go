@@ -1,6 +1,6 @@
 package dto
 
 type LoginModel struct {
-	Email    string `json:"email"`
-	PassWord string `json:"pass_word"`
+	Email    string `json:"email" validate:"required,email"`
+	PassWord string `json:"pass_word" validate:"required"`
 }
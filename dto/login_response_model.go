@@ -3,6 +3,12 @@ package dto
 import "github.com/pratyush934/sibling-bond-server/models"
 
 type LoginResponse struct {
-	User  models.User `json:"user"`
-	Token string      `json:"token"`
+	User         models.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refreshToken"`
+	ExpiresIn    int         `json:"expiresIn"`
+}
+
+type RefreshTokenModel struct {
+	RefreshToken string `json:"refreshToken"`
 }
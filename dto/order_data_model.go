@@ -47,3 +47,43 @@ type OrderItemModel struct {
 	Quantity        int    `json:"quantity"`
 	PriceAtPurchase int    `json:"priceAtPurchase"`
 }
+
+// CreateOrderModel carries the query parameters of CreateOrder.
+type CreateOrderModel struct {
+	ShippingAddressId string `json:"shippingAddressId" validate:"required"`
+	PaymentMethod     string `json:"paymentMethod" validate:"required,oneof=credit_card debit_card upi netbanking cod wallet"`
+}
+
+// UpdateOrderStatusModel carries the query parameters of UpdateOrderStatus.
+type UpdateOrderStatusModel struct {
+	OrderId string `json:"orderId" validate:"required"`
+	Status  string `json:"orderStatus" validate:"required,oneof=confirmed processing shipped delivered cancelled"`
+	Note    string `json:"note" validate:"max=500"`
+}
+
+type CancelOrderModel struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type PaymentModel struct {
+	PaymentMethod string `json:"paymentMethod" validate:"required,oneof=credit_card debit_card upi netbanking cod wallet"`
+}
+
+type SimulatePaymentModel struct {
+	ProviderRef string `json:"providerRef" validate:"required"`
+	Type        string `json:"type" validate:"required,oneof=payment.authorized payment.succeeded payment.failed refund.succeeded"`
+	Amount      int    `json:"amount" validate:"min=0"`
+	Reason      string `json:"reason" validate:"max=500"`
+}
+
+type ReturnRequestModel struct {
+	OrderId     string `json:"orderId" validate:"required"`
+	OrderItemId string `json:"orderItemId" validate:"required"`
+	Quantity    int    `json:"quantity" validate:"required,min=1"`
+	Reason      string `json:"reason" validate:"required,oneof=damaged defective wrong_item not_as_described no_longer_needed other"`
+	Comment     string `json:"comment" validate:"max=1000"`
+}
+
+type ReviewReturnModel struct {
+	Note string `json:"note" validate:"max=500"`
+}
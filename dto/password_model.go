@@ -0,0 +1,15 @@
+package dto
+
+type ChangePasswordModel struct {
+	Pass string `json:"pass" validate:"required,min=8,maxbytes=72"`
+}
+
+type ForgotPasswordModel struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordModel carries the token from the link in the password reset email.
+type ResetPasswordModel struct {
+	Token    string `json:"token"`
+	Password string `json:"password" validate:"required,min=8,maxbytes=72"`
+}
@@ -1,25 +1,33 @@
 package dto
 
 type ProductModel struct {
-	Name          string              `json:"name"`
-	Description   string              `json:"description"`
-	Price         int                 `json:"price"`
-	Stock         int                 `json:"stock"`
-	CategoryId    string              `json:"categoryId"`
-	Images        []ImageMode         `json:"images"`
+	Name          string              `json:"name" validate:"required,max=255"`
+	Description   string              `json:"description" validate:"max=5000"`
+	Price         int                 `json:"price" validate:"required,min=1"`
+	Stock         int                 `json:"stock" validate:"min=0"`
+	CategoryId    string              `json:"categoryId" validate:"required"`
+	Images        []ImageMode         `json:"images" validate:"max=10,dive"`
 	IsActive      bool                `json:"isActive"`
-	MinStockLevel int                 `json:"minStockLevel"`
-	MaxStockLevel int                 `json:"maxStockLevel"`
-	ReorderPoint  int                 `json:"reorderPoint"`
-	SKU           string              `json:"sku"` // Optional, will be auto-generated if empty
-	Barcode       string              `json:"barcode"`
-	Weight        float64             `json:"weight"`
-	Dimensions    string              `json:"dimensions"`
-	Variants      []ProductVariantDTO `json:"variants"`
+	MinStockLevel int                 `json:"minStockLevel" validate:"min=0"`
+	MaxStockLevel int                 `json:"maxStockLevel" validate:"min=0"`
+	ReorderPoint  int                 `json:"reorderPoint" validate:"min=0"`
+	SKU           string              `json:"sku" validate:"max=64"` // Optional, will be auto-generated if empty
+	Barcode       string              `json:"barcode" validate:"max=64"`
+	Weight        float64             `json:"weight" validate:"min=0"`
+	Dimensions    string              `json:"dimensions" validate:"max=100"`
+	Variants      []ProductVariantDTO `json:"variants" validate:"dive"`
+	// Attributes are values for the category's product level attributes by key. On update they go
+	// over the stored values and null clears one.
+	Attributes map[string]any `json:"attributes"`
 }
 
 type ProductVariantDTO struct {
-	Name            string `json:"name"`
-	Value           string `json:"value"`
-	PriceAdjustment int    `json:"priceAdjustment"`
+	Name            string `json:"name" validate:"required,max=100"`
+	Value           string `json:"value" validate:"required,max=100"`
+	PriceAdjustment int    `json:"priceAdjustment"` // added to the product price, may be negative
+	Stock           int    `json:"stock" validate:"min=0"`
+	SKU             string `json:"sku" validate:"max=64"` // Optional, will be auto-generated if empty
+	IsActive        *bool  `json:"isActive"`
+	// Attributes are values for the category's variant level attributes by key
+	Attributes map[string]any `json:"attributes"`
 }
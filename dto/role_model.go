@@ -0,0 +1,6 @@
+package dto
+
+type RoleModel struct {
+	RoleName    string `json:"roleName" validate:"required,max=50"`
+	Description string `json:"description" validate:"required,max=255"`
+}
@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/imagekit-developer/imagekit-go"
-	"os"
+	"github.com/pratyush934/sibling-bond-server/config"
 	"time"
 )
 
 type ServiceHandler struct {
-	ik *imagekit.ImageKit `json:"ik"`
+	ik *imagekit.ImageKit
 }
 
 /*
@@ -19,18 +19,16 @@ type ServiceHandler struct {
 
 func NewImageKitService() (*ServiceHandler, error) {
 
-	publicKey := os.Getenv("IMAGEKIT_PUBLIC_KEY")
-	privateKey := os.Getenv("IMAGEKIT_PRIVATE_KEY")
-	urlEndpoint := os.Getenv("IMAGEKIT_URL_ENDPOINT")
+	ikConfig := config.Get().ImageKit
 
-	if publicKey == "" || privateKey == "" || urlEndpoint == "" {
-		return nil, fmt.Errorf("required ImageKit environment variables not set")
+	if !ikConfig.Enabled() {
+		return nil, fmt.Errorf("ImageKit is not configured")
 	}
 
 	kit := imagekit.NewFromParams(imagekit.NewParams{
-		PrivateKey:  privateKey,
-		PublicKey:   publicKey,
-		UrlEndpoint: urlEndpoint,
+		PrivateKey:  ikConfig.PrivateKey,
+		PublicKey:   ikConfig.PublicKey,
+		UrlEndpoint: ikConfig.URLEndpoint,
 	})
 
 	return &ServiceHandler{kit}, nil
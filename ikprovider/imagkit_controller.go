@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/validation"
 	"github.com/rs/zerolog/log"
 	"io"
 	"net/http"
@@ -33,7 +35,7 @@ func GetImageKitService() (*ServiceHandler, error) {
 }
 
 type MultipleAuthRequest struct {
-	FileNames []string `json:"fileNames"`
+	FileNames []string `json:"fileNames" validate:"required,max=5"`
 }
 
 func GetImageKitAuthHandler(w http.ResponseWriter, r *http.Request) {
@@ -50,12 +52,12 @@ func GetImageKitAuthHandler(w http.ResponseWriter, r *http.Request) {
 	var multipleAuthRequest MultipleAuthRequest
 
 	const maxRequestSize = 5 * (1 << 20)
-	const maxFiles = 5
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
 	if err := json.NewDecoder(r.Body).Decode(&multipleAuthRequest); err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusBadRequest,
+			Code:          cjson.CodeInvalidBody,
 			Message:       "Not able to decode the MultipleAuthRequest",
 			InternalError: err,
 		})
@@ -64,16 +66,12 @@ func GetImageKitAuthHandler(w http.ResponseWriter, r *http.Request) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			log.Warn().Err(err).Msg("Failed to close request Body")
+			logging.Ctx(r.Context()).Warn().Err(err).Msg("Failed to close request Body")
 		}
 	}(r.Body)
 
-	if len(multipleAuthRequest.FileNames) == 0 || len(multipleAuthRequest.FileNames) > maxFiles {
-		panic(&cjson.HTTPError{
-			Status:        http.StatusBadRequest,
-			Message:       "File should be between 1 to 5 inclusively",
-			InternalError: nil,
-		})
+	if fields := validation.Struct(&multipleAuthRequest); len(fields) > 0 {
+		panic(cjson.NewValidationError(fields))
 	}
 
 	var newQueue []map[string]interface{}
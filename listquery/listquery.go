@@ -0,0 +1,264 @@
+package listquery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	listquery turns the query string of a list endpoint into filters, a sort and a page position.
+
+		?minPrice=1000&categoryId=...&sort=-price,name&limit=20&cursor=...
+
+	Each resource describes what it accepts in a Spec. Parse validates the parameters against it and
+	Fetch runs the query with keyset pagination: a cursor carries the sort values of the row it
+	points at, so pages stay stable while rows are added and deep pages cost as much as the first.
+	offset is still accepted for the first request, cursors take over from there.
+*/
+
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Bool
+	Time
+)
+
+type operator int
+
+const (
+	opEqual operator = iota
+	opMin
+	opMax
+	opCondition
+)
+
+// Filter maps one query parameter to a condition on a column.
+type Filter struct {
+	Param  string
+	Column string
+	Kind   Kind
+	op     operator
+}
+
+// Equal keeps the rows whose column equals the parameter.
+func Equal(param, column string, kind Kind) Filter {
+	return Filter{Param: param, Column: column, Kind: kind, op: opEqual}
+}
+
+// Min keeps the rows whose column is at least the parameter.
+func Min(param, column string, kind Kind) Filter {
+	return Filter{Param: param, Column: column, Kind: kind, op: opMin}
+}
+
+// Max keeps the rows whose column is at most the parameter. A date without a time includes the whole day.
+func Max(param, column string, kind Kind) Filter {
+	return Filter{Param: param, Column: column, Kind: kind, op: opMax}
+}
+
+// Condition is a boolean parameter backed by an SQL expression: true keeps the rows matching expr,
+// false the ones that do not.
+func Condition(param, expr string) Filter {
+	return Filter{Param: param, Column: expr, Kind: Bool, op: opCondition}
+}
+
+// Spec is what a list endpoint accepts.
+type Spec struct {
+	Filters []Filter
+	// Sorts maps the names clients sort by to columns. The columns must not be nullable.
+	Sorts map[string]string
+	// DefaultSort is used without a sort parameter, e.g. "-createdAt".
+	DefaultSort  string
+	DefaultLimit int
+	MaxLimit     int
+}
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type SortField struct {
+	Key    string
+	Column string
+	Desc   bool
+}
+
+type condition struct {
+	sql  string
+	args []any
+}
+
+// Query is a parsed list request.
+type Query struct {
+	conditions []condition
+	Sort       []SortField
+	Limit      int
+	Offset     int
+	cursor     *cursor
+}
+
+// Where adds a condition the client can not change, such as the owner of the rows.
+func (q *Query) Where(sql string, args ...any) *Query {
+	q.conditions = append(q.conditions, condition{sql: sql, args: args})
+	return q
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Parse reads the list parameters of values. Every invalid parameter is reported, not just the first.
+func Parse(values url.Values, spec Spec) (*Query, []cjson.FieldError) {
+	var fields []cjson.FieldError
+	invalid := func(field, code, message string) {
+		fields = append(fields, cjson.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	q := &Query{Limit: spec.DefaultLimit}
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	max := spec.MaxLimit
+	if max <= 0 {
+		max = maxLimit
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		switch {
+		case err != nil:
+			invalid("limit", cjson.FieldInvalid, "must be a whole number")
+		case limit < 1:
+			invalid("limit", cjson.FieldTooSmall, "must be at least 1")
+		case limit > max:
+			invalid("limit", cjson.FieldTooLarge, fmt.Sprintf("must be at most %d", max))
+		default:
+			q.Limit = limit
+		}
+	}
+
+	for _, f := range spec.Filters {
+		raw := strings.TrimSpace(values.Get(f.Param))
+		if raw == "" {
+			continue
+		}
+		c, err := f.condition(raw)
+		if err != nil {
+			invalid(f.Param, cjson.FieldInvalid, err.Error())
+			continue
+		}
+		q.conditions = append(q.conditions, c)
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = spec.DefaultSort
+	}
+	seen := map[string]bool{}
+	for _, key := range strings.Split(sortParam, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		field := SortField{Key: key}
+		if strings.HasPrefix(key, "-") {
+			field.Key, field.Desc = key[1:], true
+		}
+		column, ok := spec.Sorts[field.Key]
+		if !ok {
+			invalid("sort", cjson.FieldInvalid, fmt.Sprintf("can not sort by %q, use one of %s", field.Key, sortKeys(spec)))
+			continue
+		}
+		if seen[field.Key] {
+			invalid("sort", cjson.FieldInvalid, fmt.Sprintf("%q is listed twice", field.Key))
+			continue
+		}
+		seen[field.Key] = true
+		field.Column = column
+		q.Sort = append(q.Sort, field)
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw, q.Sort)
+		if err != nil {
+			invalid("cursor", cjson.FieldInvalid, "is not a cursor of this list and sort, start again from the first page")
+		}
+		q.cursor = c
+	} else if raw := values.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		switch {
+		case err != nil:
+			invalid("offset", cjson.FieldInvalid, "must be a whole number")
+		case offset < 0:
+			invalid("offset", cjson.FieldTooSmall, "can not be negative")
+		default:
+			q.Offset = offset
+		}
+	}
+
+	return q, fields
+}
+
+func (f Filter) condition(raw string) (condition, error) {
+	var value any
+	var err error
+	switch f.Kind {
+	case Int:
+		value, err = strconv.Atoi(raw)
+		if err != nil {
+			return condition{}, errors.New("must be a whole number")
+		}
+	case Bool:
+		value, err = strconv.ParseBool(raw)
+		if err != nil {
+			return condition{}, errors.New("must be true or false")
+		}
+	case Time:
+		t, dateOnly, err := parseTime(raw)
+		if err != nil {
+			return condition{}, errors.New("must be a date (2006-01-02) or an RFC 3339 time")
+		}
+		if f.op == opMax && dateOnly {
+			return condition{sql: f.Column + " < ?", args: []any{t.AddDate(0, 0, 1)}}, nil
+		}
+		value = t
+	default:
+		value = raw
+	}
+
+	switch f.op {
+	case opMin:
+		return condition{sql: f.Column + " >= ?", args: []any{value}}, nil
+	case opMax:
+		return condition{sql: f.Column + " <= ?", args: []any{value}}, nil
+	case opCondition:
+		if value.(bool) {
+			return condition{sql: f.Column}, nil
+		}
+		return condition{sql: "NOT (" + f.Column + ")"}, nil
+	}
+	return condition{sql: f.Column + " = ?", args: []any{value}}, nil
+}
+
+func parseTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	return t, false, err
+}
+
+func sortKeys(spec Spec) string {
+	keys := make([]string, 0, len(spec.Sorts))
+	for key := range spec.Sorts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
@@ -0,0 +1,196 @@
+package listquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// Page is the envelope every list endpoint answers with. Total counts every row matching the
+// filters, the cursors are only set when there is a page in that direction.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+}
+
+// cursor points at a row by its sort values, the primary key last. Before asks for the page that
+// ends just before that row instead of the one starting after it.
+type cursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+	Before bool              `json:"b,omitempty"`
+}
+
+func sortSignature(sort []SortField) string {
+	keys := make([]string, len(sort))
+	for i, field := range sort {
+		keys[i] = field.Key
+		if field.Desc {
+			keys[i] = "-" + field.Key
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func encodeCursor(sort []SortField, values []any, before bool) (string, error) {
+	c := cursor{Sort: sortSignature(sort), Before: before}
+	for _, value := range values {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, raw)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor only checks the cursor belongs to this sort, its values are typed by Fetch.
+func decodeCursor(raw string, sort []SortField) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sortSignature(sort) || len(c.Values) != len(sort)+1 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Fetch runs q against the model T. db carries the context and anything else every query needs,
+// preloads are only applied to the page itself, not to the count.
+func Fetch[T any](db *gorm.DB, q *Query, preloads ...string) (*Page[T], error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	// the primary key breaks ties, so every row has exactly one position
+	pk := stmt.Schema.PrioritizedPrimaryField
+	order := append(append([]SortField(nil), q.Sort...), SortField{Key: pk.DBName, Column: pk.DBName})
+	fields := make([]*schema.Field, len(order))
+	for i, sortField := range order {
+		if fields[i] = stmt.Schema.LookUpField(sortField.Column); fields[i] == nil {
+			return nil, fmt.Errorf("sort column %s is not a field of %s", sortField.Column, stmt.Schema.Name)
+		}
+	}
+
+	filtered := func() *gorm.DB {
+		tx := db.Model(new(T))
+		for _, c := range q.conditions {
+			tx = tx.Where(c.sql, c.args...)
+		}
+		return tx
+	}
+
+	var total int64
+	if err := filtered().Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	tx := filtered()
+	before := q.cursor != nil && q.cursor.Before
+	if q.cursor != nil {
+		values := make([]any, len(order))
+		for i, field := range fields {
+			ptr := reflect.New(field.FieldType)
+			if err := json.Unmarshal(q.cursor.Values[i], ptr.Interface()); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+			}
+			values[i] = ptr.Elem().Interface()
+		}
+		sql, args := keyset(order, values, before)
+		tx = tx.Where(sql, args...)
+	} else if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	// walking back reads the rows in reverse order and flips them afterwards
+	for _, sortField := range order {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: sortField.Column}, Desc: sortField.Desc != before})
+	}
+	for _, preload := range preloads {
+		tx = tx.Preload(preload)
+	}
+
+	items := []T{}
+	if err := tx.Limit(q.Limit + 1).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	more := len(items) > q.Limit
+	if more {
+		items = items[:q.Limit]
+	}
+	if before {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := &Page[T]{Items: items, Total: total, Limit: q.Limit}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	// a page reached by walking forward has one behind it, and the other way round
+	hasNext, hasPrev := more, q.cursor != nil || q.Offset > 0
+	if before {
+		hasNext, hasPrev = true, more
+	}
+	rowValues := func(item *T) []any {
+		row := reflect.ValueOf(item).Elem()
+		values := make([]any, len(fields))
+		for i, field := range fields {
+			values[i], _ = field.ValueOf(db.Statement.Context, row)
+		}
+		return values
+	}
+	var err error
+	if hasNext {
+		if page.NextCursor, err = encodeCursor(q.Sort, rowValues(&items[len(items)-1]), false); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.PrevCursor, err = encodeCursor(q.Sort, rowValues(&items[0]), true); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// keyset is the condition for the rows after values in the given order, or before them.
+// For a, b it reads (a > ?) OR (a = ? AND b > ?), with < for descending columns.
+func keyset(order []SortField, values []any, before bool) (string, []any) {
+	var clauses []string
+	var args []any
+	for i, field := range order {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, order[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if field.Desc != before {
+			op = "<"
+		}
+		parts = append(parts, field.Column+" "+op+" ?")
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
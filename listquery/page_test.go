@@ -0,0 +1,169 @@
+package listquery
+
+import (
+	"fmt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type item struct {
+	Id        string `gorm:"primaryKey"`
+	Price     int
+	CreatedAt time.Time
+}
+
+var itemSpec = Spec{
+	Filters: []Filter{Min("minPrice", "price", Int)},
+	Sorts:   map[string]string{"price": "price", "createdAt": "created_at"},
+}
+
+// openItems stores 23 items with many equal prices, so the primary key has to break ties.
+func openItems(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 23; i++ {
+		row := item{Id: fmt.Sprintf("item-%02d", i), Price: (i % 4) * 100, CreatedAt: start.Add(time.Duration(i%5) * time.Hour)}
+		if err := db.Create(&row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func parse(t *testing.T, raw string) *Query {
+	t.Helper()
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, fields := Parse(values, itemSpec)
+	if len(fields) > 0 {
+		t.Fatalf("parse %q: %v", raw, fields)
+	}
+	return q
+}
+
+func ids(items []item) []string {
+	out := make([]string, len(items))
+	for i, it := range items {
+		out[i] = it.Id
+	}
+	return out
+}
+
+func TestFetchCursorRoundTrip(t *testing.T) {
+	db := openItems(t)
+
+	for _, sort := range []string{"price", "-price", "-createdAt,price", "createdAt,-price"} {
+		t.Run(sort, func(t *testing.T) {
+			// the whole list in one page is what walking the pages has to add up to
+			all, err := Fetch[item](db, parse(t, "limit=100&sort="+sort))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := ids(all.Items)
+
+			var pages []*Page[item]
+			var walked []string
+			raw := "limit=5&sort=" + sort
+			for {
+				page, err := Fetch[item](db, parse(t, raw))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != 23 {
+					t.Fatalf("total = %d, want 23", page.Total)
+				}
+				pages = append(pages, page)
+				walked = append(walked, ids(page.Items)...)
+				if page.NextCursor == "" {
+					break
+				}
+				raw = "limit=5&sort=" + sort + "&cursor=" + page.NextCursor
+			}
+			if fmt.Sprint(walked) != fmt.Sprint(want) {
+				t.Fatalf("walking forward gave\n%v\nwant\n%v", walked, want)
+			}
+			if len(pages) != 5 || pages[0].PrevCursor != "" {
+				t.Fatalf("got %d pages, first prev cursor %q", len(pages), pages[0].PrevCursor)
+			}
+
+			// walking back from the last page gives the same pages
+			for i := len(pages) - 1; i > 0; i-- {
+				prev, err := Fetch[item](db, parse(t, "limit=5&sort="+sort+"&cursor="+pages[i].PrevCursor))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(ids(prev.Items)) != fmt.Sprint(ids(pages[i-1].Items)) {
+					t.Fatalf("page %d walking back = %v, want %v", i-1, ids(prev.Items), ids(pages[i-1].Items))
+				}
+				if (i-1 == 0) != (prev.PrevCursor == "") {
+					t.Fatalf("page %d walking back has prev cursor %q", i-1, prev.PrevCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchFiltersAndOffset(t *testing.T) {
+	db := openItems(t)
+
+	page, err := Fetch[item](db, parse(t, "minPrice=200&sort=-price&limit=4&offset=8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// prices 200 and 300 are 11 items, the offset leaves 3
+	if page.Total != 11 || len(page.Items) != 3 || page.NextCursor != "" || page.PrevCursor == "" {
+		t.Fatalf("page = total %d, %d items, next %q, prev %q", page.Total, len(page.Items), page.NextCursor, page.PrevCursor)
+	}
+	for _, it := range page.Items {
+		if it.Price < 200 {
+			t.Fatalf("item %s with price %d passed the filter", it.Id, it.Price)
+		}
+	}
+
+	q := parse(t, "sort=price").Where("id <> ?", "item-00")
+	all, err := Fetch[item](db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Total != 22 {
+		t.Fatalf("total with a fixed condition = %d, want 22", all.Total)
+	}
+}
+
+func TestParseRejectsCursorOfAnotherSort(t *testing.T) {
+	db := openItems(t)
+	page, err := Fetch[item](db, parse(t, "limit=5&sort=price"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := url.Values{"sort": {"-price"}, "cursor": {page.NextCursor}}
+	if _, fields := Parse(values, itemSpec); len(fields) != 1 || fields[0].Field != "cursor" {
+		t.Fatalf("fields = %v, want a cursor error", fields)
+	}
+	values = url.Values{"cursor": {"not-a-cursor"}}
+	if _, fields := Parse(values, itemSpec); len(fields) != 1 || fields[0].Field != "cursor" {
+		t.Fatalf("fields = %v, want a cursor error", fields)
+	}
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+	Every request gets its own zerolog logger carrying the request id (and the user id once the
+	token is validated). It travels in the request context, so code that receives the context or a
+	gorm handle built with WithContext logs lines that can be matched back to the request.
+*/
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestInfoKey struct{}
+
+// RequestInfo is filled in while the request travels down the middleware chain and read back by
+// the access log once the handler returns.
+type RequestInfo struct {
+	RequestId string
+	UserId    string
+}
+
+// Ctx returns the logger stored in ctx, or the global logger for work outside a request.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if ctx == nil {
+		return &log.Logger
+	}
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		return &log.Logger
+	}
+	return logger
+}
+
+func WithRequest(ctx context.Context, requestId string) (context.Context, *RequestInfo) {
+	info := &RequestInfo{RequestId: requestId}
+	logger := log.With().Str("requestId", requestId).Logger()
+	ctx = context.WithValue(ctx, requestInfoKey{}, info)
+	return logger.WithContext(ctx), info
+}
+
+// WithUser adds the authenticated user to the request logger.
+func WithUser(ctx context.Context, userId string) context.Context {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		info.UserId = userId
+	}
+	logger := Ctx(ctx).With().Str("userId", userId).Logger()
+	return logger.WithContext(ctx)
+}
+
+// RequestId is empty outside a request.
+func RequestId(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info.RequestId
+	}
+	return ""
+}
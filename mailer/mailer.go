@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+	Mailer delivers one rendered message. Handlers never send directly: they queue messages in the
+	email outbox (models.QueueEmail) and the outbox worker hands them to the default Mailer, so a
+	slow or failing mail server neither blocks a request nor loses the message.
+
+	Implementations:
+		smtp  SMTPMailer, a real mail server
+		file  FileMailer, writes every message as an .eml file, for development and tests
+		log   LogMailer, writes every message to the log
+*/
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+var ErrNoMailer = errors.New("no mailer configured")
+
+var (
+	mu      sync.RWMutex
+	current Mailer
+)
+
+// SetDefault installs the mailer the outbox sends through.
+func SetDefault(m Mailer) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = m
+}
+
+// Default returns the installed mailer.
+func Default() (Mailer, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return nil, ErrNoMailer
+	}
+	return current, nil
+}
+
+// Config picks and configures the mailer, see config.MailConfig.
+type Config struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	FileDir      string
+}
+
+// New builds the mailer named by cfg.Driver.
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return NewFileMailer(cfg.FileDir, cfg.From)
+	case "log", "":
+		return NewLogMailer(cfg.From), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
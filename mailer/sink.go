@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message into Dir as an .eml file that any mail client can open.
+type FileMailer struct {
+	Dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if dir == "" {
+		dir = "tmp/mail"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating mail dir: %w", err)
+	}
+	return &FileMailer{Dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Name() string {
+	return "file"
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	body, err := Compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), uuid.New().String()[:8])
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0o644)
+}
+
+// LogMailer only logs the messages, the text part included so links can be followed locally.
+type LogMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m *LogMailer) Name() string {
+	return "log"
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	logging.Ctx(ctx).Info().
+		Str("from", m.from).
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("text", msg.Text).
+		Msg("Email")
+	return nil
+}
@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+/*
+	Every message has templates/<name>.txt and templates/<name>.html. Both define a "subject" block,
+	the text one is the plain body, the HTML one a "content" block that is placed into layout.html.
+*/
+
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateWelcome           = "welcome"
+	TemplateOrderConfirmation = "order_confirmation"
+	TemplateOrderShipped      = "order_shipped"
+	TemplateOrderCancelled    = "order_cancelled"
+)
+
+type PasswordResetData struct {
+	Name      string
+	ResetURL  string
+	ExpiresIn string
+}
+
+type WelcomeData struct {
+	Name  string
+	Email string
+}
+
+// OrderData feeds the order confirmation, shipped and cancelled messages, amounts are in paise.
+type OrderData struct {
+	Name           string
+	OrderRef       string
+	Items          []OrderLine
+	Discount       int
+	Total          int
+	PaymentMode    string
+	TrackingNumber int
+	Reason         string
+	Paid           bool
+}
+
+type OrderLine struct {
+	Name     string
+	Quantity int
+	Total    int
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+var funcs = map[string]any{
+	// money formats an amount in paise as rupees
+	"money": func(paise int) string {
+		sign := ""
+		if paise < 0 {
+			sign, paise = "-", -paise
+		}
+		return fmt.Sprintf("%s₹%d.%02d", sign, paise/100, paise%100)
+	},
+}
+
+// Render fills the named templates with data. The returned message has no recipient yet.
+func Render(name string, data any) (Message, error) {
+	text, err := texttemplate.New(name+".txt").Funcs(funcs).ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("parsing %s.txt: %w", name, err)
+	}
+	html, err := htmltemplate.New("layout.html").Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return Message{}, fmt.Errorf("parsing %s.html: %w", name, err)
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return Message{}, err
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(textBody.String()) + "\n",
+		HTML:    htmlBody.String(),
+	}, nil
+}
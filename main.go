@@ -1,36 +1,37 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"github.com/pratyush934/sibling-bond-server/catalog"
 	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/controller"
 	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/mailer"
+	"github.com/pratyush934/sibling-bond-server/metrics"
+	"github.com/pratyush934/sibling-bond-server/migrations"
 	"github.com/pratyush934/sibling-bond-server/models"
+	"github.com/pratyush934/sibling-bond-server/payment"
+	"github.com/pratyush934/sibling-bond-server/ratelimit"
 	"github.com/pratyush934/sibling-bond-server/routes"
+	"github.com/pratyush934/sibling-bond-server/search"
 	"github.com/pratyush934/sibling-bond-server/utils"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var (
-	httpAddr = ":5000"
-)
-
-func init() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Warn().Err(err).Msg("Error loading .env file")
-	}
-	/*log.Info().
-	Str("IMAGEKIT_PUBLIC_KEY", os.Getenv("IMAGEKIT_PUBLIC_KEY")).
-	Str("IMAGEKIT_URL_ENDPOINT", os.Getenv("IMAGEKIT_URL_ENDPOINT")).
-	Msg("ImageKit environment variables loaded")*/
-}
-
-func LoadDB() {
-	if err := database.InitDB(); err != nil {
+func LoadDB(cfg *config.Config) {
+	if err := database.InitDB(cfg.Database); err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
 			Message:       "Issue while connecting LOAD DB",
@@ -38,27 +39,38 @@ func LoadDB() {
 		})
 	}
 
-	// Only migrate if the orders table does not exist
-	if !database.DB.Migrator().HasTable(&models.Order{}) {
-		if err := database.DB.AutoMigrate(
-			&models.User{},
-			&models.Address{},
-			&models.Role{},
-			&models.OrderItem{},
-			&models.Order{},
-			&models.Product{},
-			&models.Category{},
-			&models.ProductVariant{},
-			&models.Cart{},
-			&models.CartItem{},
-			&models.Image{},
-		); err != nil {
+	if err := database.DB.Use(metrics.GormPlugin{}); err != nil {
+		log.Err(err).Msg("Issue while installing the query metrics")
+	}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		if err := metrics.RegisterDBStats(sqlDB, database.CurrentDialect().Name()); err != nil {
+			log.Err(err).Msg("Issue while registering the DB pool metrics")
+		}
+	}
+
+	runner := migrations.NewRunner(database.DB)
+	if cfg.Database.AutoMigrate {
+		if _, err := runner.Up(0); err != nil {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusInternalServerError,
-				Message:       "Issue while migrating models to DB",
+				Message:       "Issue while migrating the DB",
 				InternalError: err,
 			})
 		}
+		return
+	}
+
+	// without auto migration the schema has to be brought up to date with `migrate up` first
+	pending, err := runner.Pending()
+	if err == nil && pending > 0 {
+		err = fmt.Errorf("%d pending migrations, run `migrate up`", pending)
+	}
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "DB schema is not up to date",
+			InternalError: err,
+		})
 	}
 }
 
@@ -92,36 +104,263 @@ func SeedData() {
 	}
 }
 
-func Server() {
+func LoadSigningKeys(cfg *config.Config) {
+	if err := utils.LoadSigningKeys(utils.SigningKeyConfig{
+		KeysDir:    cfg.Auth.JWTKeysDir,
+		ActiveKid:  cfg.Auth.JWTActiveKid,
+		HMACSecret: cfg.Auth.JWTSecret,
+	}); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Issue while loading the JWT signing keys",
+			InternalError: err,
+		})
+	}
+	utils.SetCartTokenSecret(cfg.Auth.CartTokenSecret)
+	utils.AccessTokenTTL = cfg.Auth.AccessTokenTTL
+	models.RefreshTokenTTL = cfg.Auth.RefreshTokenTTL
+}
+
+func LoadPaymentProviders(cfg *config.Config) {
+	// the fake provider lets admins mark orders paid, it is only registered when chosen
+	if cfg.Payment.Provider == payment.FakeProviderName {
+		secret := cfg.Payment.WebhookSecret
+		if secret == "" {
+			secret = cjson.CreateRandomToken()
+			log.Warn().Msg("PAYMENT_WEBHOOK_SECRET not set, using a random secret for the fake payment provider")
+		}
+		payment.Register(payment.NewFakeProvider(secret))
+	}
+
+	if err := payment.SetDefault(cfg.Payment.Provider); err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Issue while setting up the payment provider",
+			InternalError: err,
+		})
+	}
+}
+
+func LoadRateLimits(cfg *config.Config) {
+	rl := cfg.RateLimit
+	models.LockoutThreshold = rl.LockoutThreshold
+	models.LockoutDuration = rl.LockoutDuration
+	models.LockoutMaxDuration = rl.LockoutMaxDuration
+	if !rl.Enabled {
+		models.LockoutThreshold = 0
+		return
+	}
+
+	store := ratelimit.NewMemoryStore()
+	utils.AuthIPLimiter = ratelimit.NewLimiter("auth_ip", ratelimit.Limit{Requests: rl.IPRequests, Period: rl.IPPeriod}, store)
+	utils.AuthAccountLimiter = ratelimit.NewLimiter("auth_account", ratelimit.Limit{Requests: rl.AccountRequests, Period: rl.AccountPeriod}, store)
+}
+
+func LoadMailer(cfg *config.Config) {
+	m, err := mailer.New(mailer.Config{
+		Driver:       cfg.Mail.Driver,
+		From:         cfg.Mail.From,
+		SMTPHost:     cfg.Mail.SMTPHost,
+		SMTPPort:     cfg.Mail.SMTPPort,
+		SMTPUsername: cfg.Mail.SMTPUsername,
+		SMTPPassword: cfg.Mail.SMTPPassword,
+		FileDir:      cfg.Mail.FileDir,
+	})
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Issue while setting up the mailer",
+			InternalError: err,
+		})
+	}
+	mailer.SetDefault(m)
+}
+
+func LoadSearch(ctx context.Context, cfg *config.Config, workers *sync.WaitGroup) {
+	idx, err := search.New(cfg.Search.Backend, database.DB)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Issue while setting up the search index",
+			InternalError: err,
+		})
+	}
+	search.SetDefault(idx)
+
+	if _, ok := idx.(search.DocumentIndex); !ok {
+		return
+	}
+	start := time.Now()
+	if err := models.ReindexProducts(ctx); err != nil {
+		log.Err(err).Msg("Issue while building the search index, search stays empty until the next rebuild")
+	} else {
+		log.Info().Str("backend", idx.Name()).Dur("took", time.Since(start)).Msg("Search index built")
+	}
+	startWorker(workers, func() { models.StartSearchIndexer(ctx, cfg.Search.RefreshInterval) })
+}
+
+// startWorker runs a background loop that main waits for before the DB pool closes.
+func startWorker(workers *sync.WaitGroup, run func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run()
+	}()
+}
+
+func LoadMetrics() {
+	err := metrics.RegisterLowStock(func() (int, error) {
+		products, err := models.GetLowStockProducts(context.Background())
+		return len(products), err
+	})
+	if err != nil {
+		log.Err(err).Msg("Issue while registering the low stock metric")
+	}
+}
+
+// Server serves until ctx is cancelled. It then reports not ready, keeps serving for the drain
+// delay so load balancers take it out of rotation, stops accepting connections and gives in-flight
+// requests up to the shutdown timeout to finish.
+func Server(ctx context.Context, cfg *config.Config) {
+
+	utils.SetAllowedOrigins(cfg.CORS.AllowedOrigins)
+	// validated with the rest of the config
+	proxies, _ := cfg.Server.TrustedProxyPrefixes()
+	utils.SetTrustedProxies(proxies)
 
 	router := mux.NewRouter()
+	router.Use(utils.RequestLogger)
+	router.Use(utils.HTTPMetrics)
 	router.Use(utils.ErrorHandler)
 	router.Use(utils.CORSMiddleware)
 
+	routes.SetupHealthRoutes(router)
+	routes.SetupMetricsRoutes(router)
 	routes.SetupUserRoutes(router)
 	routes.SetupCartRoutes(router)
 	routes.SetupCategoryRoutes(router)
 	routes.SetupProductRoutes(router)
 	routes.SetupOrderRoutes(router)
+	routes.SetupCouponRoutes(router)
+	routes.SetupPaymentRoutes(router)
+	routes.SetupReturnRoutes(router)
 	routes.SetupRoleRoutes(router)
 	routes.SetUpImageKitRoutes(router)
 
 	server := &http.Server{
-		Addr:    httpAddr,
-		Handler: router,
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		log.Info().Str("addr", cfg.Server.Addr).Bool("tls", cfg.Server.TLS.Enabled).Msg("Server listening")
+		if cfg.Server.TLS.Enabled {
+			serverErr <- server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	// /metrics stays off the public listener unless metrics.addr is empty
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr != "" {
+		metricsServer = &http.Server{
+			Addr:              cfg.Metrics.Addr,
+			Handler:           routes.MetricsRouter(),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		}
+		go func() {
+			log.Info().Str("addr", cfg.Metrics.Addr).Msg("Metrics listening")
+			serverErr <- metricsServer.ListenAndServe()
+		}()
 	}
-	if err := server.ListenAndServe(); err != nil {
+
+	select {
+	case err := <-serverErr:
 		panic(&cjson.HTTPError{
 			Status:        http.StatusInternalServerError,
 			Message:       "Not able to start the Server",
 			InternalError: err,
 		})
+	case <-ctx.Done():
+	}
+
+	controller.MarkShuttingDown()
+	log.Info().Dur("delay", cfg.Server.ShutdownDrainDelay).Msg("Shutting down, readiness reports unavailable")
+	time.Sleep(cfg.Server.ShutdownDrainDelay)
+
+	log.Info().Dur("timeout", cfg.Server.ShutdownTimeout).Msg("Draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Err(err).Msg("Requests still running after the shutdown timeout, closing them")
+		_ = server.Close()
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Close()
 	}
 }
 
 func main() {
 
-	LoadDB()
+	configPath := flag.String("config", "", "path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+
+	if flag.Arg(0) == "migrate" {
+		if err != nil {
+			log.Fatal().Err(err).Msg("Issue while loading the configuration")
+		}
+		os.Exit(Migrate(cfg, flag.Args()[1:]))
+	}
+
+	if *printConfig && cfg != nil {
+		dump, dumpErr := cfg.Dump()
+		if dumpErr != nil {
+			log.Fatal().Err(dumpErr).Msg("Issue while printing the configuration")
+		}
+		fmt.Print(dump)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("Issue while loading the configuration")
+	}
+
+	LoadSigningKeys(cfg)
+	LoadDB(cfg)
 	SeedData()
-	Server()
+	LoadPaymentProviders(cfg)
+	LoadRateLimits(cfg)
+	LoadMailer(cfg)
+	LoadMetrics()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	startWorker(&workers, func() { models.StartReservationSweeper(ctx, time.Minute) })
+	startWorker(&workers, func() { models.StartSessionCleanup(ctx, time.Hour) })
+	startWorker(&workers, func() { models.StartEmailOutbox(ctx, 30*time.Second) })
+	startWorker(&workers, func() { catalog.StartImportWorker(ctx, 10*time.Second) })
+	LoadSearch(ctx, cfg, &workers)
+	Server(ctx, cfg)
+
+	// a sweep, outbox batch or import still running finishes before its connections go away
+	workers.Wait()
+
+	if err := database.Close(); err != nil {
+		log.Err(err).Msg("Issue while closing the DB pool")
+	}
+	log.Info().Msg("Server stopped")
 }
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+/*
+	Everything is registered on Registry rather than the global default so /metrics only shows what
+	this service exports plus the Go runtime and process collectors.
+
+	HTTP metrics are labelled by the mux route template, never the raw path, to keep cardinality
+	bounded. Domain counters are incremented by the models once the change has committed.
+*/
+
+const namespace = "siblingbond"
+
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	HTTPInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	DBQueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "gorm statement latency by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	DBQueryErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "gorm statements that failed, not counting record not found.",
+	}, []string{"operation", "table"})
+
+	OrdersCreated = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders placed, by payment mode.",
+	}, []string{"payment_mode"})
+
+	PaymentsCompleted = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_completed_total",
+		Help:      "Payments confirmed by a provider.",
+	}, []string{"provider"})
+
+	PaymentsFailed = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_failed_total",
+		Help:      "Payment attempts reported as failed by a provider.",
+	}, []string{"provider"})
+
+	CartItemsAdded = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cart_items_added_total",
+		Help:      "Units added to carts, new lines and quantity increases alike.",
+	})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests refused with 429, by limiter.",
+	}, []string{"limiter"})
+
+	EmailsSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_sent_total",
+		Help:      "Outbox messages accepted by the mailer, by template.",
+	}, []string{"template"})
+
+	EmailSendErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "email_send_errors_total",
+		Help:      "Failed send attempts of outbox messages, by template.",
+	}, []string{"template"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDBStats exports the connection pool statistics of db.
+func RegisterDBStats(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterLowStock exports the number of products at or below their reorder point. count runs on
+// every scrape, so the value is never stale.
+func RegisterLowStock(count func() (int, error)) error {
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "low_stock_products",
+		Help:      "Active products whose stock is at or below the reorder point.",
+	}, func() float64 {
+		n, err := count()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/migrations"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+  up [version]   apply pending migrations, up to version when given
+  down [steps]   roll back the last steps migrations (default 1)
+  status         list migrations and whether they are applied
+  version        print the current schema version
+  unlock         release a migration lock left behind by a crashed process`
+
+// Migrate runs the migrate subcommand and returns the process exit code.
+func Migrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err := database.InitDB(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to the database:", err)
+		return 1
+	}
+	runner := migrations.NewRunner(database.DB)
+
+	number := func(def int) (int, error) {
+		if len(args) < 2 {
+			return def, nil
+		}
+		return strconv.Atoi(args[1])
+	}
+
+	switch args[0] {
+	case "up":
+		target, err := number(0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "version must be a number")
+			return 2
+		}
+		applied, err := runner.Up(target)
+		for _, version := range applied {
+			fmt.Printf("applied %04d\n", version)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("nothing to apply")
+		}
+
+	case "down":
+		steps, err := number(1)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "steps must be a number")
+			return 2
+		}
+		rolledBack, err := runner.Down(steps)
+		for _, version := range rolledBack {
+			fmt.Printf("rolled back %04d\n", version)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "status":
+		statuses, err := runner.Status()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Modified {
+				state += " (MODIFIED since applied)"
+			}
+			fmt.Printf("%04d  %-30s %s\n", status.Version, status.Name, state)
+		}
+
+	case "version":
+		version, err := runner.Version()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("current %d, latest %d\n", version, migrations.Latest())
+
+	case "unlock":
+		if err := runner.ForceUnlock(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("migration lock released")
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,356 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+	Baseline: the schema the application used to create with AutoMigrate on first start, frozen here.
+	It is built with AutoMigrate too, which only adds what is missing, so running it against a
+	database created by the old startup code brings that database up to date instead of failing.
+*/
+
+type roleV1 struct {
+	Id          int    `gorm:"primaryKey"`
+	RoleName    string `gorm:"not null"`
+	Description string `gorm:"not null"`
+}
+
+type userV1 struct {
+	Id                  string `gorm:"primaryKey; type:varchar(100)"`
+	Email               string `gorm:"unique;not null"`
+	PassWord            string `gorm:"not null"`
+	UserName            string `gorm:"not null;unique"`
+	FirstName           string `gorm:"not null"`
+	LastName            string
+	PhoneNumber         string
+	RoleId              int         `gorm:"not null; default:1"`
+	Role                roleV1      `gorm:"constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Addresses           []addressV1 `gorm:"foreignKey:UserId"`
+	Orders              []orderV1   `gorm:"foreignKey:UserId"`
+	PrimaryAddress      string
+	PasswordResetToken  *string
+	PasswordResetExpiry *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+type addressV1 struct {
+	Id         string `gorm:"primaryKey;type:varchar(191)"`
+	UserId     string `gorm:"not null"`
+	StreetName string `gorm:"not null"`
+	LandMark   string `gorm:"not null"`
+	ZipCode    string `gorm:"not null"`
+	City       string `gorm:"not null"`
+	State      string `gorm:"not null"`
+}
+
+type categoryV1 struct {
+	Id          string `gorm:"primaryKey;type:varchar(150)"`
+	Name        string `gorm:"not null"`
+	Description string
+	Products    []productV1 `gorm:"foreignKey:category_id"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type productV1 struct {
+	Id            string `gorm:"primaryKey;type:varchar(191)"`
+	Name          string `gorm:"not null"`
+	Description   string
+	Price         int            `gorm:"not null"`
+	Stock         int            `gorm:"not null;default:0"`
+	CategoryId    string         `gorm:"not null;type:varchar(150);column:category_id"`
+	Category      *categoryV1    `gorm:"foreignKey:category_id;references:Id;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Images        []imageV1      `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	IsActive      bool           `gorm:"default:true"`
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Variants      []productVariantV1 `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	MinStockLevel int                `gorm:"default:5"`
+	MaxStockLevel int                `gorm:"default:100"`
+	ReorderPoint  int                `gorm:"default:10"`
+	SKU           string             `gorm:"unique"`
+	Barcode       string
+	Weight        float64
+	Dimensions    string
+}
+
+type productVariantV1 struct {
+	Id              string    `gorm:"primaryKey;type:varchar(191)"`
+	ProductId       string    `gorm:"not null"`
+	Product         productV1 `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	VariantName     string    `gorm:"not null"`
+	VariantValue    string    `gorm:"not null"`
+	PriceAdjustment int       `gorm:"default:0"`
+	Stock           int       `gorm:"not null;default:0"`
+	SKU             string    `gorm:"unique"`
+	IsActive        bool      `gorm:"default:true"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type imageV1 struct {
+	Id        string `gorm:"primaryKey;type:varchar(191)"`
+	URL       string `gorm:"not null"`
+	FileName  string `gorm:"not null"`
+	FieldId   string `gorm:"not null"`
+	AltText   string
+	IsPrimary bool `gorm:"default:false"`
+	SortOrder int  `gorm:"default:0"`
+	FileSize  int64
+	MimeType  string
+	ProductId string `gorm:"not null;type:varchar(191)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type cartV1 struct {
+	Id        string       `gorm:"primaryKey;type:varchar(191)"`
+	UserId    *string      `gorm:"type:varchar(100);index"`
+	User      *userV1      `gorm:"foreignKey:UserId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	CartItems []cartItemV1 `gorm:"foreignKey:CartId"`
+	CouponId  *string      `gorm:"type:varchar(191)"`
+	Coupon    *couponV1    `gorm:"foreignKey:CouponId;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type cartItemV1 struct {
+	Id            string            `gorm:"primaryKey;type:varchar(191)"`
+	CartId        string            `gorm:"not null"`
+	ProductId     string            `gorm:"not null"`
+	Product       productV1         `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	VariantId     *string           `gorm:"type:varchar(191)"`
+	Variant       *productVariantV1 `gorm:"foreignKey:VariantId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Quantity      int               `gorm:"default:0"`
+	PriceAtAdding int               `gorm:"default:0"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type orderV1 struct {
+	Id                string        `gorm:"primaryKey;type:varchar(191)"`
+	UserId            string        `gorm:"not null"`
+	User              userV1        `gorm:"foreignKey:UserId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	OrderItems        []orderItemV1 `gorm:"foreignKey:OrderId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	OrderedAt         time.Time
+	SubTotal          int
+	DiscountAmount    int     `gorm:"default:0"`
+	CouponId          *string `gorm:"type:varchar(191)"`
+	CouponCode        string
+	TotalAmount       int
+	RefundedAmount    int       `gorm:"default:0"`
+	ShippingAddressId string    `gorm:"type:varchar(191);not null"`
+	ShippingAddress   addressV1 `gorm:"foreignKey:ShippingAddressId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Status            string
+	PaymentStatus     string
+	PaymentMode       string
+	TrackingNumber    int
+	Payments          []paymentV1 `gorm:"foreignKey:OrderId"`
+	CancelReason      string
+	CancelledAt       *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+type orderItemV1 struct {
+	Id              string    `gorm:"primaryKey;type:varchar(191)"`
+	OrderId         string    `gorm:"not null"`
+	ProductId       string    `gorm:"not null;type:varchar(191)"`
+	VariantId       *string   `gorm:"type:varchar(191)"`
+	Order           orderV1   `gorm:"foreignKey:OrderId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Product         productV1 `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Quantity        int       `gorm:"not null"`
+	PriceAtPurchase int       `gorm:"not null"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type orderStatusHistoryV1 struct {
+	Id         string `gorm:"primaryKey;type:varchar(191)"`
+	OrderId    string `gorm:"not null;type:varchar(191);index"`
+	FromStatus string `gorm:"type:varchar(20)"`
+	ToStatus   string `gorm:"not null;type:varchar(20)"`
+	ChangedBy  string `gorm:"not null;type:varchar(100)"`
+	Note       string
+	CreatedAt  time.Time
+}
+
+type stockReservationV1 struct {
+	Id          string     `gorm:"primaryKey;type:varchar(191)"`
+	OrderId     string     `gorm:"not null;type:varchar(191);index"`
+	OrderItemId string     `gorm:"not null;type:varchar(191)"`
+	ProductId   string     `gorm:"not null;type:varchar(191)"`
+	VariantId   *string    `gorm:"type:varchar(191)"`
+	Quantity    int        `gorm:"not null"`
+	Status      string     `gorm:"not null;type:varchar(20);index"`
+	ExpiresAt   *time.Time `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type couponV1 struct {
+	Id            string `gorm:"primaryKey;type:varchar(191)"`
+	Code          string `gorm:"unique;not null;type:varchar(64)"`
+	Description   string
+	DiscountType  string `gorm:"not null"`
+	DiscountValue int    `gorm:"not null"`
+	MaxDiscount   int    `gorm:"default:0"`
+	MinCartValue  int    `gorm:"default:0"`
+	UsageLimit    int    `gorm:"default:0"`
+	PerUserLimit  int    `gorm:"default:0"`
+	UsedCount     int    `gorm:"default:0"`
+	ValidFrom     *time.Time
+	ValidUntil    *time.Time
+	IsActive      bool         `gorm:"default:true"`
+	Categories    []categoryV1 `gorm:"many2many:coupon_categories;joinForeignKey:CouponId;joinReferences:CategoryId"`
+	Products      []productV1  `gorm:"many2many:coupon_products;joinForeignKey:CouponId;joinReferences:ProductId"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type couponUsageV1 struct {
+	Id             string `gorm:"primaryKey;type:varchar(191)"`
+	CouponId       string `gorm:"not null;type:varchar(191);index"`
+	UserId         string `gorm:"not null;type:varchar(100);index"`
+	OrderId        string `gorm:"not null;type:varchar(191)"`
+	DiscountAmount int
+	CreatedAt      time.Time
+}
+
+type paymentV1 struct {
+	Id             string `gorm:"primaryKey;type:varchar(191)"`
+	OrderId        string `gorm:"not null;type:varchar(191);index"`
+	Provider       string `gorm:"not null;type:varchar(50);uniqueIndex:idx_payment_provider_ref"`
+	ProviderRef    string `gorm:"not null;type:varchar(191);uniqueIndex:idx_payment_provider_ref"`
+	Attempt        int    `gorm:"not null;default:1"`
+	Method         string
+	Amount         int    `gorm:"not null"`
+	RefundedAmount int    `gorm:"default:0"`
+	Currency       string `gorm:"type:varchar(10)"`
+	Status         string `gorm:"not null;type:varchar(30)"`
+	FailureReason  string
+	CompletedAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type returnRequestV1 struct {
+	Id           string  `gorm:"primaryKey;type:varchar(191)"`
+	OrderId      string  `gorm:"not null;type:varchar(191);index"`
+	OrderItemId  string  `gorm:"not null;type:varchar(191);index"`
+	UserId       string  `gorm:"not null;type:varchar(191);index"`
+	ProductId    string  `gorm:"not null;type:varchar(191)"`
+	VariantId    *string `gorm:"type:varchar(191)"`
+	Quantity     int     `gorm:"not null"`
+	Reason       string  `gorm:"not null;type:varchar(30)"`
+	Comment      string
+	Status       string `gorm:"not null;type:varchar(20);index"`
+	AdminNote    string
+	ReviewedBy   string `gorm:"type:varchar(191)"`
+	RefundAmount int    `gorm:"default:0"`
+	RefundRef    string
+	ReceivedAt   *time.Time
+	RefundedAt   *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type sessionV1 struct {
+	Id           string `gorm:"primaryKey;type:varchar(191)"`
+	UserId       string `gorm:"not null;type:varchar(100);index"`
+	UserAgent    string
+	IpAddress    string    `gorm:"type:varchar(64)"`
+	ExpiresAt    time.Time `gorm:"index"`
+	LastUsedAt   time.Time
+	RevokedAt    *time.Time
+	RevokeReason string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type refreshTokenV1 struct {
+	Id         string    `gorm:"primaryKey;type:varchar(191)"`
+	SessionId  string    `gorm:"not null;type:varchar(191);index"`
+	TokenHash  string    `gorm:"not null;type:varchar(64);uniqueIndex"`
+	ExpiresAt  time.Time `gorm:"index"`
+	UsedAt     *time.Time
+	ReplacedBy *string `gorm:"type:varchar(191)"`
+	CreatedAt  time.Time
+}
+
+type revokedTokenV1 struct {
+	Jti       string    `gorm:"primaryKey;type:varchar(191)"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (roleV1) TableName() string               { return "roles" }
+func (userV1) TableName() string               { return "users" }
+func (addressV1) TableName() string            { return "addresses" }
+func (categoryV1) TableName() string           { return "categories" }
+func (productV1) TableName() string            { return "products" }
+func (productVariantV1) TableName() string     { return "product_variants" }
+func (imageV1) TableName() string              { return "images" }
+func (cartV1) TableName() string               { return "carts" }
+func (cartItemV1) TableName() string           { return "cart_items" }
+func (orderV1) TableName() string              { return "orders" }
+func (orderItemV1) TableName() string          { return "order_items" }
+func (orderStatusHistoryV1) TableName() string { return "order_status_histories" }
+func (stockReservationV1) TableName() string   { return "stock_reservations" }
+func (couponV1) TableName() string             { return "coupons" }
+func (couponUsageV1) TableName() string        { return "coupon_usages" }
+func (paymentV1) TableName() string            { return "payments" }
+func (returnRequestV1) TableName() string      { return "return_requests" }
+func (sessionV1) TableName() string            { return "sessions" }
+func (refreshTokenV1) TableName() string       { return "refresh_tokens" }
+func (revokedTokenV1) TableName() string       { return "revoked_tokens" }
+
+// baselineTables is in creation order; Down drops them the other way round.
+var baselineTables = []any{
+	&roleV1{},
+	&userV1{},
+	&addressV1{},
+	&categoryV1{},
+	&productV1{},
+	&productVariantV1{},
+	&imageV1{},
+	&couponV1{},
+	&cartV1{},
+	&cartItemV1{},
+	&orderV1{},
+	&orderItemV1{},
+	&orderStatusHistoryV1{},
+	&stockReservationV1{},
+	&couponUsageV1{},
+	&paymentV1{},
+	&returnRequestV1{},
+	&sessionV1{},
+	&refreshTokenV1{},
+	&revokedTokenV1{},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Schema:  baselineTables,
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(baselineTables...)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("coupon_categories", "coupon_products"); err != nil {
+				return err
+			}
+			for i := len(baselineTables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(baselineTables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Failed login tracking for the progressive account lockout.
+
+type userLockoutV2 struct {
+	FailedLoginAttempts int `gorm:"not null;default:0"`
+	LockedUntil         *time.Time
+}
+
+func (userLockoutV2) TableName() string { return "users" }
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "login_lockout",
+		Schema:  []any{userLockoutV2{}},
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"FailedLoginAttempts", "LockedUntil"} {
+				if tx.Migrator().HasColumn(&userLockoutV2{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&userLockoutV2{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"LockedUntil", "FailedLoginAttempts"} {
+				if err := tx.Migrator().DropColumn(&userLockoutV2{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Outbox of rendered emails waiting for the mailer.
+
+type emailOutboxV3 struct {
+	Id            string    `gorm:"primaryKey;type:varchar(191)"`
+	Recipient     string    `gorm:"not null;type:varchar(255)"`
+	Template      string    `gorm:"not null;type:varchar(64)"`
+	Subject       string    `gorm:"not null"`
+	TextBody      string    `gorm:"type:text"`
+	HTMLBody      string    `gorm:"type:text"`
+	Status        string    `gorm:"not null;type:varchar(20);index:idx_email_outbox_due,priority:1"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index:idx_email_outbox_due,priority:2"`
+	LastError     string    `gorm:"type:text"`
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (emailOutboxV3) TableName() string { return "email_outbox" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "email_outbox",
+		Schema:  []any{emailOutboxV3{}},
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&emailOutboxV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&emailOutboxV3{})
+		},
+	})
+}
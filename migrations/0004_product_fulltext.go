@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// FULLTEXT indexes for the mysql search backend. Other databases have no use for them, so the
+// migration is recorded there without changing anything.
+
+const (
+	productFulltextSQL     = "CREATE FULLTEXT INDEX idx_products_fulltext ON products (name, description)"
+	productNameFulltextSQL = "CREATE FULLTEXT INDEX idx_products_name_fulltext ON products (name)"
+)
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "product_fulltext",
+		Schema:  []any{productFulltextSQL, productNameFulltextSQL},
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" {
+				return nil
+			}
+			for _, index := range []struct{ name, sql string }{
+				{"idx_products_fulltext", productFulltextSQL},
+				{"idx_products_name_fulltext", productNameFulltextSQL},
+			} {
+				if tx.Migrator().HasIndex("products", index.name) {
+					continue
+				}
+				if err := tx.Exec(index.sql).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" {
+				return nil
+			}
+			for _, name := range []string{"idx_products_name_fulltext", "idx_products_fulltext"} {
+				if !tx.Migrator().HasIndex("products", name) {
+					continue
+				}
+				if err := tx.Migrator().DropIndex("products", name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
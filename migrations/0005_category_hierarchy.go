@@ -0,0 +1,137 @@
+package migrations
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"unicode"
+)
+
+/*
+	Parent/child categories with URL slugs and a manual sort order.
+
+	Existing categories become roots and get a slug made from their name before the unique index
+	goes on. The parent foreign key is only added on mysql and postgres: SQLite can only add a
+	constraint by rebuilding the table, which it can not do while products point at it, so there
+	the application is what keeps parents valid.
+*/
+
+type categoryV5 struct {
+	Id        string      `gorm:"primaryKey;type:varchar(150)"`
+	Slug      string      `gorm:"type:varchar(191);uniqueIndex:idx_categories_slug"`
+	ParentId  *string     `gorm:"type:varchar(150);index:idx_categories_parent"`
+	Parent    *categoryV5 `gorm:"foreignKey:ParentId;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+	SortOrder int         `gorm:"not null;default:0"`
+}
+
+func (categoryV5) TableName() string { return "categories" }
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "category_hierarchy",
+		Schema:  []any{categoryV5{}},
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			for _, column := range []string{"Slug", "ParentId", "SortOrder"} {
+				if m.HasColumn(&categoryV5{}, column) {
+					continue
+				}
+				if err := m.AddColumn(&categoryV5{}, column); err != nil {
+					return err
+				}
+			}
+			if err := backfillCategorySlugs(tx); err != nil {
+				return err
+			}
+			for _, index := range []string{"idx_categories_slug", "idx_categories_parent"} {
+				if m.HasIndex(&categoryV5{}, index) {
+					continue
+				}
+				if err := m.CreateIndex(&categoryV5{}, index); err != nil {
+					return err
+				}
+			}
+			if tx.Dialector.Name() != "sqlite" && !m.HasConstraint(&categoryV5{}, "Parent") {
+				return m.CreateConstraint(&categoryV5{}, "Parent")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if m.HasConstraint(&categoryV5{}, "Parent") {
+				if err := m.DropConstraint(&categoryV5{}, "Parent"); err != nil {
+					return err
+				}
+			}
+			for _, index := range []string{"idx_categories_parent", "idx_categories_slug"} {
+				if !m.HasIndex(&categoryV5{}, index) {
+					continue
+				}
+				if err := m.DropIndex(&categoryV5{}, index); err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"SortOrder", "ParentId", "Slug"} {
+				if err := m.DropColumn(&categoryV5{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// backfillCategorySlugs gives every category without a slug one made from its name, numbered when
+// two names come out the same.
+func backfillCategorySlugs(tx *gorm.DB) error {
+	var rows []struct {
+		Id   string
+		Name string
+		Slug *string
+	}
+	if err := tx.Table("categories").Select("id, name, slug").Order("created_at, id").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	taken := map[string]bool{}
+	for _, row := range rows {
+		if row.Slug != nil && *row.Slug != "" {
+			taken[*row.Slug] = true
+		}
+	}
+	for _, row := range rows {
+		if row.Slug != nil && *row.Slug != "" {
+			continue
+		}
+		base := slugV5(row.Name)
+		if base == "" {
+			base = "category"
+		}
+		slug := base
+		for n := 2; taken[slug]; n++ {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken[slug] = true
+		if err := tx.Table("categories").Where("id = ?", row.Id).Update("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slugV5 is a copy of the slug rules at the time of this migration, they must not follow later changes.
+func slugV5(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
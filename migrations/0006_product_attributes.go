@@ -0,0 +1,81 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Typed attribute schemas per category and the attribute values of products and variants.
+
+// the tables the new ones point at, only as far as the foreign keys need them
+type categoryRefV6 struct {
+	Id string `gorm:"primaryKey;type:varchar(150)"`
+}
+
+type productRefV6 struct {
+	Id string `gorm:"primaryKey;type:varchar(191)"`
+}
+
+type productVariantRefV6 struct {
+	Id string `gorm:"primaryKey;type:varchar(191)"`
+}
+
+type categoryAttributeV6 struct {
+	Id         string         `gorm:"primaryKey;type:varchar(191)"`
+	CategoryId string         `gorm:"not null;type:varchar(150);uniqueIndex:idx_category_attributes_key,priority:1"`
+	Category   *categoryRefV6 `gorm:"foreignKey:CategoryId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Key        string         `gorm:"not null;type:varchar(64);uniqueIndex:idx_category_attributes_key,priority:2"`
+	Name       string         `gorm:"not null"`
+	Type       string         `gorm:"not null;type:varchar(20)"`
+	Unit       string         `gorm:"type:varchar(20)"`
+	Options    string         `gorm:"type:text"`
+	Min        *float64
+	Max        *float64
+	Level      string `gorm:"not null;type:varchar(20);default:product"`
+	Required   bool   `gorm:"not null;default:false"`
+	SortOrder  int    `gorm:"not null;default:0"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type productAttributeValueV6 struct {
+	Id          string               `gorm:"primaryKey;type:varchar(191)"`
+	ProductId   *string              `gorm:"type:varchar(191);index"`
+	Product     *productRefV6        `gorm:"foreignKey:ProductId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	VariantId   *string              `gorm:"type:varchar(191);index"`
+	Variant     *productVariantRefV6 `gorm:"foreignKey:VariantId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	AttributeId string               `gorm:"not null;type:varchar(191);index:idx_attribute_values_lookup,priority:1"`
+	Attribute   *categoryAttributeV6 `gorm:"foreignKey:AttributeId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Value       string               `gorm:"not null;type:varchar(255);index:idx_attribute_values_lookup,priority:2"`
+	Number      *float64             `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+func (categoryRefV6) TableName() string           { return "categories" }
+func (productRefV6) TableName() string            { return "products" }
+func (productVariantRefV6) TableName() string     { return "product_variants" }
+func (categoryAttributeV6) TableName() string     { return "category_attributes" }
+func (productAttributeValueV6) TableName() string { return "product_attribute_values" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "product_attributes",
+		Schema:  []any{categoryAttributeV6{}, productAttributeValueV6{}},
+		Up: func(tx *gorm.DB) error {
+			// CreateTable rather than AutoMigrate, which would also try to migrate the referenced tables
+			for _, table := range []any{&categoryAttributeV6{}, &productAttributeValueV6{}} {
+				if tx.Migrator().HasTable(table) {
+					continue
+				}
+				if err := tx.Migrator().CreateTable(table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&productAttributeValueV6{}, &categoryAttributeV6{})
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Bulk product imports: the uploaded file and the progress and report of the job working through it.
+
+type productImportJobV7 struct {
+	Id          string     `gorm:"primaryKey;type:varchar(191)"`
+	Format      string     `gorm:"not null;type:varchar(10)"`
+	FileName    string     `gorm:"type:varchar(255)"`
+	DryRun      bool       `gorm:"not null;default:false"`
+	Status      string     `gorm:"not null;type:varchar(20);index:idx_product_imports_due,priority:1"`
+	LockedUntil *time.Time `gorm:"index:idx_product_imports_due,priority:2"`
+	Payload     []byte
+	CreatedBy   string `gorm:"type:varchar(191)"`
+	TotalRows   int    `gorm:"not null;default:0"`
+	Processed   int    `gorm:"not null;default:0"`
+	Created     int    `gorm:"not null;default:0"`
+	Updated     int    `gorm:"not null;default:0"`
+	Failed      int    `gorm:"not null;default:0"`
+	Errors      string
+	Error       string `gorm:"type:text"`
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (productImportJobV7) TableName() string { return "product_import_jobs" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "product_import_jobs",
+		Schema:  []any{productImportJobV7{}},
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&productImportJobV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&productImportJobV7{})
+		},
+	})
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import "gorm.io/gorm"
+
+/*
+	Deleting a category no longer deletes its products.
+
+	The baseline created fk_products_category with ON DELETE CASCADE, so removing a category row
+	took every product in it along, and with them their variants and images. The key is recreated
+	with ON DELETE RESTRICT: a category is only deleted once its products moved elsewhere, which is
+	what DeleteCategory does. As in 0005, SQLite keeps the old key since changing it means
+	rebuilding the products table while order items and cart items point at it, there
+	DeleteCategory is what keeps products from going with their category.
+*/
+
+type categoryRefV8 struct {
+	Id string `gorm:"primaryKey;type:varchar(150)"`
+}
+
+type productV8 struct {
+	Id         string         `gorm:"primaryKey;type:varchar(191)"`
+	CategoryId string         `gorm:"not null;type:varchar(150);column:category_id"`
+	Category   *categoryRefV8 `gorm:"foreignKey:category_id;references:Id;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+}
+
+func (productV8) TableName() string { return "products" }
+
+// productCascadeV8 is the key as the baseline created it, for Down.
+type productCascadeV8 struct {
+	Id         string         `gorm:"primaryKey;type:varchar(191)"`
+	CategoryId string         `gorm:"not null;type:varchar(150);column:category_id"`
+	Category   *categoryRefV8 `gorm:"foreignKey:category_id;references:Id;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+func (productCascadeV8) TableName() string { return "products" }
+
+// replaceProductCategoryKey drops fk_products_category and creates it again as model describes it.
+func replaceProductCategoryKey(tx *gorm.DB, model any) error {
+	if tx.Dialector.Name() == "sqlite" {
+		return nil
+	}
+	m := tx.Migrator()
+	if m.HasConstraint(model, "Category") {
+		if err := m.DropConstraint(model, "Category"); err != nil {
+			return err
+		}
+	}
+	return m.CreateConstraint(model, "Category")
+}
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "product_category_restrict",
+		Schema:  []any{productV8{}, productCascadeV8{}},
+		Up: func(tx *gorm.DB) error {
+			return replaceProductCategoryKey(tx, &productV8{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return replaceProductCategoryKey(tx, &productCascadeV8{})
+		},
+	})
+}
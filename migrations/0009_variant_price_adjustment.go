@@ -0,0 +1,44 @@
+package migrations
+
+import "gorm.io/gorm"
+
+/*
+	Variant prices become adjustments to the product price.
+
+	Before the migrations a variant stored its own full price in product_variants.price, and the
+	baseline only added price_adjustment next to it, so databases from then still carry the old
+	column while carts and orders read the adjustment. Here the adjustment is worked out from the
+	old price and the column goes. A price of 0 was a variant without a price of its own, it keeps
+	the adjustment of 0. Databases created by the baseline never had the column and are left alone.
+
+	Down keeps price_adjustment as the only price: the old column predates the migrations, so the
+	baseline schema it goes back to does not have it either.
+*/
+
+const (
+	variantPriceAdjustmentSQL = "UPDATE product_variants SET price_adjustment = price - " +
+		"(SELECT products.price FROM products WHERE products.id = product_variants.product_id) " +
+		"WHERE price IS NOT NULL AND price <> 0 AND price_adjustment = 0"
+	variantDropPriceSQL = "ALTER TABLE product_variants DROP COLUMN price"
+)
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "variant_price_adjustment",
+		Schema:  []any{variantPriceAdjustmentSQL, variantDropPriceSQL},
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn("product_variants", "price") {
+				return nil
+			}
+			if err := tx.Exec(variantPriceAdjustmentSQL).Error; err != nil {
+				return err
+			}
+			// a plain ALTER TABLE, gorm would rebuild the table on SQLite while carts and orders point at it
+			return tx.Exec(variantDropPriceSQL).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
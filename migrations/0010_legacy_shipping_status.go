@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+	Orders left in the legacy "shipping" status move to "shipped".
+
+	Before the order state machine the status was free text and the admin screens used "shipping".
+	The transition table has no such state, so those orders could not move at all. Each one gets a
+	history entry for the change; Down uses those entries to put exactly these orders back.
+*/
+
+const (
+	legacyShippingStatus = "shipping"
+	legacyShippingNote   = "legacy shipping status"
+)
+
+type orderStatusV10 struct {
+	Id     string `gorm:"primaryKey;type:varchar(191)"`
+	Status string
+}
+
+func (orderStatusV10) TableName() string { return "orders" }
+
+type orderStatusHistoryV10 struct {
+	Id         string `gorm:"primaryKey;type:varchar(191)"`
+	OrderId    string `gorm:"not null;type:varchar(191);index"`
+	FromStatus string `gorm:"type:varchar(20)"`
+	ToStatus   string `gorm:"not null;type:varchar(20)"`
+	ChangedBy  string `gorm:"not null;type:varchar(100)"`
+	Note       string
+	CreatedAt  time.Time
+}
+
+func (orderStatusHistoryV10) TableName() string { return "order_status_histories" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "legacy_shipping_status",
+		Schema:  []any{orderStatusV10{}, orderStatusHistoryV10{}},
+		Up: func(tx *gorm.DB) error {
+			var ids []string
+			if err := tx.Model(&orderStatusV10{}).Where("status = ?", legacyShippingStatus).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			now := time.Now()
+			history := make([]orderStatusHistoryV10, 0, len(ids))
+			for _, id := range ids {
+				history = append(history, orderStatusHistoryV10{
+					Id:         uuid.New().String(),
+					OrderId:    id,
+					FromStatus: legacyShippingStatus,
+					ToStatus:   "shipped",
+					ChangedBy:  "system",
+					Note:       legacyShippingNote,
+					CreatedAt:  now,
+				})
+			}
+			if err := tx.CreateInBatches(&history, 200).Error; err != nil {
+				return err
+			}
+			return tx.Model(&orderStatusV10{}).Where("status = ?", legacyShippingStatus).Update("status", "shipped").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			migrated := tx.Model(&orderStatusHistoryV10{}).Select("order_id").
+				Where("from_status = ? AND note = ?", legacyShippingStatus, legacyShippingNote)
+			// an order that moved on since is left where it is
+			if err := tx.Model(&orderStatusV10{}).Where("status = ? AND id IN (?)", "shipped", migrated).
+				Update("status", legacyShippingStatus).Error; err != nil {
+				return err
+			}
+			return tx.Where("from_status = ? AND note = ?", legacyShippingStatus, legacyShippingNote).
+				Delete(&orderStatusHistoryV10{}).Error
+		},
+	})
+}
@@ -0,0 +1,337 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+	Every schema change is a numbered Migration registered from its own file (0001_baseline.go, ...).
+	Applied versions are recorded in schema_migrations together with a checksum of the migration's
+	Schema, so editing a migration after it shipped is caught instead of silently diverging.
+	A single row in schema_migration_lock keeps two instances from migrating at the same time.
+
+	Migrations must never use the live models package: they describe the schema as it was when they
+	were written, which is why each one carries its own snapshot structs.
+*/
+
+type Migration struct {
+	Version int
+	Name    string
+	// Schema is what the checksum covers: the snapshot structs and raw SQL the migration applies.
+	Schema []any
+	Up     func(tx *gorm.DB) error
+	Down   func(tx *gorm.DB) error
+}
+
+type SchemaMigration struct {
+	Version     int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name        string    `gorm:"not null" json:"name"`
+	Checksum    string    `gorm:"not null;type:varchar(64)" json:"checksum"`
+	AppliedAt   time.Time `json:"appliedAt"`
+	ExecutionMs int64     `json:"executionMs"`
+}
+
+type SchemaMigrationLock struct {
+	Id       int       `gorm:"primaryKey;autoIncrement:false"`
+	LockedBy string    `gorm:"not null;type:varchar(191)"`
+	LockedAt time.Time `gorm:"not null"`
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Modified  bool       `json:"modified"`
+}
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+	ErrLockHeld         = errors.New("migration lock is held by another process")
+)
+
+// LockTimeout is how long Up and Down wait for another process to finish migrating.
+var LockTimeout = time.Minute
+
+var registry []Migration
+
+func register(m Migration) {
+	registry = append(registry, m)
+}
+
+// All returns the registered migrations ordered by version.
+func All() []Migration {
+	all := append([]Migration(nil), registry...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s\n", m.Version, m.Name)
+	for _, item := range m.Schema {
+		if sql, ok := item.(string); ok {
+			fmt.Fprintf(h, "sql:%s\n", sql)
+			continue
+		}
+		describeType(h, reflect.TypeOf(item))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// describeType writes the column layout of a snapshot struct: names, types and gorm tags.
+func describeType(h interface{ Write([]byte) (int, error) }, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if tabler, ok := reflect.New(t).Interface().(interface{ TableName() string }); ok {
+		fmt.Fprintf(h, "table:%s\n", tabler.TableName())
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fmt.Fprintf(h, "%s %s `%s`\n", f.Name, f.Type.String(), f.Tag.Get("gorm"))
+	}
+}
+
+type Runner struct {
+	db         *gorm.DB
+	migrations []Migration
+	owner      string
+}
+
+func NewRunner(db *gorm.DB) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		db:         db,
+		migrations: All(),
+		owner:      fmt.Sprintf("%s:%d:%s", host, os.Getpid(), uuid.New().String()[:8]),
+	}
+}
+
+func (r *Runner) ensureTables() error {
+	return r.db.AutoMigrate(&SchemaMigration{}, &SchemaMigrationLock{})
+}
+
+func (r *Runner) applied() (map[int]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := r.db.Order("version ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// lock takes the single lock row, waiting up to LockTimeout for another process to release it.
+func (r *Runner) lock() error {
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := r.db.Create(&SchemaMigrationLock{Id: 1, LockedBy: r.owner, LockedAt: time.Now()}).Error
+		if err == nil {
+			return nil
+		}
+
+		var holder SchemaMigrationLock
+		if findErr := r.db.Where("id = ?", 1).First(&holder).Error; errors.Is(findErr, gorm.ErrRecordNotFound) {
+			// released between our insert and the lookup, try again right away
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s since %s, run `migrate unlock` if it is stale",
+				ErrLockHeld, holder.LockedBy, holder.LockedAt.Format(time.RFC3339))
+		}
+		log.Info().Str("holder", holder.LockedBy).Msg("Waiting for the migration lock")
+		time.Sleep(time.Second)
+	}
+}
+
+func (r *Runner) unlock() {
+	if err := r.db.Where("id = ? AND locked_by = ?", 1, r.owner).Delete(&SchemaMigrationLock{}).Error; err != nil {
+		log.Err(err).Msg("Issue while releasing the migration lock")
+	}
+}
+
+// ForceUnlock removes the lock whoever holds it, for when a migrating process died.
+func (r *Runner) ForceUnlock() error {
+	if err := r.ensureTables(); err != nil {
+		return err
+	}
+	return r.db.Where("id = ?", 1).Delete(&SchemaMigrationLock{}).Error
+}
+
+// verify refuses to go on when an applied migration no longer matches its checksum.
+func (r *Runner) verify(applied map[int]SchemaMigration) error {
+	for _, m := range r.migrations {
+		row, ok := applied[m.Version]
+		if ok && row.Checksum != m.Checksum() {
+			return fmt.Errorf("%w: %04d_%s", ErrChecksumMismatch, m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies pending migrations up to and including target, every pending one when target is 0.
+func (r *Runner) Up(target int) ([]int, error) {
+	if err := r.ensureTables(); err != nil {
+		return nil, err
+	}
+	if err := r.lock(); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for _, m := range r.migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		started := time.Now()
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Version:     m.Version,
+				Name:        m.Name,
+				Checksum:    m.Checksum(),
+				AppliedAt:   time.Now(),
+				ExecutionMs: time.Since(started).Milliseconds(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Dur("took", time.Since(started)).Msg("Applied migration")
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// Down rolls back the latest steps applied migrations.
+func (r *Runner) Down(steps int) ([]int, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps must be greater than 0")
+	}
+	if err := r.ensureTables(); err != nil {
+		return nil, err
+	}
+	if err := r.lock(); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for i := len(r.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := r.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("migration %04d_%s can not be rolled back", m.Version, m.Name)
+		}
+
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", m.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Rolled back migration")
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+func (r *Runner) Status() ([]MigrationStatus, error) {
+	if err := r.ensureTables(); err != nil {
+		return nil, err
+	}
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.Modified = row.Checksum != m.Checksum()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Version is the highest applied migration, 0 on an empty database.
+func (r *Runner) Version() (int, error) {
+	if !r.db.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var version int
+	if err := r.db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Latest is the version the code expects the database to be at.
+func Latest() int {
+	all := All()
+	if len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+// Pending reports how many registered migrations have not been applied yet.
+func (r *Runner) Pending() (int, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,128 @@
+package migrations
+
+import (
+	"github.com/rs/zerolog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// openDB is a new empty SQLite database for one test.
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=1"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+// migrateTo applies the migrations up to and including version, every one when version is 0.
+func migrateTo(t *testing.T, db *gorm.DB, version int) {
+	t.Helper()
+	if _, err := NewRunner(db).Up(version); err != nil {
+		t.Fatalf("migrate to %d: %v", version, err)
+	}
+}
+
+func exec(t *testing.T, db *gorm.DB, sql string, args ...any) {
+	t.Helper()
+	if err := db.Exec(sql, args...).Error; err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+func TestVariantPriceBecomesAdjustment(t *testing.T) {
+	db := openDB(t)
+	migrateTo(t, db, 8)
+
+	// a database from before the migrations still has the full variant price
+	exec(t, db, "ALTER TABLE product_variants ADD COLUMN price integer")
+	exec(t, db, "INSERT INTO categories (id, name, slug) VALUES ('c1', 'Lamps', 'lamps')")
+	exec(t, db, "INSERT INTO products (id, name, price, stock, category_id, sku) VALUES ('p1', 'Lamp', 1000, 5, 'c1', 'LAMP')")
+	for _, variant := range []struct {
+		id    string
+		price int
+	}{{"v-large", 1300}, {"v-small", 800}, {"v-plain", 0}} {
+		exec(t, db, "INSERT INTO product_variants (id, product_id, variant_name, variant_value, price, sku) VALUES (?, 'p1', 'Size', ?, ?, ?)",
+			variant.id, variant.id, variant.price, variant.id)
+	}
+
+	migrateTo(t, db, 0)
+
+	var adjustments []struct {
+		Id              string
+		PriceAdjustment int
+	}
+	if err := db.Table("product_variants").Order("id").Find(&adjustments).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"v-large": 300, "v-plain": 0, "v-small": -200}
+	for _, variant := range adjustments {
+		if variant.PriceAdjustment != want[variant.Id] {
+			t.Fatalf("%s adjustment = %d, want %d", variant.Id, variant.PriceAdjustment, want[variant.Id])
+		}
+	}
+	if db.Migrator().HasColumn("product_variants", "price") {
+		t.Fatal("the old price column is still there")
+	}
+}
+
+func TestLegacyShippingOrdersBecomeShipped(t *testing.T) {
+	db := openDB(t)
+	migrateTo(t, db, 9)
+
+	// orders only, the users and addresses they point at do not matter here
+	exec(t, db, "PRAGMA foreign_keys = OFF")
+	for _, order := range []struct{ id, status string }{{"o-shipping", "shipping"}, {"o-moved-on", "shipping"}, {"o-pending", "pending"}} {
+		exec(t, db, "INSERT INTO orders (id, user_id, shipping_address_id, status) VALUES (?, 'u1', 'a1', ?)", order.id, order.status)
+	}
+	exec(t, db, "PRAGMA foreign_keys = ON")
+
+	statuses := func() map[string]string {
+		t.Helper()
+		var rows []orderStatusV10
+		if err := db.Find(&rows).Error; err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]string{}
+		for _, row := range rows {
+			out[row.Id] = row.Status
+		}
+		return out
+	}
+
+	migrateTo(t, db, 10)
+	if got := statuses(); got["o-shipping"] != "shipped" || got["o-moved-on"] != "shipped" || got["o-pending"] != "pending" {
+		t.Fatalf("after up %v", got)
+	}
+	var history []orderStatusHistoryV10
+	if err := db.Where("order_id = ?", "o-shipping").Find(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].FromStatus != "shipping" || history[0].ToStatus != "shipped" {
+		t.Fatalf("history %+v", history)
+	}
+
+	exec(t, db, "UPDATE orders SET status = 'delivered' WHERE id = 'o-moved-on'")
+	if _, err := NewRunner(db).Down(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(); got["o-shipping"] != "shipping" || got["o-moved-on"] != "delivered" || got["o-pending"] != "pending" {
+		t.Fatalf("after down %v", got)
+	}
+}
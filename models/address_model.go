@@ -1,9 +1,10 @@
 package models
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
 )
 
@@ -18,7 +19,7 @@ type Address struct {
 }
 
 /*
-Create(address *Address) (*Address, error)
+Create(ctx context.Context, address *Address) (*Address, error)
 
 GetByID(id string) (*Address, error)
 
@@ -40,40 +41,40 @@ func (a *Address) BeforeCreate(t *gorm.DB) error {
 //	return nil
 //}
 
-func (a *Address) Create() (*Address, error) {
-	if err := database.DB.Create(a).Error; err != nil {
-		log.Err(err).Msg("Issue persist in Create")
+func (a *Address) Create(ctx context.Context) (*Address, error) {
+	if err := database.DB.WithContext(ctx).Create(a).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in Create")
 		return nil, err
 	}
 	return a, nil
 }
 
-func GetAddressById(id string) (*Address, error) {
+func GetAddressById(ctx context.Context, id string) (*Address, error) {
 	var address Address
-	if err := database.DB.Where(&Address{Id: id}).First(&address).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetAddressById")
+	if err := database.DB.WithContext(ctx).Where(&Address{Id: id}).First(&address).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetAddressById")
 		return nil, err
 	}
 	return &address, nil
 }
 
-func GetAddressByUserId(userId string) ([]*Address, error) {
+func GetAddressByUserId(ctx context.Context, userId string) ([]*Address, error) {
 	var address []*Address
-	if err := database.DB.Where(&Address{UserId: userId}).Find(&address).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetAddressByUserId")
+	if err := database.DB.WithContext(ctx).Where(&Address{UserId: userId}).Find(&address).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetAddressByUserId")
 		return address, err
 	}
 	return address, nil
 }
 
-func UpdateAddress(address *Address) (*Address, error) {
-	if err := database.DB.Save(address).Error; err != nil {
-		log.Err(err).Msg("Issue persist in Update")
+func UpdateAddress(ctx context.Context, address *Address) (*Address, error) {
+	if err := database.DB.WithContext(ctx).Save(address).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in Update")
 		return nil, err
 	}
 	return address, nil
 }
 
-func DeleteAddress(id string) error {
-	return database.DB.Where(&Address{Id: id}).Delete(&Address{}).Error
+func DeleteAddress(ctx context.Context, id string) error {
+	return database.DB.WithContext(ctx).Where(&Address{Id: id}).Delete(&Address{}).Error
 }
@@ -1,32 +1,36 @@
 package models
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/metrics"
 	"gorm.io/gorm"
 	"time"
 )
 
 type CartItem struct {
-	Id            string    `gorm:"primaryKey;type:varchar(191)" json:"id"`
-	CartId        string    `gorm:"not null" json:"cartId"`
-	ProductId     string    `gorm:"not null" json:"productId"`
-	Product       Product   `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
-	Quantity      int       `gorm:"default:0"  json:"quantity"`
-	PriceAtAdding int       `gorm:"default:0" json:"priceAtAdding"`
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	Id            string          `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	CartId        string          `gorm:"not null" json:"cartId"`
+	ProductId     string          `gorm:"not null" json:"productId"`
+	Product       Product         `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
+	VariantId     *string         `gorm:"type:varchar(191)" json:"variantId"`
+	Variant       *ProductVariant `gorm:"foreignKey:VariantId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"variant,omitempty"`
+	Quantity      int             `gorm:"default:0"  json:"quantity"`
+	PriceAtAdding int             `gorm:"default:0" json:"priceAtAdding"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
 }
 
 /*
-AddItem(cartItem *CartItem) (*CartItem, error) (Handles both new additions and quantity increments)
+AddItem(ctx context.Context, cartItem *CartItem) (*CartItem, error) (Handles both new additions and quantity increments)
 
-GetItemByCartAndProduct(cartID, productID string) (*CartItem, error)
+GetItemByCartAndProduct(ctx context.Context, cartID, productID string, variantId *string) (*CartItem, error)
 
-UpdateItemQuantity(cartItemID string, newQuantity int) (*CartItem, error)
+UpdateItemQuantity(ctx context.Context, cartItemID string, newQuantity int) (*CartItem, error)
 
-RemoveItem(cartItemID string) error
+RemoveItem(ctx context.Context, cartItemID string) error
 
 GetItemsByCartID(cartID string) ([]*CartItem, error)
 
@@ -39,64 +43,83 @@ func (ct *CartItem) BeforeCreate(t *gorm.DB) error {
 	return nil
 }
 
-func AddItem(item *CartItem) (*CartItem, error) {
-	if err := database.DB.Create(item).Error; err != nil {
-		log.Err(err).Msg("Issue persist in AddItem")
+func AddItem(ctx context.Context, item *CartItem) (*CartItem, error) {
+	if err := database.DB.WithContext(ctx).Create(item).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in AddItem")
 		return nil, err
 	}
+	metrics.CartItemsAdded.Add(float64(item.Quantity))
 	return item, nil
 }
 
-func GetItemByCartAndProduct(cartID, productId string) (*CartItem, error) {
+// GetItemByCartAndProduct finds the cart line for the product, the same product in another variant is a different line.
+func GetItemByCartAndProduct(ctx context.Context, cartID, productId string, variantId *string) (*CartItem, error) {
 	var cartItem CartItem
-	if err := database.DB.Preload("Product").Where(&CartItem{CartId: cartID, ProductId: productId}).First(&cartItem).Error; err != nil {
+	query := database.DB.WithContext(ctx).Preload("Product").Preload("Variant").Where(&CartItem{CartId: cartID, ProductId: productId})
+	if variantId != nil {
+		query = query.Where("variant_id = ?", *variantId)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+	if err := query.First(&cartItem).Error; err != nil {
 
-		log.Err(err).Msg("Issue persist in GetItemByCartAndProduct")
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetItemByCartAndProduct")
 		return nil, err
 	}
 	return &cartItem, nil
 }
 
-func UpdateItemQuantity(cartItemId string, newQuantity int) (*CartItem, error) {
+func UpdateItemQuantity(ctx context.Context, cartItemId string, newQuantity int) (*CartItem, error) {
 	var cartItem CartItem
-	if err := database.DB.Model(&CartItem{}).Where(&CartItem{Id: cartItemId}).Update("quantity", newQuantity).Error; err != nil {
+	if err := database.DB.WithContext(ctx).Model(&CartItem{}).Where(&CartItem{Id: cartItemId}).Update("quantity", newQuantity).Error; err != nil {
 
-		log.Err(err).Msg("Issue persist in UpdateItemQuantity")
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in UpdateItemQuantity")
 		return nil, err
 	}
-	if err := database.DB.Where(&CartItem{Id: cartItemId}).First(&cartItem).Error; err != nil {
-		log.Err(err).Msg("Issue persist in UpdateItemQuantity Part 2")
+	if err := database.DB.WithContext(ctx).Where(&CartItem{Id: cartItemId}).First(&cartItem).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in UpdateItemQuantity Part 2")
 		return nil, err
 	}
 	return &cartItem, nil
 }
 
-func RemoveItem(cartItemId string) error {
-	return database.DB.Where(&CartItem{Id: cartItemId}).Delete(&CartItem{}).Error
+func RemoveItem(ctx context.Context, cartItemId string) error {
+	return database.DB.WithContext(ctx).Where(&CartItem{Id: cartItemId}).Delete(&CartItem{}).Error
 }
 
-func IncrementItemQuantity(cartItemId string, quantityToAdd int) (*CartItem, error) {
+func IncrementItemQuantity(ctx context.Context, cartItemId string, quantityToAdd int) (*CartItem, error) {
 	var cartItem CartItem
-	if err := database.DB.Model(&cartItem).Where("id = ?", cartItemId).Update("quantity", gorm.Expr("quantity + ?", quantityToAdd)).Error; err != nil {
-		log.Err(err).Msg("Issue persist in IncrementItemQuantity")
+	if err := database.DB.WithContext(ctx).Model(&cartItem).Where("id = ?", cartItemId).Update("quantity", gorm.Expr("quantity + ?", quantityToAdd)).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in IncrementItemQuantity")
 		return nil, err
 	}
-	if err := database.DB.Where(&CartItem{Id: cartItemId}).First(&cartItem).Error; err != nil {
-		log.Err(err).Msg("Issue persist in IncrementItemQuantity Part 2")
+	if quantityToAdd > 0 {
+		metrics.CartItemsAdded.Add(float64(quantityToAdd))
+	}
+	if err := database.DB.WithContext(ctx).Where(&CartItem{Id: cartItemId}).First(&cartItem).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in IncrementItemQuantity Part 2")
 		return nil, err
 	}
 	return &cartItem, nil
 }
 
-func GetItemsByCartId(cartId string) ([]*CartItem, error) {
+func GetItemsByCartId(ctx context.Context, cartId string) ([]*CartItem, error) {
 	var cartItem []*CartItem
-	if err := database.DB.Preload("Product").Where(&CartItem{CartId: cartId}).Find(&cartItem).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetItemsByCartIt")
+	if err := database.DB.WithContext(ctx).Preload("Product").Preload("Variant").Where(&CartItem{CartId: cartId}).Find(&cartItem).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetItemsByCartIt")
 		return nil, err
 	}
 	return cartItem, nil
 }
 
-func DeleteAllItemsByCartId(cartId string) error {
-	return database.DB.Where(&CartItem{CartId: cartId}).Delete(&CartItem{}).Error
+func DeleteAllItemsByCartId(ctx context.Context, cartId string) error {
+	return database.DB.WithContext(ctx).Where(&CartItem{CartId: cartId}).Delete(&CartItem{}).Error
+}
+
+// AvailableStock is the stock the line can draw from: the variant's when one is selected, otherwise the product's.
+func (ct *CartItem) AvailableStock() int {
+	if ct.Variant != nil {
+		return ct.Variant.Stock
+	}
+	return ct.Product.Stock
 }
@@ -1,18 +1,25 @@
 package models
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/metrics"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
 type Cart struct {
 	Id        string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
-	UserId    string     `gorm:"not null" json:"userId"`
-	User      User       `gorm:"foreignKey:UserId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
+	UserId    *string    `gorm:"type:varchar(100);index" json:"userId"` // nil for guest carts
+	User      *User      `gorm:"foreignKey:UserId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user,omitempty"`
 	CartItems []CartItem `gorm:"foreignKey:CartId" json:"cartItems"`
+	CouponId  *string    `gorm:"type:varchar(191)" json:"couponId"`
+	Coupon    *Coupon    `gorm:"foreignKey:CouponId;constraint:onUpdate:CASCADE,onDelete:SET NULL" json:"coupon,omitempty"`
 	CreatedAt time.Time  `json:"createdAt"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 }
@@ -21,11 +28,15 @@ type Cart struct {
 
 GetByUserID(userID string) (*Cart, error)
 
-Create(cart *Cart) (*Cart, error)
+Create(ctx context.Context, cart *Cart) (*Cart, error)
 
 Delete(cartID string) error
 
 Save(cart *Cart) (*Cart, error)
+
+SetCartCoupon(ctx context.Context, cartId string, couponId *string) error
+
+MergeGuestCart(ctx context.Context, guestCartId, userId string) (*Cart, error)
 */
 
 func (c *Cart) BeforeCreate(t *gorm.DB) error {
@@ -33,40 +44,171 @@ func (c *Cart) BeforeCreate(t *gorm.DB) error {
 	return nil
 }
 
-func Create(cart *Cart) (*Cart, error) {
-	if err := database.DB.Create(cart).Error; err != nil {
-		log.Err(err).Msg("issue exist in Create in Cart")
+func Create(ctx context.Context, cart *Cart) (*Cart, error) {
+	if err := database.DB.WithContext(ctx).Create(cart).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("issue exist in Create in Cart")
 		return nil, err
 	}
 	return cart, nil
 }
 
-func GetCartByUserId(userId string) (*Cart, error) {
+func GetCartByUserId(ctx context.Context, userId string) (*Cart, error) {
 	var cart Cart
-	if err := database.DB.Preload("CartItems.Product").Where(&Cart{UserId: userId}).First(&cart).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetCartByUserId")
+	if err := database.DB.WithContext(ctx).Preload("CartItems.Product").Preload("CartItems.Variant").Preload("Coupon.Categories").Preload("Coupon.Products").Where("user_id = ?", userId).First(&cart).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetCartByUserId")
 		return nil, err
 	}
 	return &cart, nil
 }
 
-func GetCartById(cartId string) (*Cart, error) {
+func GetCartById(ctx context.Context, cartId string) (*Cart, error) {
 	var cart Cart
-	if err := database.DB.Preload("CartItems.Product").Where(&Cart{Id: cartId}).First(&cart).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetCartById")
+	if err := database.DB.WithContext(ctx).Preload("CartItems.Product").Preload("CartItems.Variant").Preload("Coupon.Categories").Preload("Coupon.Products").Where(&Cart{Id: cartId}).First(&cart).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in GetCartById")
 		return nil, err
 	}
 	return &cart, nil
 }
 
-func DeleteCart(cartId string) error {
-	return database.DB.Where(&Cart{Id: cartId}).Delete(&Cart{}).Error
+func DeleteCart(ctx context.Context, cartId string) error {
+	return database.DB.WithContext(ctx).Where(&Cart{Id: cartId}).Delete(&Cart{}).Error
 }
 
-func UpdateCart(cart *Cart) (*Cart, error) {
-	if err := database.DB.Save(cart).Error; err != nil {
-		log.Err(err).Msg("issue exist in Create in Cart")
+func UpdateCart(ctx context.Context, cart *Cart) (*Cart, error) {
+	if err := database.DB.WithContext(ctx).Save(cart).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("issue exist in Create in Cart")
 		return nil, err
 	}
 	return cart, nil
 }
+
+func SetCartCoupon(ctx context.Context, cartId string, couponId *string) error {
+	if err := database.DB.WithContext(ctx).Model(&Cart{}).Where("id = ?", cartId).Update("coupon_id", couponId).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in SetCartCoupon")
+		return err
+	}
+	return nil
+}
+
+func (c *Cart) IsGuest() bool {
+	return c.UserId == nil
+}
+
+// OwnerId returns the id of the user owning the cart, or an empty string for guest carts.
+func (c *Cart) OwnerId() string {
+	if c.UserId == nil {
+		return ""
+	}
+	return *c.UserId
+}
+
+func (c *Cart) CalculateSubTotal() int {
+	total := 0
+	for _, item := range c.CartItems {
+		total += item.PriceAtAdding * item.Quantity
+	}
+	return total
+}
+
+// CalculateDiscount validates the applied coupon against the current cart and returns the discount.
+// A cart without a coupon has no discount.
+func (c *Cart) CalculateDiscount(ctx context.Context) (int, error) {
+	if c.Coupon == nil {
+		return 0, nil
+	}
+	if err := c.Coupon.Validate(ctx, c.OwnerId(), c.CalculateSubTotal()); err != nil {
+		return 0, err
+	}
+	return c.Coupon.CalculateDiscount(c.CartItems), nil
+}
+
+// MergeGuestCart moves the items of a guest cart into the user's cart and deletes the guest cart.
+// Quantities of products already in the user cart are summed, and every line is capped at the available stock.
+// The merge is one transaction, a failure part way leaves both carts as they were.
+func MergeGuestCart(ctx context.Context, guestCartId, userId string) (*Cart, error) {
+	guestCart, err := GetCartById(ctx, guestCartId)
+	if err != nil {
+		return nil, err
+	}
+	if !guestCart.IsGuest() {
+		return nil, fmt.Errorf("cart %s is not a guest cart", guestCartId)
+	}
+
+	added := 0
+	err = database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// deleting the guest cart first claims it, a merge running at the same time finds nothing left
+		if err := tx.Where(&CartItem{CartId: guestCart.Id}).Delete(&CartItem{}).Error; err != nil {
+			return err
+		}
+		deleted := tx.Where(&Cart{Id: guestCart.Id}).Delete(&Cart{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var userCart Cart
+		err := tx.Where("user_id = ?", userId).First(&userCart).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			userCart = Cart{UserId: &userId}
+			err = tx.Create(&userCart).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, item := range guestCart.CartItems {
+			available := item.AvailableStock()
+
+			var existingItem CartItem
+			query := tx.Where(&CartItem{CartId: userCart.Id, ProductId: item.ProductId})
+			if item.VariantId != nil {
+				query = query.Where("variant_id = ?", *item.VariantId)
+			} else {
+				query = query.Where("variant_id IS NULL")
+			}
+			err := query.First(&existingItem).Error
+			if err == nil {
+				toAdd := min(item.Quantity, available-existingItem.Quantity)
+				if toAdd <= 0 {
+					continue
+				}
+				if err := tx.Model(&CartItem{}).Where("id = ?", existingItem.Id).Update("quantity", gorm.Expr("quantity + ?", toAdd)).Error; err != nil {
+					return err
+				}
+				added += toAdd
+				continue
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			quantity := min(item.Quantity, available)
+			if quantity <= 0 {
+				continue
+			}
+			newItem := CartItem{
+				CartId:        userCart.Id,
+				ProductId:     item.ProductId,
+				VariantId:     item.VariantId,
+				Quantity:      quantity,
+				PriceAtAdding: item.PriceAtAdding,
+			}
+			if err := tx.Omit(clause.Associations).Create(&newItem).Error; err != nil {
+				return err
+			}
+			added += quantity
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in MergeGuestCart")
+		return nil, err
+	}
+	if added > 0 {
+		metrics.CartItemsAdded.Add(float64(added))
+	}
+
+	return GetCartByUserId(ctx, userId)
+}
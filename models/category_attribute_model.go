@@ -0,0 +1,332 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attribute types.
+const (
+	AttributeEnum    = "enum"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+	AttributeText    = "text"
+)
+
+// Where an attribute is set: once on the product, or on each variant (size, colour).
+const (
+	AttributeLevelProduct = "product"
+	AttributeLevelVariant = "variant"
+)
+
+// maxAttributeText is the longest value a text attribute takes, the value column is a varchar(255).
+const maxAttributeText = 255
+
+// CategoryAttribute is one entry of a category's specification schema. Products of the category
+// and of its subcategories carry a value for it. A key can not be reused below the category that
+// defines it, but when a parent later adds a key a subcategory already had, the subcategory's
+// attribute keeps winning for its products.
+type CategoryAttribute struct {
+	Id         string `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	CategoryId string `gorm:"not null;type:varchar(150);uniqueIndex:idx_category_attributes_key,priority:1" json:"categoryId"`
+	// Key is what clients filter by (attr.<key>) and send values under, it never changes
+	Key  string `gorm:"not null;type:varchar(64);uniqueIndex:idx_category_attributes_key,priority:2" json:"key"`
+	Name string `gorm:"not null" json:"name"`
+	Type string `gorm:"not null;type:varchar(20)" json:"type"`
+	// Unit is shown after number values, e.g. "cm" or "kg"
+	Unit string `gorm:"type:varchar(20)" json:"unit,omitempty"`
+	// Options are the values an enum takes
+	Options   []string  `gorm:"type:text;serializer:json" json:"options,omitempty"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+	Level     string    `gorm:"not null;type:varchar(20);default:product" json:"level"`
+	Required  bool      `gorm:"not null;default:false" json:"required"`
+	SortOrder int       `gorm:"not null;default:0" json:"sortOrder"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+var (
+	ErrAttributeKeyTaken    = errors.New("attribute key is already used in this category or above it")
+	ErrAttributeOptionInUse = errors.New("a removed option is still used by products")
+	ErrAttributeNoOptions   = errors.New("an enum attribute needs at least one option")
+)
+
+func (a *CategoryAttribute) BeforeCreate(tx *gorm.DB) error {
+	a.Id = uuid.New().String()
+	return nil
+}
+
+/*
+CreateCategoryAttribute(ctx context.Context) (*CategoryAttribute, error)
+
+GetCategoryAttributeById(ctx context.Context, id string) (*CategoryAttribute, error)
+
+GetCategorySchema(ctx context.Context, categoryId string) ([]CategoryAttribute, error)
+
+UpdateCategoryAttribute(ctx context.Context, attribute *CategoryAttribute) (*CategoryAttribute, error)
+
+DeleteCategoryAttribute(ctx context.Context, id string) error
+
+AttributeKeys(ctx context.Context, level string) ([]string, error)
+*/
+
+// CreateCategoryAttribute stores a, its key may not already be used by the category or its ancestors.
+func (a *CategoryAttribute) CreateCategoryAttribute(ctx context.Context) (*CategoryAttribute, error) {
+	path, err := GetCategoryBreadcrumbs(ctx, a.CategoryId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(path))
+	for _, category := range path {
+		ids = append(ids, category.Id)
+	}
+
+	var count int64
+	if err := database.DB.WithContext(ctx).Model(&CategoryAttribute{}).Where("category_id IN ?", ids).Where(&CategoryAttribute{Key: a.Key}).Count(&count).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateCategoryAttribute")
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAttributeKeyTaken
+	}
+
+	if err := database.DB.WithContext(ctx).Create(a).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateCategoryAttribute")
+		return nil, err
+	}
+	return a, nil
+}
+
+func GetCategoryAttributeById(ctx context.Context, id string) (*CategoryAttribute, error) {
+	var attribute CategoryAttribute
+	if err := database.DB.WithContext(ctx).Where("id = ?", id).First(&attribute).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCategoryAttributeById")
+		return nil, err
+	}
+	return &attribute, nil
+}
+
+// GetCategorySchema is every attribute a product of the category carries: its own and those of
+// its ancestors, the root's first. An attribute redefined lower down replaces the inherited one.
+func GetCategorySchema(ctx context.Context, categoryId string) ([]CategoryAttribute, error) {
+	path, err := GetCategoryBreadcrumbs(ctx, categoryId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(path))
+	depth := map[string]int{}
+	for i, category := range path {
+		ids = append(ids, category.Id)
+		depth[category.Id] = i
+	}
+
+	var attributes []CategoryAttribute
+	if err := database.DB.WithContext(ctx).Where("category_id IN ?", ids).Order("sort_order, name").Find(&attributes).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCategorySchema")
+		return nil, err
+	}
+
+	byKey := map[string]int{}
+	schema := make([]CategoryAttribute, 0, len(attributes))
+	for level := range path {
+		for _, attribute := range attributes {
+			if depth[attribute.CategoryId] != level {
+				continue
+			}
+			if i, ok := byKey[attribute.Key]; ok {
+				schema[i] = attribute
+				continue
+			}
+			byKey[attribute.Key] = len(schema)
+			schema = append(schema, attribute)
+		}
+	}
+	return schema, nil
+}
+
+// UpdateCategoryAttribute saves the editable fields of attribute. Key, Type and Level stay as
+// they were created, the stored values depend on them. Dropping an enum option that products
+// still use is ErrAttributeOptionInUse, dropping all of them is ErrAttributeNoOptions.
+func UpdateCategoryAttribute(ctx context.Context, attribute *CategoryAttribute) (*CategoryAttribute, error) {
+	// gorm turns NOT IN with an empty list into NOT IN (NULL), which matches no row and would pass the in-use check
+	if attribute.Type == AttributeEnum && len(attribute.Options) == 0 {
+		return nil, ErrAttributeNoOptions
+	}
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if attribute.Type == AttributeEnum {
+			var inUse int64
+			if err := tx.Model(&ProductAttributeValue{}).
+				Where("attribute_id = ? AND value NOT IN ?", attribute.Id, attribute.Options).
+				Count(&inUse).Error; err != nil {
+				return err
+			}
+			if inUse > 0 {
+				return ErrAttributeOptionInUse
+			}
+		}
+		return tx.Model(attribute).
+			Select("name", "unit", "options", "min", "max", "required", "sort_order").
+			Updates(attribute).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrAttributeOptionInUse) {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateCategoryAttribute")
+		}
+		return nil, err
+	}
+	return attribute, nil
+}
+
+// DeleteCategoryAttribute removes the attribute and every value products and variants had for it.
+func DeleteCategoryAttribute(ctx context.Context, id string) error {
+	var productIds []string
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ProductAttributeValue{}).
+			Select("DISTINCT COALESCE(product_attribute_values.product_id, product_variants.product_id)").
+			Joins("LEFT JOIN product_variants ON product_variants.id = product_attribute_values.variant_id").
+			Where("attribute_id = ?", id).
+			Pluck("product_id", &productIds).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("attribute_id = ?", id).Delete(&ProductAttributeValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&CategoryAttribute{}).Error
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in DeleteCategoryAttribute")
+		return err
+	}
+	RefreshProductSearch(ctx, productIds...)
+	return nil
+}
+
+// AttributeKeys is every attribute key of level used by any category, sorted.
+func AttributeKeys(ctx context.Context, level string) ([]string, error) {
+	var keys []string
+	// Pluck quotes the column, key is a reserved word in mysql
+	if err := database.DB.WithContext(ctx).Model(&CategoryAttribute{}).Where(&CategoryAttribute{Level: level}).
+		Distinct().Pluck("key", &keys).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in AttributeKeys")
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Parse checks a value given for the attribute and returns it ready to store. raw is what JSON
+// decoding gives (string, float64, bool) or the text of a CSV cell: numbers and booleans are
+// also read from strings. The error message is meant for the client.
+func (a *CategoryAttribute) Parse(raw any) (ProductAttributeValue, error) {
+	value := ProductAttributeValue{AttributeId: a.Id, Attribute: a}
+	text, isText := raw.(string)
+	text = strings.TrimSpace(text)
+
+	switch a.Type {
+	case AttributeEnum:
+		if !isText {
+			return value, fmt.Errorf("must be one of: %s", strings.Join(a.Options, ", "))
+		}
+		for _, option := range a.Options {
+			if strings.EqualFold(option, text) {
+				value.Value = option
+				return value, nil
+			}
+		}
+		return value, fmt.Errorf("must be one of: %s", strings.Join(a.Options, ", "))
+
+	case AttributeNumber:
+		number, ok := raw.(float64)
+		if isText {
+			parsed, err := strconv.ParseFloat(text, 64)
+			number, ok = parsed, err == nil
+		}
+		if !ok || math.IsNaN(number) || math.IsInf(number, 0) {
+			return value, errors.New("must be a number")
+		}
+		if a.Min != nil && number < *a.Min {
+			return value, fmt.Errorf("must be at least %s", formatNumber(*a.Min))
+		}
+		if a.Max != nil && number > *a.Max {
+			return value, fmt.Errorf("must be at most %s", formatNumber(*a.Max))
+		}
+		value.Value, value.Number = formatNumber(number), &number
+		return value, nil
+
+	case AttributeBoolean:
+		flag, ok := raw.(bool)
+		if isText {
+			parsed, err := strconv.ParseBool(text)
+			flag, ok = parsed, err == nil
+		}
+		if !ok {
+			return value, errors.New("must be true or false")
+		}
+		value.Value = strconv.FormatBool(flag)
+		return value, nil
+	}
+
+	if !isText || text == "" {
+		return value, errors.New("must be text")
+	}
+	if len([]rune(text)) > maxAttributeText {
+		return value, fmt.Errorf("must have at most %d characters", maxAttributeText)
+	}
+	value.Value = text
+	return value, nil
+}
+
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+// AttributeValues checks the values given for one product or one variant against schema and
+// returns the ones to store. level says which of the schema's attributes apply; the problems
+// come back as a message per attribute key.
+func AttributeValues(schema []CategoryAttribute, level string, input map[string]any) ([]ProductAttributeValue, map[string]string) {
+	problems := map[string]string{}
+	byKey := make(map[string]*CategoryAttribute, len(schema))
+	for i := range schema {
+		byKey[schema[i].Key] = &schema[i]
+	}
+
+	values := make([]ProductAttributeValue, 0, len(input))
+	for key, raw := range input {
+		attribute, ok := byKey[key]
+		switch {
+		case !ok:
+			problems[key] = "is not an attribute of this category"
+		case attribute.Level != level && level == AttributeLevelProduct:
+			problems[key] = "is set on each variant, not on the product"
+		case attribute.Level != level:
+			problems[key] = "is set on the product, not on a variant"
+		case raw == nil:
+			// null leaves the attribute without a value
+		default:
+			value, err := attribute.Parse(raw)
+			if err != nil {
+				problems[key] = err.Error()
+				continue
+			}
+			values = append(values, value)
+		}
+	}
+
+	for _, attribute := range schema {
+		if attribute.Level == level && attribute.Required && input[attribute.Key] == nil && problems[attribute.Key] == "" {
+			problems[attribute.Key] = "is required"
+		}
+	}
+	return values, problems
+}
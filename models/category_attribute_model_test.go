@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"reflect"
+	"testing"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestCategoryAttributeParse(t *testing.T) {
+	colour := &CategoryAttribute{Key: "colour", Type: AttributeEnum, Options: []string{"Red", "Blue"}}
+	height := &CategoryAttribute{Key: "height", Type: AttributeNumber, Min: float(1), Max: float(200)}
+	washable := &CategoryAttribute{Key: "washable", Type: AttributeBoolean}
+	care := &CategoryAttribute{Key: "care", Type: AttributeText}
+
+	cases := []struct {
+		name      string
+		attribute *CategoryAttribute
+		raw       any
+		value     string
+		number    *float64
+		problem   string
+	}{
+		{"enum keeps the option's case", colour, " red ", "Red", nil, ""},
+		{"enum outside the options", colour, "Green", "", nil, "must be one of: Red, Blue"},
+		{"enum given a number", colour, 1.0, "", nil, "must be one of: Red, Blue"},
+		{"number from JSON", height, 42.5, "42.5", float(42.5), ""},
+		{"number from a CSV cell", height, " 10 ", "10", float(10), ""},
+		{"number below min", height, 0.5, "", nil, "must be at least 1"},
+		{"number above max", height, "250", "", nil, "must be at most 200"},
+		{"number that is not one", height, "tall", "", nil, "must be a number"},
+		{"boolean from JSON", washable, true, "true", nil, ""},
+		{"boolean from a CSV cell", washable, "FALSE", "false", nil, ""},
+		{"boolean that is not one", washable, "yes", "", nil, "must be true or false"},
+		{"text is trimmed", care, "  hand wash  ", "hand wash", nil, ""},
+		{"blank text", care, "   ", "", nil, "must be text"},
+		{"text given a number", care, 3.0, "", nil, "must be text"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			value, err := c.attribute.Parse(c.raw)
+			if c.problem != "" {
+				if err == nil || err.Error() != c.problem {
+					t.Fatalf("got %v, want %q", err, c.problem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.Value != c.value || !reflect.DeepEqual(value.Number, c.number) {
+				t.Fatalf("got %q (%v), want %q (%v)", value.Value, value.Number, c.value, c.number)
+			}
+		})
+	}
+}
+
+func TestAttributeValues(t *testing.T) {
+	schema := []CategoryAttribute{
+		{Key: "material", Type: AttributeEnum, Options: []string{"Cotton", "Linen"}, Level: AttributeLevelProduct, Required: true},
+		{Key: "weight", Type: AttributeNumber, Level: AttributeLevelProduct},
+		{Key: "size", Type: AttributeEnum, Options: []string{"S", "M"}, Level: AttributeLevelVariant, Required: true},
+	}
+	cases := []struct {
+		name     string
+		level    string
+		input    map[string]any
+		values   map[string]string
+		problems map[string]string
+	}{
+		{"product values", AttributeLevelProduct, map[string]any{"material": "linen", "weight": 1.5},
+			map[string]string{"material": "Linen", "weight": "1.5"}, map[string]string{}},
+		{"missing required", AttributeLevelProduct, map[string]any{"weight": 2.0},
+			map[string]string{"weight": "2"}, map[string]string{"material": "is required"}},
+		{"null counts as missing", AttributeLevelProduct, map[string]any{"material": nil},
+			map[string]string{}, map[string]string{"material": "is required"}},
+		{"null clears an optional value", AttributeLevelProduct, map[string]any{"material": "Cotton", "weight": nil},
+			map[string]string{"material": "Cotton"}, map[string]string{}},
+		{"variant attribute on the product", AttributeLevelProduct, map[string]any{"material": "Cotton", "size": "M"},
+			map[string]string{"material": "Cotton"}, map[string]string{"size": "is set on each variant, not on the product"}},
+		{"product attribute on a variant", AttributeLevelVariant, map[string]any{"size": "S", "material": "Cotton"},
+			map[string]string{"size": "S"}, map[string]string{"material": "is set on the product, not on a variant"}},
+		{"unknown key", AttributeLevelVariant, map[string]any{"size": "S", "fit": "slim"},
+			map[string]string{"size": "S"}, map[string]string{"fit": "is not an attribute of this category"}},
+		// a bad value is reported as it is, not as missing as well
+		{"bad required value", AttributeLevelVariant, map[string]any{"size": "XL"},
+			map[string]string{}, map[string]string{"size": "must be one of: S, M"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			values, problems := AttributeValues(schema, c.level, c.input)
+			got := map[string]string{}
+			for _, value := range values {
+				got[value.Attribute.Key] = value.Value
+			}
+			if !reflect.DeepEqual(got, c.values) {
+				t.Errorf("values %v, want %v", got, c.values)
+			}
+			if !reflect.DeepEqual(problems, c.problems) {
+				t.Errorf("problems %v, want %v", problems, c.problems)
+			}
+		})
+	}
+}
+
+// categoryChain creates a root category with a child and a grandchild below it.
+func categoryChain(t *testing.T) (root, child, grandchild Category) {
+	t.Helper()
+	suffix := uuid.New().String()[:8]
+	root = Category{Name: "Home " + suffix, Slug: "home-" + suffix}
+	mustCreate(t, &root)
+	child = Category{Name: "Textiles " + suffix, Slug: "textiles-" + suffix, ParentId: &root.Id}
+	mustCreate(t, &child)
+	grandchild = Category{Name: "Cushions " + suffix, Slug: "cushions-" + suffix, ParentId: &child.Id}
+	mustCreate(t, &grandchild)
+	return root, child, grandchild
+}
+
+func TestGetCategorySchemaOverrideOrder(t *testing.T) {
+	ctx := context.Background()
+	root, child, grandchild := categoryChain(t)
+	attributes := []CategoryAttribute{
+		{CategoryId: root.Id, Key: "material", Name: "Material", Type: AttributeText, Level: AttributeLevelProduct, SortOrder: 2},
+		{CategoryId: root.Id, Key: "brand", Name: "Brand", Type: AttributeText, Level: AttributeLevelProduct, SortOrder: 1},
+		{CategoryId: child.Id, Key: "washable", Name: "Washable", Type: AttributeBoolean, Level: AttributeLevelProduct},
+		{CategoryId: grandchild.Id, Key: "size", Name: "Size", Type: AttributeEnum, Options: []string{"S", "M"}, Level: AttributeLevelVariant},
+	}
+	for i := range attributes {
+		if _, err := attributes[i].CreateCategoryAttribute(ctx); err != nil {
+			t.Fatalf("create %s: %v", attributes[i].Key, err)
+		}
+	}
+	// the grandchild had material before the root added it, a key taken above can not be created
+	// below any more, so it is stored directly
+	override := CategoryAttribute{CategoryId: grandchild.Id, Key: "material", Name: "Cover", Type: AttributeEnum, Options: []string{"Velvet"}, Level: AttributeLevelProduct}
+	mustCreate(t, &override)
+	if _, err := (&CategoryAttribute{CategoryId: child.Id, Key: "brand", Name: "Label", Type: AttributeText, Level: AttributeLevelProduct}).CreateCategoryAttribute(ctx); !errors.Is(err, ErrAttributeKeyTaken) {
+		t.Fatalf("reusing a key of the root: got %v, want ErrAttributeKeyTaken", err)
+	}
+
+	cases := []struct {
+		categoryId string
+		want       []string
+	}{
+		{root.Id, []string{"brand:Brand", "material:Material"}},
+		{child.Id, []string{"brand:Brand", "material:Material", "washable:Washable"}},
+		// the override keeps the inherited position but is the grandchild's own attribute
+		{grandchild.Id, []string{"brand:Brand", "material:Cover", "washable:Washable", "size:Size"}},
+	}
+	for _, c := range cases {
+		schema, err := GetCategorySchema(ctx, c.categoryId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(schema))
+		for _, attribute := range schema {
+			got = append(got, attribute.Key+":"+attribute.Name)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("schema of %s: got %v, want %v", c.categoryId, got, c.want)
+		}
+	}
+}
+
+func TestUpdateCategoryAttributeOptions(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, 1)
+	colour := CategoryAttribute{CategoryId: f.product.CategoryId, Key: "colour", Name: "Colour", Type: AttributeEnum, Options: []string{"Red", "Blue"}, Level: AttributeLevelProduct}
+	if _, err := colour.CreateCategoryAttribute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceAttributeValues(ctx, &f.product.Id, nil, []ProductAttributeValue{{AttributeId: colour.Id, Value: "Red"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range [][]string{nil, {}} {
+		update := colour
+		update.Options = options
+		if _, err := UpdateCategoryAttribute(ctx, &update); !errors.Is(err, ErrAttributeNoOptions) {
+			t.Fatalf("options %v: got %v, want ErrAttributeNoOptions", options, err)
+		}
+	}
+	update := colour
+	update.Options = []string{"Blue"}
+	if _, err := UpdateCategoryAttribute(ctx, &update); !errors.Is(err, ErrAttributeOptionInUse) {
+		t.Fatalf("dropping a used option: got %v, want ErrAttributeOptionInUse", err)
+	}
+	update.Options = []string{"Red", "Green"}
+	if _, err := UpdateCategoryAttribute(ctx, &update); err != nil {
+		t.Fatalf("dropping an unused option: %v", err)
+	}
+
+	var stored CategoryAttribute
+	if err := database.DB.Where("id = ?", colour.Id).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.Options, []string{"Red", "Green"}) {
+		t.Fatalf("stored options %v", stored.Options)
+	}
+}
@@ -1,36 +1,60 @@
 package models
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type Category struct {
-	Id          string    `gorm:"primaryKey;type:varchar(150)" json:"id"`
-	Name        string    `gorm:"not null" json:"name"`
-	Description string    `json:"description"`
-	Products    []Product `gorm:"foreignKey:category_id" json:"products,omitempty"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Id          string  `gorm:"primaryKey;type:varchar(150)" json:"id"`
+	Name        string  `gorm:"not null" json:"name"`
+	Slug        string  `gorm:"type:varchar(191);uniqueIndex:idx_categories_slug" json:"slug"`
+	Description string  `json:"description"`
+	ParentId    *string `gorm:"type:varchar(150);index:idx_categories_parent" json:"parentId"`
+	// SortOrder orders siblings, lower first, ties by name
+	SortOrder int         `gorm:"not null;default:0" json:"sortOrder"`
+	Children  []*Category `gorm:"-" json:"children,omitempty"`
+	Products  []Product   `gorm:"foreignKey:category_id" json:"products,omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
+var (
+	ErrCategoryHasProducts = errors.New("category still has products")
+	ErrCategoryCycle       = errors.New("category can not be moved below itself")
+	ErrCategorySlugTaken   = errors.New("category slug is already taken")
+)
+
+// maxCategoryDepth stops a walk up the parents should the data ever hold a loop.
+const maxCategoryDepth = 32
+
 /*
-Create(category *Category) (*Category, error)
+Create(ctx context.Context, category *Category) (*Category, error)
 
 GetByID(id string) (*Category, error)
 
-GetByName(name string) (*Category, error)
+GetBySlug(slug string) (*Category, error)
+
+GetSiblingByName(parentId *string, name string) (*Category, error)
 
 Update(category *Category) (*Category, error)
 
-Delete(id string) error
+Delete(id, reassignTo string) error
+
+ListCategories(ctx context.Context, q *listquery.Query) (*listquery.Page[Category], error)
+
+Tree(ctx context.Context) ([]*Category, error)
 
-GetAll() ([]*Category, error)
+Breadcrumbs(ctx context.Context, id string) ([]Category, error)
 
 */
 
@@ -39,60 +63,336 @@ func (c *Category) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-func (c *Category) CreateCategory() (*Category, error) {
-	if err := database.DB.Create(c).Error; err != nil {
-		log.Err(err).Msg("Issue exist in CreateCategory")
+// CreateCategory stores c, making its slug from the name when it has none.
+func (c *Category) CreateCategory(ctx context.Context) (*Category, error) {
+	if err := c.assignSlug(ctx); err != nil {
+		return nil, err
+	}
+	if err := database.DB.WithContext(ctx).Create(c).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateCategory")
 		return nil, err
 	}
 	return c, nil
 }
 
-func GetCategoryById(id string) (*Category, error) {
+func GetCategoryById(ctx context.Context, id string) (*Category, error) {
+	var category Category
+	if err := database.DB.WithContext(ctx).Where(&Category{Id: id}).First(&category).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCategoryById")
+		return nil, err
+	}
+	return &category, nil
+}
+
+func GetCategoryBySlug(ctx context.Context, slug string) (*Category, error) {
 	var category Category
-	if err := database.DB.Where(&Category{Id: id}).First(&category).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetCategoryById")
+	if err := database.DB.WithContext(ctx).Where("slug = ?", slug).First(&category).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCategoryBySlug")
+		}
 		return nil, err
 	}
 	return &category, nil
 }
 
-func GetCategoryByName(name string) (*Category, error) {
+// GetSiblingCategoryByName finds the category called name under parentId, names only have to be
+// unique among siblings.
+func GetSiblingCategoryByName(ctx context.Context, parentId *string, name string) (*Category, error) {
 	var category Category
-	if err := database.DB.Where(&Category{Name: name}).First(&category).Error; err != nil {
+	query := database.DB.WithContext(ctx).Where("name = ?", name)
+	if parentId == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentId)
+	}
+	if err := query.First(&category).Error; err != nil {
 		// Only log if it's not the expected "record not found" error
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Err(err).Msg("Unexpected error in GetCategoryByName")
+			logging.Ctx(ctx).Err(err).Msg("Unexpected error in GetSiblingCategoryByName")
 		}
 		return nil, err
 	}
 	return &category, nil
 }
 
-func UpdateCategory(category *Category) (*Category, error) {
-	if err := database.DB.Updates(category).Error; err != nil {
-		log.Err(err).Msg("Issue exist in UpdateCategory")
+// UpdateCategory saves every editable field, so a category can be moved back to the root.
+// Moving it below itself or one of its descendants is ErrCategoryCycle.
+func UpdateCategory(ctx context.Context, category *Category) (*Category, error) {
+	if category.ParentId != nil {
+		parents, err := categoryParents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for id, depth := *category.ParentId, 0; depth < maxCategoryDepth; depth++ {
+			if id == category.Id {
+				return nil, ErrCategoryCycle
+			}
+			parent := parents[id]
+			if parent == nil {
+				break
+			}
+			id = *parent
+		}
+	}
+	if err := category.assignSlug(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.WithContext(ctx).Model(category).
+		Select("name", "slug", "description", "parent_id", "sort_order").
+		Updates(category).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateCategory")
 		return nil, err
 	}
 	return category, nil
 }
 
-func DeleteCategory(id string) error {
-	return database.DB.Where(&Category{Id: id}).Delete(&Category{}).Error
+// DeleteCategory removes the category and moves its children up to its parent. Its products, soft
+// deleted ones included, move to reassignTo; without one a category that still has products is
+// ErrCategoryHasProducts.
+func DeleteCategory(ctx context.Context, id, reassignTo string) error {
+	var moved []string
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var category Category
+		if err := tx.Where("id = ?", id).First(&category).Error; err != nil {
+			return err
+		}
+
+		products := tx.Unscoped().Model(&Product{}).Where("category_id = ?", id)
+		if err := products.Pluck("id", &moved).Error; err != nil {
+			return err
+		}
+		if len(moved) > 0 {
+			if reassignTo == "" {
+				return ErrCategoryHasProducts
+			}
+			if err := tx.Unscoped().Model(&Product{}).Where("category_id = ?", id).
+				Update("category_id", reassignTo).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&Category{}).Where("parent_id = ?", id).
+			Update("parent_id", category.ParentId).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&Category{}).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrCategoryHasProducts) {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in DeleteCategory")
+		}
+		return err
+	}
+	RefreshProductSearch(ctx, moved...)
+	return nil
+}
+
+var CategoryListSpec = listquery.Spec{
+	Filters: []listquery.Filter{
+		listquery.Equal("parentId", "parent_id", listquery.String),
+		listquery.Condition("root", "parent_id IS NULL"),
+		listquery.Min("createdFrom", "created_at", listquery.Time),
+		listquery.Max("createdTo", "created_at", listquery.Time),
+	},
+	Sorts: map[string]string{
+		"name":      "name",
+		"sortOrder": "sort_order",
+		"createdAt": "created_at",
+	},
+	DefaultSort:  "sortOrder,name",
+	DefaultLimit: 50,
 }
 
-func GetAll(limit, offSet int) (*[]Category, error) {
+func ListCategories(ctx context.Context, q *listquery.Query) (*listquery.Page[Category], error) {
+	page, err := listquery.Fetch[Category](database.DB.WithContext(ctx), q)
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ListCategories")
+		return nil, err
+	}
+	return page, nil
+}
+
+// CategoryHasProducts also counts soft deleted products, they keep the category from being deleted too.
+func CategoryHasProducts(ctx context.Context, categoryId string) (bool, error) {
+	var count int64
+	if err := database.DB.WithContext(ctx).Unscoped().Model(&Product{}).Where("category_id = ?", categoryId).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// allCategories loads every category in display order. Catalogues have few enough categories that
+// the tree is built in memory rather than with recursive queries.
+func allCategories(ctx context.Context) ([]Category, error) {
 	var categories []Category
-	if err := database.DB.Find(&categories).Limit(limit).Offset(offSet).Error; err != nil {
+	if err := database.DB.WithContext(ctx).Order("sort_order, name, id").Find(&categories).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in allCategories")
+		return nil, err
+	}
+	return categories, nil
+}
+
+func categoryParents(ctx context.Context) (map[string]*string, error) {
+	categories, err := allCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]*string, len(categories))
+	for _, category := range categories {
+		parents[category.Id] = category.ParentId
+	}
+	return parents, nil
+}
+
+// GetCategoryTree returns the root categories with their Children filled in all the way down.
+func GetCategoryTree(ctx context.Context) ([]*Category, error) {
+	categories, err := allCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]*Category, len(categories))
+	for i := range categories {
+		byId[categories[i].Id] = &categories[i]
+	}
+
+	roots := []*Category{}
+	for i := range categories {
+		category := &categories[i]
+		// a parent that is gone leaves its children at the root rather than hiding them
+		if parent := categoryParent(byId, category); parent != nil {
+			parent.Children = append(parent.Children, category)
+		} else {
+			roots = append(roots, category)
+		}
+	}
+	return roots, nil
+}
+
+func categoryParent(byId map[string]*Category, category *Category) *Category {
+	if category.ParentId == nil {
+		return nil
+	}
+	return byId[*category.ParentId]
+}
+
+// GetCategoryBreadcrumbs is the path from the root down to the category, the category itself last.
+func GetCategoryBreadcrumbs(ctx context.Context, id string) ([]Category, error) {
+	categories, err := allCategories(ctx)
+	if err != nil {
 		return nil, err
 	}
-	return &categories, nil
+	byId := make(map[string]*Category, len(categories))
+	for i := range categories {
+		byId[categories[i].Id] = &categories[i]
+	}
+
+	category, ok := byId[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var path []Category
+	for depth := 0; category != nil && depth < maxCategoryDepth; depth++ {
+		path = append(path, *category)
+		category = categoryParent(byId, category)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
 }
 
-func CategoryHasProducts(categoryId string) (bool, error) {
+// CategoryWithDescendants is the id of the category followed by the ids of every category below it,
+// what a listing of the category has to cover.
+func CategoryWithDescendants(ctx context.Context, id string) ([]string, error) {
+	categories, err := allCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children := map[string][]string{}
+	for _, category := range categories {
+		if category.ParentId != nil {
+			children[*category.ParentId] = append(children[*category.ParentId], category.Id)
+		}
+	}
+
+	ids := []string{id}
+	seen := map[string]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// assignSlug makes the slug from the name when there is none. A made up slug that is taken gets
+// the parent's slug in front and then a number, one that was asked for is ErrCategorySlugTaken.
+func (c *Category) assignSlug(ctx context.Context) error {
+	if c.Slug != "" {
+		taken, err := categorySlugTaken(ctx, c.Slug, c.Id)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrCategorySlugTaken
+		}
+		return nil
+	}
+
+	base := Slugify(c.Name)
+	if base == "" {
+		base = "category"
+	}
+	candidates := []string{base}
+	if c.ParentId != nil {
+		if parent, err := GetCategoryById(ctx, *c.ParentId); err == nil {
+			candidates = append(candidates, parent.Slug+"-"+base)
+		}
+	}
+	for n := 2; ; n++ {
+		for _, candidate := range candidates {
+			taken, err := categorySlugTaken(ctx, candidate, c.Id)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				c.Slug = candidate
+				return nil
+			}
+		}
+		candidates = []string{fmt.Sprintf("%s-%d", base, n)}
+	}
+}
+
+func categorySlugTaken(ctx context.Context, slug, exceptId string) (bool, error) {
 	var count int64
-	if err := database.DB.Model(&Product{}).Where("category_id = ?", categoryId).Count(&count).Error; err != nil {
+	query := database.DB.WithContext(ctx).Model(&Category{}).Where("slug = ?", slug)
+	if exceptId != "" {
+		query = query.Where("id <> ?", exceptId)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in categorySlugTaken")
 		return false, err
 	}
-	fmt.Println("The count is there and it is ", count)
 	return count > 0, nil
 }
+
+// Slugify turns a name into the lower case, dash separated form used in URLs: "Home & Decor" -> "home-decor".
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,320 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	CouponTypePercentage = "percentage"
+	CouponTypeFlat       = "flat"
+)
+
+type Coupon struct {
+	Id            string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	Code          string     `gorm:"unique;not null;type:varchar(64)" json:"code"`
+	Description   string     `json:"description"`
+	DiscountType  string     `gorm:"not null" json:"discountType"`
+	DiscountValue int        `gorm:"not null" json:"discountValue"`
+	MaxDiscount   int        `gorm:"default:0" json:"maxDiscount"`
+	MinCartValue  int        `gorm:"default:0" json:"minCartValue"`
+	UsageLimit    int        `gorm:"default:0" json:"usageLimit"`
+	PerUserLimit  int        `gorm:"default:0" json:"perUserLimit"`
+	UsedCount     int        `gorm:"default:0" json:"usedCount"`
+	ValidFrom     *time.Time `json:"validFrom"`
+	ValidUntil    *time.Time `json:"validUntil"`
+	IsActive      bool       `gorm:"default:true" json:"isActive"`
+	Categories    []Category `gorm:"many2many:coupon_categories" json:"categories,omitempty"`
+	Products      []Product  `gorm:"many2many:coupon_products" json:"products,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+type CouponUsage struct {
+	Id             string    `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	CouponId       string    `gorm:"not null;type:varchar(191);index" json:"couponId"`
+	UserId         string    `gorm:"not null;type:varchar(100);index" json:"userId"`
+	OrderId        string    `gorm:"not null;type:varchar(191)" json:"orderId"`
+	DiscountAmount int       `json:"discountAmount"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+/*
+Create(ctx context.Context, coupon *Coupon) (*Coupon, error)
+
+GetCouponById(ctx context.Context, id string) (*Coupon, error)
+
+GetCouponByCode(ctx context.Context, code string) (*Coupon, error)
+
+Validate(userId string, subTotal int) error
+
+CalculateDiscount(items []CartItem) int
+
+RedeemCoupon(tx *gorm.DB, couponId, userId, orderId string, discount int) error
+
+ReleaseCouponUsage(tx *gorm.DB, orderId string) error
+*/
+
+func (c *Coupon) BeforeCreate(t *gorm.DB) error {
+	c.Id = uuid.New().String()
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (c *Coupon) BeforeUpdate(t *gorm.DB) error {
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (cu *CouponUsage) BeforeCreate(t *gorm.DB) error {
+	cu.Id = uuid.New().String()
+	cu.CreatedAt = time.Now()
+	return nil
+}
+
+func (c *Coupon) CreateCoupon(ctx context.Context) (*Coupon, error) {
+	if err := c.ValidateCoupon(); err != nil {
+		return nil, err
+	}
+	if err := database.DB.WithContext(ctx).Create(c).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateCoupon")
+		return nil, err
+	}
+	return c, nil
+}
+
+// ValidateCoupon checks the coupon definition itself, not whether it can be used right now.
+func (c *Coupon) ValidateCoupon() error {
+	if strings.TrimSpace(c.Code) == "" {
+		return fmt.Errorf("coupon code is required")
+	}
+	switch c.DiscountType {
+	case CouponTypePercentage:
+		if c.DiscountValue <= 0 || c.DiscountValue > 100 {
+			return fmt.Errorf("percentage discount must be between 1 and 100")
+		}
+	case CouponTypeFlat:
+		if c.DiscountValue <= 0 {
+			return fmt.Errorf("flat discount must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("invalid discount type : %s", c.DiscountType)
+	}
+	if c.MaxDiscount < 0 || c.MinCartValue < 0 || c.UsageLimit < 0 || c.PerUserLimit < 0 {
+		return fmt.Errorf("coupon limits can not be negative")
+	}
+	if c.ValidFrom != nil && c.ValidUntil != nil && c.ValidUntil.Before(*c.ValidFrom) {
+		return fmt.Errorf("validUntil must be after validFrom")
+	}
+	return nil
+}
+
+func GetCouponById(ctx context.Context, id string) (*Coupon, error) {
+	var coupon Coupon
+	if err := database.DB.WithContext(ctx).Preload("Categories").Preload("Products").Where(&Coupon{Id: id}).First(&coupon).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCouponById")
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func GetCouponByCode(ctx context.Context, code string) (*Coupon, error) {
+	var coupon Coupon
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if err := database.DB.WithContext(ctx).Preload("Categories").Preload("Products").Where(&Coupon{Code: code}).First(&coupon).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetCouponByCode")
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func GetAllCoupons(ctx context.Context, limit, offset int) ([]Coupon, error) {
+	var coupons []Coupon
+	if err := database.DB.WithContext(ctx).Preload("Categories").Preload("Products").Order("created_at DESC").Limit(limit).Offset(offset).Find(&coupons).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetAllCoupons")
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// UpdateCoupon saves the scalar fields and replaces the category/product scope.
+func UpdateCoupon(ctx context.Context, c *Coupon) (*Coupon, error) {
+	if err := c.ValidateCoupon(); err != nil {
+		return nil, err
+	}
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Categories", "Products").Save(c).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(c).Association("Categories").Replace(c.Categories); err != nil {
+			return err
+		}
+		return tx.Model(c).Association("Products").Replace(c.Products)
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateCoupon")
+		return nil, err
+	}
+	return c, nil
+}
+
+func DeleteCoupon(ctx context.Context, id string) error {
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		coupon := Coupon{Id: id}
+		if err := tx.Model(&coupon).Association("Categories").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(&coupon).Association("Products").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(&Cart{}).Where("coupon_id = ?", id).Update("coupon_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Where(&Coupon{Id: id}).Delete(&Coupon{}).Error
+	})
+}
+
+func CountCouponUsageByUser(ctx context.Context, couponId, userId string) (int64, error) {
+	var count int64
+	if err := database.DB.WithContext(ctx).Model(&CouponUsage{}).Where("coupon_id = ? AND user_id = ?", couponId, userId).Count(&count).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CountCouponUsageByUser")
+		return 0, err
+	}
+	return count, nil
+}
+
+// Validate checks whether the coupon can be used right now by the user for a cart worth subTotal.
+func (c *Coupon) Validate(ctx context.Context, userId string, subTotal int) error {
+	now := time.Now()
+
+	if !c.IsActive {
+		return fmt.Errorf("coupon %s is not active", c.Code)
+	}
+	if c.ValidFrom != nil && now.Before(*c.ValidFrom) {
+		return fmt.Errorf("coupon %s is not valid yet", c.Code)
+	}
+	if c.ValidUntil != nil && now.After(*c.ValidUntil) {
+		return fmt.Errorf("coupon %s has expired", c.Code)
+	}
+	if c.UsageLimit > 0 && c.UsedCount >= c.UsageLimit {
+		return fmt.Errorf("coupon %s has reached its usage limit", c.Code)
+	}
+	if subTotal < c.MinCartValue {
+		return fmt.Errorf("cart value must be at least %d to use coupon %s", c.MinCartValue, c.Code)
+	}
+	if c.PerUserLimit > 0 {
+		used, err := CountCouponUsageByUser(ctx, c.Id, userId)
+		if err != nil {
+			return err
+		}
+		if used >= int64(c.PerUserLimit) {
+			return fmt.Errorf("you have already used coupon %s the maximum number of times", c.Code)
+		}
+	}
+	return nil
+}
+
+// AppliesTo reports whether the coupon scope includes the product; an unscoped coupon applies to everything.
+func (c *Coupon) AppliesTo(product Product) bool {
+	if len(c.Categories) == 0 && len(c.Products) == 0 {
+		return true
+	}
+	for _, p := range c.Products {
+		if p.Id == product.Id {
+			return true
+		}
+	}
+	for _, category := range c.Categories {
+		if category.Id == product.CategoryId {
+			return true
+		}
+	}
+	return false
+}
+
+// CalculateDiscount returns the discount for the eligible cart lines, never more than what they cost.
+func (c *Coupon) CalculateDiscount(items []CartItem) int {
+	eligible := 0
+	for _, item := range items {
+		if c.AppliesTo(item.Product) {
+			eligible += item.PriceAtAdding * item.Quantity
+		}
+	}
+	if eligible == 0 {
+		return 0
+	}
+
+	discount := 0
+	switch c.DiscountType {
+	case CouponTypePercentage:
+		discount = eligible * c.DiscountValue / 100
+		if c.MaxDiscount > 0 && discount > c.MaxDiscount {
+			discount = c.MaxDiscount
+		}
+	case CouponTypeFlat:
+		discount = c.DiscountValue
+	}
+	return min(discount, eligible)
+}
+
+// RedeemCoupon records one use of the coupon inside the order transaction.
+// The conditional update keeps the global usage limit safe against concurrent checkouts.
+func RedeemCoupon(tx *gorm.DB, couponId, userId, orderId string, discount int) error {
+	result := tx.Model(&Coupon{}).
+		Where("id = ? AND (usage_limit = 0 OR used_count < usage_limit)", couponId).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		logging.Ctx(tx.Statement.Context).Err(result.Error).Msg("Issue exist in RedeemCoupon")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("coupon has reached its usage limit")
+	}
+
+	usage := CouponUsage{
+		CouponId:       couponId,
+		UserId:         userId,
+		OrderId:        orderId,
+		DiscountAmount: discount,
+	}
+	if err := tx.Create(&usage).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in RedeemCoupon creating usage")
+		return err
+	}
+	return nil
+}
+
+// ReleaseCouponUsage gives back the coupon use of an order that is cancelled, inside the transaction
+// cancelling it, so the coupon's usage limit and the customer's allowance count it no more.
+func ReleaseCouponUsage(tx *gorm.DB, orderId string) error {
+	var usages []CouponUsage
+	if err := tx.Where("order_id = ?", orderId).Find(&usages).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in ReleaseCouponUsage")
+		return err
+	}
+	for _, usage := range usages {
+		// deleting the row first makes a second release of the same order a no-op
+		result := tx.Where("id = ?", usage.Id).Delete(&CouponUsage{})
+		if result.Error != nil {
+			logging.Ctx(tx.Statement.Context).Err(result.Error).Msg("Issue exist in ReleaseCouponUsage")
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		if err := tx.Model(&Coupon{}).Where("id = ? AND used_count > 0", usage.CouponId).
+			UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+			logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in ReleaseCouponUsage")
+			return err
+		}
+	}
+	return nil
+}
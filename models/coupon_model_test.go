@@ -0,0 +1,88 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// couponOrder places an order of one fixture product that redeems coupon.
+func (f *fixture) couponOrder(t *testing.T, coupon *Coupon) *Order {
+	t.Helper()
+	order := Order{
+		UserId:            f.user.Id,
+		ShippingAddressId: f.address.Id,
+		OrderItems:        []OrderItem{{ProductId: f.product.Id, Quantity: 1, PriceAtPurchase: f.product.Price}},
+		SubTotal:          f.product.Price,
+		DiscountAmount:    100,
+		TotalAmount:       f.product.Price - 100,
+		CouponId:          &coupon.Id,
+		PaymentMode:       "upi",
+	}
+	created, err := order.Create(f.ctx)
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+	return created
+}
+
+func newCoupon(t *testing.T) *Coupon {
+	t.Helper()
+	coupon := Coupon{Code: "ONCE-" + uuid.New().String()[:8], DiscountType: CouponTypeFlat, DiscountValue: 100, UsageLimit: 1, PerUserLimit: 1}
+	mustCreate(t, &coupon)
+	return &coupon
+}
+
+// expectCouponUses checks the coupon's count and the customer's uses of it.
+func (f *fixture) expectCouponUses(t *testing.T, coupon *Coupon, want int) {
+	t.Helper()
+	var stored Coupon
+	if err := database.DB.Where("id = ?", coupon.Id).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	byUser, err := CountCouponUsageByUser(f.ctx, coupon.Id, f.user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UsedCount != want || byUser != int64(want) {
+		t.Fatalf("coupon used %d times, %d by the customer, want %d", stored.UsedCount, byUser, want)
+	}
+}
+
+func TestCancelOrderReleasesCoupon(t *testing.T) {
+	f := newFixture(t, 5)
+	coupon := newCoupon(t)
+	order := f.couponOrder(t, coupon)
+	f.expectCouponUses(t, coupon, 1)
+
+	if _, err := CancelOrder(f.ctx, order.Id, f.user.Id, "changed my mind"); err != nil {
+		t.Fatal(err)
+	}
+	f.expectCouponUses(t, coupon, 0)
+
+	// the single use is free again
+	f.couponOrder(t, coupon)
+	f.expectCouponUses(t, coupon, 1)
+}
+
+func TestReleaseExpiredReservationsReleasesCoupon(t *testing.T) {
+	f := newFixture(t, 5)
+	coupon := newCoupon(t)
+	order := f.couponOrder(t, coupon)
+	expire(t, order.Id)
+
+	if _, err := ReleaseExpiredReservations(f.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadOrder(t, order.Id).Status; got != OrderStatusCancelled {
+		t.Fatalf("status = %s, want cancelled", got)
+	}
+	f.expectCouponUses(t, coupon, 0)
+
+	// releasing again takes nothing more off the count
+	if err := database.DB.Transaction(func(tx *gorm.DB) error { return ReleaseCouponUsage(tx, order.Id) }); err != nil {
+		t.Fatal(err)
+	}
+	f.expectCouponUses(t, coupon, 0)
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/mailer"
+	"github.com/pratyush934/sibling-bond-server/metrics"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+var (
+	// EmailMaxAttempts is how many sends a message gets before it is marked failed.
+	EmailMaxAttempts = 8
+	// emailRetryBase is the wait after the first failed send, it doubles with every further failure.
+	emailRetryBase = 30 * time.Second
+	emailRetryMax  = 2 * time.Hour
+	// emailClaimLease keeps other instances off a message while one is sending it.
+	emailClaimLease = 2 * time.Minute
+)
+
+// EmailOutbox holds every message until the mailer accepted it. Messages are queued in the same
+// transaction as the change they report, so an order is never placed without its confirmation.
+type EmailOutbox struct {
+	Id            string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	Recipient     string     `gorm:"not null;type:varchar(255)" json:"recipient"`
+	Template      string     `gorm:"not null;type:varchar(64)" json:"template"`
+	Subject       string     `gorm:"not null" json:"subject"`
+	TextBody      string     `gorm:"type:text" json:"-"`
+	HTMLBody      string     `gorm:"type:text" json:"-"`
+	Status        string     `gorm:"not null;type:varchar(20);index:idx_email_outbox_due,priority:1" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_email_outbox_due,priority:2" json:"nextAttemptAt"`
+	LastError     string     `gorm:"type:text" json:"lastError,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+func (EmailOutbox) TableName() string {
+	return "email_outbox"
+}
+
+func (e *EmailOutbox) BeforeCreate(t *gorm.DB) error {
+	e.Id = uuid.New().String()
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+	if e.Status == "" {
+		e.Status = EmailStatusPending
+	}
+	if e.NextAttemptAt.IsZero() {
+		e.NextAttemptAt = e.CreatedAt
+	}
+	return nil
+}
+
+// QueueEmail renders the template and stores the message for the outbox worker.
+func QueueEmail(ctx context.Context, to, template string, data any) error {
+	return queueEmail(database.DB.WithContext(ctx), to, template, data)
+}
+
+// queueEmail is QueueEmail inside tx, the message is only sent when tx commits.
+func queueEmail(tx *gorm.DB, to, template string, data any) error {
+	msg, err := mailer.Render(template, data)
+	if err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Str("template", template).Msg("Issue exist in queueEmail rendering")
+		return err
+	}
+
+	email := EmailOutbox{
+		Recipient: to,
+		Template:  template,
+		Subject:   msg.Subject,
+		TextBody:  msg.Text,
+		HTMLBody:  msg.HTML,
+	}
+	if err := tx.Create(&email).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in queueEmail")
+		return err
+	}
+	return nil
+}
+
+// emailRetryDelay is the wait before the next send of a message that failed attempts times.
+func emailRetryDelay(attempts int) time.Duration {
+	delay := emailRetryBase
+	for i := 1; i < attempts && delay < emailRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > emailRetryMax {
+		return emailRetryMax
+	}
+	return delay
+}
+
+// DeliverDueEmails sends up to limit messages that are due and returns how many went out.
+func DeliverDueEmails(ctx context.Context, limit int) (int, error) {
+	m, err := mailer.Default()
+	if err != nil {
+		return 0, err
+	}
+
+	var due []EmailOutbox
+	if err := database.DB.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", EmailStatusPending, time.Now()).
+		Order("next_attempt_at ASC").Limit(limit).Find(&due).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in DeliverDueEmails")
+		return 0, err
+	}
+
+	sent := 0
+	for _, email := range due {
+		claimed, err := claimEmail(ctx, email)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		sendErr := m.Send(ctx, mailer.Message{To: email.Recipient, Subject: email.Subject, Text: email.TextBody, HTML: email.HTMLBody})
+		if err := recordEmailAttempt(ctx, email, sendErr); err != nil {
+			return sent, err
+		}
+		if sendErr == nil {
+			sent++
+			metrics.EmailsSent.WithLabelValues(email.Template).Inc()
+		} else {
+			metrics.EmailSendErrors.WithLabelValues(email.Template).Inc()
+		}
+	}
+	return sent, nil
+}
+
+// claimEmail pushes the next attempt out by the lease, only one instance wins the update.
+func claimEmail(ctx context.Context, email EmailOutbox) (bool, error) {
+	result := database.DB.WithContext(ctx).Model(&EmailOutbox{}).
+		Where("id = ? AND status = ? AND attempts = ? AND next_attempt_at <= ?", email.Id, EmailStatusPending, email.Attempts, time.Now()).
+		Updates(map[string]any{"next_attempt_at": time.Now().Add(emailClaimLease), "updated_at": time.Now()})
+	if result.Error != nil {
+		logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in claimEmail")
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func recordEmailAttempt(ctx context.Context, email EmailOutbox, sendErr error) error {
+	now := time.Now()
+	attempts := email.Attempts + 1
+	updates := map[string]any{"attempts": attempts, "updated_at": now}
+
+	switch {
+	case sendErr == nil:
+		updates["status"] = EmailStatusSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	case attempts >= EmailMaxAttempts:
+		updates["status"] = EmailStatusFailed
+		updates["last_error"] = sendErr.Error()
+		logging.Ctx(ctx).Error().Err(sendErr).Str("emailId", email.Id).Int("attempts", attempts).Msg("Giving up on email")
+	default:
+		updates["next_attempt_at"] = now.Add(emailRetryDelay(attempts))
+		updates["last_error"] = sendErr.Error()
+		logging.Ctx(ctx).Warn().Err(sendErr).Str("emailId", email.Id).Int("attempts", attempts).Msg("Email send failed, will retry")
+	}
+
+	if err := database.DB.WithContext(ctx).Model(&EmailOutbox{}).Where("id = ?", email.Id).Updates(updates).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in recordEmailAttempt")
+		return err
+	}
+	return nil
+}
+
+// StartEmailOutbox delivers queued messages every interval until ctx is done.
+func StartEmailOutbox(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent, err := DeliverDueEmails(ctx, 50)
+			if err != nil {
+				if !errors.Is(err, mailer.ErrNoMailer) && !errors.Is(err, context.Canceled) {
+					log.Err(err).Msg("Issue while delivering the email outbox")
+				}
+				continue
+			}
+			if sent > 0 {
+				log.Info().Int("sent", sent).Msg("Delivered queued emails")
+			}
+		}
+	}
+}
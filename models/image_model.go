@@ -1,9 +1,10 @@
 package models
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
 	"time"
 )
@@ -38,9 +39,9 @@ func (i *Image) BeforeUpdate(tx *gorm.DB) error {
 	return nil
 }
 
-func (i *Image) CreateImage() (*Image, error) {
-	if err := database.DB.Create(i).Error; err != nil {
-		log.Err(err).Msg("Issue exist while CreatingImage")
+func (i *Image) CreateImage(ctx context.Context) (*Image, error) {
+	if err := database.DB.WithContext(ctx).Create(i).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist while CreatingImage")
 		return nil, err
 	}
 	return i, nil
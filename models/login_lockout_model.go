@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+	Progressive lockout: LockoutThreshold failed passwords in a row lock the account for
+	LockoutDuration, every further failure doubles the lock up to LockoutMaxDuration.
+	A successful login starts the count again. Set from the config at startup.
+*/
+
+var (
+	LockoutThreshold   = 5
+	LockoutDuration    = time.Minute
+	LockoutMaxDuration = time.Hour
+)
+
+// LockedFor is how long the account stays locked, zero when it is not.
+func (u *User) LockedFor(now time.Time) time.Duration {
+	if u.LockedUntil == nil || !now.Before(*u.LockedUntil) {
+		return 0
+	}
+	return u.LockedUntil.Sub(now)
+}
+
+// lockoutFor is the lock earned by the given number of failed attempts in a row.
+func lockoutFor(attempts int) time.Duration {
+	if LockoutThreshold <= 0 || attempts < LockoutThreshold {
+		return 0
+	}
+	lock := LockoutDuration
+	for i := LockoutThreshold; i < attempts && lock < LockoutMaxDuration; i++ {
+		lock *= 2
+	}
+	if lock > LockoutMaxDuration {
+		return LockoutMaxDuration
+	}
+	return lock
+}
+
+// RecordFailedLogin counts a wrong password for the user and returns the lock it caused, if any.
+func RecordFailedLogin(ctx context.Context, userId string) (time.Duration, error) {
+	var lock time.Duration
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the increment happens in the DB so parallel attempts can not overwrite each other's count
+		if err := tx.Model(&User{}).Where("id = ?", userId).
+			UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error; err != nil {
+			return err
+		}
+		var user User
+		if err := tx.Select("id", "failed_login_attempts").Where("id = ?", userId).First(&user).Error; err != nil {
+			return err
+		}
+		lock = lockoutFor(user.FailedLoginAttempts)
+		if lock == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", userId).UpdateColumn("locked_until", time.Now().Add(lock)).Error
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RecordFailedLogin")
+		return 0, err
+	}
+	return lock, nil
+}
+
+// ClearFailedLogins resets the count after a successful login.
+func ClearFailedLogins(ctx context.Context, userId string) error {
+	err := database.DB.WithContext(ctx).Model(&User{}).Where("id = ?", userId).
+		UpdateColumns(map[string]interface{}{"failed_login_attempts": 0, "locked_until": nil}).Error
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClearFailedLogins")
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/migrations"
+	"github.com/rs/zerolog"
+	"os"
+	"testing"
+)
+
+// The tests share one in-memory SQLite database migrated like a real one. Every test seeds its
+// own user, category and products, so tests do not see each other's rows.
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	cfg := config.DatabaseConfig{Driver: database.DriverSQLite, DSN: database.SQLiteMemoryDSN}
+	if err := database.InitDB(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "open test database:", err)
+		os.Exit(1)
+	}
+	if _, err := migrations.NewRunner(database.DB).Up(0); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate test database:", err)
+		os.Exit(1)
+	}
+	if err := database.DB.FirstOrCreate(&Role{Id: 1, RoleName: "User", Description: "Normal User"}).Error; err != nil {
+		fmt.Fprintln(os.Stderr, "seed roles:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+type fixture struct {
+	ctx     context.Context
+	user    User
+	address Address
+	product Product
+}
+
+// newFixture seeds a user with an address and a product with the given stock.
+func newFixture(t *testing.T, stock int) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	suffix := uuid.New().String()[:8]
+
+	f := &fixture{ctx: ctx}
+	f.user = User{Email: "buyer-" + suffix + "@example.com", PassWord: "secret", UserName: "buyer-" + suffix, FirstName: "Buyer"}
+	mustCreate(t, &f.user)
+	f.address = Address{Id: uuid.New().String(), UserId: f.user.Id, StreetName: "1 Main Road", ZipCode: "560001", City: "Bengaluru", State: "KA"}
+	mustCreate(t, &f.address)
+	category := Category{Name: "Category " + suffix, Slug: "category-" + suffix}
+	mustCreate(t, &category)
+	f.product = Product{Name: "Lamp " + suffix, Price: 1000, Stock: stock, CategoryId: category.Id, IsActive: true}
+	mustCreate(t, &f.product)
+	return f
+}
+
+func mustCreate(t *testing.T, value any) {
+	t.Helper()
+	if err := database.DB.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+// placeOrder orders quantity of the fixture product, paid online unless paymentMode says otherwise.
+func (f *fixture) placeOrder(t *testing.T, quantity int, paymentMode string) *Order {
+	t.Helper()
+	order := Order{
+		UserId:            f.user.Id,
+		ShippingAddressId: f.address.Id,
+		OrderItems:        []OrderItem{{ProductId: f.product.Id, Quantity: quantity, PriceAtPurchase: f.product.Price}},
+		SubTotal:          quantity * f.product.Price,
+		TotalAmount:       quantity * f.product.Price,
+		PaymentMode:       paymentMode,
+	}
+	created, err := order.Create(f.ctx)
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+	return created
+}
+
+func (f *fixture) stock(t *testing.T) int {
+	t.Helper()
+	var product Product
+	if err := database.DB.Where("id = ?", f.product.Id).First(&product).Error; err != nil {
+		t.Fatalf("load product: %v", err)
+	}
+	return product.Stock
+}
+
+func loadOrder(t *testing.T, id string) Order {
+	t.Helper()
+	var order Order
+	if err := database.DB.Where("id = ?", id).First(&order).Error; err != nil {
+		t.Fatalf("load order: %v", err)
+	}
+	return order
+}
+
+func reservationStatuses(t *testing.T, orderId string) []string {
+	t.Helper()
+	var statuses []string
+	if err := database.DB.Model(&StockReservation{}).Where("order_id = ?", orderId).Pluck("status", &statuses).Error; err != nil {
+		t.Fatalf("load reservations: %v", err)
+	}
+	return statuses
+}
+
+func expectStatuses(t *testing.T, got []string, want string) {
+	t.Helper()
+	if len(got) == 0 {
+		t.Fatalf("no reservations, want them %s", want)
+	}
+	for _, status := range got {
+		if status != want {
+			t.Fatalf("reservations are %v, want all %s", got, want)
+		}
+	}
+}
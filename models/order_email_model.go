@@ -0,0 +1,89 @@
+package models
+
+import (
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/mailer"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// orderEmails maps the statuses customers are told about to the template that tells them.
+var orderEmails = map[string]string{
+	OrderStatusPending:   mailer.TemplateOrderConfirmation,
+	OrderStatusShipped:   mailer.TemplateOrderShipped,
+	OrderStatusCancelled: mailer.TemplateOrderCancelled,
+}
+
+// queueOrderEmail queues the message for the order entering status inside tx. Statuses without
+// a message are ignored.
+func queueOrderEmail(tx *gorm.DB, order *Order, status, note string) error {
+	template, ok := orderEmails[status]
+	if !ok {
+		return nil
+	}
+
+	var user User
+	if err := tx.Select("id", "email", "first_name").Where("id = ?", order.UserId).First(&user).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in queueOrderEmail getting user")
+		return err
+	}
+
+	items := order.OrderItems
+	if len(items) == 0 {
+		if err := tx.Where("order_id = ?", order.Id).Find(&items).Error; err != nil {
+			logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in queueOrderEmail getting items")
+			return err
+		}
+	}
+	lines, err := orderEmailLines(tx, items)
+	if err != nil {
+		return err
+	}
+
+	data := mailer.OrderData{
+		Name:           user.FirstName,
+		OrderRef:       orderRef(order.Id),
+		Items:          lines,
+		Discount:       order.DiscountAmount,
+		Total:          order.TotalAmount,
+		PaymentMode:    order.PaymentMode,
+		TrackingNumber: order.TrackingNumber,
+		Reason:         note,
+		Paid:           order.PaymentStatus == PaymentStatusCompleted,
+	}
+	return queueEmail(tx, user.Email, template, data)
+}
+
+func orderEmailLines(tx *gorm.DB, items []OrderItem) ([]mailer.OrderLine, error) {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductId)
+	}
+
+	// deleted products still have to show up by name on old orders
+	var products []Product
+	if err := tx.Unscoped().Select("id", "name").Where("id IN ?", ids).Find(&products).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in orderEmailLines")
+		return nil, err
+	}
+	names := make(map[string]string, len(products))
+	for _, product := range products {
+		names[product.Id] = product.Name
+	}
+
+	lines := make([]mailer.OrderLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, mailer.OrderLine{
+			Name:     names[item.ProductId],
+			Quantity: item.Quantity,
+			Total:    item.PriceAtPurchase * item.Quantity,
+		})
+	}
+	return lines, nil
+}
+
+// orderRef is the short order number shown to customers.
+func orderRef(orderId string) string {
+	ref, _, _ := strings.Cut(orderId, "-")
+	return strings.ToUpper(ref)
+}
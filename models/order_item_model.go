@@ -1,10 +1,11 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
 	"time"
 )
@@ -13,7 +14,7 @@ type OrderItem struct {
 	Id              string    `gorm:"primaryKey;type:varchar(191)" json:"id"`
 	OrderId         string    `gorm:"not null" json:"orderId"`
 	ProductId       string    `gorm:"not null;type:varchar(191)" json:"productId"`
-	VariantId       *string   `json:"variantId"`
+	VariantId       *string   `gorm:"type:varchar(191)" json:"variantId"`
 	Order           Order     `gorm:"foreignKey:OrderId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"order"`
 	Product         Product   `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
 	Quantity        int       `gorm:"not null" json:"quantity"`
@@ -23,11 +24,11 @@ type OrderItem struct {
 }
 
 /*
-Create(orderItem *OrderItem) (*OrderItem, error)
+Create(ctx context.Context, orderItem *OrderItem) (*OrderItem, error)
 
-CreateMany(orderItems []*OrderItem) error
+CreateMany(ctx context.Context, orderItems []*OrderItem) error
 
-GetByOrderID(orderID string) ([]*OrderItem, error)
+GetByOrderID(ctx context.Context, orderID string) ([]*OrderItem, error)
 
 */
 
@@ -36,35 +37,35 @@ func (o *OrderItem) BeforeCreate(t *gorm.DB) error {
 	return nil
 }
 
-func (o *OrderItem) Create(orderItem *OrderItem) (*OrderItem, error) {
-	if err := database.DB.Create(orderItem).Error; err != nil {
-		log.Err(err).Msg("Issue persist in the Create")
+func (o *OrderItem) Create(ctx context.Context, orderItem *OrderItem) (*OrderItem, error) {
+	if err := database.DB.WithContext(ctx).Create(orderItem).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in the Create")
 		return nil, err
 	}
 	return orderItem, nil
 }
 
-func (oi *OrderItem) ValidateOrderItem() error {
+func (oi *OrderItem) ValidateOrderItem(ctx context.Context) error {
 
 	var product Product
-	if err := database.DB.Preload("Variants").Where("id = ?", oi.ProductId).First(&product).Error; err != nil {
+	if err := database.DB.WithContext(ctx).Preload("Variants").Where("id = ?", oi.ProductId).First(&product).Error; err != nil {
 		return fmt.Errorf("product not found: %s", oi.ProductId)
 	}
 
 	// If variant ID is specified, validate variant stock
 	if oi.VariantId != nil && *oi.VariantId != "" {
 		var variant ProductVariant
-		if err := database.DB.Where("id = ? AND product_id = ?", *oi.VariantId, oi.ProductId).First(&variant).Error; err != nil {
+		if err := database.DB.WithContext(ctx).Where("id = ? AND product_id = ?", *oi.VariantId, oi.ProductId).First(&variant).Error; err != nil {
 			return fmt.Errorf("variant not found: %s", *oi.VariantId)
 		}
 
 		if variant.Stock < oi.Quantity {
-			return fmt.Errorf("insufficient variant stock for %s", *oi.VariantId)
+			return fmt.Errorf("%w for variant %s", ErrInsufficientStock, *oi.VariantId)
 		}
 	} else {
 		// Check main product stock
 		if product.Stock < oi.Quantity {
-			return fmt.Errorf("insufficient stock for product %s", oi.ProductId)
+			return fmt.Errorf("%w for product %s", ErrInsufficientStock, oi.ProductId)
 		}
 	}
 
@@ -75,23 +76,43 @@ func (oi *OrderItem) ValidateOrderItem() error {
 	return nil
 }
 
+// UpdateProductStock takes the ordered quantity out of the variant's stock when a variant was chosen,
+// otherwise out of the product's stock.
 func (oi *OrderItem) UpdateProductStock(tx *gorm.DB) error {
-	return tx.Model(&Product{}).Where("id = ?", oi.ProductId).UpdateColumn("stock", gorm.Expr("stock - ?", oi.Quantity)).Error
+	if oi.VariantId != nil && *oi.VariantId != "" {
+		variant := ProductVariant{Id: *oi.VariantId}
+		return variant.ReserveStock(tx, oi.Quantity)
+	}
+	product := Product{Id: oi.ProductId}
+	return product.ReserveStock(tx, oi.Quantity)
+}
 
+// RestoreProductStock gives the ordered quantity back to the variant or product it was taken from.
+func (oi *OrderItem) RestoreProductStock(tx *gorm.DB) error {
+	return restoreStock(tx, oi.ProductId, oi.VariantId, oi.Quantity)
+}
+
+func restoreStock(tx *gorm.DB, productId string, variantId *string, quantity int) error {
+	if variantId != nil && *variantId != "" {
+		variant := ProductVariant{Id: *variantId}
+		return variant.RestoreStock(tx, quantity)
+	}
+	product := Product{Id: productId}
+	return product.RestoreStock(tx, quantity)
 }
 
-func CreateMany(orderItem []*OrderItem) error {
-	if err := database.DB.Create(orderItem).Error; err != nil {
-		log.Err(err).Msg("issue persist in CreateMany")
+func CreateMany(ctx context.Context, orderItem []*OrderItem) error {
+	if err := database.DB.WithContext(ctx).Create(orderItem).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("issue persist in CreateMany")
 		return err
 	}
 	return nil
 }
 
-func GetByOrderID(orderID string) ([]*OrderItem, error) {
+func GetByOrderID(ctx context.Context, orderID string) ([]*OrderItem, error) {
 	var orderItems []*OrderItem
-	if err := database.DB.Where("order_id = ?", orderID).Find(&orderItems).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetByOrderID")
+	if err := database.DB.WithContext(ctx).Where("order_id = ?", orderID).Find(&orderItems).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetByOrderID")
 		return nil, err
 	}
 	return orderItems, nil
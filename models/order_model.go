@@ -1,10 +1,13 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/metrics"
 	"gorm.io/gorm"
 	"time"
 )
@@ -15,27 +18,35 @@ type Order struct {
 	User              User        `gorm:"foreignKey:UserId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
 	OrderItems        []OrderItem `gorm:"foreignKey:OrderId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"orderItems"`
 	OrderedAt         time.Time   `json:"orderedAt"`
+	SubTotal          int         `json:"subTotal"`
+	DiscountAmount    int         `gorm:"default:0" json:"discountAmount"`
+	CouponId          *string     `gorm:"type:varchar(191)" json:"couponId"`
+	CouponCode        string      `json:"couponCode"`
 	TotalAmount       int         `json:"totalAmount"`
+	RefundedAmount    int         `gorm:"default:0" json:"refundedAmount"`
 	ShippingAddressId string      `gorm:"type:varchar(191);not null" json:"shippingAddressId"`
 	ShippingAddress   Address     `gorm:"foreignKey:ShippingAddressId;constraint:onUpdate:CASCADE,onDelete:CASCADE"  json:"address"`
 	Status            string      `json:"status"`
 	PaymentStatus     string      `json:"paymentStatus"`
 	PaymentMode       string      `json:"paymentMode"`
 	TrackingNumber    int         `json:"trackingNumber"`
+	Payments          []Payment   `gorm:"foreignKey:OrderId" json:"payments,omitempty"`
+	CancelReason      string      `json:"cancelReason,omitempty"`
+	CancelledAt       *time.Time  `json:"cancelledAt,omitempty"`
 	CreatedAt         time.Time   `json:"createdAt"`
 	UpdatedAt         time.Time   `json:"updatedAt"`
 }
 
 /*
-Create(order *Order) (*Order, error)
+Create(ctx context.Context, order *Order) (*Order, error)
 
 GetByID(id string) (*Order, error)
 
 GetByUserID(userID string, offset, limit int) ([]*Order, error)
 
-UpdateStatus(orderID string, newStatus string) (*Order, error)
+UpdateStatus(ctx context.Context, orderID, newStatus, changedBy, note string) (*Order, error)
 
-GetAll(offset, limit int, statusFilter string) ([]*Order, error)
+GetAll(ctx context.Context, offset, limit int, statusFilter string) ([]*Order, error)
 */
 
 func (o *Order) BeforeCreate(t *gorm.DB) error {
@@ -46,10 +57,10 @@ func (o *Order) BeforeCreate(t *gorm.DB) error {
 	o.OrderedAt = time.Now()
 
 	if o.Status == "" {
-		o.Status = "pending"
+		o.Status = OrderStatusPending
 	}
 	if o.PaymentStatus == "" {
-		o.PaymentStatus = "pending"
+		o.PaymentStatus = PaymentStatusPending
 	}
 
 	return nil
@@ -60,42 +71,71 @@ func (o *Order) BeforeUpdate(t *gorm.DB) error {
 	return nil
 }
 
-func (o *Order) Create() (*Order, error) {
+func (o *Order) Create(ctx context.Context) (*Order, error) {
 
-	if err := o.ValidateOrder(); err != nil {
-		log.Err(err).Msg("Order Validation failed")
+	if err := o.ValidateOrder(ctx); err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Order Validation failed")
 		return nil, err
 	}
 
-	tx := database.DB.Begin()
+	expiresAt := o.reservationExpiry()
 
-	if err := tx.Create(o).Error; err != nil {
-		tx.Rollback()
-		log.Err(err).Msg("issue exist in create order")
-		return nil, err
-	}
+	// ValidateOrder is only a fast pre-check; the conditional stock update inside the
+	// transaction is what actually guarantees we never sell more than we have.
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(o).Error; err != nil {
+			logging.Ctx(ctx).Err(err).Msg("issue exist in create order")
+			return err
+		}
 
-	for _, item := range o.OrderItems {
-		if err := item.UpdateProductStock(tx); err != nil {
-			tx.Rollback()
-			return nil, err
+		if err := recordStatusChange(tx, o.Id, "", o.Status, o.UserId, "order placed"); err != nil {
+			return err
+		}
+
+		for _, item := range o.OrderItems {
+			if err := item.UpdateProductStock(tx); err != nil {
+				return err
+			}
+			if err := CreateReservation(tx, item, expiresAt); err != nil {
+				return err
+			}
 		}
+
+		if o.CouponId != nil {
+			if err := RedeemCoupon(tx, *o.CouponId, o.UserId, o.Id, o.DiscountAmount); err != nil {
+				return err
+			}
+		}
+		return queueOrderEmail(tx, o, o.Status, "")
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	tx.Commit()
+	metrics.OrdersCreated.WithLabelValues(o.PaymentMode).Inc()
 	return o, nil
 }
 
-func (o *Order) ValidateOrder() error {
+// reservationExpiry is when the stock held for an unpaid order goes back on sale.
+// Cash on delivery orders are paid on delivery, so their stock is held until the order is closed.
+func (o *Order) reservationExpiry() *time.Time {
+	if o.PaymentMode == "cod" || o.PaymentStatus == PaymentStatusCompleted {
+		return nil
+	}
+	expiresAt := time.Now().Add(ReservationTTL)
+	return &expiresAt
+}
+
+func (o *Order) ValidateOrder(ctx context.Context) error {
 	var user User
-	if err := database.DB.Where("id = ?", o.UserId).First(&user).Error; err != nil {
-		log.Err(err).Msg("Issue in ValidateUser getting user")
+	if err := database.DB.WithContext(ctx).Where("id = ?", o.UserId).First(&user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue in ValidateUser getting user")
 		return err
 	}
 
 	var address Address
-	if err := database.DB.Where(&Address{UserId: o.UserId, Id: o.ShippingAddressId}).First(&address).Error; err != nil {
-		log.Err(err).Msg("Issue in ValidateUser Getting Address")
+	if err := database.DB.WithContext(ctx).Where(&Address{UserId: o.UserId, Id: o.ShippingAddressId}).First(&address).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue in ValidateUser Getting Address")
 		return err
 	}
 
@@ -104,8 +144,8 @@ func (o *Order) ValidateOrder() error {
 	}
 
 	for _, item := range o.OrderItems {
-		if err := item.ValidateOrderItem(); err != nil {
-			log.Err(err).Msg("Issue persist")
+		if err := item.ValidateOrderItem(ctx); err != nil {
+			logging.Ctx(ctx).Err(err).Msg("Issue persist")
 			return err
 		}
 	}
@@ -114,8 +154,8 @@ func (o *Order) ValidateOrder() error {
 		return fmt.Errorf("total amount mismatch")
 	}
 
-	validStatuses := []string{"pending", "confirmed", "processing", "shipping"}
-	if !contains(validStatuses, o.Status) {
+	// every order enters the state machine as pending
+	if o.Status != "" && o.Status != OrderStatusPending {
 		return fmt.Errorf("invalid order status : %s", o.Status)
 	}
 
@@ -139,96 +179,181 @@ func (o *Order) CalculateTotal() int {
 	return total
 }
 
-func (o *Order) UpdateTotalAmount() error {
+func (o *Order) UpdateTotalAmount(ctx context.Context) error {
 
 	if len(o.OrderItems) == 0 {
-		if err := database.DB.Preload("OrderItems").Where("id = ?", o.Id).First(o).Error; err != nil {
+		if err := database.DB.WithContext(ctx).Preload("OrderItems").Where("id = ?", o.Id).First(o).Error; err != nil {
 			return err
 		}
 	}
-	o.TotalAmount = o.CalculateTotal()
-	return database.DB.Save(o).Error
+	o.SubTotal = o.CalculateTotal()
+	o.TotalAmount = o.SubTotal - o.DiscountAmount
+	return database.DB.WithContext(ctx).Save(o).Error
 }
 
 func (o *Order) ValidateTotal() bool {
 	calculateTotal := o.CalculateTotal()
-	return o.TotalAmount == calculateTotal
+	if o.DiscountAmount < 0 || o.DiscountAmount > calculateTotal {
+		return false
+	}
+	return o.TotalAmount == calculateTotal-o.DiscountAmount
 }
 
-func GetOrderById(id string) (*Order, error) {
+func GetOrderById(ctx context.Context, id string) (*Order, error) {
 	var order Order
-	if err := database.DB.Where(&Order{Id: id}).First(&order).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetOrderById")
+	if err := database.DB.WithContext(ctx).Where(&Order{Id: id}).First(&order).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetOrderById")
 		return nil, err
 	}
 	return &order, nil
 
 }
 
-func UpdateStatus(orderId string, newStatus string) (*Order, error) {
-	var order Order
-
-	if err := database.DB.Model(&Order{}).Where("id = ?", orderId).Update("status", newStatus).Error; err != nil {
-		log.Err(err).Msg("Issue exist in UpdateStatus")
-		return nil, err
+// UpdateStatus moves the order through the status state machine, rejecting illegal transitions.
+// Cancelling goes through CancelOrder so the stock comes back and a paid order is flagged for
+// refund. Returned and refunded are only reached by booking a return or a refund.
+func UpdateStatus(ctx context.Context, orderId, newStatus, changedBy, note string) (*Order, error) {
+	switch newStatus {
+	case OrderStatusCancelled:
+		return CancelOrder(ctx, orderId, changedBy, note)
+	case OrderStatusReturned, OrderStatusRefunded:
+		return nil, fmt.Errorf("%w: %s is set by recording a return or a refund", ErrIllegalStatusTransition, newStatus)
 	}
 
-	if err := database.DB.Where("id = ?", orderId).First(&order).Error; err != nil {
-		log.Err(err).Msg("issue exist in fetching updated User")
+	var order Order
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", orderId).First(&order).Error; err != nil {
+			return err
+		}
+		if err := TransitionOrderStatus(tx, &order, newStatus, changedBy, note); err != nil {
+			return err
+		}
+		// cash on delivery is collected by the courier when the parcel is handed over
+		if newStatus == OrderStatusDelivered && order.PaymentMode == "cod" && order.PaymentStatus == PaymentStatusPending {
+			if err := tx.Model(&Order{}).Where("id = ?", order.Id).Update("payment_status", PaymentStatusCompleted).Error; err != nil {
+				return err
+			}
+			order.PaymentStatus = PaymentStatusCompleted
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateStatus")
 		return nil, err
 	}
 
 	return &order, nil
 }
 
-func GetAllOrder(limit, offset int, status string) ([]Order, error) {
-	var orders []Order
-	query := database.DB.Limit(limit).Offset(offset)
+var OrderListSpec = listquery.Spec{
+	Filters: []listquery.Filter{
+		listquery.Equal("status", "status", listquery.String),
+		listquery.Equal("paymentStatus", "payment_status", listquery.String),
+		listquery.Equal("userId", "user_id", listquery.String),
+		listquery.Min("minTotal", "total_amount", listquery.Int),
+		listquery.Max("maxTotal", "total_amount", listquery.Int),
+		listquery.Min("createdFrom", "created_at", listquery.Time),
+		listquery.Max("createdTo", "created_at", listquery.Time),
+	},
+	Sorts: map[string]string{
+		"createdAt":   "created_at",
+		"totalAmount": "total_amount",
+	},
+	DefaultSort: "-createdAt",
+}
 
-	if status != "" {
-		query = query.Where("status = ?", status)
-	}
+// CustomerOrderListSpec is what a customer's own order history accepts, the owner is added by the
+// handler and can not be filtered on.
+var CustomerOrderListSpec = listquery.Spec{
+	Filters: []listquery.Filter{
+		listquery.Equal("status", "status", listquery.String),
+		listquery.Equal("paymentStatus", "payment_status", listquery.String),
+		listquery.Min("createdFrom", "created_at", listquery.Time),
+		listquery.Max("createdTo", "created_at", listquery.Time),
+	},
+	Sorts: map[string]string{
+		"createdAt":   "created_at",
+		"totalAmount": "total_amount",
+	},
+	DefaultSort:  "-createdAt",
+	DefaultLimit: 10,
+}
 
-	if err := query.Find(&orders).Error; err != nil {
-		log.Err(err).Msg("Issue persist in GetAll Order")
+func ListOrders(ctx context.Context, q *listquery.Query) (*listquery.Page[Order], error) {
+	page, err := listquery.Fetch[Order](database.DB.WithContext(ctx), q, "OrderItems")
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ListOrders")
 		return nil, err
 	}
-	return orders, nil
+	return page, nil
 }
 
-func GetOrdersByUserId(userId string, page, pageSize int) ([]Order, error) {
-
-	if pageSize <= 0 {
-		pageSize = 10
-	}
-
-	if page <= 0 {
-		page = 1
+func GetOrderByUserIdAndOrderId(ctx context.Context, userId, orderId string) (*Order, error) {
+	if userId == "" || orderId == "" {
+		return nil, fmt.Errorf("user Id or Order Id is required")
 	}
 
-	offSet := (page - 1) * pageSize
-
-	var order []Order
-	if err := database.DB.Where(&Order{UserId: userId}).Order("created_at DESC").Limit(pageSize).Offset(offSet).Find(&order).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetOrderByUserId")
+	var order Order
+	if err := database.DB.WithContext(ctx).Where("id = ? AND user_id = ?", orderId, userId).Preload("OrderItems").Preload("ShippingAddress").Preload("Payments").First(&order).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetOrderByUserIdAndOrderId")
 		return nil, err
 	}
-	return order, nil
+	return &order, nil
 }
 
-func GetOrderByUserIdAndOrderId(userId, orderId string) (*Order, error) {
-	if userId == "" || orderId == "" {
-		return nil, fmt.Errorf("user Id or Order Id is required")
-	}
-
+// CancelOrder cancels the order without deleting it: the status moves to cancelled, the stock of every
+// item is restored, its coupon use is given back and a completed payment is flagged for refund, all in
+// one transaction.
+func CancelOrder(ctx context.Context, orderId, changedBy, reason string) (*Order, error) {
 	var order Order
-	if err := database.DB.Where("id = ? AND user_id = ?", orderId, userId).Preload("OrderItems").Preload("ShippingAddress").First(&order).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetOrderByUserIdAndOrderId")
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("OrderItems").Where("id = ?", orderId).First(&order).Error; err != nil {
+			return err
+		}
+
+		if err := TransitionOrderStatus(tx, &order, OrderStatusCancelled, changedBy, reason); err != nil {
+			return err
+		}
+
+		for _, item := range order.OrderItems {
+			if err := item.RestoreProductStock(tx); err != nil {
+				return err
+			}
+		}
+
+		if err := ReleaseOrderReservations(tx, order.Id); err != nil {
+			return err
+		}
+		if err := ReleaseCouponUsage(tx, order.Id); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]any{"cancel_reason": reason, "cancelled_at": now}
+		if order.PaymentStatus == PaymentStatusCompleted {
+			updates["payment_status"] = PaymentStatusRefundPending
+		}
+		if err := tx.Model(&Order{}).Where("id = ?", order.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		order.CancelReason = reason
+		order.CancelledAt = &now
+		if status, ok := updates["payment_status"].(string); ok {
+			order.PaymentStatus = status
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CancelOrder")
 		return nil, err
 	}
+
 	return &order, nil
 }
 
-func DeleteOrderById(orderId string) error {
-	return database.DB.Where(&Order{Id: orderId}).Delete(&Order{}).Error
+func DeleteOrderById(ctx context.Context, orderId string) error {
+	return database.DB.WithContext(ctx).Where(&Order{Id: orderId}).Delete(&Order{}).Error
 }
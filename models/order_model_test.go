@@ -0,0 +1,151 @@
+package models
+
+import (
+	"errors"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"net/url"
+	"testing"
+)
+
+func TestCancelOrder(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 2, "upi")
+	if got := f.stock(t); got != 3 {
+		t.Fatalf("stock after ordering = %d, want 3", got)
+	}
+
+	cancelled, err := CancelOrder(f.ctx, order.Id, f.user.Id, "changed my mind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != OrderStatusCancelled || cancelled.CancelReason != "changed my mind" || cancelled.CancelledAt == nil {
+		t.Fatalf("cancelled order = %+v", cancelled)
+	}
+	if got := f.stock(t); got != 5 {
+		t.Fatalf("stock after cancelling = %d, want 5", got)
+	}
+	expectStatuses(t, reservationStatuses(t, order.Id), ReservationReleased)
+	if got := loadOrder(t, order.Id).PaymentStatus; got != PaymentStatusPending {
+		t.Fatalf("payment status = %s, an unpaid order has nothing to refund", got)
+	}
+}
+
+func TestCancelOrderFlagsPaidOrderForRefund(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 1, "upi")
+	if err := database.DB.Model(&Order{}).Where("id = ?", order.Id).Update("payment_status", PaymentStatusCompleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := CancelOrder(f.ctx, order.Id, f.user.Id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.PaymentStatus != PaymentStatusRefundPending {
+		t.Fatalf("payment status = %s, want refund_pending", cancelled.PaymentStatus)
+	}
+	if got := loadOrder(t, order.Id).PaymentStatus; got != PaymentStatusRefundPending {
+		t.Fatalf("stored payment status = %s, want refund_pending", got)
+	}
+}
+
+func TestCancelOrderRejectsShippedOrder(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 1, "cod")
+	for _, status := range []string{OrderStatusConfirmed, OrderStatusProcessing, OrderStatusShipped} {
+		if _, err := UpdateStatus(f.ctx, order.Id, status, "admin-1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := CancelOrder(f.ctx, order.Id, f.user.Id, ""); !errors.Is(err, ErrIllegalStatusTransition) {
+		t.Fatalf("err = %v, want ErrIllegalStatusTransition", err)
+	}
+	if got := f.stock(t); got != 4 {
+		t.Fatalf("stock = %d, a shipped order keeps its stock", got)
+	}
+}
+
+func TestUpdateStatusCancelsThroughCancelOrder(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 3, "upi")
+	if _, err := UpdateStatus(f.ctx, order.Id, OrderStatusConfirmed, "admin-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := UpdateStatus(f.ctx, order.Id, OrderStatusCancelled, "admin-1", "out of stock at the warehouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != OrderStatusCancelled || cancelled.CancelReason != "out of stock at the warehouse" {
+		t.Fatalf("cancelled order = %+v", cancelled)
+	}
+	if got := f.stock(t); got != 5 {
+		t.Fatalf("stock = %d, want 5", got)
+	}
+	expectStatuses(t, reservationStatuses(t, order.Id), ReservationReleased)
+}
+
+func TestUpdateStatusRefusesReturnedAndRefunded(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 1, "cod")
+	for _, status := range []string{OrderStatusConfirmed, OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered} {
+		if _, err := UpdateStatus(f.ctx, order.Id, status, "admin-1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, status := range []string{OrderStatusReturned, OrderStatusRefunded} {
+		if _, err := UpdateStatus(f.ctx, order.Id, status, "admin-1", ""); !errors.Is(err, ErrIllegalStatusTransition) {
+			t.Fatalf("%s: err = %v, want ErrIllegalStatusTransition", status, err)
+		}
+	}
+	stored := loadOrder(t, order.Id)
+	if stored.Status != OrderStatusDelivered || stored.PaymentStatus != PaymentStatusCompleted {
+		t.Fatalf("order = %s / %s, want delivered and paid on delivery", stored.Status, stored.PaymentStatus)
+	}
+}
+
+func TestCustomerOrderHistoryPagesOwnOrders(t *testing.T) {
+	f := newFixture(t, 10)
+	other := newFixture(t, 10)
+	for i := 0; i < 3; i++ {
+		f.placeOrder(t, 1, "cod")
+	}
+	other.placeOrder(t, 1, "cod")
+
+	// userId is not a filter of the customer listing, it can not reach another user's orders
+	values := url.Values{"limit": {"2"}, "userId": {other.user.Id}}
+	seen := map[string]bool{}
+	for pages := 0; ; pages++ {
+		q, fields := listquery.Parse(values, CustomerOrderListSpec)
+		if len(fields) > 0 {
+			t.Fatalf("parse: %v", fields)
+		}
+		q.Where("user_id = ?", f.user.Id)
+		page, err := ListOrders(f.ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 3 {
+			t.Fatalf("total %d, want 3", page.Total)
+		}
+		for _, order := range page.Items {
+			if order.UserId != f.user.Id || seen[order.Id] {
+				t.Fatalf("unexpected order %s of %s on page %d", order.Id, order.UserId, pages)
+			}
+			seen[order.Id] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if pages > 2 {
+			t.Fatal("the cursor never ran out")
+		}
+		values.Set("cursor", page.NextCursor)
+	}
+	if len(seen) != 3 {
+		t.Fatalf("listed %d orders, want 3", len(seen))
+	}
+}
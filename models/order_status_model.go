@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusConfirmed  = "confirmed"
+	OrderStatusProcessing = "processing"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+	OrderStatusReturned   = "returned"
+	OrderStatusRefunded   = "refunded"
+)
+
+// ChangedBySystem marks status changes made by the server itself, e.g. the reservation sweeper.
+const ChangedBySystem = "system"
+
+var ErrIllegalStatusTransition = errors.New("illegal order status transition")
+
+/*
+pending -> confirmed -> processing -> shipped -> delivered
+pending, confirmed, processing -> cancelled
+shipped, delivered -> returned
+delivered, returned, cancelled -> refunded
+*/
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusConfirmed, OrderStatusCancelled},
+	OrderStatusConfirmed:  {OrderStatusProcessing, OrderStatusCancelled},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:    {OrderStatusDelivered, OrderStatusReturned},
+	OrderStatusDelivered:  {OrderStatusReturned, OrderStatusRefunded},
+	OrderStatusReturned:   {OrderStatusRefunded},
+	OrderStatusCancelled:  {OrderStatusRefunded},
+	OrderStatusRefunded:   {},
+}
+
+type OrderStatusHistory struct {
+	Id         string    `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	OrderId    string    `gorm:"not null;type:varchar(191);index" json:"orderId"`
+	FromStatus string    `gorm:"type:varchar(20)" json:"fromStatus"`
+	ToStatus   string    `gorm:"not null;type:varchar(20)" json:"toStatus"`
+	ChangedBy  string    `gorm:"not null;type:varchar(100)" json:"changedBy"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (h *OrderStatusHistory) BeforeCreate(t *gorm.DB) error {
+	h.Id = uuid.New().String()
+	h.CreatedAt = time.Now()
+	return nil
+}
+
+func IsValidOrderStatus(status string) bool {
+	_, ok := orderStatusTransitions[status]
+	return ok
+}
+
+func CanTransition(from, to string) bool {
+	return contains(orderStatusTransitions[from], to)
+}
+
+func recordStatusChange(tx *gorm.DB, orderId, from, to, changedBy, note string) error {
+	history := OrderStatusHistory{
+		OrderId:    orderId,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Note:       note,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in recordStatusChange")
+		return err
+	}
+	return nil
+}
+
+// TransitionOrderStatus moves the order to the new status inside tx and records who did it.
+// The update is conditional on the status we read, so two concurrent changes can not both win.
+// Leaving pending for anything but cancelled commits the order's stock reservations, and moving
+// to shipped or cancelled queues the matching customer email in the same tx.
+func TransitionOrderStatus(tx *gorm.DB, order *Order, to, changedBy, note string) error {
+	from := order.Status
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalStatusTransition, from, to)
+	}
+
+	result := tx.Model(&Order{}).
+		Where("id = ? AND status = ?", order.Id, from).
+		Updates(map[string]any{"status": to, "updated_at": time.Now()})
+	if result.Error != nil {
+		logging.Ctx(tx.Statement.Context).Err(result.Error).Msg("Issue exist in TransitionOrderStatus")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: order %s is no longer %s", ErrIllegalStatusTransition, order.Id, from)
+	}
+
+	if err := recordStatusChange(tx, order.Id, from, to, changedBy, note); err != nil {
+		return err
+	}
+	// an order leaving pending other than by cancelling keeps its stock, whoever moved it on
+	if from == OrderStatusPending && to != OrderStatusCancelled {
+		if err := CommitReservations(tx, order.Id); err != nil {
+			return err
+		}
+	}
+	order.Status = to
+	return queueOrderEmail(tx, order, to, note)
+}
+
+func GetOrderStatusHistory(ctx context.Context, orderId string) ([]OrderStatusHistory, error) {
+	var history []OrderStatusHistory
+	if err := database.DB.WithContext(ctx).Where("order_id = ?", orderId).Order("created_at ASC").Find(&history).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetOrderStatusHistory")
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestTransitionOrderStatus(t *testing.T) {
+	f := newFixture(t, 10)
+
+	tests := []struct {
+		from, to string
+		legal    bool
+	}{
+		{OrderStatusPending, OrderStatusConfirmed, true},
+		{OrderStatusPending, OrderStatusShipped, false},
+		{OrderStatusConfirmed, OrderStatusProcessing, true},
+		{OrderStatusShipped, OrderStatusCancelled, false},
+		{OrderStatusDelivered, OrderStatusReturned, true},
+		{OrderStatusRefunded, OrderStatusPending, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			order := f.placeOrder(t, 1, "upi")
+			if err := database.DB.Model(&Order{}).Where("id = ?", order.Id).Update("status", tt.from).Error; err != nil {
+				t.Fatal(err)
+			}
+			order.Status = tt.from
+
+			err := database.DB.Transaction(func(tx *gorm.DB) error {
+				return TransitionOrderStatus(tx, order, tt.to, "tester", "")
+			})
+			if tt.legal && err != nil {
+				t.Fatalf("transition failed: %v", err)
+			}
+			if !tt.legal && !errors.Is(err, ErrIllegalStatusTransition) {
+				t.Fatalf("err = %v, want ErrIllegalStatusTransition", err)
+			}
+
+			want := tt.from
+			if tt.legal {
+				want = tt.to
+			}
+			if got := loadOrder(t, order.Id).Status; got != want {
+				t.Fatalf("status = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTransitionOrderStatusRecordsHistory(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 1, "upi")
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return TransitionOrderStatus(tx, order, OrderStatusConfirmed, "admin-1", "checked by phone")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := GetOrderStatusHistory(f.ctx, order.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d entries, want 2", len(history))
+	}
+	last := history[1]
+	if last.FromStatus != OrderStatusPending || last.ToStatus != OrderStatusConfirmed || last.ChangedBy != "admin-1" || last.Note != "checked by phone" {
+		t.Fatalf("last entry = %+v", last)
+	}
+}
+
+func TestTransitionOrderStatusStaleRead(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 1, "upi")
+	stale := *order
+
+	if _, err := UpdateStatus(f.ctx, order.Id, OrderStatusConfirmed, "admin-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy still says pending, pending -> cancelled is legal but the row has moved on
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return TransitionOrderStatus(tx, &stale, OrderStatusCancelled, "admin-2", "")
+	})
+	if !errors.Is(err, ErrIllegalStatusTransition) {
+		t.Fatalf("err = %v, want ErrIllegalStatusTransition", err)
+	}
+	if got := loadOrder(t, order.Id).Status; got != OrderStatusConfirmed {
+		t.Fatalf("status = %s, want confirmed", got)
+	}
+}
+
+func TestTransitionOrderStatusCommitsReservations(t *testing.T) {
+	f := newFixture(t, 5)
+	order := f.placeOrder(t, 2, "upi")
+	expectStatuses(t, reservationStatuses(t, order.Id), ReservationActive)
+
+	if _, err := UpdateStatus(f.ctx, order.Id, OrderStatusConfirmed, "admin-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectStatuses(t, reservationStatuses(t, order.Id), ReservationCommitted)
+}
@@ -0,0 +1,471 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/metrics"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Payment statuses, also used for Order.PaymentStatus
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusCompleted         = "completed"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusExpired           = "expired"
+	PaymentStatusRefundPending     = "refund_pending"
+	PaymentStatusRefunding         = "refunding"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+)
+
+var (
+	ErrRefundExceedsPaid    = errors.New("refund exceeds the amount paid")
+	ErrPaymentNotRefundable = errors.New("payment is not waiting for a refund")
+	ErrNothingToRefund      = errors.New("nothing left to refund on the order")
+	ErrRefundInProgress     = errors.New("a refund of the order is already under way")
+)
+
+// paidOrderStatuses are the order payment statuses that mean a payment has been taken for it.
+var paidOrderStatuses = []string{PaymentStatusCompleted, PaymentStatusPartiallyRefunded, PaymentStatusRefunded, PaymentStatusRefundPending, PaymentStatusRefunding}
+
+type Payment struct {
+	Id             string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	OrderId        string     `gorm:"not null;type:varchar(191);index" json:"orderId"`
+	Provider       string     `gorm:"not null;type:varchar(50);uniqueIndex:idx_payment_provider_ref" json:"provider"`
+	ProviderRef    string     `gorm:"not null;type:varchar(191);uniqueIndex:idx_payment_provider_ref" json:"providerRef"`
+	Attempt        int        `gorm:"not null;default:1" json:"attempt"`
+	Method         string     `json:"method"`
+	Amount         int        `gorm:"not null" json:"amount"`
+	RefundedAmount int        `gorm:"default:0" json:"refundedAmount"`
+	Currency       string     `gorm:"type:varchar(10)" json:"currency"`
+	Status         string     `gorm:"not null;type:varchar(30)" json:"status"`
+	FailureReason  string     `json:"failureReason,omitempty"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+/*
+CreatePayment() (*Payment, error)
+
+GetPaymentByProviderRef(ctx context.Context, provider, providerRef string) (*Payment, error)
+
+GetPaymentsByOrderId(ctx context.Context, orderId string) ([]Payment, error)
+
+CompletePayment(ctx context.Context, provider, providerRef string, amount int) (*Payment, error)
+
+FailPayment(ctx context.Context, provider, providerRef, reason string) (*Payment, error)
+
+ConfirmCashOnDelivery(ctx context.Context, orderId, changedBy string) (*Order, error)
+
+ClaimPaymentRefund(ctx context.Context, paymentId string) (*Payment, error)
+
+CompletePaymentRefund(ctx context.Context, paymentId string) (*Payment, error)
+
+ReleasePaymentRefund(ctx context.Context, paymentId string) error
+
+GetRefundablePayment(ctx context.Context, orderId string, amount int) (*Payment, error)
+
+ApplyRefund(tx *gorm.DB, orderId string, paymentId *string, amount int, changedBy string) error
+
+ClaimOrderRefund(ctx context.Context, orderId string) (*Order, int, error)
+
+ReleaseOrderRefund(ctx context.Context, orderId, paymentStatus string) error
+
+RefundOrder(ctx context.Context, orderId string, paymentId *string, amount int, changedBy string) (*Order, error)
+*/
+
+func (p *Payment) BeforeCreate(t *gorm.DB) error {
+	p.Id = uuid.New().String()
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	if p.Status == "" {
+		p.Status = PaymentStatusPending
+	}
+	return nil
+}
+
+func (p *Payment) BeforeUpdate(t *gorm.DB) error {
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+// CreatePayment stores a new attempt for the order, numbering it after the previous ones.
+func (p *Payment) CreatePayment(ctx context.Context) (*Payment, error) {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var attempts int64
+		if err := tx.Model(&Payment{}).Where("order_id = ?", p.OrderId).Count(&attempts).Error; err != nil {
+			return err
+		}
+		p.Attempt = int(attempts) + 1
+		return tx.Create(p).Error
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreatePayment")
+		return nil, err
+	}
+	return p, nil
+}
+
+func GetPaymentByProviderRef(ctx context.Context, provider, providerRef string) (*Payment, error) {
+	var payment Payment
+	if err := database.DB.WithContext(ctx).Where("provider = ? AND provider_ref = ?", provider, providerRef).First(&payment).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetPaymentByProviderRef")
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func GetPaymentsByOrderId(ctx context.Context, orderId string) ([]Payment, error) {
+	var payments []Payment
+	if err := database.DB.WithContext(ctx).Where("order_id = ?", orderId).Order("attempt ASC").Find(&payments).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetPaymentsByOrderId")
+		return nil, err
+	}
+	return payments, nil
+}
+
+// CompletePayment applies a verified payment.succeeded webhook. It is idempotent: providers retry
+// deliveries, and a payment that is already completed is returned unchanged. A payment for an order
+// another attempt has paid already is left waiting for a refund and the order is not touched.
+func CompletePayment(ctx context.Context, provider, providerRef string, amount int) (*Payment, error) {
+	var payment Payment
+	completed := false
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("provider = ? AND provider_ref = ?", provider, providerRef).First(&payment).Error; err != nil {
+			return err
+		}
+		// anything past pending or failed has already been applied, e.g. completed and later refunded
+		if payment.Status != PaymentStatusPending && payment.Status != PaymentStatusFailed {
+			return nil
+		}
+		if payment.Amount != amount {
+			return fmt.Errorf("paid amount %d does not match payment amount %d", amount, payment.Amount)
+		}
+
+		now := time.Now()
+		result := tx.Model(&Payment{}).
+			Where("id = ? AND status IN ?", payment.Id, []string{PaymentStatusPending, PaymentStatusFailed}).
+			Updates(map[string]any{"status": PaymentStatusCompleted, "completed_at": now, "failure_reason": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		payment.Status = PaymentStatusCompleted
+		payment.CompletedAt = &now
+		completed = true
+
+		var order Order
+		if err := tx.Where("id = ?", payment.OrderId).First(&order).Error; err != nil {
+			return err
+		}
+
+		// money arrived for an order that was cancelled meanwhile, it has to go back
+		paymentStatus := PaymentStatusCompleted
+		if order.Status == OrderStatusCancelled {
+			paymentStatus = PaymentStatusRefundPending
+		}
+		// conditional, so of two attempts completing at the same time only one pays the order
+		result = tx.Model(&Order{}).Where("id = ? AND payment_status NOT IN ?", order.Id, paidOrderStatuses).
+			Updates(map[string]any{"payment_status": paymentStatus, "payment_mode": payment.Method})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			logging.Ctx(ctx).Warn().Str("orderId", order.Id).Str("paymentId", payment.Id).Msg("Order was paid already, payment left for a refund")
+			payment.Status = PaymentStatusRefundPending
+			return tx.Model(&Payment{}).Where("id = ?", payment.Id).Update("status", PaymentStatusRefundPending).Error
+		}
+		if order.Status == OrderStatusCancelled {
+			return nil
+		}
+		if order.Status == OrderStatusPending {
+			if err := TransitionOrderStatus(tx, &order, OrderStatusConfirmed, ChangedBySystem, "payment completed via "+provider); err != nil {
+				return err
+			}
+		}
+		return CommitReservations(tx, order.Id)
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CompletePayment")
+		return nil, err
+	}
+	if completed {
+		metrics.PaymentsCompleted.WithLabelValues(provider).Inc()
+	}
+	return &payment, nil
+}
+
+// FailPayment records a failed attempt. The order stays pending so the customer can retry.
+func FailPayment(ctx context.Context, provider, providerRef, reason string) (*Payment, error) {
+	payment, err := GetPaymentByProviderRef(ctx, provider, providerRef)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != PaymentStatusPending {
+		return payment, nil
+	}
+	result := database.DB.WithContext(ctx).Model(&Payment{}).Where("id = ? AND status = ?", payment.Id, PaymentStatusPending).
+		Updates(map[string]any{"status": PaymentStatusFailed, "failure_reason": reason})
+	if result.Error != nil {
+		logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in FailPayment")
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		metrics.PaymentsFailed.WithLabelValues(provider).Inc()
+	}
+	payment.Status = PaymentStatusFailed
+	payment.FailureReason = reason
+	return payment, nil
+}
+
+// ConfirmCashOnDelivery switches the order to cash on delivery and confirms it. The payment itself
+// stays pending until the cash is collected.
+func ConfirmCashOnDelivery(ctx context.Context, orderId, changedBy string) (*Order, error) {
+	var order Order
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", orderId).First(&order).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Order{}).Where("id = ?", orderId).Update("payment_mode", "cod").Error; err != nil {
+			return err
+		}
+		order.PaymentMode = "cod"
+		if order.Status == OrderStatusPending {
+			if err := TransitionOrderStatus(tx, &order, OrderStatusConfirmed, changedBy, "cash on delivery"); err != nil {
+				return err
+			}
+		}
+		return CommitReservations(tx, orderId)
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ConfirmCashOnDelivery")
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ClaimPaymentRefund takes a payment that is waiting for a refund, e.g. a second payment of an
+// order that was paid already, and moves it to refunding. Only one caller gets it, the others get
+// ErrPaymentNotRefundable, so the money is sent back once.
+func ClaimPaymentRefund(ctx context.Context, paymentId string) (*Payment, error) {
+	result := database.DB.WithContext(ctx).Model(&Payment{}).
+		Where("id = ? AND status = ?", paymentId, PaymentStatusRefundPending).
+		Update("status", PaymentStatusRefunding)
+	if result.Error != nil {
+		logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in ClaimPaymentRefund")
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	var payment Payment
+	if err := database.DB.WithContext(ctx).Where("id = ?", paymentId).First(&payment).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimPaymentRefund")
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// CompletePaymentRefund records that the provider gave back the whole of a claimed payment.
+func CompletePaymentRefund(ctx context.Context, paymentId string) (*Payment, error) {
+	result := database.DB.WithContext(ctx).Model(&Payment{}).
+		Where("id = ? AND status = ?", paymentId, PaymentStatusRefunding).
+		Updates(map[string]any{"status": PaymentStatusRefunded, "refunded_amount": gorm.Expr("amount")})
+	if result.Error != nil {
+		logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in CompletePaymentRefund")
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	var payment Payment
+	if err := database.DB.WithContext(ctx).Where("id = ?", paymentId).First(&payment).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CompletePaymentRefund")
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ReleasePaymentRefund puts a claimed payment back to waiting after the provider refused the refund.
+func ReleasePaymentRefund(ctx context.Context, paymentId string) error {
+	if err := database.DB.WithContext(ctx).Model(&Payment{}).
+		Where("id = ? AND status = ?", paymentId, PaymentStatusRefunding).
+		Update("status", PaymentStatusRefundPending).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReleasePaymentRefund")
+		return err
+	}
+	return nil
+}
+
+// RefundableAmount is how much of what the customer paid has not been given back yet.
+func (o *Order) RefundableAmount() int {
+	switch o.PaymentStatus {
+	case PaymentStatusCompleted, PaymentStatusPartiallyRefunded, PaymentStatusRefundPending:
+		return o.TotalAmount - o.RefundedAmount
+	}
+	return 0
+}
+
+// GetRefundablePayment finds the provider payment of the order that can still cover the refund.
+// Cash on delivery orders have none and are refunded outside of any provider.
+func GetRefundablePayment(ctx context.Context, orderId string, amount int) (*Payment, error) {
+	var payment Payment
+	if err := database.DB.WithContext(ctx).
+		Where("order_id = ? AND status IN ? AND amount - refunded_amount >= ?", orderId, []string{PaymentStatusCompleted, PaymentStatusPartiallyRefunded}, amount).
+		Order("completed_at DESC").
+		First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func refundStatus(refunded, total int) string {
+	if refunded >= total {
+		return PaymentStatusRefunded
+	}
+	return PaymentStatusPartiallyRefunded
+}
+
+// ApplyRefund books a refund that the provider has already accepted. Both updates are conditional,
+// so concurrent refunds can never give back more than was paid. Once everything is refunded the
+// order moves to refunded when its current status allows it.
+func ApplyRefund(tx *gorm.DB, orderId string, paymentId *string, amount int, changedBy string) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	result := tx.Model(&Order{}).
+		Where("id = ? AND refunded_amount + ? <= total_amount", orderId, amount).
+		UpdateColumn("refunded_amount", gorm.Expr("refunded_amount + ?", amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRefundExceedsPaid
+	}
+
+	if paymentId != nil {
+		var payment Payment
+		if err := tx.Where("id = ?", *paymentId).First(&payment).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Payment{}).
+			Where("id = ? AND refunded_amount + ? <= amount", payment.Id, amount).
+			Updates(map[string]any{
+				"refunded_amount": gorm.Expr("refunded_amount + ?", amount),
+				"status":          refundStatus(payment.RefundedAmount+amount, payment.Amount),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefundExceedsPaid
+		}
+	}
+
+	var order Order
+	if err := tx.Where("id = ?", orderId).First(&order).Error; err != nil {
+		return err
+	}
+	paymentStatus := refundStatus(order.RefundedAmount, order.TotalAmount)
+	if err := tx.Model(&Order{}).Where("id = ?", orderId).Update("payment_status", paymentStatus).Error; err != nil {
+		return err
+	}
+
+	if paymentStatus == PaymentStatusRefunded && CanTransition(order.Status, OrderStatusRefunded) {
+		return TransitionOrderStatus(tx, &order, OrderStatusRefunded, changedBy, "order fully refunded")
+	}
+	return nil
+}
+
+// ClaimOrderRefund moves the order's payment status to refunding before any money is sent, so a
+// concurrent or retried refund of the order fails with ErrRefundInProgress. The order comes back as
+// it was before the claim, with the amount the caller may refund: what is refundable less the
+// refunds owed to received returns, which are paid through the returns.
+//
+// The returns are counted after the claim, and ClaimReturnRefund checks the order after claiming
+// the return, so of an order refund and a return refund racing at least one sees the other.
+func ClaimOrderRefund(ctx context.Context, orderId string) (*Order, int, error) {
+	var order Order
+	if err := database.DB.WithContext(ctx).Where("id = ?", orderId).First(&order).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimOrderRefund")
+		return nil, 0, err
+	}
+	if order.PaymentStatus == PaymentStatusRefunding {
+		return nil, 0, ErrRefundInProgress
+	}
+	if order.RefundableAmount() <= 0 {
+		return nil, 0, ErrNothingToRefund
+	}
+
+	result := database.DB.WithContext(ctx).Model(&Order{}).
+		Where("id = ? AND payment_status = ? AND refunded_amount = ?", order.Id, order.PaymentStatus, order.RefundedAmount).
+		Update("payment_status", PaymentStatusRefunding)
+	if result.Error != nil {
+		logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in ClaimOrderRefund")
+		return nil, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, 0, ErrRefundInProgress
+	}
+
+	var owedToReturns int
+	err := database.DB.WithContext(ctx).Model(&ReturnRequest{}).
+		Where("order_id = ? AND status IN ?", order.Id, []string{ReturnStatusReceived, ReturnStatusRefunding}).
+		Select("COALESCE(SUM(refund_amount), 0)").Scan(&owedToReturns).Error
+	amount := order.RefundableAmount() - owedToReturns
+	if err == nil && amount <= 0 {
+		err = fmt.Errorf("%w: the rest is owed to returns waiting for their refund", ErrNothingToRefund)
+	}
+	if err != nil {
+		if releaseErr := ReleaseOrderRefund(ctx, order.Id, order.PaymentStatus); releaseErr != nil {
+			return nil, 0, releaseErr
+		}
+		if !errors.Is(err, ErrNothingToRefund) {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimOrderRefund")
+		}
+		return nil, 0, err
+	}
+	return &order, amount, nil
+}
+
+// ReleaseOrderRefund gives a claimed order its payment status back after the provider refused the refund.
+func ReleaseOrderRefund(ctx context.Context, orderId, paymentStatus string) error {
+	if err := database.DB.WithContext(ctx).Model(&Order{}).
+		Where("id = ? AND payment_status = ?", orderId, PaymentStatusRefunding).
+		Update("payment_status", paymentStatus).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReleaseOrderRefund")
+		return err
+	}
+	return nil
+}
+
+// RefundOrder records a refund of the order as a whole, e.g. the money of a cancelled paid order.
+func RefundOrder(ctx context.Context, orderId string, paymentId *string, amount int, changedBy string) (*Order, error) {
+	var order Order
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ApplyRefund(tx, orderId, paymentId, amount, changedBy); err != nil {
+			return err
+		}
+		return tx.Where("id = ?", orderId).First(&order).Error
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RefundOrder")
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// newPayment stores a pending provider payment for the whole order.
+func newPayment(t *testing.T, order *Order) *Payment {
+	t.Helper()
+	payment := Payment{OrderId: order.Id, Provider: "fake", ProviderRef: "pi_" + uuid.New().String(), Method: "upi", Amount: order.TotalAmount}
+	created, err := payment.CreatePayment(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func applyRefund(orderId string, paymentId *string, amount int) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return ApplyRefund(tx, orderId, paymentId, amount, "admin-1")
+	})
+}
+
+func TestApplyRefund(t *testing.T) {
+	fx := newFixture(t, 10)
+	order := fx.placeOrder(t, 2, "upi")
+	payment := newPayment(t, order)
+	if _, err := CompletePayment(fx.ctx, payment.Provider, payment.ProviderRef, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CancelOrder(fx.ctx, order.Id, fx.user.Id, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyRefund(order.Id, &payment.Id, 500); err != nil {
+		t.Fatal(err)
+	}
+	stored := loadOrder(t, order.Id)
+	if stored.RefundedAmount != 500 || stored.PaymentStatus != PaymentStatusPartiallyRefunded || stored.Status != OrderStatusCancelled {
+		t.Fatalf("after a partial refund order = %d / %s / %s", stored.RefundedAmount, stored.PaymentStatus, stored.Status)
+	}
+	if got := stored.RefundableAmount(); got != 1500 {
+		t.Fatalf("refundable = %d, want 1500", got)
+	}
+
+	// more than is left is refused and changes nothing
+	if err := applyRefund(order.Id, &payment.Id, 1600); !errors.Is(err, ErrRefundExceedsPaid) {
+		t.Fatalf("err = %v, want ErrRefundExceedsPaid", err)
+	}
+	if got := loadOrder(t, order.Id).RefundedAmount; got != 500 {
+		t.Fatalf("refunded = %d after a refused refund, want 500", got)
+	}
+
+	if err := applyRefund(order.Id, &payment.Id, 1500); err != nil {
+		t.Fatal(err)
+	}
+	stored = loadOrder(t, order.Id)
+	if stored.RefundedAmount != 2000 || stored.PaymentStatus != PaymentStatusRefunded || stored.Status != OrderStatusRefunded {
+		t.Fatalf("after the full refund order = %d / %s / %s", stored.RefundedAmount, stored.PaymentStatus, stored.Status)
+	}
+	var refundedPayment Payment
+	if err := database.DB.Where("id = ?", payment.Id).First(&refundedPayment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if refundedPayment.RefundedAmount != 2000 || refundedPayment.Status != PaymentStatusRefunded {
+		t.Fatalf("payment = %d / %s, want 2000 / refunded", refundedPayment.RefundedAmount, refundedPayment.Status)
+	}
+}
+
+func TestApplyRefundWithoutPayment(t *testing.T) {
+	fx := newFixture(t, 10)
+	order := fx.placeOrder(t, 1, "cod")
+	if err := database.DB.Model(&Order{}).Where("id = ?", order.Id).Update("payment_status", PaymentStatusCompleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// cash on delivery is refunded by hand, only the order keeps count
+	if err := applyRefund(order.Id, nil, 400); err != nil {
+		t.Fatal(err)
+	}
+	if stored := loadOrder(t, order.Id); stored.RefundedAmount != 400 || stored.PaymentStatus != PaymentStatusPartiallyRefunded {
+		t.Fatalf("order = %d / %s", stored.RefundedAmount, stored.PaymentStatus)
+	}
+}
+
+func TestCompletePaymentOfPaidOrder(t *testing.T) {
+	fx := newFixture(t, 10)
+	order := fx.placeOrder(t, 1, "upi")
+	first, second := newPayment(t, order), newPayment(t, order)
+
+	if _, err := CompletePayment(fx.ctx, first.Provider, first.ProviderRef, first.Amount); err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err := CompletePayment(fx.ctx, second.Provider, second.ProviderRef, second.Amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.Status != PaymentStatusRefundPending {
+		t.Fatalf("second payment = %s, want refund_pending", duplicate.Status)
+	}
+	stored := loadOrder(t, order.Id)
+	if stored.Status != OrderStatusConfirmed || stored.PaymentStatus != PaymentStatusCompleted || stored.RefundableAmount() != order.TotalAmount {
+		t.Fatalf("order = %s / %s / %d", stored.Status, stored.PaymentStatus, stored.RefundableAmount())
+	}
+
+	// the webhook is retried, nothing changes
+	if again, err := CompletePayment(fx.ctx, second.Provider, second.ProviderRef, second.Amount); err != nil || again.Status != PaymentStatusRefundPending {
+		t.Fatalf("retry = %v, %v", again, err)
+	}
+
+	claimed, err := ClaimPaymentRefund(fx.ctx, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimPaymentRefund(fx.ctx, second.Id); !errors.Is(err, ErrPaymentNotRefundable) {
+		t.Fatalf("second claim err = %v, want ErrPaymentNotRefundable", err)
+	}
+	refunded, err := CompletePaymentRefund(fx.ctx, claimed.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != PaymentStatusRefunded || refunded.RefundedAmount != refunded.Amount {
+		t.Fatalf("refunded payment = %s / %d", refunded.Status, refunded.RefundedAmount)
+	}
+	if got := loadOrder(t, order.Id).RefundedAmount; got != 0 {
+		t.Fatalf("order refunded = %d, the order's own payment was not touched", got)
+	}
+}
+
+func TestClaimOrderRefund(t *testing.T) {
+	fx := newFixture(t, 10)
+	order := fx.placeOrder(t, 1, "upi")
+
+	if _, _, err := ClaimOrderRefund(fx.ctx, order.Id); !errors.Is(err, ErrNothingToRefund) {
+		t.Fatalf("unpaid order: err = %v, want ErrNothingToRefund", err)
+	}
+
+	payment := newPayment(t, order)
+	if _, err := CompletePayment(fx.ctx, payment.Provider, payment.ProviderRef, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+	claimed, amount, err := ClaimOrderRefund(fx.ctx, order.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount != order.TotalAmount {
+		t.Fatalf("claimed amount = %d, want %d", amount, order.TotalAmount)
+	}
+	if _, _, err := ClaimOrderRefund(fx.ctx, order.Id); !errors.Is(err, ErrRefundInProgress) {
+		t.Fatalf("second claim err = %v, want ErrRefundInProgress", err)
+	}
+
+	if err := ReleaseOrderRefund(fx.ctx, order.Id, claimed.PaymentStatus); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadOrder(t, order.Id).PaymentStatus; got != PaymentStatusCompleted {
+		t.Fatalf("released payment status = %s, want completed", got)
+	}
+}
+
+// receivedReturn stores a return of the order's first line that is waiting for its refund.
+func receivedReturn(t *testing.T, fx *fixture, order *Order, refund int) *ReturnRequest {
+	t.Helper()
+	rr := ReturnRequest{OrderId: order.Id, OrderItemId: order.OrderItems[0].Id, UserId: fx.user.Id, ProductId: fx.product.Id,
+		Quantity: 1, Reason: ReturnReasonDamaged, Status: ReturnStatusReceived, RefundAmount: refund}
+	mustCreate(t, &rr)
+	return &rr
+}
+
+func TestClaimOrderRefundLeavesReturnRefunds(t *testing.T) {
+	fx := newFixture(t, 10)
+	order := fx.placeOrder(t, 2, "upi")
+	payment := newPayment(t, order)
+	if _, err := CompletePayment(fx.ctx, payment.Provider, payment.ProviderRef, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+	rr := receivedReturn(t, fx, order, 1000)
+
+	// the order refund only covers what the return is not owed
+	_, amount, err := ClaimOrderRefund(fx.ctx, order.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount != 1000 {
+		t.Fatalf("claimed amount = %d, want 1000", amount)
+	}
+
+	// and while it is in flight the return waits
+	if _, err := ClaimReturnRefund(fx.ctx, rr.Id); !errors.Is(err, ErrRefundInProgress) {
+		t.Fatalf("return claim err = %v, want ErrRefundInProgress", err)
+	}
+	var stored ReturnRequest
+	if err := database.DB.Where("id = ?", rr.Id).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != ReturnStatusReceived {
+		t.Fatalf("return status = %s, want received", stored.Status)
+	}
+
+	if _, err := RefundOrder(fx.ctx, order.Id, &payment.Id, amount, "admin-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimReturnRefund(fx.ctx, rr.Id); err != nil {
+		t.Fatalf("return claim after the order refund: %v", err)
+	}
+	// everything left belongs to the return now
+	if _, _, err := ClaimOrderRefund(fx.ctx, order.Id); !errors.Is(err, ErrNothingToRefund) {
+		t.Fatalf("claim with the return refunding: err = %v, want ErrNothingToRefund", err)
+	}
+	if got := loadOrder(t, order.Id).PaymentStatus; got != PaymentStatusPartiallyRefunded {
+		t.Fatalf("payment status = %s, want partially_refunded", got)
+	}
+}
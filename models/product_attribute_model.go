@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// ProductAttributeValue is the value of one attribute for a product, or for one of its variants
+// when the attribute is set per variant. Exactly one of ProductId and VariantId is set.
+type ProductAttributeValue struct {
+	Id          string             `gorm:"primaryKey;type:varchar(191)"`
+	ProductId   *string            `gorm:"type:varchar(191);index"`
+	VariantId   *string            `gorm:"type:varchar(191);index"`
+	AttributeId string             `gorm:"not null;type:varchar(191);index:idx_attribute_values_lookup,priority:1"`
+	Attribute   *CategoryAttribute `gorm:"foreignKey:AttributeId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Value is the value as text: the enum option, the text, "true"/"false" or the number
+	Value string `gorm:"not null;type:varchar(255);index:idx_attribute_values_lookup,priority:2"`
+	// Number is set for number attributes so listings can filter by range
+	Number    *float64 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (v *ProductAttributeValue) BeforeCreate(tx *gorm.DB) error {
+	v.Id = uuid.New().String()
+	return nil
+}
+
+// MarshalJSON shows the value with its attribute and in its own type. Attribute has to be loaded.
+func (v ProductAttributeValue) MarshalJSON() ([]byte, error) {
+	type attributeValue struct {
+		AttributeId string `json:"attributeId"`
+		Key         string `json:"key,omitempty"`
+		Name        string `json:"name,omitempty"`
+		Type        string `json:"type,omitempty"`
+		Unit        string `json:"unit,omitempty"`
+		Value       any    `json:"value"`
+	}
+	out := attributeValue{AttributeId: v.AttributeId, Value: v.Value}
+	if a := v.Attribute; a != nil {
+		out.Key, out.Name, out.Type, out.Unit = a.Key, a.Name, a.Type, a.Unit
+		switch {
+		case a.Type == AttributeNumber && v.Number != nil:
+			out.Value = *v.Number
+		case a.Type == AttributeBoolean:
+			out.Value, _ = strconv.ParseBool(v.Value)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// AttributeInput turns stored values back into what a client sends, keyed by attribute key, so
+// they can be checked again against another schema. Attribute has to be loaded.
+func AttributeInput(values []ProductAttributeValue) map[string]any {
+	input := make(map[string]any, len(values))
+	for _, value := range values {
+		if value.Attribute != nil {
+			input[value.Attribute.Key] = value.Value
+		}
+	}
+	return input
+}
+
+// ReplaceAttributeValues swaps the attribute values of a product, or of a variant when variantId
+// is set, for values.
+func ReplaceAttributeValues(ctx context.Context, productId, variantId *string, values []ProductAttributeValue) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceAttributeValues(tx, productId, variantId, values)
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReplaceAttributeValues")
+		return err
+	}
+	return nil
+}
+
+// replaceAttributeValues is ReplaceAttributeValues inside tx.
+func replaceAttributeValues(tx *gorm.DB, productId, variantId *string, values []ProductAttributeValue) error {
+	owner, id := "product_id = ?", productId
+	if variantId != nil {
+		owner, id = "variant_id = ?", variantId
+	}
+	if err := tx.Where(owner, *id).Delete(&ProductAttributeValue{}).Error; err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	for i := range values {
+		values[i].ProductId, values[i].VariantId = productId, variantId
+		if variantId != nil {
+			values[i].ProductId = nil
+		}
+	}
+	return tx.Omit("Attribute").Create(&values).Error
+}
+
+// attributeMatch keeps the products with a value for the attribute key that passes the condition
+// in %s, set on the product itself or on one of its active variants.
+const attributeMatch = "EXISTS (SELECT 1 FROM product_attribute_values pav " +
+	"JOIN category_attributes ca ON ca.id = pav.attribute_id " +
+	"LEFT JOIN product_variants pv ON pv.id = pav.variant_id " +
+	"WHERE (pav.product_id = products.id OR (pv.product_id = products.id AND pv.is_active = true)) " +
+	"AND ca.key = ? AND %s)"
+
+// AttributeEquals is the product listing condition for attr.<key>=raw. Text compares ignoring
+// case, a number also matches numerically so 10 finds 10.0.
+func AttributeEquals(key, raw string) (string, []any) {
+	if number, err := strconv.ParseFloat(raw, 64); err == nil {
+		return fmt.Sprintf(attributeMatch, "(pav.number = ? OR LOWER(pav.value) = LOWER(?))"), []any{key, number, raw}
+	}
+	return fmt.Sprintf(attributeMatch, "LOWER(pav.value) = LOWER(?)"), []any{key, raw}
+}
+
+// AttributeAtLeast is the product listing condition for attr.<key>.min, number attributes only.
+func AttributeAtLeast(key string, least float64) (string, []any) {
+	return fmt.Sprintf(attributeMatch, "pav.number >= ?"), []any{key, least}
+}
+
+// AttributeAtMost is the product listing condition for attr.<key>.max, number attributes only.
+func AttributeAtMost(key string, most float64) (string, []any) {
+	return fmt.Sprintf(attributeMatch, "pav.number <= ?"), []any{key, most}
+}
@@ -0,0 +1,477 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// importClaimLease keeps other instances off a running import. The worker renews it every time it
+// saves progress, a job whose lease ran out was left by an instance that stopped and starts over.
+var importClaimLease = 2 * time.Minute
+
+// ProductImportJob is one uploaded catalog file and how far the import of it got. A dry run goes
+// through every row the same way but rolls each one back, its counts say what the import would do.
+type ProductImportJob struct {
+	Id          string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	Format      string     `gorm:"not null;type:varchar(10)" json:"format"`
+	FileName    string     `gorm:"type:varchar(255)" json:"fileName,omitempty"`
+	DryRun      bool       `gorm:"not null;default:false" json:"dryRun"`
+	Status      string     `gorm:"not null;type:varchar(20);index:idx_product_imports_due,priority:1" json:"status"`
+	LockedUntil *time.Time `gorm:"index:idx_product_imports_due,priority:2" json:"-"`
+	Payload     []byte     `json:"-"`
+	CreatedBy   string     `gorm:"type:varchar(191)" json:"createdBy,omitempty"`
+	// TotalRows is the number of products in the file, a product with variants spans several CSV lines
+	TotalRows int              `gorm:"not null;default:0" json:"totalRows"`
+	Processed int              `gorm:"not null;default:0" json:"processed"`
+	Created   int              `gorm:"not null;default:0" json:"created"`
+	Updated   int              `gorm:"not null;default:0" json:"updated"`
+	Failed    int              `gorm:"not null;default:0" json:"failed"`
+	Errors    []ImportRowError `gorm:"serializer:json" json:"errors"`
+	// Error is why the whole file could not be imported, e.g. a CSV header with an unknown column
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// ImportRowError is one problem with one product of an import file. Line is where the product
+// starts in the file, Field uses the names of the product API (variants[1].sku, attributes.size).
+type ImportRowError struct {
+	Line    int    `json:"line"`
+	SKU     string `json:"sku,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (j *ProductImportJob) BeforeCreate(tx *gorm.DB) error {
+	j.Id = uuid.New().String()
+	if j.Status == "" {
+		j.Status = ImportStatusPending
+	}
+	return nil
+}
+
+/*
+CreateProductImport(ctx context.Context) (*ProductImportJob, error)
+
+GetProductImportById(ctx context.Context, id string) (*ProductImportJob, error)
+
+ClaimProductImport(ctx context.Context) (*ProductImportJob, error)
+
+SaveProductImportProgress(ctx context.Context, job *ProductImportJob) error
+
+FinishProductImport(ctx context.Context, job *ProductImportJob, cause error) error
+*/
+
+func (j *ProductImportJob) CreateProductImport(ctx context.Context) (*ProductImportJob, error) {
+	if err := database.DB.WithContext(ctx).Create(j).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateProductImport")
+		return nil, err
+	}
+	return j, nil
+}
+
+// GetProductImportById loads a job without its file, for showing its progress.
+func GetProductImportById(ctx context.Context, id string) (*ProductImportJob, error) {
+	var job ProductImportJob
+	if err := database.DB.WithContext(ctx).Omit("payload").Where("id = ?", id).First(&job).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetProductImportById")
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimProductImport takes the oldest import waiting to run, or one whose worker went away, and
+// returns it with its file. It returns gorm.ErrRecordNotFound when there is nothing to do.
+func ClaimProductImport(ctx context.Context) (*ProductImportJob, error) {
+	now := time.Now()
+	claimable := "status = ? OR (status = ? AND locked_until < ?)"
+	var candidates []string
+	if err := database.DB.WithContext(ctx).Model(&ProductImportJob{}).
+		Where(claimable, ImportStatusPending, ImportStatusRunning, now).
+		Order("created_at ASC").Limit(5).Pluck("id", &candidates).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimProductImport")
+		return nil, err
+	}
+
+	for _, id := range candidates {
+		// a job picked up again starts over, the rows it already imported are upserted a second time
+		result := database.DB.WithContext(ctx).Model(&ProductImportJob{}).
+			Where("id = ?", id).Where(claimable, ImportStatusPending, ImportStatusRunning, now).
+			Updates(map[string]any{
+				"status":       ImportStatusRunning,
+				"locked_until": now.Add(importClaimLease),
+				"started_at":   now,
+				"processed":    0,
+				"created":      0,
+				"updated":      0,
+				"failed":       0,
+				"errors":       nil,
+				"updated_at":   now,
+			})
+		if result.Error != nil {
+			logging.Ctx(ctx).Err(result.Error).Msg("Issue exist in ClaimProductImport")
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		var job ProductImportJob
+		if err := database.DB.WithContext(ctx).Where("id = ?", id).First(&job).Error; err != nil {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimProductImport")
+			return nil, err
+		}
+		return &job, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// SaveProductImportProgress stores the counts and errors so far and renews the job's lease.
+func SaveProductImportProgress(ctx context.Context, job *ProductImportJob) error {
+	lockedUntil := time.Now().Add(importClaimLease)
+	job.LockedUntil = &lockedUntil
+	err := database.DB.WithContext(ctx).Model(job).
+		Select("total_rows", "processed", "created", "updated", "failed", "errors", "locked_until").
+		Updates(job).Error
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in SaveProductImportProgress")
+		return err
+	}
+	return nil
+}
+
+// FinishProductImport marks the job completed, or failed with cause, and drops its file.
+func FinishProductImport(ctx context.Context, job *ProductImportJob, cause error) error {
+	now := time.Now()
+	job.Status, job.FinishedAt, job.LockedUntil, job.Payload = ImportStatusCompleted, &now, nil, nil
+	if cause != nil {
+		job.Status, job.Error = ImportStatusFailed, cause.Error()
+	}
+	err := database.DB.WithContext(ctx).Model(job).
+		Select("status", "total_rows", "processed", "created", "updated", "failed", "errors", "error", "payload", "locked_until", "finished_at").
+		Updates(job).Error
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in FinishProductImport")
+		return err
+	}
+	return nil
+}
+
+// ErrImportSKUTaken is returned by ImportProduct for a variant SKU another product already uses.
+var ErrImportSKUTaken = errors.New("sku is already used by another product")
+
+// errDryRun rolls back the transaction of a dry run import, it never leaves ImportProduct.
+var errDryRun = errors.New("dry run")
+
+// GetProductBySKU loads the product with the SKU for an import, soft deleted ones included since
+// the SKU stays taken, with its variants, images and attribute values.
+func GetProductBySKU(ctx context.Context, sku string) (*Product, error) {
+	var product Product
+	err := database.DB.WithContext(ctx).Unscoped().
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+		Preload("Variants.Attributes.Attribute").
+		Preload("Images", func(tx *gorm.DB) *gorm.DB { return tx.Order("sort_order") }).
+		Preload("Attributes.Attribute").
+		Where("sku = ?", sku).First(&product).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in GetProductBySKU")
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// ImportStock is the stock an import file gives for a product and its variants, by the index in
+// p.Variants. A nil count leaves the stored stock alone.
+//
+// The counts are on hand: they still include what unpaid orders hold. Order creation already took
+// that stock and an expired hold puts it back, so the active reservations are subtracted before
+// the count is stored.
+type ImportStock struct {
+	Product  *int
+	Variants []*int
+}
+
+func (s ImportStock) variant(i int) *int {
+	if i < len(s.Variants) {
+		return s.Variants[i]
+	}
+	return nil
+}
+
+// ImportProduct creates p, or updates the product p.Id names, with its variants, images and
+// attribute values in one transaction. The stock comes from stock, not from p.
+//
+// On update the variants with an Id are updated, the others created, and the product's variants
+// left out of p.Variants are deactivated rather than deleted, carts and orders still point at
+// them. Images are replaced when p.Images is not nil. A soft deleted product comes back.
+func ImportProduct(ctx context.Context, p *Product, stock ImportStock, dryRun bool) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkVariantSKUs(tx, p); err != nil {
+			return err
+		}
+		var err error
+		if p.Id == "" {
+			err = createImportedProduct(tx, p, stock)
+		} else {
+			err = updateImportedProduct(tx, p, stock)
+		}
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	if err != nil {
+		if !errors.Is(err, ErrImportSKUTaken) {
+			logging.Ctx(ctx).Err(err).Str("sku", p.SKU).Msg("Issue exist in ImportProduct")
+		}
+		return err
+	}
+	RefreshProductSearch(ctx, p.Id)
+	return nil
+}
+
+// checkVariantSKUs makes sure no variant of p takes a SKU that belongs to another product's variant.
+func checkVariantSKUs(tx *gorm.DB, p *Product) error {
+	skus := make([]string, 0, len(p.Variants))
+	for _, variant := range p.Variants {
+		if variant.SKU != "" {
+			skus = append(skus, variant.SKU)
+		}
+	}
+	if len(skus) == 0 {
+		return nil
+	}
+	var taken int64
+	if err := tx.Model(&ProductVariant{}).Where("sku IN ? AND product_id <> ?", skus, p.Id).Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrImportSKUTaken
+	}
+	return nil
+}
+
+// createImportedProduct creates p with its variants. is_active defaults to true, so a product or
+// variant imported inactive is switched off after the insert that skipped the false.
+func createImportedProduct(tx *gorm.DB, p *Product, stock ImportStock) error {
+	p.Stock = 0
+	if stock.Product != nil {
+		p.Stock = *stock.Product
+	}
+	// the insert may read the default back into the flags
+	isActive := p.IsActive
+	variantActive := make([]bool, len(p.Variants))
+	for i := range p.Variants {
+		variantActive[i] = p.Variants[i].IsActive
+		p.Variants[i].Stock = 0
+		if count := stock.variant(i); count != nil {
+			p.Variants[i].Stock = *count
+		}
+	}
+	if err := tx.Create(p).Error; err != nil {
+		return err
+	}
+	if !isActive {
+		if err := tx.Model(&Product{}).Where("id = ?", p.Id).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		p.IsActive = false
+	}
+	for i := range p.Variants {
+		if variantActive[i] {
+			continue
+		}
+		if err := tx.Model(&ProductVariant{}).Where("id = ?", p.Variants[i].Id).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		p.Variants[i].IsActive = false
+	}
+	return nil
+}
+
+func updateImportedProduct(tx *gorm.DB, p *Product, stock ImportStock) error {
+	held, err := heldStock(tx, []string{p.Id})
+	if err != nil {
+		return err
+	}
+	onHand := func(count int, id string) int {
+		return max(count-held[id], 0)
+	}
+
+	p.UpdatedAt = time.Now()
+	columns := map[string]any{
+		"name":            p.Name,
+		"description":     p.Description,
+		"price":           p.Price,
+		"category_id":     p.CategoryId,
+		"is_active":       p.IsActive,
+		"min_stock_level": p.MinStockLevel,
+		"max_stock_level": p.MaxStockLevel,
+		"reorder_point":   p.ReorderPoint,
+		"barcode":         p.Barcode,
+		"weight":          p.Weight,
+		"dimensions":      p.Dimensions,
+		"deleted_at":      nil,
+		"updated_at":      p.UpdatedAt,
+	}
+	if stock.Product != nil {
+		columns["stock"] = onHand(*stock.Product, p.Id)
+	}
+	if err := tx.Unscoped().Model(&Product{}).Where("id = ?", p.Id).Updates(columns).Error; err != nil {
+		return err
+	}
+	if err := replaceAttributeValues(tx, &p.Id, nil, p.Attributes); err != nil {
+		return err
+	}
+
+	kept := make([]string, 0, len(p.Variants))
+	for i := range p.Variants {
+		variant := &p.Variants[i]
+		variant.ProductId = p.Id
+		values := variant.Attributes
+		variant.Attributes = nil
+
+		count := stock.variant(i)
+		if variant.Id == "" {
+			isActive := variant.IsActive
+			variant.Stock = 0
+			if count != nil {
+				variant.Stock = *count
+			}
+			if err := tx.Omit(clause.Associations).Create(variant).Error; err != nil {
+				return err
+			}
+			if !isActive {
+				if err := tx.Model(&ProductVariant{}).Where("id = ?", variant.Id).Update("is_active", false).Error; err != nil {
+					return err
+				}
+				variant.IsActive = false
+			}
+		} else {
+			columns := map[string]any{
+				"variant_name":     variant.VariantName,
+				"variant_value":    variant.VariantValue,
+				"price_adjustment": variant.PriceAdjustment,
+				"sku":              variant.SKU,
+				"is_active":        variant.IsActive,
+				"updated_at":       time.Now(),
+			}
+			if count != nil {
+				columns["stock"] = onHand(*count, variant.Id)
+			}
+			if err := tx.Model(&ProductVariant{}).Where("id = ? AND product_id = ?", variant.Id, p.Id).Updates(columns).Error; err != nil {
+				return err
+			}
+		}
+		kept = append(kept, variant.Id)
+
+		if err := replaceAttributeValues(tx, nil, &variant.Id, values); err != nil {
+			return err
+		}
+		variant.Attributes = values
+	}
+	deactivate := tx.Model(&ProductVariant{}).Where("product_id = ?", p.Id)
+	if len(kept) > 0 {
+		deactivate = deactivate.Where("id NOT IN ?", kept)
+	}
+	if err := deactivate.Update("is_active", false).Error; err != nil {
+		return err
+	}
+
+	if p.Images == nil {
+		return nil
+	}
+	if err := tx.Where("product_id = ?", p.Id).Delete(&Image{}).Error; err != nil {
+		return err
+	}
+	for i := range p.Images {
+		p.Images[i].ProductId = p.Id
+	}
+	if len(p.Images) == 0 {
+		return nil
+	}
+	return tx.Create(&p.Images).Error
+}
+
+// ExportProducts hands every product with its variants, images and attribute values to fn, batch
+// at a time, so a catalog export never holds more than one batch. Stock is on hand, as ImportStock
+// reads it.
+func ExportProducts(ctx context.Context, batch int, fn func([]Product) error) error {
+	var products []Product
+	err := database.DB.WithContext(ctx).
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+		Preload("Variants.Attributes.Attribute").
+		Preload("Images", func(tx *gorm.DB) *gorm.DB { return tx.Order("sort_order") }).
+		Preload("Attributes.Attribute").
+		FindInBatches(&products, batch, func(tx *gorm.DB, _ int) error {
+			// the files count stock on hand, what unpaid orders hold is added back
+			ids := make([]string, len(products))
+			for i := range products {
+				ids[i] = products[i].Id
+			}
+			held, err := heldStock(database.DB.WithContext(ctx), ids)
+			if err != nil {
+				return err
+			}
+			for i := range products {
+				products[i].Stock += held[products[i].Id]
+				for j := range products[i].Variants {
+					products[i].Variants[j].Stock += held[products[i].Variants[j].Id]
+				}
+			}
+			return fn(products)
+		}).Error
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ExportProducts")
+		return err
+	}
+	return nil
+}
+
+// heldStock sums the active reservations of the products, by variant id for the stock held from a
+// variant and by product id for the stock held from the product itself.
+func heldStock(tx *gorm.DB, productIds []string) (map[string]int, error) {
+	var rows []struct {
+		ProductId string
+		VariantId *string
+		Quantity  int
+	}
+	if err := tx.Model(&StockReservation{}).
+		Select("product_id, variant_id, SUM(quantity) AS quantity").
+		Where("status = ? AND product_id IN ?", ReservationActive, productIds).
+		Group("product_id, variant_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	held := make(map[string]int, len(rows))
+	for _, row := range rows {
+		id := row.ProductId
+		if row.VariantId != nil && *row.VariantId != "" {
+			id = *row.VariantId
+		}
+		held[id] += row.Quantity
+	}
+	return held, nil
+}
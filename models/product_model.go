@@ -1,15 +1,21 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
-	"github.com/rs/zerolog/log"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
 	"strings"
 	"time"
 )
 
+// ErrInsufficientStock is wrapped by every check that refuses a quantity the stock can not cover.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
 type Product struct {
 	Id          string `gorm:"primaryKey;type:varchar(191)" json:"id"`
 	Name        string `gorm:"not null" json:"name"`
@@ -18,7 +24,7 @@ type Product struct {
 	Stock       int    `gorm:"not null;default:0" json:"stock"`
 
 	CategoryId string    `gorm:"not null;type:varchar(150);column:category_id" json:"categoryId"`
-	Category   *Category `gorm:"foreignKey:category_id;references:Id;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"category"`
+	Category   *Category `gorm:"foreignKey:category_id;references:Id;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"category"`
 
 	Images []Image `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"images"`
 
@@ -28,6 +34,8 @@ type Product struct {
 	UpdatedAt time.Time      `json:"updatedAt"`
 
 	Variants []ProductVariant `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"variants"`
+	// Attributes are the values of the category's product level attributes, see CategoryAttribute
+	Attributes []ProductAttributeValue `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"attributes,omitempty"`
 
 	MinStockLevel int     `gorm:"default:5" json:"minStockLevel"`
 	MaxStockLevel int     `gorm:"default:100" json:"maxStockLevel"`
@@ -81,11 +89,12 @@ func min(a, b int) int {
 	return b
 }
 
-func (p *Product) CreateProduct() (*Product, error) {
-	if err := database.DB.Create(p).Error; err != nil {
-		log.Err(err).Msg("Issue persist in the CreateProduct")
+func (p *Product) CreateProduct(ctx context.Context) (*Product, error) {
+	if err := database.DB.WithContext(ctx).Create(p).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue persist in the CreateProduct")
 		return &Product{}, err
 	}
+	RefreshProductSearch(ctx, p.Id)
 	return p, nil
 }
 
@@ -101,7 +110,7 @@ func (p *Product) CanFulFillOrder(quantity int) bool {
 	return p.Stock >= quantity && p.IsActive
 }
 
-func (p *Product) UpdateStock(quantity int, operation string) (int, error) {
+func (p *Product) UpdateStock(ctx context.Context, quantity int, operation string) (int, error) {
 	switch operation {
 	case "add":
 		p.Stock += quantity
@@ -116,7 +125,11 @@ func (p *Product) UpdateStock(quantity int, operation string) (int, error) {
 		return p.Stock, fmt.Errorf("please add valid operation")
 	}
 	// Only update the stock field, not the whole struct with associations
-	return p.Stock, database.DB.Model(&Product{}).Where("id = ?", p.Id).Update("stock", p.Stock).Error
+	if err := database.DB.WithContext(ctx).Model(&Product{}).Where("id = ?", p.Id).Update("stock", p.Stock).Error; err != nil {
+		return p.Stock, err
+	}
+	RefreshProductSearch(ctx, p.Id)
+	return p.Stock, nil
 }
 
 func (p *Product) GetStockStatus() string {
@@ -129,130 +142,156 @@ func (p *Product) GetStockStatus() string {
 	}
 }
 
-//func (p *Product) ReserveStock(quantity int) error {
-//	if !p.CanFulFillOrder(quantity) {
-//		return fmt.Errorf("can not full fill the order")
-//	}
-//}
+// ReserveStock takes quantity out of stock inside tx. The stock check and the decrement are a single
+// conditional UPDATE, so concurrent checkouts can not both pass the check and oversell.
+func (p *Product) ReserveStock(tx *gorm.DB, quantity int) error {
+	if quantity <= 0 {
+		return fmt.Errorf("quantity must be greater than 0")
+	}
+	result := tx.Model(&Product{}).
+		Where("id = ? AND stock >= ? AND is_active = ?", p.Id, quantity, true).
+		UpdateColumn("stock", gorm.Expr("stock - ?", quantity))
+	if result.Error != nil {
+		logging.Ctx(tx.Statement.Context).Err(result.Error).Msg("Issue exist in ReserveStock")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w for product %s", ErrInsufficientStock, p.Id)
+	}
+	return nil
+}
 
-func (p *Product) RestoreStock(quantity int) (int, error) {
-	return p.UpdateStock(quantity, "add")
+// RestoreStock puts previously reserved or sold quantity back inside tx.
+func (p *Product) RestoreStock(tx *gorm.DB, quantity int) error {
+	return tx.Model(&Product{}).Where("id = ?", p.Id).UpdateColumn("stock", gorm.Expr("stock + ?", quantity)).Error
 }
 
-func (p *Product) SoftDelete() error {
-	return database.DB.Delete(p).Error
+func (p *Product) SoftDelete(ctx context.Context) error {
+	if err := database.DB.WithContext(ctx).Delete(p).Error; err != nil {
+		return err
+	}
+	RefreshProductSearch(ctx, p.Id)
+	return nil
 }
 
-func (p *Product) Restore() error {
-	return database.DB.Unscoped().Model(p).Update("deleted_at", nil).Error
+func (p *Product) Restore(ctx context.Context) error {
+	if err := database.DB.WithContext(ctx).Unscoped().Model(p).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	RefreshProductSearch(ctx, p.Id)
+	return nil
 }
 
-func (p *Product) ToggleActive() error {
+func (p *Product) ToggleActive(ctx context.Context) error {
 	p.IsActive = !p.IsActive
-	return database.DB.Save(p).Error
+	if err := database.DB.WithContext(ctx).Save(p).Error; err != nil {
+		return err
+	}
+	RefreshProductSearch(ctx, p.Id)
+	return nil
 }
 
-func GetDeleteProducts(limit, offset int) ([]Product, error) {
+func GetDeleteProducts(ctx context.Context, limit, offset int) ([]Product, error) {
 	var products []Product
-	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").Limit(limit).Offset(offset).Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue getting deleted products")
+	if err := database.DB.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL").Limit(limit).Offset(offset).Find(&products).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue getting deleted products")
 		return nil, err
 	}
 	return products, nil
 }
 
-func GetProductById(id string) (*Product, error) {
+func GetProductById(ctx context.Context, id string) (*Product, error) {
 	var product Product
-	if err := database.DB.Preload("Category").Preload("Variants").Preload("Images").Where(&Product{Id: id}).First(&product).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetProductById")
+	if err := database.DB.WithContext(ctx).Preload("Category").Preload("Variants.Attributes.Attribute").Preload("Images").
+		Preload("Attributes.Attribute").Where(&Product{Id: id}).First(&product).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetProductById")
 		return nil, err
 	}
 	return &product, nil
 }
 
-func GetProductsByCategoryId(categoryId string, limit, offset int) ([]Product, error) {
-	var products []Product
-
-	if err := database.DB.Where(&Product{CategoryId: categoryId}).
-		Preload("Category").
-		Preload("Variants").
-		Preload("Images").
-		Limit(limit).
-		Offset(offset).
-		Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue getting products by category id")
-		return nil, err
-	}
-
-	return products, nil
-}
-
-func GetLowStockProducts() ([]Product, error) {
+func GetLowStockProducts(ctx context.Context) ([]Product, error) {
 	var products []Product
-	if err := database.DB.Where("stock <= reorder_point AND is_active = ?", true).Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue exist in getting LowStockProduct")
+	if err := database.DB.WithContext(ctx).Where("stock <= reorder_point AND is_active = ?", true).Find(&products).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in getting LowStockProduct")
 		return nil, err
 	}
 	return products, nil
 }
 
-func GetOutOfStockProducts() ([]Product, error) {
+func GetOutOfStockProducts(ctx context.Context) ([]Product, error) {
 	var products []Product
-	if err := database.DB.Where("stock = 0 AND is_active = ?", true).Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue exist in getting OutOfStock")
+	if err := database.DB.WithContext(ctx).Where("stock = 0 AND is_active = ?", true).Find(&products).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in getting OutOfStock")
 		return nil, err
 	}
 	return products, nil
 }
 
-func UpdateProduct(p *Product) (*Product, error) {
-	if err := database.DB.Updates(p).Error; err != nil {
-		log.Err(err).Msg("Issue exist in UpdateProduct")
+func UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
+	if err := database.DB.WithContext(ctx).Updates(p).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateProduct")
 		return &Product{}, err
 	}
+	RefreshProductSearch(ctx, p.Id)
 	return p, nil
 }
 
-func DeleteProduct(id string) error {
-	return database.DB.Where(&Product{Id: id}).Delete(&Product{}).Error
-}
-
-func GetAllProducts(limit, offSet int) ([]Product, error) {
-	var products []Product
-	query := database.DB.Limit(limit).Offset(offSet)
-
-	if err := query.Preload("Images").Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetAllProducts")
-		return nil, err
+func DeleteProduct(ctx context.Context, id string) error {
+	if err := database.DB.WithContext(ctx).Where(&Product{Id: id}).Delete(&Product{}).Error; err != nil {
+		return err
 	}
-
-	return products, nil
+	RefreshProductSearch(ctx, id)
+	return nil
 }
 
-func GetAllProductsWithQueries(limit, offSet int, categoryId, searchQuery string) ([]Product, error) {
-	var products []Product
-	query := database.DB.Limit(limit).Offset(offSet)
-
-	if categoryId != "" {
-		query = query.Where(&Product{CategoryId: categoryId})
-	}
+// ProductListSpec is what the public product listings accept, they only ever show active products.
+// inStock also counts the stock of active variants. categoryId is not among the filters because it
+// covers the subcategories, see CategoryWithDescendants.
+var ProductListSpec = listquery.Spec{
+	Filters: []listquery.Filter{
+		listquery.Min("minPrice", "price", listquery.Int),
+		listquery.Max("maxPrice", "price", listquery.Int),
+		listquery.Condition("inStock", "(stock > 0 OR EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = products.id AND v.is_active = true AND v.stock > 0))"),
+		listquery.Min("createdFrom", "created_at", listquery.Time),
+		listquery.Max("createdTo", "created_at", listquery.Time),
+	},
+	Sorts: map[string]string{
+		"price":     "price",
+		"name":      "name",
+		"stock":     "stock",
+		"createdAt": "created_at",
+		"updatedAt": "updated_at",
+	},
+	DefaultSort: "-createdAt",
+}
 
-	if searchQuery != "" {
-		query = query.Where("name ILIKE ? OR description ILIKE ?", "%"+searchQuery+"%", "%"+searchQuery+"%")
-	}
+// AdminProductListSpec is ProductListSpec for the admin listing, which also sees inactive products
+// and can filter on isActive.
+var AdminProductListSpec = listquery.Spec{
+	Filters:     append([]listquery.Filter{listquery.Equal("isActive", "is_active", listquery.Bool)}, ProductListSpec.Filters...),
+	Sorts:       ProductListSpec.Sorts,
+	DefaultSort: ProductListSpec.DefaultSort,
+}
 
-	if err := query.Find(&products).Error; err != nil {
-		log.Err(err).Msg("Issue exist in GetAllProducts")
+func ListProducts(ctx context.Context, q *listquery.Query) (*listquery.Page[Product], error) {
+	page, err := listquery.Fetch[Product](database.DB.WithContext(ctx), q, "Category", "Variants.Attributes.Attribute", "Images", "Attributes.Attribute")
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ListProducts")
 		return nil, err
 	}
-
-	return products, nil
-
+	return page, nil
 }
 
-func UpdateStock(productId string, quantityChange int) error {
-	if err := database.DB.Model(&Product{}).Where("id = ?", productId).Update("stock", gorm.Expr("stock + ?", quantityChange)).Error; err != nil {
-		log.Err(err).Msg("Issue exist in UpdateStock")
+// UpdateStock adds quantityChange to the stock of the variant when one is given, otherwise to the
+// product. Pass database.DB as tx when the change does not belong to a larger transaction.
+func UpdateStock(tx *gorm.DB, productId string, variantId *string, quantityChange int) error {
+	query := tx.Model(&Product{}).Where("id = ?", productId)
+	if variantId != nil && *variantId != "" {
+		query = tx.Model(&ProductVariant{}).Where("id = ? AND product_id = ?", *variantId, productId)
+	}
+	if err := query.UpdateColumn("stock", gorm.Expr("stock + ?", quantityChange)).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in UpdateStock")
 		return err
 	}
 	return nil
@@ -270,18 +309,18 @@ func (p *Product) GetPrimaryImage() *Image {
 	return nil
 }
 
-func (p *Product) SetPrimaryImage(imageId string) error {
+func (p *Product) SetPrimaryImage(ctx context.Context, imageId string) error {
 	// Reset all images to non-primary
-	err := database.DB.Model(&Image{}).Where("product_id = ?", p.Id).Update("is_primary", false).Error
+	err := database.DB.WithContext(ctx).Model(&Image{}).Where("product_id = ?", p.Id).Update("is_primary", false).Error
 	if err != nil {
 		return err
 	}
 
 	// Set specified image as primary
-	return database.DB.Model(&Image{}).Where("id = ? AND product_id = ?", imageId, p.Id).Update("is_primary", true).Error
+	return database.DB.WithContext(ctx).Model(&Image{}).Where("id = ? AND product_id = ?", imageId, p.Id).Update("is_primary", true).Error
 }
 
-func (p *Product) AddImage(url, fileName, altText string) error {
+func (p *Product) AddImage(ctx context.Context, url, fileName, altText string) error {
 	image := Image{
 		URL:       url,
 		FileName:  fileName,
@@ -289,5 +328,5 @@ func (p *Product) AddImage(url, fileName, altText string) error {
 		ProductId: p.Id,
 		SortOrder: len(p.Images),
 	}
-	return database.DB.Create(&image).Error
+	return database.DB.WithContext(ctx).Create(&image).Error
 }
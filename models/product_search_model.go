@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/search"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"time"
+)
+
+// productDocument is what the search index knows about p. Category, Variants and the attribute
+// values with their attributes have to be loaded.
+func productDocument(p Product) search.Document {
+	doc := search.Document{
+		ID:          p.Id,
+		Name:        p.Name,
+		Description: p.Description,
+		SKU:         p.SKU,
+		CategoryId:  p.CategoryId,
+		Price:       p.Price,
+		InStock:     p.Stock > 0,
+		Attributes:  map[string][]string{},
+		CreatedAt:   p.CreatedAt,
+	}
+	if p.Category != nil {
+		doc.CategoryName = p.Category.Name
+	}
+
+	addAttributes(doc.Attributes, p.Attributes)
+	for _, variant := range p.Variants {
+		if !variant.IsActive {
+			continue
+		}
+		if variant.Stock > 0 {
+			doc.InStock = true
+		}
+		addAttributes(doc.Attributes, variant.Attributes)
+		name, value := strings.ToLower(strings.TrimSpace(variant.VariantName)), strings.ToLower(strings.TrimSpace(variant.VariantValue))
+		if name == "" || value == "" || contains(doc.Attributes[name], value) {
+			continue
+		}
+		doc.Attributes[name] = append(doc.Attributes[name], value)
+	}
+	return doc
+}
+
+// addAttributes adds the enum and boolean values to the document's attributes, they are what
+// shoppers narrow a search by. Numbers and free text would only make endless facets.
+func addAttributes(attributes map[string][]string, values []ProductAttributeValue) {
+	for _, value := range values {
+		if value.Attribute == nil || (value.Attribute.Type != AttributeEnum && value.Attribute.Type != AttributeBoolean) {
+			continue
+		}
+		key, text := value.Attribute.Key, strings.ToLower(value.Value)
+		if !contains(attributes[key], text) {
+			attributes[key] = append(attributes[key], text)
+		}
+	}
+}
+
+// searchableProducts loads the active products among ids, or all of them when ids is empty.
+func searchableProducts(ctx context.Context, ids []string) ([]Product, error) {
+	var products []Product
+	query := database.DB.WithContext(ctx).Preload("Category").Preload("Variants.Attributes.Attribute").
+		Preload("Attributes.Attribute").Where("is_active = ?", true)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	if err := query.Find(&products).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in searchableProducts")
+		return nil, err
+	}
+	return products, nil
+}
+
+// ReindexProducts rebuilds the search index from the database. Indexes that read the database
+// themselves need no rebuild and are left alone.
+func ReindexProducts(ctx context.Context) error {
+	idx, ok := search.Default().(search.DocumentIndex)
+	if !ok {
+		return nil
+	}
+
+	products, err := searchableProducts(ctx, nil)
+	if err != nil {
+		return err
+	}
+	docs := make([]search.Document, 0, len(products))
+	for _, product := range products {
+		docs = append(docs, productDocument(product))
+	}
+	return idx.Replace(ctx, docs)
+}
+
+// RefreshProductSearch brings the given products up to date in the search index after they were
+// created, edited or deleted. A failure only leaves the index stale until the next rebuild, so it
+// is logged rather than returned.
+func RefreshProductSearch(ctx context.Context, ids ...string) {
+	idx, ok := search.Default().(search.DocumentIndex)
+	if !ok || len(ids) == 0 {
+		return
+	}
+
+	products, err := searchableProducts(ctx, ids)
+	if err != nil {
+		return
+	}
+	found := map[string]bool{}
+	docs := make([]search.Document, 0, len(products))
+	for _, product := range products {
+		found[product.Id] = true
+		docs = append(docs, productDocument(product))
+	}
+	var gone []string
+	for _, id := range ids {
+		if !found[id] {
+			gone = append(gone, id)
+		}
+	}
+
+	if err := idx.Upsert(ctx, docs...); err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RefreshProductSearch")
+	}
+	if err := idx.Remove(ctx, gone...); err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RefreshProductSearch")
+	}
+}
+
+// StartSearchIndexer rebuilds the search index every interval until ctx is done. Stock changes from
+// orders are not pushed to the index one by one, the rebuild is what brings them in.
+func StartSearchIndexer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ReindexProducts(ctx); err != nil {
+				log.Err(err).Msg("Issue while rebuilding the search index")
+			}
+		}
+	}
+}
+
+// SearchProducts runs q against the search index and loads the products of the returned page in
+// ranked order.
+func SearchProducts(ctx context.Context, q search.Query) ([]Product, *search.Result, error) {
+	result, err := search.Default().Search(ctx, q)
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in SearchProducts")
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.ID)
+	}
+	var found []Product
+	if len(ids) > 0 {
+		if err := database.DB.WithContext(ctx).Preload("Category").Preload("Images").Where("id IN ?", ids).Find(&found).Error; err != nil {
+			logging.Ctx(ctx).Err(err).Msg("Issue exist in SearchProducts loading products")
+			return nil, nil, err
+		}
+	}
+
+	byId := make(map[string]Product, len(found))
+	for _, product := range found {
+		byId[product.Id] = product
+	}
+	// a product deleted since the index last saw it is simply skipped
+	products := make([]Product, 0, len(ids))
+	for _, id := range ids {
+		if product, ok := byId[id]; ok {
+			products = append(products, product)
+		}
+	}
+	return products, result, nil
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/search"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// attributeCatalog creates a category with typed attributes and three products:
+//
+//	cotton  material Cotton, an active variant of size M
+//	linen   material Linen, a legacy Colour/Red variant and an inactive variant of size S
+//	plain   no typed values, a legacy Colour/Blue variant
+func attributeCatalog(t *testing.T) (categoryId string, ids map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	suffix := uuid.New().String()[:8]
+	category := Category{Name: "Cushions " + suffix, Slug: "cushions-" + suffix}
+	mustCreate(t, &category)
+
+	material := CategoryAttribute{CategoryId: category.Id, Key: "material", Name: "Material", Type: AttributeEnum, Options: []string{"Cotton", "Linen"}, Level: AttributeLevelProduct}
+	size := CategoryAttribute{CategoryId: category.Id, Key: "size", Name: "Size", Type: AttributeEnum, Options: []string{"S", "M"}, Level: AttributeLevelVariant}
+	weight := CategoryAttribute{CategoryId: category.Id, Key: "weight", Name: "Weight", Type: AttributeNumber, Level: AttributeLevelProduct}
+	for _, attribute := range []*CategoryAttribute{&material, &size, &weight} {
+		if _, err := attribute.CreateCategoryAttribute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	product := func(name string) *Product {
+		p := &Product{Name: name + " " + suffix, Price: 1000, Stock: 1, CategoryId: category.Id, IsActive: true}
+		mustCreate(t, p)
+		return p
+	}
+	variant := func(p *Product, name, value string, active bool) *ProductVariant {
+		v := &ProductVariant{ProductId: p.Id, VariantName: name, VariantValue: value, SKU: uuid.New().String(), IsActive: true}
+		mustCreate(t, v)
+		if !active {
+			// IsActive has a default, a false on create would be replaced by it
+			if err := database.DB.Model(v).Update("is_active", false).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		return v
+	}
+	values := func(productId, variantId *string, values ...ProductAttributeValue) {
+		if err := ReplaceAttributeValues(ctx, productId, variantId, values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	number := 1.5
+
+	cotton := product("Cotton Cushion")
+	values(&cotton.Id, nil, ProductAttributeValue{AttributeId: material.Id, Value: "Cotton"}, ProductAttributeValue{AttributeId: weight.Id, Value: "1.5", Number: &number})
+	medium := variant(cotton, "", "", true)
+	values(nil, &medium.Id, ProductAttributeValue{AttributeId: size.Id, Value: "M"})
+
+	linen := product("Linen Cushion")
+	values(&linen.Id, nil, ProductAttributeValue{AttributeId: material.Id, Value: "Linen"})
+	variant(linen, "Colour", "Red", true)
+	small := variant(linen, "", "", false)
+	values(nil, &small.Id, ProductAttributeValue{AttributeId: size.Id, Value: "S"})
+
+	plain := product("Plain Cushion")
+	variant(plain, "Colour", "Blue", true)
+
+	return category.Id, map[string]string{cotton.Id: "cotton", linen.Id: "linen", plain.Id: "plain"}
+}
+
+// TestSearchAttributeFiltersMatchAcrossIndexes runs the same attribute queries on the memory index
+// and on the SQL of the mysql index, which SQLite can run as long as there is no text to match.
+func TestSearchAttributeFiltersMatchAcrossIndexes(t *testing.T) {
+	ctx := context.Background()
+	categoryId, names := attributeCatalog(t)
+
+	ids := make([]string, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	products, err := searchableProducts(ctx, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := search.NewMemoryIndex()
+	for _, product := range products {
+		if err := memory.Upsert(ctx, productDocument(product)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexes := []search.Index{memory, search.NewMySQLIndex(database.DB)}
+
+	cases := []struct {
+		name       string
+		attributes map[string]string
+		hits       []string
+		facets     map[string][]search.FacetCount
+	}{
+		{"no filter", nil, []string{"cotton", "linen", "plain"}, map[string][]search.FacetCount{
+			"material": {{Value: "cotton", Count: 1}, {Value: "linen", Count: 1}},
+			"colour":   {{Value: "blue", Count: 1}, {Value: "red", Count: 1}},
+			"size":     {{Value: "m", Count: 1}},
+		}},
+		{"typed product attribute", map[string]string{"material": "cotton"}, []string{"cotton"}, map[string][]search.FacetCount{
+			"material": {{Value: "cotton", Count: 1}, {Value: "linen", Count: 1}},
+			"size":     {{Value: "m", Count: 1}},
+		}},
+		{"typed variant attribute", map[string]string{"size": "m"}, []string{"cotton"}, map[string][]search.FacetCount{
+			"material": {{Value: "cotton", Count: 1}},
+			"size":     {{Value: "m", Count: 1}},
+		}},
+		{"inactive variant", map[string]string{"size": "s"}, []string{}, map[string][]search.FacetCount{
+			"size": {{Value: "m", Count: 1}},
+		}},
+		{"legacy variant", map[string]string{"colour": "red"}, []string{"linen"}, map[string][]search.FacetCount{
+			"material": {{Value: "linen", Count: 1}},
+			"colour":   {{Value: "blue", Count: 1}, {Value: "red", Count: 1}},
+		}},
+		{"typed and legacy together", map[string]string{"material": "linen", "colour": "red"}, []string{"linen"}, map[string][]search.FacetCount{
+			"material": {{Value: "linen", Count: 1}},
+			"colour":   {{Value: "red", Count: 1}},
+		}},
+	}
+	for _, idx := range indexes {
+		for _, c := range cases {
+			t.Run(idx.Name()+"/"+c.name, func(t *testing.T) {
+				result, err := idx.Search(ctx, search.Query{CategoryIds: []string{categoryId}, Attributes: c.attributes})
+				if err != nil {
+					t.Fatal(err)
+				}
+				hits := make([]string, 0, len(result.Hits))
+				for _, hit := range result.Hits {
+					hits = append(hits, names[hit.ID])
+				}
+				sort.Strings(hits)
+				if !reflect.DeepEqual(hits, c.hits) {
+					t.Errorf("hits %v, want %v", hits, c.hits)
+				}
+				if !reflect.DeepEqual(result.Facets.Attributes, c.facets) {
+					t.Errorf("facets %v, want %v", result.Facets.Attributes, c.facets)
+				}
+			})
+		}
+	}
+}
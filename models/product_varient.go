@@ -1,26 +1,31 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"gorm.io/gorm"
 	"strings"
 	"time"
 )
 
 type ProductVariant struct {
-	Id           string    `gorm:"primaryKey;type:varchar(191)" json:"id"`
-	ProductId    string    `gorm:"not null" json:"productId"`
-	Product      Product   `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
-	VariantName  string    `gorm:"not null" json:"variantName"`
-	VariantValue string    `gorm:"not null" json:"variantValue"`
-	Price        int       `json:"price"`
-	Stock        int       `gorm:"not null;default:0" json:"stock"`
-	SKU          string    `gorm:"unique" json:"sku"`
-	IsActive     bool      `gorm:"default:true" json:"isActive"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	Id           string  `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	ProductId    string  `gorm:"not null" json:"productId"`
+	Product      Product `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
+	VariantName  string  `gorm:"not null" json:"variantName"`
+	VariantValue string  `gorm:"not null" json:"variantValue"`
+	// PriceAdjustment is added to the product's base price, it may be negative
+	PriceAdjustment int    `gorm:"default:0" json:"priceAdjustment"`
+	Stock           int    `gorm:"not null;default:0" json:"stock"`
+	SKU             string `gorm:"unique" json:"sku"`
+	IsActive        bool   `gorm:"default:true" json:"isActive"`
+	// Attributes are the values of the category's variant level attributes
+	Attributes []ProductAttributeValue `gorm:"foreignKey:VariantId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"attributes,omitempty"`
+	CreatedAt  time.Time               `json:"createdAt"`
+	UpdatedAt  time.Time               `json:"updatedAt"`
 }
 
 func (pv *ProductVariant) BeforeCreate(t *gorm.DB) error {
@@ -29,7 +34,7 @@ func (pv *ProductVariant) BeforeCreate(t *gorm.DB) error {
 	pv.UpdatedAt = time.Now()
 
 	if pv.SKU == "" {
-		pv.SKU = fmt.Sprintf("%s-%s-%s", pv.ProductId[:8], strings.ToUpper(pv.VariantName[:3]), uuid.New().String()[:6])
+		pv.SKU = fmt.Sprintf("%s-%s-%s", pv.ProductId[:min(8, len(pv.ProductId))], strings.ToUpper(pv.VariantName[:min(3, len(pv.VariantName))]), uuid.New().String()[:6])
 	}
 	return nil
 }
@@ -39,10 +44,92 @@ func (pv *ProductVariant) BeforeUpdate(t *gorm.DB) error {
 	return nil
 }
 
-func GetProductVariants(productId string) ([]ProductVariant, error) {
+func GetProductVariants(ctx context.Context, productId string) ([]ProductVariant, error) {
 	var variants []ProductVariant
-	if err := database.DB.Where("product_id = ? AND is_active = ?", productId, true).Find(&variants).Error; err != nil {
+	if err := database.DB.WithContext(ctx).Where("product_id = ? AND is_active = ?", productId, true).Find(&variants).Error; err != nil {
 		return nil, err
 	}
 	return variants, nil
 }
+
+/*
+CreateVariant() (*ProductVariant, error)
+
+GetVariantById(ctx context.Context, productId, variantId string) (*ProductVariant, error)
+
+UpdateVariant(ctx context.Context, variant *ProductVariant) (*ProductVariant, error)
+
+DeleteVariant(ctx context.Context, productId, variantId string) error
+*/
+
+func (pv *ProductVariant) CreateVariant(ctx context.Context) (*ProductVariant, error) {
+	if err := database.DB.WithContext(ctx).Create(pv).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateVariant")
+		return nil, err
+	}
+	RefreshProductSearch(ctx, pv.ProductId)
+	return pv, nil
+}
+
+func GetAllProductVariants(ctx context.Context, productId string) ([]ProductVariant, error) {
+	var variants []ProductVariant
+	if err := database.DB.WithContext(ctx).Where("product_id = ?", productId).Find(&variants).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetAllProductVariants")
+		return nil, err
+	}
+	return variants, nil
+}
+
+func GetVariantById(ctx context.Context, productId, variantId string) (*ProductVariant, error) {
+	var variant ProductVariant
+	if err := database.DB.WithContext(ctx).Where("id = ? AND product_id = ?", variantId, productId).First(&variant).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetVariantById")
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func UpdateVariant(ctx context.Context, variant *ProductVariant) (*ProductVariant, error) {
+	if err := database.DB.WithContext(ctx).Omit("Product").Save(variant).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in UpdateVariant")
+		return nil, err
+	}
+	RefreshProductSearch(ctx, variant.ProductId)
+	return variant, nil
+}
+
+func DeleteVariant(ctx context.Context, productId, variantId string) error {
+	if err := database.DB.WithContext(ctx).Where("id = ? AND product_id = ?", variantId, productId).Delete(&ProductVariant{}).Error; err != nil {
+		return err
+	}
+	RefreshProductSearch(ctx, productId)
+	return nil
+}
+
+// UnitPrice is the price of one unit of this variant given the product's base price.
+func (pv *ProductVariant) UnitPrice(basePrice int) int {
+	return basePrice + pv.PriceAdjustment
+}
+
+// ReserveStock takes quantity out of the variant's stock inside tx, see Product.ReserveStock.
+func (pv *ProductVariant) ReserveStock(tx *gorm.DB, quantity int) error {
+	if quantity <= 0 {
+		return fmt.Errorf("quantity must be greater than 0")
+	}
+	result := tx.Model(&ProductVariant{}).
+		Where("id = ? AND stock >= ? AND is_active = ?", pv.Id, quantity, true).
+		UpdateColumn("stock", gorm.Expr("stock - ?", quantity))
+	if result.Error != nil {
+		logging.Ctx(tx.Statement.Context).Err(result.Error).Msg("Issue exist in variant ReserveStock")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w for variant %s", ErrInsufficientStock, pv.Id)
+	}
+	return nil
+}
+
+// RestoreStock puts previously reserved or sold variant quantity back inside tx.
+func (pv *ProductVariant) RestoreStock(tx *gorm.DB, quantity int) error {
+	return tx.Model(&ProductVariant{}).Where("id = ?", pv.Id).UpdateColumn("stock", gorm.Expr("stock + ?", quantity)).Error
+}
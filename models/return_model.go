@@ -0,0 +1,403 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunding = "refunding"
+	ReturnStatusRefunded  = "refunded"
+)
+
+const (
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonDefective      = "defective"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonNoLongerNeeded = "no_longer_needed"
+	ReturnReasonOther          = "other"
+)
+
+// returns for reason "other" need at least a short explanation
+const returnReasonOtherCommentMin = 5
+
+// ReturnWindow is how long after delivery an item can still be returned.
+var ReturnWindow = 30 * 24 * time.Hour
+
+var ErrReturnStatusChanged = errors.New("return request is no longer in the expected status")
+
+var returnReasons = []string{
+	ReturnReasonDamaged,
+	ReturnReasonDefective,
+	ReturnReasonWrongItem,
+	ReturnReasonNotAsDescribed,
+	ReturnReasonNoLongerNeeded,
+	ReturnReasonOther,
+}
+
+type ReturnRequest struct {
+	Id           string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	OrderId      string     `gorm:"not null;type:varchar(191);index" json:"orderId"`
+	OrderItemId  string     `gorm:"not null;type:varchar(191);index" json:"orderItemId"`
+	UserId       string     `gorm:"not null;type:varchar(191);index" json:"userId"`
+	ProductId    string     `gorm:"not null;type:varchar(191)" json:"productId"`
+	VariantId    *string    `gorm:"type:varchar(191)" json:"variantId"`
+	Quantity     int        `gorm:"not null" json:"quantity"`
+	Reason       string     `gorm:"not null;type:varchar(30)" json:"reason"`
+	Comment      string     `json:"comment"`
+	Status       string     `gorm:"not null;type:varchar(20);index" json:"status"`
+	AdminNote    string     `json:"adminNote"`
+	ReviewedBy   string     `gorm:"type:varchar(191)" json:"reviewedBy"`
+	RefundAmount int        `gorm:"default:0" json:"refundAmount"`
+	RefundRef    string     `json:"refundRef,omitempty"`
+	ReceivedAt   *time.Time `json:"receivedAt,omitempty"`
+	RefundedAt   *time.Time `json:"refundedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+/*
+CreateReturnRequest() (*ReturnRequest, error)
+
+GetReturnRequestById(ctx context.Context, id string) (*ReturnRequest, error)
+
+GetReturnRequestsByUserId(ctx context.Context, userId string) ([]ReturnRequest, error)
+
+GetAllReturnRequests(ctx context.Context, limit, offset int, status string) ([]ReturnRequest, error)
+
+ApproveReturn(ctx context.Context, id, reviewedBy, note string) (*ReturnRequest, error)
+
+RejectReturn(ctx context.Context, id, reviewedBy, note string) (*ReturnRequest, error)
+
+ReceiveReturn(ctx context.Context, id, receivedBy, note string) (*ReturnRequest, error)
+
+ClaimReturnRefund(ctx context.Context, id string) (*ReturnRequest, error)
+
+ReleaseReturnRefund(ctx context.Context, id string) error
+
+CompleteReturnRefund(ctx context.Context, id string, paymentId *string, refundRef, changedBy string) (*ReturnRequest, error)
+*/
+
+func (rr *ReturnRequest) BeforeCreate(t *gorm.DB) error {
+	rr.Id = uuid.New().String()
+	rr.CreatedAt = time.Now()
+	rr.UpdatedAt = time.Now()
+	if rr.Status == "" {
+		rr.Status = ReturnStatusRequested
+	}
+	return nil
+}
+
+func (rr *ReturnRequest) BeforeUpdate(t *gorm.DB) error {
+	rr.UpdatedAt = time.Now()
+	return nil
+}
+
+func IsValidReturnReason(reason string) bool {
+	return contains(returnReasons, reason)
+}
+
+// deliveredAt is when the order was last marked delivered, taken from its status history.
+func deliveredAt(tx *gorm.DB, orderId string) (*time.Time, error) {
+	var history OrderStatusHistory
+	err := tx.Where("order_id = ? AND to_status = ?", orderId, OrderStatusDelivered).
+		Order("created_at DESC").
+		First(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return &history.CreatedAt, nil
+}
+
+// returnedQuantity sums what is already being returned for the item, rejected requests excluded.
+func returnedQuantity(tx *gorm.DB, orderItemId string) (int, error) {
+	var quantity int64
+	err := tx.Model(&ReturnRequest{}).
+		Where("order_item_id = ? AND status <> ?", orderItemId, ReturnStatusRejected).
+		Select("COALESCE(SUM(quantity), 0)").
+		Scan(&quantity).Error
+	return int(quantity), err
+}
+
+// CreateReturnRequest opens a return for part or all of a delivered order line. The checks run in
+// the same transaction as the insert, so two requests can not together return more than was bought.
+func (rr *ReturnRequest) CreateReturnRequest(ctx context.Context) (*ReturnRequest, error) {
+	if !IsValidReturnReason(rr.Reason) {
+		return nil, fmt.Errorf("invalid return reason : %s", rr.Reason)
+	}
+	if rr.Reason == ReturnReasonOther && len(rr.Comment) < returnReasonOtherCommentMin {
+		return nil, fmt.Errorf("please describe the reason for the return")
+	}
+	if rr.Quantity <= 0 {
+		return nil, fmt.Errorf("quantity must be greater than 0")
+	}
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := tx.Where("id = ? AND user_id = ?", rr.OrderId, rr.UserId).First(&order).Error; err != nil {
+			return err
+		}
+		if order.Status != OrderStatusDelivered {
+			return fmt.Errorf("only delivered orders can be returned, order is %s", order.Status)
+		}
+
+		delivered, err := deliveredAt(tx, order.Id)
+		if err != nil {
+			return err
+		}
+		if time.Since(*delivered) > ReturnWindow {
+			return fmt.Errorf("the return window for this order has closed")
+		}
+
+		var item OrderItem
+		if err := tx.Where("id = ? AND order_id = ?", rr.OrderItemId, order.Id).First(&item).Error; err != nil {
+			return err
+		}
+
+		alreadyReturned, err := returnedQuantity(tx, item.Id)
+		if err != nil {
+			return err
+		}
+		if alreadyReturned+rr.Quantity > item.Quantity {
+			return fmt.Errorf("only %d of this item can still be returned", item.Quantity-alreadyReturned)
+		}
+
+		rr.ProductId = item.ProductId
+		rr.VariantId = item.VariantId
+		rr.Status = ReturnStatusRequested
+		return tx.Create(rr).Error
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateReturnRequest")
+		return nil, err
+	}
+	return rr, nil
+}
+
+func GetReturnRequestById(ctx context.Context, id string) (*ReturnRequest, error) {
+	var rr ReturnRequest
+	if err := database.DB.WithContext(ctx).Where("id = ?", id).First(&rr).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetReturnRequestById")
+		return nil, err
+	}
+	return &rr, nil
+}
+
+func GetReturnRequestsByUserId(ctx context.Context, userId string) ([]ReturnRequest, error) {
+	var requests []ReturnRequest
+	if err := database.DB.WithContext(ctx).Where("user_id = ?", userId).Order("created_at DESC").Find(&requests).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetReturnRequestsByUserId")
+		return nil, err
+	}
+	return requests, nil
+}
+
+func GetAllReturnRequests(ctx context.Context, limit, offset int, status string) ([]ReturnRequest, error) {
+	var requests []ReturnRequest
+	query := database.DB.WithContext(ctx).Limit(limit).Offset(offset).Order("created_at DESC")
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Find(&requests).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetAllReturnRequests")
+		return nil, err
+	}
+	return requests, nil
+}
+
+// moveReturn changes the status only if the request is still in from, like TransitionOrderStatus does for orders.
+func moveReturn(tx *gorm.DB, id, from, to string, updates map[string]any) error {
+	updates["status"] = to
+	updates["updated_at"] = time.Now()
+	result := tx.Model(&ReturnRequest{}).Where("id = ? AND status = ?", id, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: expected %s", ErrReturnStatusChanged, from)
+	}
+	return nil
+}
+
+func reviewReturn(ctx context.Context, id, to, reviewedBy, note string) (*ReturnRequest, error) {
+	err := moveReturn(database.DB, id, ReturnStatusRequested, to, map[string]any{"reviewed_by": reviewedBy, "admin_note": note})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in reviewReturn")
+		return nil, err
+	}
+	return GetReturnRequestById(ctx, id)
+}
+
+func ApproveReturn(ctx context.Context, id, reviewedBy, note string) (*ReturnRequest, error) {
+	return reviewReturn(ctx, id, ReturnStatusApproved, reviewedBy, note)
+}
+
+func RejectReturn(ctx context.Context, id, reviewedBy, note string) (*ReturnRequest, error) {
+	return reviewReturn(ctx, id, ReturnStatusRejected, reviewedBy, note)
+}
+
+// ReceiveReturn books the returned goods back into stock and works out the refund for the line.
+// The refund is the price paid for the returned units less their share of the order discount;
+// when this return completes the whole order, it is whatever is still left to refund instead,
+// so rounding never leaves a few paise behind. The order moves to returned at that point.
+func ReceiveReturn(ctx context.Context, id, receivedBy, note string) (*ReturnRequest, error) {
+	var rr ReturnRequest
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&rr).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]any{"received_at": now}
+		if note != "" {
+			updates["admin_note"] = note
+		}
+		if err := moveReturn(tx, rr.Id, ReturnStatusApproved, ReturnStatusReceived, updates); err != nil {
+			return err
+		}
+		rr.Status = ReturnStatusReceived
+		rr.ReceivedAt = &now
+
+		if err := UpdateStock(tx, rr.ProductId, rr.VariantId, rr.Quantity); err != nil {
+			return err
+		}
+
+		var order Order
+		if err := tx.Preload("OrderItems").Where("id = ?", rr.OrderId).First(&order).Error; err != nil {
+			return err
+		}
+		var item OrderItem
+		if err := tx.Where("id = ?", rr.OrderItemId).First(&item).Error; err != nil {
+			return err
+		}
+
+		// refunds already worked out for other received returns but not paid out yet
+		var awaitingRefund int64
+		if err := tx.Model(&ReturnRequest{}).
+			Where("order_id = ? AND status IN ? AND id <> ?", order.Id, []string{ReturnStatusReceived, ReturnStatusRefunding}, rr.Id).
+			Select("COALESCE(SUM(refund_amount), 0)").
+			Scan(&awaitingRefund).Error; err != nil {
+			return err
+		}
+		remaining := order.RefundableAmount() - int(awaitingRefund)
+
+		var backQuantity int64
+		if err := tx.Model(&ReturnRequest{}).
+			Where("order_id = ? AND status IN ?", order.Id, []string{ReturnStatusReceived, ReturnStatusRefunding, ReturnStatusRefunded}).
+			Select("COALESCE(SUM(quantity), 0)").
+			Scan(&backQuantity).Error; err != nil {
+			return err
+		}
+		orderedQuantity := 0
+		for _, orderItem := range order.OrderItems {
+			orderedQuantity += orderItem.Quantity
+		}
+		wholeOrderBack := int(backQuantity) >= orderedQuantity
+
+		amount := item.PriceAtPurchase * rr.Quantity
+		if order.SubTotal > 0 {
+			amount -= order.DiscountAmount * amount / order.SubTotal
+		}
+		if wholeOrderBack || amount > remaining {
+			amount = remaining
+		}
+		if amount < 0 {
+			amount = 0
+		}
+
+		if err := tx.Model(&ReturnRequest{}).Where("id = ?", rr.Id).Update("refund_amount", amount).Error; err != nil {
+			return err
+		}
+		rr.RefundAmount = amount
+
+		if wholeOrderBack && CanTransition(order.Status, OrderStatusReturned) {
+			return TransitionOrderStatus(tx, &order, OrderStatusReturned, receivedBy, "all items returned")
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReceiveReturn")
+		return nil, err
+	}
+	return &rr, nil
+}
+
+// ClaimReturnRefund moves a received return to refunding before any money is sent. Only one caller
+// gets it, a concurrent or retried refund fails with ErrReturnStatusChanged. While a refund of the
+// whole order is in flight the return goes back to received and ErrRefundInProgress is returned.
+func ClaimReturnRefund(ctx context.Context, id string) (*ReturnRequest, error) {
+	if err := moveReturn(database.DB.WithContext(ctx), id, ReturnStatusReceived, ReturnStatusRefunding, map[string]any{}); err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimReturnRefund")
+		return nil, err
+	}
+	rr, err := GetReturnRequestById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var refundingOrders int64
+	if err := database.DB.WithContext(ctx).Model(&Order{}).
+		Where("id = ? AND payment_status = ?", rr.OrderId, PaymentStatusRefunding).
+		Count(&refundingOrders).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ClaimReturnRefund")
+		return nil, err
+	}
+	if refundingOrders > 0 {
+		if err := ReleaseReturnRefund(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefundInProgress
+	}
+	return rr, nil
+}
+
+// ReleaseReturnRefund puts a claimed return back to received after the provider refused the refund,
+// so it can be retried.
+func ReleaseReturnRefund(ctx context.Context, id string) error {
+	if err := moveReturn(database.DB.WithContext(ctx), id, ReturnStatusRefunding, ReturnStatusReceived, map[string]any{}); err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReleaseReturnRefund")
+		return err
+	}
+	return nil
+}
+
+// CompleteReturnRefund records that the refund of a claimed return went through. paymentId is nil
+// when nothing was refunded through a provider, e.g. cash on delivery.
+func CompleteReturnRefund(ctx context.Context, id string, paymentId *string, refundRef, changedBy string) (*ReturnRequest, error) {
+	var rr ReturnRequest
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&rr).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := moveReturn(tx, rr.Id, ReturnStatusRefunding, ReturnStatusRefunded, map[string]any{"refund_ref": refundRef, "refunded_at": now}); err != nil {
+			return err
+		}
+		rr.Status = ReturnStatusRefunded
+		rr.RefundRef = refundRef
+		rr.RefundedAt = &now
+
+		return ApplyRefund(tx, rr.OrderId, paymentId, rr.RefundAmount, changedBy)
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CompleteReturnRefund")
+		return nil, err
+	}
+	return &rr, nil
+}
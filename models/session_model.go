@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RefreshTokenTTL is how long a login lasts without the user signing in again.
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// Session is one login of a user. Every refresh token rotated out of it belongs to the same
+// session, so revoking the session ends the login on that device, access tokens included.
+type Session struct {
+	Id           string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	UserId       string     `gorm:"not null;type:varchar(100);index" json:"userId"`
+	UserAgent    string     `json:"userAgent"`
+	IpAddress    string     `gorm:"type:varchar(64)" json:"ipAddress"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expiresAt"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	RevokeReason string     `json:"revokeReason,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// RefreshToken stores only the hash of the token handed to the client. A token is good for one
+// rotation; presenting it a second time means it leaked.
+type RefreshToken struct {
+	Id         string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	SessionId  string     `gorm:"not null;type:varchar(191);index" json:"sessionId"`
+	TokenHash  string     `gorm:"not null;type:varchar(64);uniqueIndex" json:"-"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expiresAt"`
+	UsedAt     *time.Time `json:"usedAt,omitempty"`
+	ReplacedBy *string    `gorm:"type:varchar(191)" json:"replacedBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// RevokedToken is an access token that was logged out before it expired.
+type RevokedToken struct {
+	Jti       string    `gorm:"primaryKey;type:varchar(191)" json:"jti"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+/*
+CreateSession(ctx context.Context, userId, userAgent, ipAddress string) (*Session, string, error)
+
+RotateRefreshToken(ctx context.Context, rawToken string) (*Session, string, error)
+
+RevokeSession(ctx context.Context, sessionId, reason string) error
+
+RevokeUserSessions(ctx context.Context, userId, reason string) error
+
+RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+
+IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error)
+
+PurgeExpiredTokens(ctx context.Context) error
+
+StartSessionCleanup(ctx context.Context, interval time.Duration)
+*/
+
+func (s *Session) BeforeCreate(t *gorm.DB) error {
+	if s.Id == "" {
+		s.Id = uuid.New().String()
+	}
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = time.Now()
+	s.LastUsedAt = time.Now()
+	return nil
+}
+
+func (s *Session) BeforeUpdate(t *gorm.DB) error {
+	s.UpdatedAt = time.Now()
+	return nil
+}
+
+func (rt *RefreshToken) BeforeCreate(t *gorm.DB) error {
+	rt.Id = uuid.New().String()
+	rt.CreatedAt = time.Now()
+	return nil
+}
+
+func (rv *RevokedToken) BeforeCreate(t *gorm.DB) error {
+	rv.CreatedAt = time.Now()
+	return nil
+}
+
+func hashRefreshToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken(tx *gorm.DB, sessionId string, expiresAt time.Time) (*RefreshToken, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", err
+	}
+	rawToken := base64.RawURLEncoding.EncodeToString(bytes)
+
+	token := RefreshToken{
+		SessionId: sessionId,
+		TokenHash: hashRefreshToken(rawToken),
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	return &token, rawToken, nil
+}
+
+// CreateSession starts a login and returns the first refresh token in plain text. It is the only
+// time the raw value exists on the server.
+func CreateSession(ctx context.Context, userId, userAgent, ipAddress string) (*Session, string, error) {
+	session := Session{
+		UserId:    userId,
+		UserAgent: userAgent,
+		IpAddress: ipAddress,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	var rawToken string
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		_, raw, err := newRefreshToken(tx, session.Id, session.ExpiresAt)
+		rawToken = raw
+		return err
+	})
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in CreateSession")
+		return nil, "", err
+	}
+	return &session, rawToken, nil
+}
+
+// RotateRefreshToken swaps a refresh token for a new one. Using an already rotated token revokes
+// the whole session: either the client or an attacker holds a stolen copy, and we can not tell which.
+func RotateRefreshToken(ctx context.Context, rawToken string) (*Session, string, error) {
+	var session Session
+	var newRawToken string
+	var reusedSessionId string
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var token RefreshToken
+		if err := tx.Where("token_hash = ?", hashRefreshToken(rawToken)).First(&token).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.Where("id = ?", token.SessionId).First(&session).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if session.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if token.UsedAt != nil {
+			reusedSessionId = session.Id
+			return ErrRefreshTokenReused
+		}
+
+		next, raw, err := newRefreshToken(tx, session.Id, session.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		// the used_at guard makes two concurrent rotations of the same token count as reuse
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", token.Id).
+			Updates(map[string]any{"used_at": time.Now(), "replaced_by": next.Id})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reusedSessionId = session.Id
+			return ErrRefreshTokenReused
+		}
+
+		newRawToken = raw
+		return tx.Model(&Session{}).Where("id = ?", session.Id).Update("last_used_at", time.Now()).Error
+	})
+
+	// revoke outside the rolled back transaction so it sticks
+	if errors.Is(err, ErrRefreshTokenReused) {
+		logging.Ctx(ctx).Warn().Str("sessionId", reusedSessionId).Msg("Refresh token reuse detected, revoking session")
+		if revokeErr := RevokeSession(ctx, reusedSessionId, "refresh token reuse"); revokeErr != nil {
+			return nil, "", revokeErr
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, newRawToken, nil
+}
+
+func RevokeSession(ctx context.Context, sessionId, reason string) error {
+	if err := database.DB.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionId).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoke_reason": reason}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RevokeSession")
+		return err
+	}
+	return nil
+}
+
+// RevokeUserSessions logs the user out everywhere, e.g. after a password change.
+func RevokeUserSessions(ctx context.Context, userId, reason string) error {
+	if err := database.DB.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoke_reason": reason}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RevokeUserSessions")
+		return err
+	}
+	return nil
+}
+
+func RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	revoked := RevokedToken{Jti: jti, ExpiresAt: expiresAt}
+	if err := database.DB.WithContext(ctx).Where(RevokedToken{Jti: jti}).FirstOrCreate(&revoked).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in RevokeAccessToken")
+		return err
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether the token itself was logged out or its session was ended.
+func IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error) {
+	var count int64
+	if err := database.DB.WithContext(ctx).Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in IsAccessTokenRevoked")
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	if err := database.DB.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", sessionId, time.Now()).
+		Count(&count).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in IsAccessTokenRevoked")
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// PurgeExpiredTokens drops rows that can no longer match a live token.
+func PurgeExpiredTokens(ctx context.Context) error {
+	now := time.Now()
+	if err := database.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in PurgeExpiredTokens")
+		return err
+	}
+	if err := database.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&RefreshToken{}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in PurgeExpiredTokens")
+		return err
+	}
+	if err := database.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&Session{}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in PurgeExpiredTokens")
+		return err
+	}
+	return nil
+}
+
+// StartSessionCleanup purges expired tokens and sessions every interval until ctx is done.
+func StartSessionCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = PurgeExpiredTokens(ctx)
+		}
+	}
+}
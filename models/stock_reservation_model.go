@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+// ReservationTTL is how long stock stays held for an order that has not been paid yet.
+var ReservationTTL = 30 * time.Minute
+
+type StockReservation struct {
+	Id          string     `gorm:"primaryKey;type:varchar(191)" json:"id"`
+	OrderId     string     `gorm:"not null;type:varchar(191);index" json:"orderId"`
+	OrderItemId string     `gorm:"not null;type:varchar(191)" json:"orderItemId"`
+	ProductId   string     `gorm:"not null;type:varchar(191)" json:"productId"`
+	VariantId   *string    `gorm:"type:varchar(191)" json:"variantId"`
+	Quantity    int        `gorm:"not null" json:"quantity"`
+	Status      string     `gorm:"not null;type:varchar(20);index" json:"status"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+/*
+CreateReservation(tx *gorm.DB, item OrderItem, expiresAt *time.Time) error
+
+CommitReservations(tx *gorm.DB, orderId string) error
+
+ReleaseOrderReservations(tx *gorm.DB, orderId string) error
+
+ReleaseExpiredReservations(ctx context.Context) (int, error)
+
+StartReservationSweeper(ctx context.Context, interval time.Duration)
+*/
+
+func (sr *StockReservation) BeforeCreate(t *gorm.DB) error {
+	sr.Id = uuid.New().String()
+	sr.CreatedAt = time.Now()
+	sr.UpdatedAt = time.Now()
+	return nil
+}
+
+func (sr *StockReservation) BeforeUpdate(t *gorm.DB) error {
+	sr.UpdatedAt = time.Now()
+	return nil
+}
+
+// CreateReservation records stock already taken by the order item. A nil expiresAt means the
+// reservation is committed straight away (cash on delivery), otherwise it is held until paid.
+func CreateReservation(tx *gorm.DB, item OrderItem, expiresAt *time.Time) error {
+	status := ReservationActive
+	if expiresAt == nil {
+		status = ReservationCommitted
+	}
+	reservation := StockReservation{
+		OrderId:     item.OrderId,
+		OrderItemId: item.Id,
+		ProductId:   item.ProductId,
+		VariantId:   item.VariantId,
+		Quantity:    item.Quantity,
+		Status:      status,
+		ExpiresAt:   expiresAt,
+	}
+	if err := tx.Create(&reservation).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in CreateReservation")
+		return err
+	}
+	return nil
+}
+
+// CommitReservations makes the held stock permanent once the order is paid.
+func CommitReservations(tx *gorm.DB, orderId string) error {
+	if err := tx.Model(&StockReservation{}).
+		Where("order_id = ? AND status = ?", orderId, ReservationActive).
+		Updates(map[string]any{"status": ReservationCommitted, "expires_at": nil}).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in CommitReservations")
+		return err
+	}
+	return nil
+}
+
+// ReleaseOrderReservations closes every open reservation of the order. It does not touch stock,
+// the caller restores it from the order items.
+func ReleaseOrderReservations(tx *gorm.DB, orderId string) error {
+	if err := tx.Model(&StockReservation{}).
+		Where("order_id = ? AND status IN ?", orderId, []string{ReservationActive, ReservationCommitted}).
+		Updates(map[string]any{"status": ReservationReleased, "expires_at": nil}).Error; err != nil {
+		logging.Ctx(tx.Statement.Context).Err(err).Msg("Issue exist in ReleaseOrderReservations")
+		return err
+	}
+	return nil
+}
+
+func GetReservationsByOrderId(ctx context.Context, orderId string) ([]StockReservation, error) {
+	var reservations []StockReservation
+	if err := database.DB.WithContext(ctx).Where("order_id = ?", orderId).Find(&reservations).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in GetReservationsByOrderId")
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// releaseReservation puts the stock of one active reservation back. The status guard makes sure a
+// reservation committed or released concurrently is never restored twice.
+func releaseReservation(tx *gorm.DB, reservation StockReservation) (bool, error) {
+	result := tx.Model(&StockReservation{}).
+		Where("id = ? AND status = ?", reservation.Id, ReservationActive).
+		Update("status", ReservationReleased)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	if err := restoreStock(tx, reservation.ProductId, reservation.VariantId, reservation.Quantity); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseExpiredReservations returns the stock and coupon use of unpaid orders whose hold has run out and cancels
+// those orders.
+// An order that is no longer pending and unpaid keeps its stock, its reservations are committed instead.
+func ReleaseExpiredReservations(ctx context.Context) (int, error) {
+	var expired []StockReservation
+	if err := database.DB.WithContext(ctx).Where("status = ? AND expires_at < ?", ReservationActive, time.Now()).Find(&expired).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue exist in ReleaseExpiredReservations")
+		return 0, err
+	}
+
+	byOrder := make(map[string][]StockReservation)
+	for _, reservation := range expired {
+		byOrder[reservation.OrderId] = append(byOrder[reservation.OrderId], reservation)
+	}
+
+	released := 0
+	for orderId, reservations := range byOrder {
+		releasedForOrder := 0
+		err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var order Order
+			if err := tx.Where("id = ?", orderId).First(&order).Error; err != nil {
+				return err
+			}
+			unpaid := order.PaymentStatus == PaymentStatusPending || order.PaymentStatus == PaymentStatusFailed
+			if order.Status != OrderStatusPending || !unpaid {
+				return CommitReservations(tx, orderId)
+			}
+
+			for _, reservation := range reservations {
+				ok, err := releaseReservation(tx, reservation)
+				if err != nil {
+					return err
+				}
+				if ok {
+					releasedForOrder++
+				}
+			}
+			// conditional on the order still being pending, a payment landing meanwhile rolls all of this back
+			if err := TransitionOrderStatus(tx, &order, OrderStatusCancelled, ChangedBySystem, "payment window expired"); err != nil {
+				return err
+			}
+			if err := ReleaseCouponUsage(tx, orderId); err != nil {
+				return err
+			}
+			return tx.Model(&Order{}).Where("id = ?", orderId).Update("payment_status", PaymentStatusExpired).Error
+		})
+		if err != nil {
+			logging.Ctx(ctx).Err(err).Str("orderId", orderId).Msg("Issue exist while releasing expired reservations")
+			continue
+		}
+		released += releasedForOrder
+	}
+	return released, nil
+}
+
+// StartReservationSweeper releases expired reservations every interval until ctx is done.
+func StartReservationSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := ReleaseExpiredReservations(ctx)
+			if err != nil {
+				continue
+			}
+			if released > 0 {
+				log.Info().Int("released", released).Msg("Released expired stock reservations")
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"github.com/pratyush934/sibling-bond-server/database"
+	"testing"
+	"time"
+)
+
+// expire moves the hold of the order's active reservations into the past.
+func expire(t *testing.T, orderId string) {
+	t.Helper()
+	if err := database.DB.Model(&StockReservation{}).
+		Where("order_id = ? AND status = ?", orderId, ReservationActive).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseExpiredReservations(t *testing.T) {
+	f := newFixture(t, 10)
+	unpaid := f.placeOrder(t, 2, "upi")
+	// confirmed by an admin before it was paid, the order goes on and keeps its stock
+	confirmed := f.placeOrder(t, 3, "upi")
+	if _, err := UpdateStatus(f.ctx, confirmed.Id, OrderStatusConfirmed, "admin-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	// confirmed without its reservations being committed, as orders were before they had to be
+	stale := f.placeOrder(t, 1, "upi")
+	if err := database.DB.Model(&Order{}).Where("id = ?", stale.Id).Update("status", OrderStatusConfirmed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := f.stock(t); got != 4 {
+		t.Fatalf("stock after ordering = %d, want 4", got)
+	}
+
+	expire(t, unpaid.Id)
+	expire(t, stale.Id)
+	released, err := ReleaseExpiredReservations(f.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 {
+		t.Fatalf("released %d reservations, want 1", released)
+	}
+	if got := f.stock(t); got != 6 {
+		t.Fatalf("stock after the sweep = %d, want 6", got)
+	}
+
+	order := loadOrder(t, unpaid.Id)
+	if order.Status != OrderStatusCancelled || order.PaymentStatus != PaymentStatusExpired {
+		t.Fatalf("unpaid order = %s / %s, want cancelled / expired", order.Status, order.PaymentStatus)
+	}
+	expectStatuses(t, reservationStatuses(t, unpaid.Id), ReservationReleased)
+	expectStatuses(t, reservationStatuses(t, confirmed.Id), ReservationCommitted)
+	expectStatuses(t, reservationStatuses(t, stale.Id), ReservationCommitted)
+	if got := loadOrder(t, stale.Id).Status; got != OrderStatusConfirmed {
+		t.Fatalf("stale order status = %s, want confirmed", got)
+	}
+
+	// a second sweep finds nothing, and cancelling the order later does not restock it again
+	if released, err := ReleaseExpiredReservations(f.ctx); err != nil || released != 0 {
+		t.Fatalf("second sweep released %d, err %v", released, err)
+	}
+	if _, err := CancelOrder(f.ctx, unpaid.Id, f.user.Id, ""); err == nil {
+		t.Fatal("cancelling an order the sweeper cancelled succeeded")
+	}
+	if got := f.stock(t); got != 6 {
+		t.Fatalf("stock = %d, want 6", got)
+	}
+}
+
+func TestReleaseExpiredReservationsKeepsLiveHolds(t *testing.T) {
+	f := newFixture(t, 10)
+	order := f.placeOrder(t, 2, "upi")
+
+	if _, err := ReleaseExpiredReservations(f.ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectStatuses(t, reservationStatuses(t, order.Id), ReservationActive)
+	if got := f.stock(t); got != 8 {
+		t.Fatalf("stock = %d, want 8", got)
+	}
+}
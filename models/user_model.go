@@ -1,8 +1,11 @@
 package models
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/database"
+	"github.com/pratyush934/sibling-bond-server/listquery"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -24,6 +27,8 @@ type User struct {
 	PrimaryAddress      string     `json:"primaryAddress"`
 	PasswordResetToken  *string    `json:"-"`
 	PasswordResetExpiry *time.Time `json:"-"`
+	FailedLoginAttempts int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil         *time.Time `json:"-"`
 	CreatedAt           time.Time  `json:"createdAt"`
 	UpdatedAt           time.Time  `json:"updatedAt"`
 }
@@ -58,9 +63,9 @@ func (u *User) ValidatePassWord(pass string) bool {
 	return err == nil
 }
 
-func (u *User) CreateUser() (*User, error) {
-	if err := database.DB.Preload("role").Create(u).Error; err != nil {
-		log.Err(err).Msg("Issue while creating User")
+func (u *User) CreateUser(ctx context.Context) (*User, error) {
+	if err := database.DB.WithContext(ctx).Preload("role").Create(u).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue while creating User")
 		return nil, err
 	}
 	return u, nil
@@ -95,70 +100,84 @@ func (u *User) ResetPassword(newPassword string) error {
 	return nil
 }
 
-func GetUserByResetToken(token string) (*User, error) {
+func GetUserByResetToken(ctx context.Context, token string) (*User, error) {
 	var user User
-	if err := database.DB.Where("password_reset_token = ? AND password_reset_expiry > ?", token, time.Now()).First(&user).Error; err != nil {
-		log.Err(err).Msg("Invalid or expired reset token")
+	if err := database.DB.WithContext(ctx).Where("password_reset_token = ? AND password_reset_expiry > ?", token, time.Now()).First(&user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Invalid or expired reset token")
 		return nil, err
 	}
 	return &user, nil
 }
 
-func InitiatePasswordReset(email string) (*User, string, error) {
-	user, err := GetUserByEmail(email)
+func InitiatePasswordReset(ctx context.Context, email string) (*User, string, error) {
+	user, err := GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, "", err
 	}
 
 	token := user.GeneratePasswordResetToken()
 
-	if err := database.DB.Save(user).Error; err != nil {
-		log.Err(err).Msg("Failed to save password reset token")
+	if err := database.DB.WithContext(ctx).Save(user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Failed to save password reset token")
 		return nil, "", err
 	}
 
 	return user, token, nil
 }
 
-func GetUserById(id string) (*User, error) {
+func GetUserById(ctx context.Context, id string) (*User, error) {
 	var user User
-	if err := database.DB.Where(&User{Id: id}).First(&user).Error; err != nil {
-		log.Err(err).Msg("Didn't get the User")
+	if err := database.DB.WithContext(ctx).Where(&User{Id: id}).First(&user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Didn't get the User")
 		return nil, err
 	}
 	return &user, nil
 }
 
-func GetUserByEmail(email string) (*User, error) {
+func GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
-	if err := database.DB.Where(&User{Email: email}).First(&user).Error; err != nil {
-		log.Err(err).Msg("Didn't get the user by email")
+	if err := database.DB.WithContext(ctx).Where(&User{Email: email}).First(&user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Didn't get the user by email")
 		return nil, err
 	}
 	return &user, nil
 }
 
-func UpdateUser(user *User) (*User, error) {
-	if err := database.DB.Updates(user).Error; err != nil {
-		log.Err(err).Msg("issue while updating the user")
+func UpdateUser(ctx context.Context, user *User) (*User, error) {
+	if err := database.DB.WithContext(ctx).Updates(user).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("issue while updating the user")
 		return &User{}, err
 	}
 	return user, nil
 }
 
-func DeleteUser(id string) error {
-	if err := database.DB.Where(&User{Id: id}).Delete(&User{}).Error; err != nil {
-		log.Err(err).Msg("Issue while deleting the user")
+func DeleteUser(ctx context.Context, id string) error {
+	if err := database.DB.WithContext(ctx).Where(&User{Id: id}).Delete(&User{}).Error; err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue while deleting the user")
 		return err
 	}
 	return nil
 }
 
-func GetAllUsers(offset, limit int) ([]User, error) {
-	var users []User
-	if err := database.DB.Limit(limit).Offset(offset).Find(&users).Error; err != nil {
-		log.Err(err).Msg("Issue while getting all the users")
-		return users, err
+var UserListSpec = listquery.Spec{
+	Filters: []listquery.Filter{
+		listquery.Equal("roleId", "role_id", listquery.Int),
+		listquery.Min("createdFrom", "created_at", listquery.Time),
+		listquery.Max("createdTo", "created_at", listquery.Time),
+	},
+	Sorts: map[string]string{
+		"createdAt": "created_at",
+		"email":     "email",
+		"firstName": "first_name",
+	},
+	DefaultSort: "-createdAt",
+}
+
+func ListUsers(ctx context.Context, q *listquery.Query) (*listquery.Page[User], error) {
+	page, err := listquery.Fetch[User](database.DB.WithContext(ctx), q)
+	if err != nil {
+		logging.Ctx(ctx).Err(err).Msg("Issue while listing the users")
+		return nil, err
 	}
-	return users, nil
+	return page, nil
 }
@@ -0,0 +1,144 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FakeProviderName    = "fake"
+	FakeSignatureHeader = "X-Fake-Signature"
+	fakeSignatureMaxAge = 5 * time.Minute
+)
+
+type fakeIntent struct {
+	amount   int
+	captured bool
+	refunded int
+}
+
+// FakeProvider is an in-process gateway for development and offline testing. It behaves like a
+// real provider: intents are created up front and payments are only confirmed through webhooks
+// signed with the shared secret, which BuildWebhook can produce.
+type FakeProvider struct {
+	secret  []byte
+	mu      sync.Mutex
+	intents map[string]*fakeIntent
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{
+		secret:  []byte(secret),
+		intents: map[string]*fakeIntent{},
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return FakeProviderName
+}
+
+func (f *FakeProvider) CreateIntent(orderId string, amount int, currency string) (*Intent, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be greater than 0")
+	}
+	ref := "fake_pi_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+
+	f.mu.Lock()
+	f.intents[ref] = &fakeIntent{amount: amount}
+	f.mu.Unlock()
+
+	return &Intent{
+		ProviderRef:  ref,
+		ClientSecret: ref + "_secret_" + orderId,
+		Amount:       amount,
+		Currency:     currency,
+	}, nil
+}
+
+func (f *FakeProvider) Capture(providerRef string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[providerRef]
+	if !ok {
+		return fmt.Errorf("unknown payment intent %s", providerRef)
+	}
+	if amount != intent.amount {
+		return fmt.Errorf("capture amount %d does not match intent amount %d", amount, intent.amount)
+	}
+	intent.captured = true
+	return nil
+}
+
+func (f *FakeProvider) Refund(providerRef string, amount int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[providerRef]
+	if !ok {
+		// intents do not survive a restart, refunds of older payments are only checked by the caller
+		intent = &fakeIntent{amount: -1, captured: true}
+		f.intents[providerRef] = intent
+	}
+	if amount <= 0 || (intent.amount >= 0 && intent.refunded+amount > intent.amount) {
+		return "", fmt.Errorf("refund of %d exceeds the refundable amount", amount)
+	}
+	intent.refunded += amount
+	return "fake_re_" + strings.ReplaceAll(uuid.New().String(), "-", ""), nil
+}
+
+func (f *FakeProvider) sign(timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the "t=<unix>,v1=<hex hmac>" signature header and rejects stale deliveries.
+func (f *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+	if time.Since(time.Unix(timestamp, 0)) > fakeSignatureMaxAge {
+		return nil, fmt.Errorf("%w: signature too old", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(signature), []byte(f.sign(timestamp, payload))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// BuildWebhook produces a signed webhook delivery, the way the real gateway would send it.
+func (f *FakeProvider) BuildWebhook(event Event) ([]byte, http.Header, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+	timestamp := time.Now().Unix()
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, f.sign(timestamp, payload)))
+	return payload, header, nil
+}
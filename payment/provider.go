@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const DefaultCurrency = "INR"
+
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentSucceeded  = "payment.succeeded"
+	EventPaymentFailed     = "payment.failed"
+	EventRefundSucceeded   = "refund.succeeded"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Intent is what the client needs to complete a payment with the provider.
+type Intent struct {
+	ProviderRef  string `json:"providerRef"`
+	ClientSecret string `json:"clientSecret"`
+	Amount       int    `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+// Event is a verified webhook notification from a provider.
+type Event struct {
+	Type        string `json:"type"`
+	ProviderRef string `json:"providerRef"`
+	RefundRef   string `json:"refundRef,omitempty"`
+	Amount      int    `json:"amount"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Provider is a payment gateway. Money only ever counts as received once VerifyWebhook
+// has accepted a signed payment.succeeded event from the provider.
+type Provider interface {
+	Name() string
+	CreateIntent(orderId string, amount int, currency string) (*Intent, error)
+	Capture(providerRef string, amount int) error
+	Refund(providerRef string, amount int) (string, error)
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+var (
+	mu          sync.RWMutex
+	providers   = map[string]Provider{}
+	defaultName string
+)
+
+// Register makes the provider available by name, the first one registered becomes the default.
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[p.Name()] = p
+	if defaultName == "" {
+		defaultName = p.Name()
+	}
+}
+
+func SetDefault(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := providers[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	defaultName = name
+	return nil
+}
+
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
+
+func Default() (Provider, error) {
+	mu.RLock()
+	name := defaultName
+	mu.RUnlock()
+	return Get(name)
+}
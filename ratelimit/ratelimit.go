@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+	Token buckets: every key owns a bucket of Limit.Requests tokens that refills evenly over
+	Limit.Period. A request takes one token, an empty bucket is refused until the next token arrives.
+
+	Store is where the buckets live. MemoryStore keeps them in the process, which is enough for a
+	single instance; several instances behind a load balancer need a shared Store (Redis, the DB)
+	so a client can not multiply its allowance by the number of instances.
+*/
+
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Enabled reports whether the limit allows anything to be counted at all.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+type Store interface {
+	// Take removes a token from the bucket of key. When the bucket is empty it returns false and how
+	// long the caller has to wait for the next token.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// Limiter applies one Limit to the buckets of a Store, Name keeps its keys apart from other limiters.
+type Limiter struct {
+	Name  string
+	Limit Limit
+	Store Store
+}
+
+func NewLimiter(name string, limit Limit, store Store) *Limiter {
+	return &Limiter{Name: name, Limit: limit, Store: store}
+}
+
+// Allow takes a token for key. A nil or disabled limiter allows everything.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	if l == nil || !l.Limit.Enabled() {
+		return true, 0, nil
+	}
+	return l.Store.Take(ctx, l.Name+":"+key, l.Limit)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled completely
+	full time.Time
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepEvery is how often Take drops buckets that have refilled completely.
+const sweepEvery = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepEvery {
+		s.sweep(now)
+	}
+
+	capacity := float64(limit.Requests)
+	perToken := limit.Period / time.Duration(limit.Requests)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	} else {
+		refilled := float64(now.Sub(b.updated)) / float64(perToken)
+		b.tokens = math.Min(capacity, b.tokens+refilled)
+		b.updated = now
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((capacity - b.tokens) * float64(perToken)))
+	if allowed {
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) * float64(perToken)), nil
+}
+
+// sweep forgets buckets that are full again, they behave exactly like a missing one.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -8,9 +8,9 @@ import (
 
 // SetupCartRoutes configures all cart-related routes
 func SetupCartRoutes(router *mux.Router) {
-	// All cart routes require user authentication
+	// Cart routes work for guests through the signed cart token and for logged-in users through the JWT
 	cartRoutes := router.PathPrefix("/api/cart").Subrouter()
-	cartRoutes.Use(utils.ValidateUser)
+	cartRoutes.Use(utils.OptionalUser)
 
 	// Cart operations
 	cartRoutes.HandleFunc("", controller.GetCart).Methods("GET")
@@ -20,6 +20,11 @@ func SetupCartRoutes(router *mux.Router) {
 	cartRoutes.HandleFunc("", controller.ClearCart).Methods("DELETE")
 	cartRoutes.HandleFunc("/total", controller.GetCartItemTotal).Methods("GET")
 
+	// These need a logged-in user, the handlers reject guests
+	cartRoutes.HandleFunc("/coupon", controller.ApplyCoupon).Methods("POST")
+	cartRoutes.HandleFunc("/coupon", controller.RemoveCoupon).Methods("DELETE")
+	cartRoutes.HandleFunc("/merge", controller.MergeCart).Methods("POST")
+
 	// Cart summary/checkout preparation
 	//cartRoutes.HandleFunc("/summary", controller.GetCartSummary).Methods("GET")
 }
@@ -11,6 +11,9 @@ func SetupCategoryRoutes(router *mux.Router) {
 	// Public routes (no authentication required)
 	router.HandleFunc("/api/categories", controller.GetAllCategories).Methods("GET")
 	router.HandleFunc("/api/categories/category", controller.GetCategoryById).Methods("GET")
+	router.HandleFunc("/api/categories/tree", controller.GetCategoryTree).Methods("GET")
+	router.HandleFunc("/api/categories/breadcrumbs", controller.GetCategoryBreadcrumbs).Methods("GET")
+	router.HandleFunc("/api/categories/attributes", controller.GetCategoryAttributes).Methods("GET")
 
 	// Admin routes (admin authentication required)
 	adminRoutes := router.PathPrefix("/api/admin/categories").Subrouter()
@@ -18,4 +21,7 @@ func SetupCategoryRoutes(router *mux.Router) {
 	adminRoutes.HandleFunc("", controller.CreateCategory).Methods("POST")
 	adminRoutes.HandleFunc("", controller.UpdateCategory).Methods("PUT")
 	adminRoutes.HandleFunc("", controller.DeleteCategory).Methods("DELETE")
+	adminRoutes.HandleFunc("/attributes", controller.CreateCategoryAttribute).Methods("POST")
+	adminRoutes.HandleFunc("/attributes", controller.UpdateCategoryAttribute).Methods("PUT")
+	adminRoutes.HandleFunc("/attributes", controller.DeleteCategoryAttribute).Methods("DELETE")
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/controller"
+	"github.com/pratyush934/sibling-bond-server/utils"
+)
+
+// SetupCouponRoutes configures all coupon-related admin routes
+func SetupCouponRoutes(router *mux.Router) {
+	adminCouponRoutes := router.PathPrefix("/api/admin/coupons").Subrouter()
+	adminCouponRoutes.Use(utils.ValidateAdmin)
+	adminCouponRoutes.HandleFunc("", controller.GetAllCoupons).Methods("GET")
+	adminCouponRoutes.HandleFunc("", controller.CreateCoupon).Methods("POST")
+	adminCouponRoutes.HandleFunc("/{id}", controller.GetCouponById).Methods("GET")
+	adminCouponRoutes.HandleFunc("/{id}", controller.UpdateCoupon).Methods("PUT")
+	adminCouponRoutes.HandleFunc("/{id}", controller.DeleteCoupon).Methods("DELETE")
+}
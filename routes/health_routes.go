@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/controller"
+)
+
+func SetupHealthRoutes(router *mux.Router) {
+	router.HandleFunc("/healthz", controller.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", controller.Readyz).Methods("GET")
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/config"
+	"github.com/pratyush934/sibling-bond-server/utils"
+	"net/http"
+)
+
+// SetupMetricsRoutes puts /metrics on the public router, only when no internal metrics listener
+// is configured. The config requires a token in that case.
+func SetupMetricsRoutes(router *mux.Router) {
+	cfg := config.Get().Metrics
+	if !cfg.Enabled || cfg.Addr != "" {
+		return
+	}
+	router.Handle("/metrics", utils.MetricsHandler(cfg.Token)).Methods("GET")
+}
+
+// MetricsRouter serves /metrics alone, for the internal metrics listener.
+func MetricsRouter() http.Handler {
+	router := mux.NewRouter()
+	router.Handle("/metrics", utils.MetricsHandler(config.Get().Metrics.Token)).Methods("GET")
+	return router
+}
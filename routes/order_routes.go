@@ -14,6 +14,8 @@ func SetupOrderRoutes(router *mux.Router) {
 	userOrderRoutes.HandleFunc("", controller.CreateOrder).Methods("POST")
 	userOrderRoutes.HandleFunc("/cancel", controller.CancelOrder).Methods("POST")
 	userOrderRoutes.HandleFunc("/payment", controller.ProcessPayment).Methods("POST")
+	userOrderRoutes.HandleFunc("/returns", controller.CreateReturnRequest).Methods("POST")
+	userOrderRoutes.HandleFunc("/returns", controller.GetMyReturnRequests).Methods("GET")
 
 	// Note: GetOrderHistory and GetOrderDetails are already defined in user_routes.go
 	// under /api/users/orders and /api/users/orders/{id}
@@ -23,4 +25,5 @@ func SetupOrderRoutes(router *mux.Router) {
 	adminOrderRoutes.Use(utils.ValidateAdmin)
 	adminOrderRoutes.HandleFunc("", controller.GetAllOrders).Methods("GET")
 	adminOrderRoutes.HandleFunc("/status", controller.UpdateOrderStatus).Methods("PUT")
+	adminOrderRoutes.HandleFunc("/refund", controller.RefundOrder).Methods("POST")
 }
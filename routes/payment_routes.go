@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/controller"
+	"github.com/pratyush934/sibling-bond-server/utils"
+)
+
+// SetupPaymentRoutes configures provider webhooks and the admin payment tools
+func SetupPaymentRoutes(router *mux.Router) {
+	// Webhooks are authenticated by the provider signature, not by a user token
+	paymentRoutes := router.PathPrefix("/api/payments").Subrouter()
+	paymentRoutes.HandleFunc("/webhook/{provider}", controller.PaymentWebhook).Methods("POST")
+
+	adminPaymentRoutes := router.PathPrefix("/api/admin/payments").Subrouter()
+	adminPaymentRoutes.Use(utils.ValidateAdmin)
+	adminPaymentRoutes.HandleFunc("/fake/simulate", controller.SimulateFakePayment).Methods("POST")
+	adminPaymentRoutes.HandleFunc("/refund", controller.RefundPayment).Methods("POST")
+}
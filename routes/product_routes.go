@@ -18,8 +18,20 @@ func SetupProductRoutes(router *mux.Router) {
 	// Admin-only routes that require authentication
 	adminProductsRouter := router.PathPrefix("/api/admin/products").Subrouter()
 	adminProductsRouter.Use(utils.ValidateAdmin)
-	adminProductsRouter.HandleFunc("", controller.GetAllProducts).Methods("GET") // Admin GET
+	adminProductsRouter.HandleFunc("", controller.GetAllProductsByAdmin).Methods("GET") // Admin GET
 	adminProductsRouter.HandleFunc("", controller.CreateProduct).Methods("POST")
+
+	// Bulk import and export of the catalog
+	adminProductsRouter.HandleFunc("/import", controller.ImportProducts).Methods("POST")
+	adminProductsRouter.HandleFunc("/import", controller.GetProductImport).Methods("GET")
+	adminProductsRouter.HandleFunc("/export", controller.ExportProducts).Methods("GET")
+
 	adminProductsRouter.HandleFunc("/{id}", controller.UpdateProductDetails).Methods("PUT")
 	adminProductsRouter.HandleFunc("/{id}", controller.DeleteProduct).Methods("DELETE")
+
+	// Variant management
+	adminProductsRouter.HandleFunc("/{id}/variants", controller.GetProductVariants).Methods("GET")
+	adminProductsRouter.HandleFunc("/{id}/variants", controller.CreateProductVariant).Methods("POST")
+	adminProductsRouter.HandleFunc("/{id}/variants/{variantId}", controller.UpdateProductVariant).Methods("PUT")
+	adminProductsRouter.HandleFunc("/{id}/variants/{variantId}", controller.DeleteProductVariant).Methods("DELETE")
 }
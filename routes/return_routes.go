@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/controller"
+	"github.com/pratyush934/sibling-bond-server/utils"
+)
+
+// SetupReturnRoutes configures the admin side of the returns workflow
+func SetupReturnRoutes(router *mux.Router) {
+	adminReturnRoutes := router.PathPrefix("/api/admin/returns").Subrouter()
+	adminReturnRoutes.Use(utils.ValidateAdmin)
+	adminReturnRoutes.HandleFunc("", controller.GetAllReturnRequests).Methods("GET")
+	adminReturnRoutes.HandleFunc("/{id}", controller.GetReturnRequestById).Methods("GET")
+	adminReturnRoutes.HandleFunc("/{id}/approve", controller.ApproveReturn).Methods("PUT")
+	adminReturnRoutes.HandleFunc("/{id}/reject", controller.RejectReturn).Methods("PUT")
+	adminReturnRoutes.HandleFunc("/{id}/receive", controller.ReceiveReturn).Methods("PUT")
+	adminReturnRoutes.HandleFunc("/{id}/refund", controller.RefundReturn).Methods("POST")
+}
@@ -4,20 +4,25 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/pratyush934/sibling-bond-server/controller"
 	"github.com/pratyush934/sibling-bond-server/utils"
+	"net/http"
 )
 
 // SetupUserRoutes configures all user-related routes
 func SetupUserRoutes(router *mux.Router) {
 	// Public routes (no authentication required)
-	router.HandleFunc("/api/users/register", controller.Register).Methods("POST")
-	router.HandleFunc("/api/users/login", controller.Login).Methods("POST")
-	router.HandleFunc("/api/users/forgot-password", controller.ForgotPassWord).Methods("POST")
+	// the credential endpoints are throttled per client address, the handlers add a per account limit
+	router.Handle("/api/users/register", utils.RateLimitByIP("register")(http.HandlerFunc(controller.Register))).Methods("POST")
+	router.Handle("/api/users/login", utils.RateLimitByIP("login")(http.HandlerFunc(controller.Login))).Methods("POST")
+	router.Handle("/api/users/forgot-password", utils.RateLimitByIP("forgot-password")(http.HandlerFunc(controller.ForgotPassWord))).Methods("POST")
 	router.HandleFunc("/api/users/reset-password", controller.ResetPasswordFromToken).Methods("POST")
+	router.HandleFunc("/api/users/token/refresh", controller.RefreshToken).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", controller.GetJWKS).Methods("GET")
 
 	// Authenticated user routes
 	userRoutes := router.PathPrefix("/api/users").Subrouter()
 	userRoutes.Use(utils.ValidateUser) // Assuming you have this middleware to validate JWT
 	userRoutes.HandleFunc("/logout", controller.LogOut).Methods("POST")
+	userRoutes.HandleFunc("/logout-all", controller.LogOutAll).Methods("POST")
 	userRoutes.HandleFunc("/profile", controller.GetProfile).Methods("GET")
 	userRoutes.HandleFunc("/change-password", controller.ChangePassword).Methods("POST")
 
@@ -30,6 +35,7 @@ func SetupUserRoutes(router *mux.Router) {
 	// Order routes
 	userRoutes.HandleFunc("/orders", controller.GetOrderHistory).Methods("GET")
 	userRoutes.HandleFunc("/orders/{id}", controller.GetOrderDetails).Methods("GET")
+	userRoutes.HandleFunc("/orders/{id}/timeline", controller.GetOrderTimeline).Methods("GET")
 
 	// Admin routes
 	adminRoutes := router.PathPrefix("/api/admin/users").Subrouter()
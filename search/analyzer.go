@@ -0,0 +1,150 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords carry no meaning in a product search and are dropped from documents and queries.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+// Tokenize splits text into lower-cased words without the stop words.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := fields[:0]
+	for _, field := range fields {
+		if stopWords[field] {
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+	return tokens
+}
+
+// Analyze tokenizes and stems text, it is applied the same way to documents and queries.
+func Analyze(text string) []string {
+	tokens := Tokenize(text)
+	for i, token := range tokens {
+		tokens[i] = Stem(token)
+	}
+	return tokens
+}
+
+// Stem is a light English suffix stripper. It does not try to produce real words, only to bring
+// the forms shoppers type to the same term: "shirts", "shirt" -> "shirt", "candles" -> "candl",
+// "handcrafted", "handcrafting" -> "handcraft", "batteries" -> "battery".
+func Stem(word string) string {
+	if len(word) <= 3 || !isASCIIWord(word) {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") ||
+		strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "zes"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly", "ness", "ment", "ful"} {
+		stem := strings.TrimSuffix(word, suffix)
+		if stem == word || len(stem) < 3 || !hasVowel(stem) {
+			continue
+		}
+		word = stem
+		// "shipped" -> "shipp" -> "ship"
+		if n := len(word); n > 3 && word[n-1] == word[n-2] && !strings.ContainsRune("lsz", rune(word[n-1])) {
+			word = word[:n-1]
+		}
+		break
+	}
+
+	// "candle" and "candl" from "candles"/"candled" have to meet
+	if n := len(word); n > 4 && word[n-1] == 'e' {
+		word = word[:n-1]
+	}
+	return word
+}
+
+func isASCIIWord(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasVowel(word string) bool {
+	return strings.ContainsAny(word, "aeiouy")
+}
+
+// maxTypos is how many edits a query term may be away from an indexed term and still match.
+// Short terms have to be exact, there are too many near neighbours.
+func maxTypos(term string) int {
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment) distance between a and b,
+// giving up with max+1 as soon as it is certain to exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			// a swap of two neighbouring letters is one typo, not two
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
@@ -0,0 +1,83 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"The Lamp of the Night", []string{"lamp", "night"}},
+		{"brass-lamp, 12V!", []string{"brass", "lamp", "12v"}},
+		{"Kurta für Männer", []string{"kurta", "für", "männer"}},
+		{"a an the", []string{}},
+	}
+	for _, c := range cases {
+		got := Tokenize(c.text)
+		if len(got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	cases := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"shirt", "shirts"}, "shirt"},
+		{[]string{"candle", "candles", "candled"}, "candl"},
+		{[]string{"handcrafted", "handcrafting"}, "handcraft"},
+		{[]string{"battery", "batteries"}, "battery"},
+		{[]string{"box", "boxes"}, "box"},
+		{[]string{"ship", "shipped", "shipping"}, "ship"},
+		{[]string{"glass"}, "glass"},
+		{[]string{"cactus"}, "cactus"},
+		{[]string{"bus"}, "bus"},
+		{[]string{"männer"}, "männer"},
+	}
+	for _, c := range cases {
+		for _, word := range c.words {
+			if got := Stem(word); got != c.want {
+				t.Errorf("Stem(%q) = %q, want %q", word, got, c.want)
+			}
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"lamp", "lamp", 1, 0},
+		{"lamp", "lmap", 1, 1},
+		{"lamp", "lamb", 1, 1},
+		{"lamp", "lam", 1, 1},
+		{"lamp", "clamps", 2, 2},
+		// further than max answers max+1
+		{"lamp", "table", 1, 2},
+		{"cushion", "cousin", 1, 2},
+	}
+	for _, c := range cases {
+		if got := editDistance(c.a, c.b, c.max); got != c.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", c.a, c.b, c.max, got, c.want)
+		}
+	}
+}
+
+func TestMaxTypos(t *testing.T) {
+	for term, want := range map[string]int{"mug": 0, "lamp": 1, "cushion": 1, "handcraft": 2} {
+		if got := maxTypos(term); got != want {
+			t.Errorf("maxTypos(%q) = %d, want %d", term, got, want)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field weights, a word in the name says more about a product than the same word in its description
+const (
+	weightName        = 3.0
+	weightSKU         = 3.0
+	weightCategory    = 1.5
+	weightAttribute   = 1.5
+	weightDescription = 1.0
+)
+
+// how much a term counts when it only matched the query term loosely
+const (
+	matchPrefix = 0.6
+	matchTypo1  = 0.7
+	matchTypo2  = 0.5
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// MemoryIndex is an inverted index over the stemmed terms of every document, ranked with BM25.
+// It is rebuilt from the database at startup, so it only suits a catalogue that fits in memory.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedDoc
+	postings map[string]map[string]float64 // term -> document id -> weighted term frequency
+	totalLen int
+}
+
+type indexedDoc struct {
+	Document
+	terms  map[string]float64
+	length int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     map[string]*indexedDoc{},
+		postings: map[string]map[string]float64{},
+	}
+}
+
+func (m *MemoryIndex) Name() string {
+	return "memory"
+}
+
+func (m *MemoryIndex) Upsert(_ context.Context, docs ...Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, doc := range docs {
+		m.remove(doc.ID)
+		m.add(doc)
+	}
+	return nil
+}
+
+func (m *MemoryIndex) Remove(_ context.Context, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		m.remove(id)
+	}
+	return nil
+}
+
+func (m *MemoryIndex) Replace(_ context.Context, docs []Document) error {
+	fresh := NewMemoryIndex()
+	for _, doc := range docs {
+		fresh.add(doc)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs, m.postings, m.totalLen = fresh.docs, fresh.postings, fresh.totalLen
+	return nil
+}
+
+func (m *MemoryIndex) add(doc Document) {
+	indexed := &indexedDoc{Document: doc, terms: map[string]float64{}}
+	addField := func(text string, weight float64) {
+		for _, term := range Analyze(text) {
+			indexed.terms[term] += weight
+			indexed.length++
+		}
+	}
+	addField(doc.Name, weightName)
+	addField(doc.SKU, weightSKU)
+	addField(doc.CategoryName, weightCategory)
+	addField(doc.Description, weightDescription)
+	for name, values := range doc.Attributes {
+		addField(name, weightAttribute)
+		addField(strings.Join(values, " "), weightAttribute)
+	}
+
+	m.docs[doc.ID] = indexed
+	m.totalLen += indexed.length
+	for term, tf := range indexed.terms {
+		if m.postings[term] == nil {
+			m.postings[term] = map[string]float64{}
+		}
+		m.postings[term][doc.ID] = tf
+	}
+}
+
+func (m *MemoryIndex) remove(id string) {
+	indexed, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for term := range indexed.terms {
+		delete(m.postings[term], id)
+		if len(m.postings[term]) == 0 {
+			delete(m.postings, term)
+		}
+	}
+	m.totalLen -= indexed.length
+	delete(m.docs, id)
+}
+
+func (m *MemoryIndex) Search(_ context.Context, q Query) (*Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	scores := m.score(q.Text)
+
+	var hits []Hit
+	counter := newFacetCounter(q)
+	for id, doc := range m.docs {
+		score, matched := 1.0, true
+		if scores != nil {
+			score, matched = scores[id]
+		}
+		if !matched {
+			continue
+		}
+		if counter.add(doc.Document) {
+			hits = append(hits, Hit{ID: id, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		a, b := m.docs[hits[i].ID], m.docs[hits[j].ID]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	if scores == nil {
+		for i := range hits {
+			hits[i].Score = 0
+		}
+	}
+
+	return &Result{Hits: page(hits, q.Limit, q.Offset), Total: len(hits), Facets: counter.facets()}, nil
+}
+
+// score ranks every document matching the text, nil means there was no text to match.
+// Documents matching all query terms win, when there are none the partial matches are returned.
+func (m *MemoryIndex) score(text string) map[string]float64 {
+	raw := Tokenize(text)
+	if len(raw) == 0 {
+		return nil
+	}
+
+	docCount := float64(len(m.docs))
+	avgLen := 1.0
+	if len(m.docs) > 0 && m.totalLen > 0 {
+		avgLen = float64(m.totalLen) / docCount
+	}
+
+	scores := map[string]float64{}
+	matchedTerms := map[string]int{}
+	seen := map[string]bool{}
+	for i, token := range raw {
+		term := Stem(token)
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		// the best way each document matched this query term
+		best := map[string]float64{}
+		for indexTerm, factor := range m.expand(term, token, i == len(raw)-1) {
+			postings := m.postings[indexTerm]
+			df := float64(len(postings))
+			idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+			for id, tf := range postings {
+				norm := tf + bm25K1*(1-bm25B+bm25B*float64(m.docs[id].length)/avgLen)
+				if s := factor * idf * tf * (bm25K1 + 1) / norm; s > best[id] {
+					best[id] = s
+				}
+			}
+		}
+		for id, s := range best {
+			scores[id] += s
+			matchedTerms[id]++
+		}
+	}
+
+	terms := len(seen)
+	full := map[string]float64{}
+	for id, s := range scores {
+		if matchedTerms[id] == terms {
+			full[id] = s
+		}
+	}
+	if len(full) > 0 {
+		return full
+	}
+	for id := range scores {
+		scores[id] *= float64(matchedTerms[id]) / float64(terms)
+	}
+	return scores
+}
+
+// expand finds the indexed terms a query term matches and how much each match counts. The last
+// word of a query may still be being typed, so it also matches as a prefix.
+func (m *MemoryIndex) expand(term, raw string, last bool) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := m.postings[term]; ok {
+		matches[term] = 1
+	}
+
+	typos := maxTypos(term)
+	prefix := last && len(raw) >= 3
+	if typos == 0 && !prefix {
+		return matches
+	}
+
+	for indexTerm := range m.postings {
+		if indexTerm == term {
+			continue
+		}
+		factor := 0.0
+		if prefix && strings.HasPrefix(indexTerm, raw) {
+			factor = matchPrefix
+		}
+		// editDistance answers typos+1 for anything further away
+		if d := editDistance(term, indexTerm, typos); typos > 0 && d == 1 {
+			factor = math.Max(factor, matchTypo1)
+		} else if typos > 1 && d == 2 {
+			factor = math.Max(factor, matchTypo2)
+		}
+		if factor > 0 {
+			matches[indexTerm] = factor
+		}
+	}
+	return matches
+}
+
+func page(hits []Hit, limit, offset int) []Hit {
+	if offset >= len(hits) {
+		return []Hit{}
+	}
+	hits = hits[offset:]
+	if limit > 0 && limit < len(hits) {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// facetCounter applies the query filters to one document at a time and counts every facet
+// over the documents that pass all the other filters.
+type facetCounter struct {
+	q          Query
+	categories map[string]*FacetCount
+	bands      []int
+	stock      map[string]int
+	attributes map[string]map[string]int
+}
+
+func newFacetCounter(q Query) *facetCounter {
+	return &facetCounter{
+		q:          q,
+		categories: map[string]*FacetCount{},
+		bands:      make([]int, len(PriceBands)),
+		stock:      map[string]int{},
+		attributes: map[string]map[string]int{},
+	}
+}
+
+// add counts doc and reports whether it passes every filter.
+func (c *facetCounter) add(doc Document) bool {
+	q := c.q
+	categoryOK := len(q.CategoryIds) == 0 || containsValue(q.CategoryIds, doc.CategoryId)
+	priceOK := (q.MinPrice == nil || doc.Price >= *q.MinPrice) && (q.MaxPrice == nil || doc.Price <= *q.MaxPrice)
+	stockOK := q.InStock == nil || doc.InStock == *q.InStock
+
+	// failedAttribute is the one attribute filter doc fails, "" when it passes all,
+	// failing two or more means it is left out of every facet that needs the attributes
+	failedAttribute, attributeFailures := "", 0
+	for name, value := range q.Attributes {
+		if !containsValue(doc.Attributes[name], value) {
+			failedAttribute = name
+			attributeFailures++
+		}
+	}
+	attributesOK := attributeFailures == 0
+
+	if priceOK && stockOK && attributesOK {
+		facet := c.categories[doc.CategoryId]
+		if facet == nil {
+			facet = &FacetCount{Value: doc.CategoryId, Label: doc.CategoryName}
+			c.categories[doc.CategoryId] = facet
+		}
+		facet.Count++
+	}
+	if categoryOK && stockOK && attributesOK {
+		for i, band := range PriceBands {
+			if band.Contains(doc.Price) {
+				c.bands[i]++
+			}
+		}
+	}
+	if categoryOK && priceOK && attributesOK {
+		if doc.InStock {
+			c.stock[StockIn]++
+		} else {
+			c.stock[StockOut]++
+		}
+	}
+	if categoryOK && priceOK && stockOK && attributeFailures <= 1 {
+		for name, values := range doc.Attributes {
+			if attributeFailures == 1 && name != failedAttribute {
+				continue
+			}
+			if c.attributes[name] == nil {
+				c.attributes[name] = map[string]int{}
+			}
+			for _, value := range values {
+				c.attributes[name][value]++
+			}
+		}
+	}
+
+	return categoryOK && priceOK && stockOK && attributesOK
+}
+
+func (c *facetCounter) facets() Facets {
+	facets := Facets{
+		Categories: []FacetCount{},
+		PriceBands: make([]FacetCount, len(PriceBands)),
+		Stock: []FacetCount{
+			{Value: StockIn, Count: c.stock[StockIn]},
+			{Value: StockOut, Count: c.stock[StockOut]},
+		},
+		Attributes: map[string][]FacetCount{},
+	}
+	for _, facet := range c.categories {
+		facets.Categories = append(facets.Categories, *facet)
+	}
+	sortFacets(facets.Categories)
+	for i, band := range PriceBands {
+		facets.PriceBands[i] = FacetCount{Value: band.Key, Count: c.bands[i]}
+	}
+	for name, values := range c.attributes {
+		counts := make([]FacetCount, 0, len(values))
+		for value, count := range values {
+			counts = append(counts, FacetCount{Value: value, Count: count})
+		}
+		sortFacets(counts)
+		facets.Attributes[name] = counts
+	}
+	return facets
+}
+
+func sortFacets(counts []FacetCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
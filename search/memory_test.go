@@ -0,0 +1,205 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newCatalog indexes a small catalogue: three lamps, a cushion and a candle over two categories.
+func newCatalog(t *testing.T) *MemoryIndex {
+	t.Helper()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []Document{
+		{ID: "brass-lamp", Name: "Brass Table Lamp", Description: "A handcrafted lamp for the bedside.", CategoryId: "lighting", CategoryName: "Lighting",
+			Price: 150000, InStock: true, Attributes: map[string][]string{"finish": {"brass"}}, CreatedAt: start},
+		{ID: "floor-lamp", Name: "Floor Lamp", Description: "Tall and bright.", CategoryId: "lighting", CategoryName: "Lighting",
+			Price: 450000, InStock: false, Attributes: map[string][]string{"finish": {"black"}}, CreatedAt: start.Add(time.Hour)},
+		{ID: "lamp-shade", Name: "Linen Shade", Description: "Fits any table lamp.", CategoryId: "lighting", CategoryName: "Lighting",
+			Price: 40000, InStock: true, Attributes: map[string][]string{"finish": {"linen"}}, CreatedAt: start.Add(2 * time.Hour)},
+		{ID: "cushion", Name: "Cotton Cushion", Description: "Soft cushion cover.", CategoryId: "decor", CategoryName: "Home Decor",
+			Price: 80000, InStock: true, Attributes: map[string][]string{"size": {"m", "l"}}, CreatedAt: start.Add(3 * time.Hour)},
+		{ID: "candle", Name: "Scented Candles", Description: "Handcrafting takes two days.", CategoryId: "decor", CategoryName: "Home Decor",
+			SKU: "CND-01", Price: 30000, InStock: false, CreatedAt: start.Add(4 * time.Hour)},
+	}
+	idx := NewMemoryIndex()
+	if err := idx.Replace(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func search(t *testing.T, idx *MemoryIndex, q Query) *Result {
+	t.Helper()
+	result, err := idx.Search(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func hitIds(result *Result) []string {
+	ids := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestMemoryIndexRanking(t *testing.T) {
+	idx := newCatalog(t)
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		// lamp in name and description, then in the name, then only in the description
+		{"weighted fields", "lamp", []string{"brass-lamp", "floor-lamp", "lamp-shade"}},
+		{"every term has to match", "brass lamp", []string{"brass-lamp"}},
+		{"stemmed forms meet", "candle", []string{"candle"}},
+		{"stemmed query", "handcrafted", []string{"brass-lamp", "candle"}},
+		// equal scores, the newer product first
+		{"category name", "decor", []string{"candle", "cushion"}},
+		{"sku", "cnd", []string{"candle"}},
+		{"attribute value", "linen", []string{"lamp-shade"}},
+		{"partial matches when nothing matches every term", "brass vase", []string{"brass-lamp"}},
+		{"empty text lists newest first", "", []string{"candle", "cushion", "lamp-shade", "floor-lamp", "brass-lamp"}},
+		{"only stop words", "the of", []string{"candle", "cushion", "lamp-shade", "floor-lamp", "brass-lamp"}},
+		{"no match", "vase", []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := search(t, idx, Query{Text: c.text})
+			if got := hitIds(result); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+			if result.Total != len(c.want) {
+				t.Fatalf("total %d, want %d", result.Total, len(c.want))
+			}
+		})
+	}
+}
+
+func TestMemoryIndexTypos(t *testing.T) {
+	idx := newCatalog(t)
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"lmap", []string{"brass-lamp", "floor-lamp", "lamp-shade"}},
+		// "table" stems to "tabl", one letter away from "tall"
+		{"table", []string{"brass-lamp", "floor-lamp", "lamp-shade"}},
+		{"cushoin", []string{"cushion"}},
+		{"handcraftde", []string{"brass-lamp", "candle"}},
+		// the last word may still be being typed
+		{"cush", []string{"cushion"}},
+		{"brass lam", []string{"brass-lamp"}},
+		// short terms have to be exact
+		{"lam brass", []string{"brass-lamp"}},
+		{"mug", []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			if got := hitIds(search(t, idx, Query{Text: c.text})); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	exact := search(t, idx, Query{Text: "cushion"}).Hits[0].Score
+	typo := search(t, idx, Query{Text: "cushoin"}).Hits[0].Score
+	if typo >= exact {
+		t.Fatalf("a typo scored %v, not less than the exact match %v", typo, exact)
+	}
+}
+
+func TestMemoryIndexFilters(t *testing.T) {
+	idx := newCatalog(t)
+	min, max, inStock := 50000, 200000, true
+	cases := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"category", Query{CategoryIds: []string{"decor"}}, []string{"candle", "cushion"}},
+		{"price range", Query{MinPrice: &min, MaxPrice: &max}, []string{"cushion", "brass-lamp"}},
+		{"in stock", Query{Text: "lamp", InStock: &inStock}, []string{"brass-lamp", "lamp-shade"}},
+		{"attribute", Query{Attributes: map[string]string{"finish": "brass"}}, []string{"brass-lamp"}},
+		{"paged", Query{Limit: 2, Offset: 1}, []string{"cushion", "lamp-shade"}},
+		{"past the end", Query{Offset: 10}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := hitIds(search(t, idx, c.q)); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMemoryIndexFacets(t *testing.T) {
+	idx := newCatalog(t)
+	inStock := true
+	result := search(t, idx, Query{CategoryIds: []string{"lighting"}, InStock: &inStock, Attributes: map[string]string{"finish": "brass"}})
+
+	if got := hitIds(result); !reflect.DeepEqual(got, []string{"brass-lamp"}) {
+		t.Fatalf("hits %v", got)
+	}
+	// each facet ignores its own filter: the decor category has no brass finish, so it counts 0
+	// products and is not listed
+	wantCategories := []FacetCount{{Value: "lighting", Label: "Lighting", Count: 1}}
+	if !reflect.DeepEqual(result.Facets.Categories, wantCategories) {
+		t.Errorf("categories %v, want %v", result.Facets.Categories, wantCategories)
+	}
+	wantStock := []FacetCount{{Value: StockIn, Count: 1}, {Value: StockOut, Count: 0}}
+	if !reflect.DeepEqual(result.Facets.Stock, wantStock) {
+		t.Errorf("stock %v, want %v", result.Facets.Stock, wantStock)
+	}
+	// the finish facet counts the in stock lighting whatever their finish
+	wantFinish := []FacetCount{{Value: "brass", Count: 1}, {Value: "linen", Count: 1}}
+	if got := result.Facets.Attributes["finish"]; !reflect.DeepEqual(got, wantFinish) {
+		t.Errorf("finish %v, want %v", got, wantFinish)
+	}
+	if _, ok := result.Facets.Attributes["size"]; ok {
+		t.Errorf("size facet counted products outside the category")
+	}
+
+	all := search(t, idx, Query{})
+	wantBands := []int{2, 1, 1, 1, 0}
+	for i, band := range all.Facets.PriceBands {
+		if band.Value != PriceBands[i].Key || band.Count != wantBands[i] {
+			t.Errorf("band %d = %v, want %s with %d", i, band, PriceBands[i].Key, wantBands[i])
+		}
+	}
+	wantAllCategories := []FacetCount{{Value: "lighting", Label: "Lighting", Count: 3}, {Value: "decor", Label: "Home Decor", Count: 2}}
+	if !reflect.DeepEqual(all.Facets.Categories, wantAllCategories) {
+		t.Errorf("categories %v, want %v", all.Facets.Categories, wantAllCategories)
+	}
+}
+
+func TestMemoryIndexUpsertAndRemove(t *testing.T) {
+	ctx := context.Background()
+	idx := newCatalog(t)
+
+	renamed := Document{ID: "cushion", Name: "Velvet Pillow", CategoryId: "decor", CategoryName: "Home Decor", Price: 80000, InStock: true}
+	if err := idx.Upsert(ctx, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIds(search(t, idx, Query{Text: "cotton"})); len(got) != 0 {
+		t.Fatalf("old name still matches: %v", got)
+	}
+	if got := hitIds(search(t, idx, Query{Text: "pillow"})); !reflect.DeepEqual(got, []string{"cushion"}) {
+		t.Fatalf("new name: got %v", got)
+	}
+
+	if err := idx.Remove(ctx, "brass-lamp", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIds(search(t, idx, Query{Text: "lamp"})); !reflect.DeepEqual(got, []string{"floor-lamp", "lamp-shade"}) {
+		t.Fatalf("after remove: got %v", got)
+	}
+	if _, ok := idx.postings["brass"]; ok {
+		t.Fatal("terms of the removed document stay in the index")
+	}
+}
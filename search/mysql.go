@@ -0,0 +1,235 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+/*
+	MySQLIndex searches the FULLTEXT indexes on products (migration 0004) in boolean mode. Query
+	words are stemmed and matched as prefixes, so "shirts" finds "shirt", but there is no typo
+	tolerance: a misspelt word only matches what shares its stem. Name matches weigh triple.
+*/
+
+const mysqlNameBoost = 3
+
+// mysqlInStock mirrors Document.InStock: the product or one of its active variants has stock.
+const mysqlInStock = "(p.stock > 0 OR EXISTS (SELECT 1 FROM product_variants sv WHERE sv.product_id = p.id AND sv.is_active = true AND sv.stock > 0))"
+
+// mysqlAttributeMatch mirrors Document.Attributes for one name/value pair: an active variant with
+// that variant name and value, or an enum or boolean attribute value with that key set on the
+// product or on one of its active variants.
+const mysqlAttributeMatch = "(EXISTS (SELECT 1 FROM product_variants av WHERE av.product_id = p.id AND av.is_active = true " +
+	"AND LOWER(av.variant_name) = ? AND LOWER(av.variant_value) = ?) " +
+	"OR EXISTS (SELECT 1 FROM product_attribute_values pav JOIN category_attributes ca ON ca.id = pav.attribute_id " +
+	"LEFT JOIN product_variants pv ON pv.id = pav.variant_id " +
+	"WHERE (pav.product_id = p.id OR (pv.product_id = p.id AND pv.is_active = true)) " +
+	"AND ca.type IN ('enum', 'boolean') AND ca.key = ? AND LOWER(pav.value) = ?))"
+
+// mysqlAttributePairs lists every name/value pair mysqlAttributeMatch can match, one row per
+// product and pair, for the attribute facets.
+const mysqlAttributePairs = "(SELECT v.product_id AS product_id, LOWER(v.variant_name) AS name, LOWER(v.variant_value) AS value " +
+	"FROM product_variants v WHERE v.is_active = true AND v.variant_name <> '' AND v.variant_value <> '' " +
+	"UNION SELECT COALESCE(pav.product_id, pv.product_id), ca.key, LOWER(pav.value) " +
+	"FROM product_attribute_values pav JOIN category_attributes ca ON ca.id = pav.attribute_id " +
+	"LEFT JOIN product_variants pv ON pv.id = pav.variant_id " +
+	"WHERE ca.type IN ('enum', 'boolean') AND (pav.product_id IS NOT NULL OR pv.is_active = true)) AS a"
+
+type MySQLIndex struct {
+	db *gorm.DB
+}
+
+func NewMySQLIndex(db *gorm.DB) *MySQLIndex {
+	return &MySQLIndex{db: db}
+}
+
+func (m *MySQLIndex) Name() string {
+	return "mysql"
+}
+
+// facet dimensions a filtered query can leave out
+const (
+	skipNone      = ""
+	skipCategory  = "category"
+	skipPrice     = "price"
+	skipStock     = "stock"
+	skipAttribute = "attribute:"
+)
+
+// booleanQuery turns text into a boolean mode expression of stemmed prefix terms.
+func booleanQuery(text string) string {
+	terms := Analyze(text)
+	for i, term := range terms {
+		terms[i] = term + "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// filtered selects the active products matching the text and every filter but skip. skip may
+// also be skipAttribute followed by an attribute name.
+func (m *MySQLIndex) filtered(ctx context.Context, q Query, match, skip string) *gorm.DB {
+	query := m.db.WithContext(ctx).Table("products AS p").Where("p.deleted_at IS NULL AND p.is_active = ?", true)
+	if match != "" {
+		query = query.Where("MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)", match)
+	}
+	if len(q.CategoryIds) > 0 && skip != skipCategory {
+		query = query.Where("p.category_id IN ?", q.CategoryIds)
+	}
+	if skip != skipPrice {
+		if q.MinPrice != nil {
+			query = query.Where("p.price >= ?", *q.MinPrice)
+		}
+		if q.MaxPrice != nil {
+			query = query.Where("p.price <= ?", *q.MaxPrice)
+		}
+	}
+	if q.InStock != nil && skip != skipStock {
+		if *q.InStock {
+			query = query.Where(mysqlInStock)
+		} else {
+			query = query.Where("NOT " + mysqlInStock)
+		}
+	}
+	for name, value := range q.Attributes {
+		if skip == skipAttribute+name {
+			continue
+		}
+		query = query.Where(mysqlAttributeMatch, name, value, name, value)
+	}
+	return query
+}
+
+func (m *MySQLIndex) Search(ctx context.Context, q Query) (*Result, error) {
+	match := booleanQuery(q.Text)
+	if strings.TrimSpace(q.Text) != "" && match == "" {
+		// only stop words, nothing can match
+		return &Result{Hits: []Hit{}, Facets: Facets{Categories: []FacetCount{}, Attributes: map[string][]FacetCount{}}}, nil
+	}
+
+	var total int64
+	if err := m.filtered(ctx, q, match, skipNone).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	hits := []Hit{}
+	query := m.filtered(ctx, q, match, skipNone)
+	if match != "" {
+		query = query.Select("p.id AS id, (? * MATCH(p.name) AGAINST (? IN BOOLEAN MODE) + MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)) AS score", mysqlNameBoost, match, match).
+			Order("score DESC")
+	} else {
+		query = query.Select("p.id AS id, 0 AS score")
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	if err := query.Order("p.created_at DESC").Order("p.id").Offset(q.Offset).Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+
+	facets, err := m.facets(ctx, q, match)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Hits: hits, Total: int(total), Facets: *facets}, nil
+}
+
+func (m *MySQLIndex) facets(ctx context.Context, q Query, match string) (*Facets, error) {
+	facets := &Facets{Attributes: map[string][]FacetCount{}}
+
+	facets.Categories = []FacetCount{}
+	if err := m.filtered(ctx, q, match, skipCategory).
+		Joins("LEFT JOIN categories c ON c.id = p.category_id").
+		Select("p.category_id AS value, COALESCE(c.name, '') AS label, COUNT(*) AS count").
+		Group("p.category_id, c.name").
+		Order("count DESC").Order("p.category_id").
+		Scan(&facets.Categories).Error; err != nil {
+		return nil, err
+	}
+
+	// one CASE branch per band, in band order
+	var band strings.Builder
+	var bandArgs []any
+	band.WriteString("CASE")
+	for i, b := range PriceBands {
+		if b.Max > 0 {
+			band.WriteString(" WHEN p.price >= ? AND p.price < ? THEN ?")
+			bandArgs = append(bandArgs, b.Min, b.Max, i)
+		} else {
+			band.WriteString(" WHEN p.price >= ? THEN ?")
+			bandArgs = append(bandArgs, b.Min, i)
+		}
+	}
+	band.WriteString(" ELSE -1 END")
+
+	var bandRows []struct {
+		Band  int
+		Count int
+	}
+	if err := m.filtered(ctx, q, match, skipPrice).
+		Select(fmt.Sprintf("%s AS band, COUNT(*) AS count", band.String()), bandArgs...).
+		Group("band").
+		Scan(&bandRows).Error; err != nil {
+		return nil, err
+	}
+	facets.PriceBands = make([]FacetCount, len(PriceBands))
+	for i, b := range PriceBands {
+		facets.PriceBands[i] = FacetCount{Value: b.Key}
+	}
+	for _, row := range bandRows {
+		if row.Band >= 0 && row.Band < len(PriceBands) {
+			facets.PriceBands[row.Band].Count = row.Count
+		}
+	}
+
+	var stockRows []FacetCount
+	if err := m.filtered(ctx, q, match, skipStock).
+		Select(fmt.Sprintf("CASE WHEN %s THEN ? ELSE ? END AS value, COUNT(*) AS count", mysqlInStock), StockIn, StockOut).
+		Group("value").
+		Scan(&stockRows).Error; err != nil {
+		return nil, err
+	}
+	stock := map[string]int{}
+	for _, row := range stockRows {
+		stock[row.Value] = row.Count
+	}
+	facets.Stock = []FacetCount{{Value: StockIn, Count: stock[StockIn]}, {Value: StockOut, Count: stock[StockOut]}}
+
+	// attributes without a filter share one query, every filtered one needs its own without it
+	filteredNames := make([]string, 0, len(q.Attributes))
+	for name := range q.Attributes {
+		filteredNames = append(filteredNames, name)
+		if err := m.attributeFacets(m.filtered(ctx, q, match, skipAttribute+name).Where("a.name = ?", name), facets); err != nil {
+			return nil, err
+		}
+	}
+	others := m.filtered(ctx, q, match, skipNone)
+	if len(filteredNames) > 0 {
+		others = others.Where("a.name NOT IN ?", filteredNames)
+	}
+	if err := m.attributeFacets(others, facets); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+func (m *MySQLIndex) attributeFacets(query *gorm.DB, facets *Facets) error {
+	var rows []struct {
+		Name  string
+		Value string
+		Count int
+	}
+	if err := query.
+		Joins("JOIN " + mysqlAttributePairs + " ON a.product_id = p.id").
+		Select("a.name AS name, a.value AS value, COUNT(DISTINCT p.id) AS count").
+		Group("a.name, a.value").
+		Order("count DESC").Order("value").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		facets.Attributes[row.Name] = append(facets.Attributes[row.Name], FacetCount{Value: row.Value, Count: row.Count})
+	}
+	return nil
+}
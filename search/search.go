@@ -0,0 +1,150 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+/*
+	Index answers product searches with relevance ranked hits and facet counts.
+
+	Implementations:
+		memory  MemoryIndex, an inverted index built in process from Documents (default)
+		mysql   MySQLIndex, queries the FULLTEXT index on products, MySQL keeps it up to date
+
+	Indexes that are fed documents by the application also implement DocumentIndex. The
+	models package keeps those in sync when products change and rebuilds them periodically.
+*/
+
+type Index interface {
+	Name() string
+	Search(ctx context.Context, q Query) (*Result, error)
+}
+
+type DocumentIndex interface {
+	Index
+	// Upsert adds the documents or replaces the ones with the same ID.
+	Upsert(ctx context.Context, docs ...Document) error
+	Remove(ctx context.Context, ids ...string) error
+	// Replace swaps the whole content for docs.
+	Replace(ctx context.Context, docs []Document) error
+}
+
+// Document is the searchable view of an active product. Prices are in paise.
+type Document struct {
+	ID           string
+	Name         string
+	Description  string
+	SKU          string
+	CategoryId   string
+	CategoryName string
+	Price        int
+	InStock      bool
+	// Attributes maps a lower-cased variant name to its lower-cased values, e.g. "size": ["m", "l"].
+	Attributes map[string][]string
+	CreatedAt  time.Time
+}
+
+// Query is one search. An empty Text lists every product that passes the filters, newest first.
+type Query struct {
+	Text string
+	// CategoryIds keeps the products of any of these categories, a category and its subcategories.
+	CategoryIds []string
+	MinPrice    *int
+	MaxPrice    *int
+	InStock     *bool
+	// Attributes keeps products with a variant matching every name/value pair.
+	Attributes map[string]string
+	Limit      int
+	Offset     int
+}
+
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+type Result struct {
+	Hits   []Hit  `json:"hits"`
+	Total  int    `json:"total"`
+	Facets Facets `json:"facets"`
+}
+
+// Facets count the matches per value. Every facet ignores its own filter, so picking a category
+// still shows how many matches the other categories have.
+type Facets struct {
+	Categories []FacetCount            `json:"categories"`
+	PriceBands []FacetCount            `json:"priceBands"`
+	Stock      []FacetCount            `json:"stock"`
+	Attributes map[string][]FacetCount `json:"attributes"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// PriceBand is the half open range [Min, Max) in paise, Max 0 means no upper bound.
+type PriceBand struct {
+	Key string
+	Min int
+	Max int
+}
+
+// PriceBands are the buckets of the price facet.
+var PriceBands = []PriceBand{
+	{Key: "0-500", Min: 0, Max: 50000},
+	{Key: "500-1000", Min: 50000, Max: 100000},
+	{Key: "1000-2500", Min: 100000, Max: 250000},
+	{Key: "2500-5000", Min: 250000, Max: 500000},
+	{Key: "5000+", Min: 500000},
+}
+
+func (b PriceBand) Contains(price int) bool {
+	return price >= b.Min && (b.Max == 0 || price < b.Max)
+}
+
+const (
+	StockIn  = "in_stock"
+	StockOut = "out_of_stock"
+)
+
+var ErrUnknownBackend = errors.New("unknown search backend")
+
+var (
+	mu      sync.RWMutex
+	current Index
+)
+
+// SetDefault installs the index product searches go to.
+func SetDefault(idx Index) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = idx
+}
+
+// Default returns the installed index, a fresh MemoryIndex until one is set.
+func Default() Index {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		current = NewMemoryIndex()
+	}
+	return current
+}
+
+// New builds the index named by backend. db is only used by the mysql backend.
+func New(backend string, db *gorm.DB) (Index, error) {
+	switch backend {
+	case "memory", "":
+		return NewMemoryIndex(), nil
+	case "mysql":
+		return NewMySQLIndex(db), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backend)
+}
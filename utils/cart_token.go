@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	CartTokenCookie = "cart_token"
+	CartTokenHeader = "X-Cart-Token"
+	cartTokenTTL    = 30 * 24 * time.Hour
+)
+
+/*
+	Guest carts are identified by "<cartId>.<signature>" where the signature is an
+	HMAC-SHA256 of the cart id, so a client can not guess or forge another guest cart.
+*/
+
+var cartTokenSecret = func() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}()
+
+// SetCartTokenSecret sets the key guest cart tokens are signed with. Without it a random key is
+// used and guest carts are lost on restart.
+func SetCartTokenSecret(secret string) {
+	if secret != "" {
+		cartTokenSecret = []byte(secret)
+	}
+}
+
+func signCartId(cartId string) string {
+	mac := hmac.New(sha256.New, cartTokenSecret)
+	mac.Write([]byte("cart:" + cartId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func CreateCartToken(cartId string) string {
+	return cartId + "." + signCartId(cartId)
+}
+
+func ParseCartToken(token string) (string, error) {
+	cartId, signature, found := strings.Cut(token, ".")
+	if !found || cartId == "" || signature == "" {
+		return "", fmt.Errorf("malformed cart token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(signCartId(cartId))) {
+		return "", fmt.Errorf("invalid cart token signature")
+	}
+	return cartId, nil
+}
+
+// GetCartTokenFromRequest reads the guest cart token from the X-Cart-Token header, falling back to the cookie.
+func GetCartTokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get(CartTokenHeader); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(CartTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func SetCartTokenCookie(w http.ResponseWriter, cartId string) {
+	token := CreateCartToken(cartId)
+	w.Header().Set(CartTokenHeader, token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CartTokenCookie,
+		Value:    token,
+		Expires:  time.Now().Add(cartTokenTTL),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearCartTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CartTokenCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -4,13 +4,38 @@ import (
 	"net/http"
 )
 
+var allowedOrigins = []string{"*"}
+
+// SetAllowedOrigins sets the origins the browser may call us from, "*" allows any.
+func SetAllowedOrigins(origins []string) {
+	allowedOrigins = origins
+}
+
+func allowOrigin(origin string) string {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
 // CORSMiddleware adds CORS headers to all responses
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := allowOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-Cart-Token, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Cart-Token, X-Request-ID")
 
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
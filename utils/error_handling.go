@@ -1,48 +1,49 @@
 package utils
 
 import (
-	"encoding/json"
+	"errors"
 	"github.com/pratyush934/sibling-bond-server/cjson"
-	"log"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"net/http"
+	"runtime/debug"
 )
 
+// ErrorHandler turns a panic into a problem+json response. Handlers panic with *cjson.HTTPError;
+// any other value is a bug, it is logged with its stack and answered with a generic 500 so no
+// internals reach the client.
 func ErrorHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(writer http.ResponseWriter, request *http.Request) {
 			defer func() {
-				if r := recover(); r != nil {
-					writer.Header().Set("Content-Type", "application/json")
-
-					if httpError := r.(*cjson.HTTPError); httpError != nil {
-
-						writer.WriteHeader(httpError.Status)
-
-						if httpError.InternalError != nil {
-							log.Printf("Expected Error exist : Status : %v, Message : %v, InternalError : %v", httpError.Status, httpError.Message, httpError.InternalError)
-						} else {
-							log.Printf("Expected Error exist : Status : %v, Message : %v", httpError.Status, httpError.Message)
-						}
-
-						_ = json.NewEncoder(writer).Encode(cjson.ErrorResponse{
-							Status:        httpError.Status,
-							Message:       httpError.Message,
-							InternalError: httpError.InternalError,
-						})
-
-					} else {
-
-						writer.WriteHeader(http.StatusInternalServerError)
+				r := recover()
+				if r == nil {
+					return
+				}
+				// net/http uses this panic to abort a response on purpose, it must keep going up
+				if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(r)
+				}
 
-						log.Printf("Unexpected panic which was not expected %v", r)
+				logger := logging.Ctx(request.Context())
 
-						_ = json.NewEncoder(writer).Encode(cjson.ErrorResponse{
-							Status:        http.StatusInternalServerError,
-							Message:       "Unexpected Error has happened and we need to fix this",
-							InternalError: nil,
-						})
+				httpError, ok := r.(*cjson.HTTPError)
+				if ok && httpError != nil {
+					event := logger.Warn()
+					if httpError.Status >= http.StatusInternalServerError {
+						event = logger.Error()
+					}
+					event.Err(httpError.InternalError).Int("status", httpError.Status).Str("code", httpError.ErrorCode()).Msg(httpError.Message)
+				} else {
+					logger.Error().Interface("panic", r).Bytes("stack", debug.Stack()).Msg("Unexpected panic which was not expected")
+					httpError = &cjson.HTTPError{
+						Status:  http.StatusInternalServerError,
+						Code:    cjson.CodeInternal,
+						Message: "Unexpected Error has happened and we need to fix this",
 					}
 				}
+
+				problem := httpError.Problem(request.URL.Path, logging.RequestId(request.Context()))
+				_ = cjson.WriteProblem(writer, problem)
 			}()
 			next.ServeHTTP(writer, request)
 		},
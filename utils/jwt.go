@@ -1,45 +1,103 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/logging"
 	"github.com/pratyush934/sibling-bond-server/models"
 	"net/http"
 	"strings"
 	"time"
 )
 
-var privateKey = []byte("iampratyushiampratyushiampratyushiampratyush")
+// AccessTokenTTL is kept short because a stolen access token works until it expires or is revoked;
+// clients get a new one through the refresh token.
+var AccessTokenTTL = 15 * time.Minute
 
 /*
 	1. CreateToken
 	2. GetToken
 	3. GetTokenFromHeader
 	4. ValidateToken
+	5. ensureNotRevoked
 */
 
-func CreateToken(u *models.User) (string, error) {
-	ttl := 1800
+// CreateToken issues an access token for the user bound to the login session it was issued for.
+func CreateToken(u *models.User, sessionId string) (string, error) {
+	now := time.Now()
 
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return signToken(jwt.MapClaims{
 		"id":    u.Id,
 		"email": u.Email,
 		"name":  u.FirstName,
 		"role":  u.RoleId,
-		"st":    time.Now(),
-		"et":    time.Now().Add(time.Second * time.Duration(ttl)).Unix(),
+		"sid":   sessionId,
+		"jti":   uuid.New().String(),
+		"iat":   jwt.NewNumericDate(now),
+		"exp":   jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 	})
-	return claims.SignedString(privateKey)
+}
+
+// ensureNotRevoked rejects tokens that were logged out or whose session has ended.
+func ensureNotRevoked(ctx context.Context, claims jwt.MapClaims) {
+	jti, _ := claims["jti"].(string)
+	sessionId, _ := claims["sid"].(string)
+	if jti == "" || sessionId == "" {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
+			Message:       "Token is missing session claims, please login again",
+			InternalError: fmt.Errorf("token without jti or sid"),
+		})
+	}
+
+	revoked, err := models.IsAccessTokenRevoked(ctx, jti, sessionId)
+	if err != nil {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusInternalServerError,
+			Message:       "Not able to check the token",
+			InternalError: err,
+		})
+	}
+	if revoked {
+		panic(&cjson.HTTPError{
+			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenRevoked,
+			Message:       "Token has been revoked, please login again",
+			InternalError: fmt.Errorf("revoked token %s", jti),
+		})
+	}
+}
+
+// withClaims puts the token claims the handlers rely on into the request context.
+func withClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+	ctx = context.WithValue(ctx, "userId", claims["id"])
+	if userId, ok := claims["id"].(string); ok {
+		ctx = logging.WithUser(ctx, userId)
+	}
+	ctx = context.WithValue(ctx, "email", claims["email"])
+	ctx = context.WithValue(ctx, "role", claims["role"])
+	ctx = context.WithValue(ctx, "name", claims["name"])
+	ctx = context.WithValue(ctx, "jti", claims["jti"])
+	ctx = context.WithValue(ctx, "sessionId", claims["sid"])
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		ctx = context.WithValue(ctx, "tokenExpiresAt", exp.Time)
+	}
+	return ctx
 }
 
 func ValidateAdminRole(r *http.Request) {
 	token := GetToken(r)
 	claims, ok := token.Claims.(jwt.MapClaims)
-	roleId := (uint)(claims["role"].(float64))
+	roleFloat, _ := claims["role"].(float64)
+	roleId := uint(roleFloat)
 	if !ok || !token.Valid || roleId != 2 {
 		panic(&cjson.HTTPError{
-			Status:        http.StatusUnauthorized,
+			Status:        http.StatusForbidden,
+			Code:          cjson.CodeAdminRequired,
 			Message:       "Ye Banda is not admin",
 			InternalError: fmt.Errorf("issue issue issue in admin wala banda"),
 		})
@@ -52,6 +110,7 @@ func ValidateToken(r *http.Request) {
 	if !ok || !token.Valid {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
 			Message:       "Token claim is not correct",
 			InternalError: nil,
 		})
@@ -63,20 +122,21 @@ func GetToken(r *http.Request) *jwt.Token {
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
 			Message:       "Issue from GetToken",
 			InternalError: err,
 		})
 	}
-	parse, err := jwt.Parse(header, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, err
-		}
-		return privateKey, err
-	})
+	parse, err := jwt.Parse(header, verificationKey,
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
 	if err != nil {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
 			Message:       "Invalid or malformed token",
 			InternalError: err,
 		})
@@ -91,6 +151,7 @@ func GetTokenFromHeader(r *http.Request) (string, error) {
 	if len(split) != 2 || split[0] != "Bearer" {
 		panic(&cjson.HTTPError{
 			Status:        http.StatusUnauthorized,
+			Code:          cjson.CodeTokenInvalid,
 			Message:       "Not getting any token or right token from GetTokenFromHeader",
 			InternalError: fmt.Errorf("issue issue"),
 		})
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pratyush934/sibling-bond-server/cjson"
@@ -14,15 +13,12 @@ func ValidateUser(next http.Handler) http.Handler {
 		token := GetToken(request)
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			ctx := context.WithValue(request.Context(), "userId", claims["id"])
-			ctx = context.WithValue(ctx, "email", claims["email"])
-			ctx = context.WithValue(ctx, "role", claims["role"])
-			ctx = context.WithValue(ctx, "name", claims["name"])
-
-			request = request.WithContext(ctx)
+			ensureNotRevoked(request.Context(), claims)
+			request = request.WithContext(withClaims(request.Context(), claims))
 		} else {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "there is an issue while validating the Token, the call is from middleware",
 				InternalError: fmt.Errorf("look at the ValidateUser Middleware, I think the User is not Validated"),
 			})
@@ -39,6 +35,7 @@ func ValidateAdmin(next http.Handler) http.Handler {
 		if !token.Valid {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "Invalid token",
 				InternalError: fmt.Errorf("token validation failed"),
 			})
@@ -48,6 +45,7 @@ func ValidateAdmin(next http.Handler) http.Handler {
 		if !ok {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "Invalid token claims",
 				InternalError: fmt.Errorf("cannot parse token claims"),
 			})
@@ -58,6 +56,7 @@ func ValidateAdmin(next http.Handler) http.Handler {
 		if !exists {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "Role claim missing",
 				InternalError: fmt.Errorf("role claim not found in token"),
 			})
@@ -67,6 +66,7 @@ func ValidateAdmin(next http.Handler) http.Handler {
 		if !ok {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "Invalid role format",
 				InternalError: fmt.Errorf("role claim is not a number"),
 			})
@@ -76,17 +76,15 @@ func ValidateAdmin(next http.Handler) http.Handler {
 		if role != 2 {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusForbidden,
+				Code:          cjson.CodeAdminRequired,
 				Message:       "Admin access required",
 				InternalError: fmt.Errorf("user role %d is not admin (required: 2)", role),
 			})
 		}
 
-		ctx := context.WithValue(request.Context(), "userId", claims["id"])
-		ctx = context.WithValue(ctx, "email", claims["email"])
-		ctx = context.WithValue(ctx, "role", claims["role"])
-		ctx = context.WithValue(ctx, "name", claims["name"])
+		ensureNotRevoked(request.Context(), claims)
 
-		request = request.WithContext(ctx)
+		request = request.WithContext(withClaims(request.Context(), claims))
 		next.ServeHTTP(writer, request)
 	})
 }
@@ -98,27 +96,26 @@ func ValidateTenant(next http.Handler) http.Handler {
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 
-		role := (uint)(claims["role"].(float64))
+		roleFloat, _ := claims["role"].(float64)
+		role := uint(roleFloat)
 
 		if ok && token.Valid {
 
 			if role != 3 {
 				panic(&cjson.HTTPError{
 					Status:        http.StatusForbidden,
+					Code:          cjson.CodeForbidden,
 					Message:       "Tenant access required",
 					InternalError: fmt.Errorf("user role %d is not tenant (required: 3)", role),
 				})
 			}
 
-			ctx := context.WithValue(request.Context(), "userId", claims["id"])
-			ctx = context.WithValue(ctx, "email", claims["email"])
-			ctx = context.WithValue(ctx, "role", claims["role"])
-			ctx = context.WithValue(ctx, "name", claims["name"])
-
-			request = request.WithContext(ctx)
+			ensureNotRevoked(request.Context(), claims)
+			request = request.WithContext(withClaims(request.Context(), claims))
 		} else {
 			panic(&cjson.HTTPError{
 				Status:        http.StatusUnauthorized,
+				Code:          cjson.CodeTokenInvalid,
 				Message:       "there is token while validating the Token for tenant role, the call is from ValidateTenant",
 				InternalError: fmt.Errorf("look at the Validate Middleware, I think user is not tenant"),
 			})
@@ -128,3 +125,17 @@ func ValidateTenant(next http.Handler) http.Handler {
 
 	})
 }
+
+// OptionalUser populates the user context like ValidateUser when a valid Bearer token is sent,
+// and lets anonymous requests through untouched so guests can use the cart.
+func OptionalUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+		if request.Header.Get("Authorization") == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		ValidateUser(next).ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"github.com/pratyush934/sibling-bond-server/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPMetrics records request counts and latency by route template. Like RequestLogger it wraps
+// ErrorHandler so recovered panics are counted with the status they were answered with.
+func HTTPMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		metrics.HTTPInFlight.Inc()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+		defer func() {
+			metrics.HTTPInFlight.Dec()
+			route := RouteTemplate(request)
+			if route == "" {
+				route = "unmatched"
+			}
+			metrics.HTTPRequests.WithLabelValues(request.Method, route, strconv.Itoa(recorder.status)).Inc()
+			metrics.HTTPDuration.WithLabelValues(request.Method, route).Observe(time.Since(started).Seconds())
+		}()
+
+		next.ServeHTTP(recorder, request)
+	})
+}
+
+// MetricsHandler serves the Prometheus metrics, behind a bearer token when one is configured.
+func MetricsHandler(token string) http.Handler {
+	handler := metrics.Handler()
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), expected) != 1 {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/pratyush934/sibling-bond-server/metrics"
+	"github.com/pratyush934/sibling-bond-server/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limiters of the public auth endpoints, set from the config at startup. A nil limiter lets everything through.
+var (
+	AuthIPLimiter      *ratelimit.Limiter
+	AuthAccountLimiter *ratelimit.Limiter
+)
+
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies sets the reverse proxies whose X-Forwarded-For ClientIP believes. With none,
+// the header is ignored since any client could send it.
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	trustedProxies = prefixes
+}
+
+// ClientIP is the address the request came from, without the port. Behind a trusted proxy it is
+// the right-most X-Forwarded-For entry that is not a trusted proxy itself, entries to the left of
+// it were written by the client and can say anything.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// a garbled entry ends the chain that can be trusted
+			return host
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitByIP throttles a route per client address with AuthIPLimiter. scope keeps the count of
+// each route apart, so exhausting login does not also block register.
+func RateLimitByIP(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			throttle(writer, request, AuthIPLimiter, scope+":"+ClientIP(request))
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// ThrottleAccount applies AuthAccountLimiter to one account, the email a handler read from the body.
+func ThrottleAccount(w http.ResponseWriter, r *http.Request, scope, account string) {
+	throttle(w, r, AuthAccountLimiter, scope+":"+strings.ToLower(strings.TrimSpace(account)))
+}
+
+func throttle(w http.ResponseWriter, r *http.Request, limiter *ratelimit.Limiter, key string) {
+	allowed, retryAfter, err := limiter.Allow(r.Context(), key)
+	if err != nil {
+		// a broken shared store should not take the login down with it
+		logging.Ctx(r.Context()).Err(err).Str("limiter", limiter.Name).Msg("Rate limit store failed, letting the request through")
+		return
+	}
+	if allowed {
+		return
+	}
+	metrics.RateLimited.WithLabelValues(limiter.Name).Inc()
+	TooManyRequests(w, retryAfter, cjson.CodeRateLimited, "Too many requests, please try again later")
+}
+
+// TooManyRequests answers 429 with a Retry-After header in whole seconds.
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration, code, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	panic(&cjson.HTTPError{
+		Status:        http.StatusTooManyRequests,
+		Code:          code,
+		Message:       message,
+		InternalError: nil,
+	})
+}
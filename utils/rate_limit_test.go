@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.5/32")})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:5123", nil, "203.0.113.7"},
+		{"untrusted peer sends the header", "203.0.113.7:5123", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client prepends a fake entry", "10.0.0.2:443", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:443", []string{"198.51.100.1, 192.168.1.5, 10.1.1.1"}, "198.51.100.1"},
+		{"header split over lines", "10.0.0.2:443", []string{"198.51.100.1", "10.1.1.1"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.2:443", []string{"10.1.1.1"}, "10.1.1.1"},
+		{"garbled entry", "10.0.0.2:443", []string{"198.51.100.1, not-an-ip"}, "10.0.0.2"},
+		{"trusted proxy without the header", "10.0.0.2:443", nil, "10.0.0.2"},
+		{"ipv4 mapped peer", "[::ffff:10.0.0.2]:443", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remoteAddr
+			for _, value := range c.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(r); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pratyush934/sibling-bond-server/logging"
+	"github.com/rs/zerolog"
+	"net/http"
+	"time"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, streaming handlers flush and move
+// their deadlines through it.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// RouteTemplate is the path template of the matched mux route, e.g. /api/orders/{id}, or empty
+// when called outside the router.
+func RouteTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return ""
+}
+
+// validRequestId keeps ids from upstream proxies but refuses anything that could garble the log.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogger assigns or propagates the X-Request-ID, puts the request logger into the context
+// and writes one access log line per request. It must be the outermost middleware so it sees the
+// status ErrorHandler writes.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+
+		requestId := request.Header.Get(logging.RequestIDHeader)
+		if !validRequestId(requestId) {
+			requestId = uuid.New().String()
+		}
+		writer.Header().Set(logging.RequestIDHeader, requestId)
+
+		ctx, info := logging.WithRequest(request.Context(), requestId)
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+		defer func() {
+			route := RouteTemplate(request)
+			if route == "" {
+				route = request.URL.Path
+			}
+
+			var event *zerolog.Event
+			if recorder.status >= http.StatusInternalServerError {
+				event = logging.Ctx(ctx).Error()
+			} else {
+				event = logging.Ctx(ctx).Info()
+			}
+			if info.UserId != "" {
+				event = event.Str("userId", info.UserId)
+			}
+			event.Str("method", request.Method).
+				Str("route", route).
+				Str("path", request.URL.Path).
+				Int("status", recorder.status).
+				Int("bytes", recorder.bytes).
+				Dur("latency", time.Since(started)).
+				Str("remoteAddr", request.RemoteAddr).
+				Msg("request")
+		}()
+
+		next.ServeHTTP(recorder, request.WithContext(ctx))
+	})
+}
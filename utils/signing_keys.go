@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+	Tokens are signed with the active key and carry its id in the "kid" header. Every loaded key
+	verifies, so after a rotation tokens signed with the previous key keep working until they expire.
+
+	Keys are read from a directory, the file name gives the key id:
+		<kid>.pem      private RSA (RS256) or Ed25519 (EdDSA) key, signs and verifies
+		<kid>.pub.pem  public key only, verifies tokens signed elsewhere or by a retired key
+		<kid>.secret   HMAC secret (HS256), signs and verifies
+*/
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKeyId = errors.New("unknown signing key id")
+
+type SigningKey struct {
+	Id        string
+	Algorithm string
+	signKey   any
+	verifyKey any
+}
+
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+type SigningKeyConfig struct {
+	KeysDir    string
+	ActiveKid  string
+	HMACSecret string
+}
+
+type keySet struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+var signingKeys = &keySet{keys: map[string]*SigningKey{}}
+
+func NewHMACKey(kid string, secret []byte) (*SigningKey, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("hmac key %s must be at least 32 bytes", kid)
+	}
+	return &SigningKey{Id: kid, Algorithm: AlgHS256, signKey: secret, verifyKey: secret}, nil
+}
+
+// NewKeyFromPEM reads a private key (PKCS#8 or PKCS#1) or, when it only holds a public key,
+// a verification-only key.
+func NewKeyFromPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", kid)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s has unsupported PEM type %s", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", kid, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Id: kid, Algorithm: AlgRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &SigningKey{Id: kid, Algorithm: AlgRS256, verifyKey: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Id: kid, Algorithm: AlgEdDSA, signKey: key, verifyKey: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{Id: kid, Algorithm: AlgEdDSA, verifyKey: key}, nil
+	}
+	return nil, fmt.Errorf("key %s has unsupported type %T", kid, parsed)
+}
+
+func loadKeysDir(dir string) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var key *SigningKey
+		switch {
+		case strings.HasSuffix(name, ".pub.pem"):
+			key, err = NewKeyFromPEM(strings.TrimSuffix(name, ".pub.pem"), data)
+		case strings.HasSuffix(name, ".pem"):
+			key, err = NewKeyFromPEM(strings.TrimSuffix(name, ".pem"), data)
+		case strings.HasSuffix(name, ".secret"):
+			key, err = NewHMACKey(strings.TrimSuffix(name, ".secret"), []byte(strings.TrimSpace(string(data))))
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetSigningKeys replaces the key set. activeKid may be empty when exactly one key can sign.
+func SetSigningKeys(keys []*SigningKey, activeKid string) error {
+	byId := make(map[string]*SigningKey, len(keys))
+	var signers []*SigningKey
+	for _, key := range keys {
+		if _, exists := byId[key.Id]; exists {
+			return fmt.Errorf("duplicate signing key id %s", key.Id)
+		}
+		byId[key.Id] = key
+		if key.CanSign() {
+			signers = append(signers, key)
+		}
+	}
+
+	var active *SigningKey
+	if activeKid != "" {
+		active = byId[activeKid]
+		if active == nil {
+			return fmt.Errorf("%w: active key %s", ErrUnknownKeyId, activeKid)
+		}
+		if !active.CanSign() {
+			return fmt.Errorf("active key %s has no private part", activeKid)
+		}
+	} else if len(signers) == 1 {
+		active = signers[0]
+	} else {
+		return fmt.Errorf("%d keys can sign, set the active key id", len(signers))
+	}
+
+	signingKeys.mu.Lock()
+	signingKeys.active = active
+	signingKeys.keys = byId
+	signingKeys.mu.Unlock()
+	return nil
+}
+
+// LoadSigningKeys sets up token signing from the key directory or, failing that, a single HMAC
+// secret. With neither it falls back to a random secret, which logs everyone out on restart.
+func LoadSigningKeys(cfg SigningKeyConfig) error {
+	var keys []*SigningKey
+	if cfg.KeysDir != "" {
+		loaded, err := loadKeysDir(cfg.KeysDir)
+		if err != nil {
+			return err
+		}
+		keys = loaded
+	}
+
+	if cfg.HMACSecret != "" {
+		key, err := NewHMACKey("hs-default", []byte(cfg.HMACSecret))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		log.Warn().Msg("No JWT signing keys configured, using a random key for this run")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		key, _ := NewHMACKey("ephemeral", secret)
+		keys = append(keys, key)
+	}
+
+	activeKid := cfg.ActiveKid
+	if activeKid == "" && cfg.HMACSecret != "" && len(keys) > 1 {
+		activeKid = "hs-default"
+	}
+	if err := SetSigningKeys(keys, activeKid); err != nil {
+		return err
+	}
+
+	active := activeSigningKey()
+	log.Info().Str("kid", active.Id).Str("alg", active.Algorithm).Int("keys", len(keys)).Msg("JWT signing keys loaded")
+	return nil
+}
+
+func activeSigningKey() *SigningKey {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+	return signingKeys.active
+}
+
+func signingKeyById(kid string) (*SigningKey, error) {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+	key, ok := signingKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyId, kid)
+	}
+	return key, nil
+}
+
+// signToken signs the claims with the active key and stamps its kid.
+func signToken(claims jwt.Claims) (string, error) {
+	key := activeSigningKey()
+	if key == nil {
+		return "", fmt.Errorf("signing keys are not loaded")
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.signKey)
+}
+
+// verificationKey is the jwt.Keyfunc: it picks the key named by the kid header and makes sure the
+// token uses that key's algorithm, so an RSA public key can never be used as an HMAC secret.
+func verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	key, err := signingKeyById(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS lists the public keys other services need to verify our tokens. HMAC secrets are never published.
+func JWKS() JSONWebKeySet {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range signingKeys.keys {
+		var public crypto.PublicKey = key.verifyKey
+		switch pub := public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
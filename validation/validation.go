@@ -0,0 +1,262 @@
+package validation
+
+import (
+	"fmt"
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Struct checks the `validate` tags of a struct and returns every failing field at once.
+Rules are comma separated and run left to right, the first failing rule of a field is reported:
+
+	required      the value is not empty (blank strings count as empty)
+	omitempty     skip the remaining rules when the value is empty
+	email         a plain address like someone@example.com
+	zipcode       a 6 digit PIN code that does not start with 0
+	slug          lower case letters and digits in dash separated words, e.g. home-decor
+	min=N, max=N  length for strings and slices, value for numbers
+	maxbytes=N    at most N bytes of UTF-8 for strings, e.g. passwords since bcrypt reads 72 bytes
+	oneof=a b c   one of the space separated values
+	dive          validate every struct inside a slice, or the nested struct itself
+
+Field names in the errors are the json names, nested ones look like variants[0].name.
+*/
+
+var zipCodePattern = regexp.MustCompile(`^[1-9][0-9]{5}$`)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Struct validates v, a struct or a pointer to one.
+func Struct(v any) []cjson.FieldError {
+	return check(v, false)
+}
+
+// Partial validates v like Struct but treats every field as optional, for updates where an
+// empty field means "leave it as it is".
+func Partial(v any) []cjson.FieldError {
+	return check(v, true)
+}
+
+func check(v any, partial bool) []cjson.FieldError {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []cjson.FieldError
+	walk(value, "", partial, &fields)
+	return fields
+}
+
+func walk(value reflect.Value, prefix string, partial bool, fields *[]cjson.FieldError) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldValue := value.Field(i)
+
+		// embedded structs share the json object of their parent
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && jsonName(field) == field.Name {
+			walk(fieldValue, prefix, partial, fields)
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + jsonName(field)
+		if fieldError, ok := checkField(fieldValue, name, tag, partial); !ok {
+			*fields = append(*fields, fieldError)
+			continue
+		}
+		if hasRule(tag, "dive") {
+			dive(fieldValue, name, partial, fields)
+		}
+	}
+}
+
+func dive(value reflect.Value, name string, partial bool, fields *[]cjson.FieldError) {
+	value = indirect(value)
+	switch value.Kind() {
+	case reflect.Struct:
+		walk(value, name+".", partial, fields)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			item := indirect(value.Index(i))
+			if item.Kind() == reflect.Struct {
+				walk(item, fmt.Sprintf("%s[%d].", name, i), partial, fields)
+			}
+		}
+	}
+}
+
+func checkField(value reflect.Value, name, tag string, partial bool) (cjson.FieldError, bool) {
+	empty := isEmpty(value)
+	for _, rule := range strings.Split(tag, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "required":
+			if empty && !partial {
+				return cjson.FieldError{Field: name, Code: cjson.FieldRequired, Message: name + " is required"}, false
+			}
+			if empty {
+				return cjson.FieldError{}, true
+			}
+		case "omitempty":
+			if empty {
+				return cjson.FieldError{}, true
+			}
+		case "dive":
+		default:
+			if fieldError, ok := checkRule(indirect(value), name, rule, param); !ok {
+				return fieldError, false
+			}
+		}
+	}
+	return cjson.FieldError{}, true
+}
+
+func checkRule(value reflect.Value, name, rule, param string) (cjson.FieldError, bool) {
+	if value.Kind() == reflect.Pointer {
+		// a nil pointer has nothing to check beyond required
+		return cjson.FieldError{}, true
+	}
+	switch rule {
+	case "email":
+		if !isEmail(value.String()) {
+			return cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " must be a valid email address"}, false
+		}
+	case "zipcode":
+		if !zipCodePattern.MatchString(value.String()) {
+			return cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " must be a 6 digit PIN code"}, false
+		}
+	case "slug":
+		if !slugPattern.MatchString(value.String()) {
+			return cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " must be lower case letters and digits separated by single dashes"}, false
+		}
+	case "min", "max":
+		return checkBound(value, name, rule, param)
+	case "maxbytes":
+		limit, err := strconv.Atoi(param)
+		if err != nil || value.Kind() != reflect.String {
+			panic(fmt.Sprintf("validation: bad maxbytes=%q on %s", param, name))
+		}
+		if len(value.String()) > limit {
+			return cjson.FieldError{Field: name, Code: cjson.FieldTooLarge, Message: fmt.Sprintf("%s must be at most %s bytes", name, param)}, false
+		}
+	case "oneof":
+		allowed := strings.Fields(param)
+		if !contains(allowed, fmt.Sprint(value.Interface())) {
+			return cjson.FieldError{Field: name, Code: cjson.FieldInvalid, Message: name + " must be one of: " + strings.Join(allowed, ", ")}, false
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q on %s", rule, name))
+	}
+	return cjson.FieldError{}, true
+}
+
+func checkBound(value reflect.Value, name, rule, param string) (cjson.FieldError, bool) {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validation: bad %s=%q on %s", rule, param, name))
+	}
+
+	var got float64
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		got, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		got, unit = float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		got = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		got = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		got = value.Float()
+	default:
+		return cjson.FieldError{}, true
+	}
+
+	if rule == "min" && got < limit {
+		if unit == "" {
+			return cjson.FieldError{Field: name, Code: cjson.FieldTooSmall, Message: fmt.Sprintf("%s must be at least %s", name, param)}, false
+		}
+		return cjson.FieldError{Field: name, Code: cjson.FieldTooSmall, Message: fmt.Sprintf("%s must have at least %s%s", name, param, unit)}, false
+	}
+	if rule == "max" && got > limit {
+		if unit == "" {
+			return cjson.FieldError{Field: name, Code: cjson.FieldTooLarge, Message: fmt.Sprintf("%s must be at most %s", name, param)}, false
+		}
+		return cjson.FieldError{Field: name, Code: cjson.FieldTooLarge, Message: fmt.Sprintf("%s must have at most %s%s", name, param, unit)}, false
+	}
+	return cjson.FieldError{}, true
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func isEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s {
+		return false
+	}
+	_, domain, _ := strings.Cut(s, "@")
+	return strings.Contains(domain, ".")
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	return value
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func hasRule(tag, name string) bool {
+	for _, rule := range strings.Split(tag, ",") {
+		if strings.TrimSpace(rule) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
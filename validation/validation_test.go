@@ -0,0 +1,117 @@
+package validation
+
+import (
+	"github.com/pratyush934/sibling-bond-server/cjson"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	ZipCode string `json:"zipCode" validate:"required,zipcode"`
+}
+
+type variant struct {
+	Name  string `json:"name" validate:"required,max=10"`
+	Price *int   `json:"price" validate:"omitempty,min=0"`
+}
+
+type signup struct {
+	Email    string    `json:"email" validate:"required,email"`
+	Password string    `json:"password" validate:"required,min=8,maxbytes=72"`
+	Slug     string    `json:"slug" validate:"omitempty,slug"`
+	Role     string    `json:"role" validate:"omitempty,oneof=admin user"`
+	Tags     []string  `json:"tags" validate:"omitempty,max=2"`
+	Quantity int       `json:"quantity" validate:"min=1,max=5"`
+	Address  *address  `json:"address" validate:"omitempty,dive"`
+	Variants []variant `json:"variants" validate:"dive"`
+}
+
+func validSignup() signup {
+	return signup{Email: "someone@example.com", Password: "correct horse", Quantity: 1}
+}
+
+func TestStruct(t *testing.T) {
+	negative := -1
+	cases := []struct {
+		name   string
+		change func(s *signup)
+		field  string
+		code   string
+	}{
+		{"valid", func(s *signup) {}, "", ""},
+		{"missing email", func(s *signup) { s.Email = "" }, "email", cjson.FieldRequired},
+		{"blank email", func(s *signup) { s.Email = "   " }, "email", cjson.FieldRequired},
+		{"bad email", func(s *signup) { s.Email = "Someone <someone@example.com>" }, "email", cjson.FieldInvalid},
+		{"email without domain dot", func(s *signup) { s.Email = "someone@localhost" }, "email", cjson.FieldInvalid},
+		{"short password", func(s *signup) { s.Password = "short" }, "password", cjson.FieldTooSmall},
+		{"72 byte password", func(s *signup) { s.Password = strings.Repeat("a", 72) }, "", ""},
+		{"73 byte password", func(s *signup) { s.Password = strings.Repeat("a", 73) }, "password", cjson.FieldTooLarge},
+		// 30 runes but 90 bytes, bcrypt would only read the first 24 characters
+		{"multibyte password over 72 bytes", func(s *signup) { s.Password = strings.Repeat("密", 30) }, "password", cjson.FieldTooLarge},
+		{"multibyte password under 72 bytes", func(s *signup) { s.Password = strings.Repeat("密", 24) }, "", ""},
+		{"bad slug", func(s *signup) { s.Slug = "Home--Decor" }, "slug", cjson.FieldInvalid},
+		{"good slug", func(s *signup) { s.Slug = "home-decor" }, "", ""},
+		{"role outside oneof", func(s *signup) { s.Role = "owner" }, "role", cjson.FieldInvalid},
+		{"too many tags", func(s *signup) { s.Tags = []string{"a", "b", "c"} }, "tags", cjson.FieldTooLarge},
+		{"quantity below min", func(s *signup) { s.Quantity = 0 }, "quantity", cjson.FieldTooSmall},
+		{"quantity above max", func(s *signup) { s.Quantity = 6 }, "quantity", cjson.FieldTooLarge},
+		{"nested struct", func(s *signup) { s.Address = &address{ZipCode: "012345"} }, "address.zipCode", cjson.FieldInvalid},
+		{"slice item", func(s *signup) { s.Variants = []variant{{Name: "red"}, {Name: ""}} }, "variants[1].name", cjson.FieldRequired},
+		{"max counts characters", func(s *signup) { s.Variants = []variant{{Name: strings.Repeat("é", 10)}} }, "", ""},
+		{"pointer value", func(s *signup) { s.Variants = []variant{{Name: "red", Price: &negative}} }, "variants[0].price", cjson.FieldTooSmall},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := validSignup()
+			c.change(&s)
+			fields := Struct(&s)
+			if c.field == "" {
+				if len(fields) > 0 {
+					t.Fatalf("got %v, want no errors", fields)
+				}
+				return
+			}
+			if len(fields) != 1 || fields[0].Field != c.field || fields[0].Code != c.code {
+				t.Fatalf("got %v, want one %s error on %s", fields, c.code, c.field)
+			}
+		})
+	}
+}
+
+func TestStructReportsEveryField(t *testing.T) {
+	fields := Struct(&signup{Password: "short"})
+	got := map[string]string{}
+	for _, f := range fields {
+		got[f.Field] = f.Code
+	}
+	want := map[string]string{"email": cjson.FieldRequired, "password": cjson.FieldTooSmall, "quantity": cjson.FieldTooSmall}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for field, code := range want {
+		if got[field] != code {
+			t.Errorf("%s: got %q, want %q", field, got[field], code)
+		}
+	}
+}
+
+func TestPartialSkipsEmptyFields(t *testing.T) {
+	if fields := Partial(&signup{Quantity: 2}); len(fields) > 0 {
+		t.Fatalf("got %v, want no errors", fields)
+	}
+	fields := Partial(&signup{Quantity: 2, Password: strings.Repeat("a", 73)})
+	if len(fields) != 1 || fields[0].Field != "password" {
+		t.Fatalf("got %v, want the password error only", fields)
+	}
+}
+
+func TestMaxBytesOnNonStringPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for maxbytes on an int")
+		}
+	}()
+	Struct(&struct {
+		Count int `validate:"maxbytes=4"`
+	}{Count: 1})
+}